@@ -28,6 +28,7 @@ import (
 
 	"github.com/els0r/goProbe/pkg/goDB"
 	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
 	log "github.com/els0r/log"
 
 	"flag"
@@ -231,7 +232,7 @@ func main() {
 	// try to read a summary file from the output folder. It may exist if data was previously written
 	// to the directory already.
 	summary := goDB.NewDBSummary()
-	summary, err = goDB.ReadDBSummary(config.SavePath)
+	summary, err = goDB.ReadDBSummary(gpfile.NewStore(), config.SavePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			summary = goDB.NewDBSummary()
@@ -369,10 +370,11 @@ func main() {
 
 		// insert the key-value pair into the correct flow map
 		flowMaps[rowKey.Iface][rowKey.Time][goDB.Key{
-			Sip:      rowKey.Sip,
-			Dip:      rowKey.Dip,
-			Dport:    rowKey.Dport,
-			Protocol: rowKey.Protocol,
+			Sip:       rowKey.Sip,
+			Dip:       rowKey.Dip,
+			Dport:     rowKey.Dport,
+			Protocol:  rowKey.Protocol,
+			IPVersion: rowKey.IPVersion,
 		}] = &rowVal
 
 		// fill the summary update for this flow record and update the summary
@@ -404,7 +406,7 @@ func main() {
 	// summary file update: this assumes that the summary was not modified during conversion
 	// of the CSV database. If a goProbe process were to write to the summary in the meantime,
 	// those changes would be overwritten.
-	err = goDB.ModifyDBSummary(config.SavePath, 10*time.Second,
+	err = goDB.ModifyDBSummary(gpfile.NewStore(), config.SavePath, 10*time.Second,
 		func(summ *goDB.DBSummary) (*goDB.DBSummary, error) {
 			return summary, nil
 		},
@@ -35,7 +35,7 @@ var parserTests = []struct {
 		goDB.ExtraKey{
 			int64(1460362502),
 			"eth2",
-			goDB.Key{Sip: [16]byte{213, 156, 236, 211}, Dip: [16]byte{213, 156, 236, 255}},
+			goDB.Key{Sip: [16]byte{213, 156, 236, 211}, Dip: [16]byte{213, 156, 236, 255}, IPVersion: goDB.IPv4},
 		},
 		goDB.Val{NBytesRcvd: uint64(525), NBytesSent: uint64(0), NPktsRcvd: uint64(2), NPktsSent: uint64(0)},
 	},
@@ -44,7 +44,7 @@ var parserTests = []struct {
 		goDB.ExtraKey{
 			int64(1460362502),
 			"eth2",
-			goDB.Key{Sip: [16]byte{213, 156, 236, 211}, Dip: [16]byte{213, 156, 236, 255}, Dport: [2]byte{0x1f, 0x90}, Protocol: byte(6)},
+			goDB.Key{Sip: [16]byte{213, 156, 236, 211}, Dip: [16]byte{213, 156, 236, 255}, Dport: [2]byte{0x1f, 0x90}, Protocol: byte(6), IPVersion: goDB.IPv4},
 		},
 		goDB.Val{NBytesRcvd: uint64(525), NBytesSent: uint64(0), NPktsRcvd: uint64(2), NPktsSent: uint64(0)},
 	},
@@ -53,7 +53,7 @@ var parserTests = []struct {
 		goDB.ExtraKey{
 			int64(1460362502),
 			"eth2",
-			goDB.Key{Sip: [16]byte{213, 156, 236, 211}, Dip: [16]byte{213, 156, 236, 255}, Dport: [2]byte{0x1f, 0x90}, Protocol: byte(6)},
+			goDB.Key{Sip: [16]byte{213, 156, 236, 211}, Dip: [16]byte{213, 156, 236, 255}, Dport: [2]byte{0x1f, 0x90}, Protocol: byte(6), IPVersion: goDB.IPv4},
 		},
 		goDB.Val{NBytesRcvd: uint64(525), NBytesSent: uint64(0), NPktsRcvd: uint64(2), NPktsSent: uint64(0)},
 	},
@@ -44,6 +44,13 @@ type Config struct {
 	Logging     LogConfig `json:"logging"`
 	API         APIConfig `json:"api"`
 	EncoderType string    `json:"encoder_type"`
+
+	// ColumnEncoding enables column-aware encoding prior to compression with EncoderType
+	ColumnEncoding bool `json:"column_encoding"`
+
+	Archive *ArchiveConfig `json:"archive,omitempty"`
+
+	Encryption *EncryptionConfig `json:"encryption,omitempty"`
 }
 
 // Ifaces stores the per-interface configuration
@@ -74,6 +81,28 @@ type DiscoveryConfig struct {
 	SkipVerify bool   `json:"skip_verify"`
 }
 
+// ArchiveConfig stores the parameters for archiving cold days to S3-compatible object storage.
+// If no credentials are provided, they are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+type ArchiveConfig struct {
+	Endpoint  string `json:"endpoint"`
+	Bucket    string `json:"bucket"`
+	Region    string `json:"region"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	Prefix    string `json:"prefix"`
+	AfterDays int    `json:"after_days"`
+
+	// CacheDir denotes the directory archived days are cached in when queried via the API.
+	// If unset, the cache directory of the user running goProbe is used
+	CacheDir string `json:"cache_dir,omitempty"`
+}
+
+// EncryptionConfig references the key used to encrypt all written blocks (AES-GCM). The
+// key file holds a hex-encoded 256 bit key and can be generated via `goQuery admin rekey`
+type EncryptionConfig struct {
+	KeyFile string `json:"key_file"`
+}
+
 // New creates a new configuration struct with default settings
 func New() *Config {
 	return &Config{
@@ -131,6 +160,26 @@ func (d DiscoveryConfig) validate() error {
 	return nil
 }
 
+func (a ArchiveConfig) validate() error {
+	if a.Endpoint == "" {
+		return fmt.Errorf("The archive endpoint needs to be specified, e.g. http://minio.example.com:9000")
+	}
+	if a.Bucket == "" {
+		return fmt.Errorf("The archive bucket needs to be specified")
+	}
+	if a.AfterDays < 1 {
+		return fmt.Errorf("Days can only be archived after at least one full day has passed (after_days >= 1)")
+	}
+	return nil
+}
+
+func (e EncryptionConfig) validate() error {
+	if e.KeyFile == "" {
+		return fmt.Errorf("The encryption key file needs to be specified")
+	}
+	return nil
+}
+
 func (i Ifaces) validate() error {
 	if len(i) == 0 {
 		return fmt.Errorf("No interfaces were specified")
@@ -149,10 +198,25 @@ func (c *Config) validate() error {
 	if c.DBPath == "" {
 		return fmt.Errorf("Database path must not be empty")
 	}
-	_, err := encoders.GetTypeByString(c.EncoderType)
+	et, err := encoders.GetTypeByString(c.EncoderType)
 	if err != nil {
 		return err
 	}
+	if et.Codec() != encoders.CodecNone {
+		return fmt.Errorf("Encoder type must denote a compressor, column codecs are enabled via column_encoding")
+	}
+
+	// check encryption config
+	if c.Encryption != nil {
+		if err := c.Encryption.validate(); err != nil {
+			return err
+		}
+	}
+
+	// check archive config
+	if c.Archive != nil {
+		return c.Archive.validate()
+	}
 	return nil
 }
 
@@ -82,6 +82,41 @@ var tests = []struct {
 		true,
 		`{ "db_path" : "/usr/local/goProbe/db", "interfaces" : { "en0" : { "bpf_filter" : "not arp and not icmp", "buf_size" : 2097152, "promisc" : true } }, "logging" : { "destination" : "console", "level" : "debug" }, "api" : { "port" : "6060", "request_logging" : false, "service_discovery" : { "endpoint" : "localhost:6060", "registry": "192.168.1.1:5000", "probe_identifier": "test_probe" } }, "encoder_type": "iwillneverbesupported" }`,
 	},
+	{
+		"valid configuration (column encoding)",
+		false,
+		`{ "db_path" : "/usr/local/goProbe/db", "interfaces" : { "en0" : { "bpf_filter" : "not arp and not icmp", "buf_size" : 2097152, "promisc" : true } }, "logging" : { "destination" : "console", "level" : "debug" }, "api" : { "port" : "6060" }, "encoder_type": "zstd", "column_encoding": true }`,
+	},
+	{
+		"chained encoder",
+		true,
+		`{ "db_path" : "/usr/local/goProbe/db", "interfaces" : { "en0" : { "bpf_filter" : "not arp and not icmp", "buf_size" : 2097152, "promisc" : true } }, "logging" : { "destination" : "console", "level" : "debug" }, "api" : { "port" : "6060" }, "encoder_type": "dict16+zstd" }`,
+	},
+	{
+		"valid configuration (archive)",
+		false,
+		`{ "db_path" : "/usr/local/goProbe/db", "interfaces" : { "en0" : { "bpf_filter" : "not arp and not icmp", "buf_size" : 2097152, "promisc" : true } }, "logging" : { "destination" : "console", "level" : "debug" }, "api" : { "port" : "6060" }, "archive" : { "endpoint" : "http://minio.example.com:9000", "bucket" : "goprobe", "prefix" : "probe1", "after_days" : 30 } }`,
+	},
+	{
+		"archive without bucket",
+		true,
+		`{ "db_path" : "/usr/local/goProbe/db", "interfaces" : { "en0" : { "bpf_filter" : "not arp and not icmp", "buf_size" : 2097152, "promisc" : true } }, "logging" : { "destination" : "console", "level" : "debug" }, "api" : { "port" : "6060" }, "archive" : { "endpoint" : "http://minio.example.com:9000", "after_days" : 30 } }`,
+	},
+	{
+		"archive of current day",
+		true,
+		`{ "db_path" : "/usr/local/goProbe/db", "interfaces" : { "en0" : { "bpf_filter" : "not arp and not icmp", "buf_size" : 2097152, "promisc" : true } }, "logging" : { "destination" : "console", "level" : "debug" }, "api" : { "port" : "6060" }, "archive" : { "endpoint" : "http://minio.example.com:9000", "bucket" : "goprobe", "after_days" : 0 } }`,
+	},
+	{
+		"valid configuration (encryption)",
+		false,
+		`{ "db_path" : "/usr/local/goProbe/db", "interfaces" : { "en0" : { "bpf_filter" : "not arp and not icmp", "buf_size" : 2097152, "promisc" : true } }, "logging" : { "destination" : "console", "level" : "debug" }, "api" : { "port" : "6060" }, "encryption" : { "key_file" : "/usr/local/goProbe/etc/db.key" } }`,
+	},
+	{
+		"encryption without key file",
+		true,
+		`{ "db_path" : "/usr/local/goProbe/db", "interfaces" : { "en0" : { "bpf_filter" : "not arp and not icmp", "buf_size" : 2097152, "promisc" : true } }, "logging" : { "destination" : "console", "level" : "debug" }, "api" : { "port" : "6060" }, "encryption" : {} }`,
+	},
 }
 
 func TestValidate(t *testing.T) {
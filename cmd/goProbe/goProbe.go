@@ -15,6 +15,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -24,6 +26,10 @@ import (
 	"github.com/els0r/goProbe/pkg/discovery"
 	"github.com/els0r/goProbe/pkg/goDB"
 	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/storage/s3"
 	"github.com/els0r/goProbe/pkg/version"
 	"github.com/els0r/log"
 
@@ -38,6 +44,9 @@ var (
 	// captureManager may also be accessed
 	// from multiple goroutines, so we need to synchronize access.
 	captureManager *capture.Manager
+
+	// dbStore provides access to the database files (encrypting blocks if configured)
+	dbStore storage.Store = gpfile.NewStore()
 )
 
 func main() {
@@ -101,6 +110,17 @@ func main() {
 	sigExitChan := make(chan os.Signal, 1)
 	signal.Notify(sigExitChan, syscall.SIGTERM, os.Interrupt)
 
+	// Set up encryption of the database (if configured)
+	if config.Encryption != nil {
+		key, err := encryption.ReadKeyFile(config.Encryption.KeyFile)
+		if err != nil {
+			logger.Errorf("Failed to set up encryption: %s", err)
+			os.Exit(1)
+		}
+		dbStore = gpfile.NewStore(gpfile.WithKeyring(encryption.NewKeyring(key)))
+		logger.Infof("Encrypting database blocks with key %s", key.ID())
+	}
+
 	// Create DB directory if it doesn't exist already.
 	if err := os.MkdirAll(capconfig.RuntimeDBPath(), 0755); err != nil {
 		logger.Errorf("Failed to create database directory: '%s'", err)
@@ -147,6 +167,12 @@ func main() {
 	if config.API.Timeout > 0 {
 		apiOptions = append(apiOptions, api.WithTimeout(config.API.Timeout))
 	}
+	if config.Encryption != nil {
+		apiOptions = append(apiOptions, api.WithDBKeyFiles(config.Encryption.KeyFile))
+	}
+	if config.Archive != nil && config.Archive.CacheDir != "" {
+		apiOptions = append(apiOptions, api.WithArchiveCacheDir(config.Archive.CacheDir))
+	}
 
 	// run go-routine to register with discovery service
 	var (
@@ -222,6 +248,16 @@ func main() {
 	// Start regular rotations
 	go handleRotations(captureManager, logger)
 
+	// Start archival of cold days (if configured)
+	if config.Archive != nil {
+		archiver, err := newArchiver(config.Archive)
+		if err != nil {
+			logger.Errorf("Failed to set up archival: %s", err)
+			os.Exit(1)
+		}
+		go handleArchival(archiver, config.Archive.AfterDays, logger)
+	}
+
 	// Wait for signal to exit
 	<-sigExitChan
 
@@ -283,6 +319,56 @@ func handleRotations(manager *capture.Manager, logger log.Logger) {
 	}
 }
 
+func newArchiver(cfg *capconfig.ArchiveConfig) (*s3.Archiver, error) {
+	accessKey, secretKey := cfg.AccessKey, cfg.SecretKey
+	if accessKey == "" && secretKey == "" {
+		accessKey, secretKey = os.Getenv(s3.AccessKeyEnv), os.Getenv(s3.SecretKeyEnv)
+	}
+
+	return s3.NewArchiver(s3.Config{
+		Endpoint:  cfg.Endpoint,
+		Bucket:    cfg.Bucket,
+		Region:    cfg.Region,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	}, cfg.Prefix)
+}
+
+func handleArchival(archiver *s3.Archiver, afterDays int, logger log.Logger) {
+
+	// Archival is cheap if there is nothing to do, so checking once an hour suffices
+	ticker := time.NewTicker(time.Hour)
+	for {
+		cutoff := time.Now().AddDate(0, 0, -afterDays)
+
+		logger.Debug(fmt.Sprintf("Archiving days before %s", cutoff.Format(time.ANSIC)))
+		n, err := archiver.ArchiveBefore(capconfig.RuntimeDBPath(), cutoff)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Error archiving cold days: %s", err.Error()))
+		}
+		if n > 0 {
+			logger.Info(fmt.Sprintf("Archived %d day(s) to object storage", n))
+		}
+
+		<-ticker.C
+	}
+}
+
+// buildRollups builds the rollup tables of the given day for all interfaces, allowing
+// queries over whole days to skip reading the individual blocks
+func buildRollups(ifaces []string, day int64, logger log.Logger) {
+	et, _ := encoders.GetTypeByString(config.EncoderType)
+
+	t0 := time.Now()
+	for _, iface := range ifaces {
+		dayPath := filepath.Join(capconfig.RuntimeDBPath(), iface, strconv.FormatInt(day, 10))
+		if err := goDB.BuildRollups(dbStore, dayPath, et); err != nil {
+			logger.Error(fmt.Sprintf("Error building rollup tables: %s", err.Error()))
+		}
+	}
+	logger.Debug(fmt.Sprintf("Built rollup tables of %d interface(s) in %s", len(ifaces), time.Now().Sub(t0)))
+}
+
 func handleWriteouts(handler *capture.WriteoutHandler, logToSyslog bool, logger log.Logger) {
 	var (
 		writeoutsChan  <-chan capture.Writeout = handler.WriteoutChan
@@ -292,6 +378,9 @@ func handleWriteouts(handler *capture.WriteoutHandler, logToSyslog bool, logger
 		lastWrite                              = make(map[string]int)
 	)
 
+	// lastDay denotes the day written to in the previous writeout
+	var lastDay int64
+
 	var syslogWriter *goDB.SyslogDBWriter
 	if logToSyslog {
 		var err error
@@ -311,9 +400,15 @@ func handleWriteouts(handler *capture.WriteoutHandler, logToSyslog bool, logger
 			_, exists := dbWriters[taggedMap.Iface]
 			if !exists {
 				et, _ := encoders.GetTypeByString(config.EncoderType)
+
+				writerOpts := []goDB.Option{goDB.WithStore(dbStore)}
+				if config.ColumnEncoding {
+					writerOpts = append(writerOpts, goDB.WithColumnEncoding())
+				}
 				w := goDB.NewDBWriter(capconfig.RuntimeDBPath(),
 					taggedMap.Iface,
 					et,
+					writerOpts...,
 				)
 				dbWriters[taggedMap.Iface] = w
 			}
@@ -358,7 +453,7 @@ func handleWriteouts(handler *capture.WriteoutHandler, logToSyslog bool, logger
 		}
 
 		// We are done with the writeout, let's try to write the updated summary
-		err := goDB.ModifyDBSummary(capconfig.RuntimeDBPath(), 10*time.Second, func(summ *goDB.DBSummary) (*goDB.DBSummary, error) {
+		err := goDB.ModifyDBSummary(dbStore, capconfig.RuntimeDBPath(), 10*time.Second, func(summ *goDB.DBSummary) (*goDB.DBSummary, error) {
 			if summ == nil {
 				summ = goDB.NewDBSummary()
 			}
@@ -371,6 +466,19 @@ func handleWriteouts(handler *capture.WriteoutHandler, logToSyslog bool, logger
 			logger.Error(fmt.Sprintf("Error updating summary: %s", err.Error()))
 		}
 
+		// Once the first block of a day has been written, the previous day is complete and its
+		// rollup tables can be built
+		if day := goDB.DayTimestamp(writeout.Timestamp.Unix()); day != lastDay {
+			if lastDay != 0 && day > lastDay {
+				ifaces := make([]string, 0, len(dbWriters))
+				for iface := range dbWriters {
+					ifaces = append(ifaces, iface)
+				}
+				go buildRollups(ifaces, lastDay, logger)
+			}
+			lastDay = day
+		}
+
 		// Clean up dead writers. We say that a writer is dead
 		// if it hasn't been used in the last few writeouts.
 		var remove []string
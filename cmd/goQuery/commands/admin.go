@@ -8,9 +8,12 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/storage/s3"
 	"github.com/els0r/goProbe/pkg/query"
 	"github.com/els0r/status"
 	"github.com/spf13/cobra"
@@ -83,7 +86,7 @@ var wipeCmd = &cobra.Command{
 
 func init() {
 	// subcommands
-	adminCmd.AddCommand(cleanCmd, wipeCmd)
+	adminCmd.AddCommand(cleanCmd, wipeCmd, rekeyCmd, recompressCmd, mergeCmd, exportCmd, importCmd, migrateCmd, reindexCmd, rollupCmd)
 	adminCmd.SetHelpFunc(printAdminHelp)
 }
 
@@ -116,7 +119,10 @@ func cleanIfaceDir(dbPath string, timestamp int64, iface string) (result cleanIf
 	clean := true
 	for _, entry := range entries {
 		if !entry.IsDir() {
-			clean = false
+			// the time index is updated below
+			if entry.Name() != goDB.TimeIndexFileName {
+				clean = false
+			}
 			continue
 		}
 
@@ -163,6 +169,18 @@ func cleanIfaceDir(dbPath string, timestamp int64, iface string) (result cleanIf
 		if err := os.RemoveAll(filepath.Join(dbPath, iface)); err != nil {
 			return result, err
 		}
+		return
+	}
+
+	// remove the deleted days from the time index. If it cannot be read, it is rebuilt by
+	// goProbe upon its next write
+	store := gpfile.NewStore()
+	index, indexErr := goDB.ReadTimeIndex(store, filepath.Join(dbPath, iface))
+	if indexErr == nil {
+		index.Remove(func(timestamp int64) bool {
+			return goDB.DayTimestamp(timestamp) < dayTimestamp
+		})
+		err = index.Write(store, filepath.Join(dbPath, iface))
 	}
 
 	return
@@ -195,7 +213,7 @@ func cleanOldDBDirs(dbPath string, timestamp int64) error {
 		ifaceResults[iface.Name()] = result
 	}
 
-	return goDB.ModifyDBSummary(dbPath, 10*time.Second, func(summ *goDB.DBSummary) (*goDB.DBSummary, error) {
+	return goDB.ModifyDBSummary(gpfile.NewStore(), dbPath, 10*time.Second, func(summ *goDB.DBSummary) (*goDB.DBSummary, error) {
 		if summ == nil {
 			return summ, fmt.Errorf("cannot update summary: summary missing")
 		}
@@ -236,6 +254,65 @@ func wipeDB(dbPath string) error {
 	return err
 }
 
+// dbFileFilter restricts the GPFiles visited by walkDBFiles
+type dbFileFilter struct {
+	ifaces map[string]struct{} // interfaces to visit (all if empty)
+	before int64               // only visit days starting before this timestamp (all if zero)
+}
+
+// walkDBFiles calls fn with the path of each GPFile of the database matching the filter.
+// Days archived to object storage cannot be rewritten and are returned instead
+func walkDBFiles(dbPath string, filter dbFileFilter, fn func(path string) error) (archivedDirs []string, err error) {
+	ifaces, err := ioutil.ReadDir(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, iface := range ifaces {
+		if !iface.IsDir() {
+			continue
+		}
+		if _, selected := filter.ifaces[iface.Name()]; len(filter.ifaces) > 0 && !selected {
+			continue
+		}
+
+		days, err := ioutil.ReadDir(filepath.Join(dbPath, iface.Name()))
+		if err != nil {
+			return archivedDirs, err
+		}
+		for _, day := range days {
+			if !day.IsDir() {
+				continue
+			}
+			dayTimestamp, err := strconv.ParseInt(day.Name(), 10, 64)
+			if err != nil || (filter.before != 0 && dayTimestamp >= filter.before) {
+				continue
+			}
+
+			dayPath := filepath.Join(dbPath, iface.Name(), day.Name())
+			if _, err := os.Stat(filepath.Join(dayPath, s3.StubFileName)); err == nil {
+				archivedDirs = append(archivedDirs, dayPath)
+				continue
+			}
+
+			files, err := ioutil.ReadDir(dayPath)
+			if err != nil {
+				return archivedDirs, err
+			}
+			for _, file := range files {
+				if !strings.HasSuffix(file.Name(), ".gpf"+gpfile.HeaderFileSuffix) {
+					continue
+				}
+				if err := fn(filepath.Join(dayPath, strings.TrimSuffix(file.Name(), gpfile.HeaderFileSuffix))); err != nil {
+					return archivedDirs, err
+				}
+			}
+		}
+	}
+
+	return archivedDirs, nil
+}
+
 func handleStatus(err error) {
 	if err != nil {
 		status.Failf("%s", err)
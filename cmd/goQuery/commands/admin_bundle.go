@@ -0,0 +1,175 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/bundle"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var exportParams struct {
+	ifaces string
+	first  string
+	last   string
+	output string
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export a time range of the database to a portable bundle file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if exportParams.output == "" {
+			return errors.New("export requires an output file (-o)")
+		}
+		if exportParams.ifaces == "" {
+			return errors.New("export requires at least one interface (-i)")
+		}
+
+		first, err := goDB.ParseTimeArgument(exportParams.first)
+		if err != nil {
+			return fmt.Errorf("invalid time format for --first: %s", err)
+		}
+		last, err := goDB.ParseTimeArgument(exportParams.last)
+		if err != nil {
+			return fmt.Errorf("invalid time format for --last: %s", err)
+		}
+		if first > last {
+			return errors.New("--first must not be after --last")
+		}
+
+		// check if DB exists at path
+		if err = query.CheckDBExists(subcmdLineParams.DBPath); err != nil {
+			return err
+		}
+
+		ifaces := strings.Split(exportParams.ifaces, ",")
+		if strings.ToLower(exportParams.ifaces) == "any" {
+			summary, err := goDB.ReadDBSummary(gpfile.NewStore(), subcmdLineParams.DBPath)
+			if err != nil {
+				return err
+			}
+			ifaces = ifaces[:0]
+			for iface := range summary.Interfaces {
+				ifaces = append(ifaces, iface)
+			}
+			sort.Strings(ifaces)
+		}
+
+		result, err := bundle.Export(subcmdLineParams.DBPath, ifaces, first, last, exportParams.output)
+		if err != nil {
+			return fmt.Errorf("database export failed: %s", err)
+		}
+		for _, dir := range result.ArchivedDirs {
+			fmt.Printf("Skipped archived directory %s\n", dir)
+		}
+		printManifest(exportParams.output, result.Manifest)
+		return nil
+	},
+}
+
+var importParams struct {
+	renames  []string
+	prefix   string
+	encoder  string
+	keyFiles []string
+	verify   bool
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import <bundle>",
+	Short: "Verify a bundle file and merge it into the database",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.New("import requires exactly one bundle file as argument")
+		}
+
+		if importParams.verify {
+			manifest, err := bundle.Verify(args[0])
+			if err != nil {
+				return fmt.Errorf("bundle verification failed: %s", err)
+			}
+			printManifest(args[0], manifest)
+			return nil
+		}
+
+		renames, err := parseRenames(importParams.renames)
+		if err != nil {
+			return err
+		}
+		encoderType, err := encoders.GetTypeByString(importParams.encoder)
+		if err != nil {
+			return err
+		}
+		store, err := keyedStore(importParams.keyFiles)
+		if err != nil {
+			return err
+		}
+		if err = os.MkdirAll(subcmdLineParams.DBPath, 0755); err != nil {
+			return err
+		}
+
+		// the bundle is extracted next to the database and merged into it from there
+		tmpDir, err := ioutil.TempDir(subcmdLineParams.DBPath, ".import")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+
+		manifest, err := bundle.Extract(args[0], tmpDir)
+		if err != nil {
+			return fmt.Errorf("bundle verification failed: %s", err)
+		}
+		printManifest(args[0], manifest)
+
+		// imported interfaces are prefixed with the given string (if any)
+		if importParams.prefix != "" {
+			for iface := range manifest.Interfaces {
+				renames = append(renames, ifaceRename{iface: iface, name: importParams.prefix + iface})
+			}
+		}
+
+		return mergeDBs([]string{tmpDir}, subcmdLineParams.DBPath, renames, false, encoderType, store)
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportParams.ifaces, "ifaces", "i", "", "Comma separated list of interfaces to export (or \"any\")")
+	exportCmd.Flags().StringVarP(&exportParams.first, "first", "f", time.Now().AddDate(0, -1, 0).Format(time.ANSIC), "Export flows no earlier than --first")
+	exportCmd.Flags().StringVarP(&exportParams.last, "last", "l", time.Now().Format(time.ANSIC), "Export flows no later than --last")
+	exportCmd.Flags().StringVarP(&exportParams.output, "output", "o", "", "Bundle file to write (conventionally ending in "+bundle.FileSuffix+")")
+
+	importCmd.Flags().StringSliceVarP(&importParams.renames, "rename", "", nil, "Rename an interface of the bundle: <iface>=<name>")
+	importCmd.Flags().StringVarP(&importParams.prefix, "prefix", "", "", "Prefix all interfaces of the bundle with the given string")
+	importCmd.Flags().StringVarP(&importParams.encoder, "encoder", "", "lz4", "Encoder / compressor used to write merged days")
+	importCmd.Flags().StringSliceVarP(&importParams.keyFiles, "key-file", "", nil, "Key file(s) of encrypted data (merged days are encrypted with the first one)")
+	importCmd.Flags().BoolVarP(&importParams.verify, "verify", "", false, "Only verify the bundle without importing it")
+}
+
+func printManifest(path string, manifest *bundle.Manifest) {
+	fmt.Printf("Bundle %s (created %s", path, manifest.Created.Local().Format(time.ANSIC))
+	if manifest.Hostname != "" {
+		fmt.Printf(" on %s", manifest.Hostname)
+	}
+	fmt.Printf(", %d files)\n", len(manifest.Files))
+
+	var ifaces []string
+	for iface := range manifest.Interfaces {
+		ifaces = append(ifaces, iface)
+	}
+	sort.Strings(ifaces)
+	for _, iface := range ifaces {
+		summ := manifest.Interfaces[iface]
+		fmt.Printf("  %s: %d flows from %s to %s\n", iface, summ.FlowCount,
+			time.Unix(summ.Begin, 0).Format(time.ANSIC), time.Unix(summ.End, 0).Format(time.ANSIC))
+	}
+}
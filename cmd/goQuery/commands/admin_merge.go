@@ -0,0 +1,191 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/storage/s3"
+	"github.com/els0r/goProbe/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var mergeParams struct {
+	renames  []string
+	prefix   bool
+	encoder  string
+	keyFiles []string
+}
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <src...> <dst>",
+	Short: "Merge the interfaces of one or more databases into another database",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return errors.New("merge requires at least one source and a destination database")
+		}
+		srcs, dst := args[:len(args)-1], args[len(args)-1]
+
+		renames, err := parseRenames(mergeParams.renames)
+		if err != nil {
+			return err
+		}
+		encoderType, err := encoders.GetTypeByString(mergeParams.encoder)
+		if err != nil {
+			return err
+		}
+
+		store, err := keyedStore(mergeParams.keyFiles)
+		if err != nil {
+			return err
+		}
+
+		for _, src := range srcs {
+			if err = query.CheckDBExists(src); err != nil {
+				return err
+			}
+		}
+		if err = os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+
+		return mergeDBs(srcs, dst, renames, mergeParams.prefix, encoderType, store)
+	},
+}
+
+func init() {
+	mergeCmd.Flags().StringSliceVarP(&mergeParams.renames, "rename", "", nil, "Rename an interface: [<src>:]<iface>=<name> (optionally restricted to one source)")
+	mergeCmd.Flags().BoolVarP(&mergeParams.prefix, "prefix", "", false, "Prefix the interfaces with the name of their source directory (e.g. probeA_eth0)")
+	mergeCmd.Flags().StringVarP(&mergeParams.encoder, "encoder", "", "lz4", "Encoder / compressor used to write merged days")
+	mergeCmd.Flags().StringSliceVarP(&mergeParams.keyFiles, "key-file", "", nil, "Key file(s) of encrypted sources (merged days are encrypted with the first one)")
+}
+
+// keyedStore provides access to (encrypted) databases, including days archived to object
+// storage. The first key provided is used to encrypt newly written blocks
+func keyedStore(keyFiles []string) (storage.Store, error) {
+	keyring, err := readKeyring(keyFiles)
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewStore(gpfile.NewStore(gpfile.WithKeyring(keyring)), s3.WithKeyring(keyring)), nil
+}
+
+// readKeyring reads the given key files into a keyring encrypting with the first key
+func readKeyring(keyFiles []string) (*encryption.Keyring, error) {
+	var keys []*encryption.Key
+	for _, path := range keyFiles {
+		key, err := encryption.ReadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return encryption.NewKeyring(keys[0], keys[1:]...), nil
+}
+
+// ifaceRename denotes the renaming of an interface of one (or all) source databases
+type ifaceRename struct {
+	src, iface, name string
+}
+
+// parseRenames parses renames of the form [<src>:]<iface>=<name>
+func parseRenames(specs []string) ([]ifaceRename, error) {
+	var renames []ifaceRename
+	for _, spec := range specs {
+		var rename ifaceRename
+
+		i := strings.LastIndexByte(spec, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid rename %q: expected [<src>:]<iface>=<name>", spec)
+		}
+		rename.iface, rename.name = spec[:i], spec[i+1:]
+		if j := strings.LastIndexByte(rename.iface, ':'); j >= 0 {
+			rename.src, rename.iface = rename.iface[:j], rename.iface[j+1:]
+		}
+		if err := validateIfaceName(rename.name); err != nil || rename.iface == "" {
+			return nil, fmt.Errorf("invalid rename %q: %v", spec, err)
+		}
+		renames = append(renames, rename)
+	}
+	return renames, nil
+}
+
+// validateIfaceName makes sure that the name can be used as interface directory
+func validateIfaceName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, filepath.Separator) {
+		return fmt.Errorf("invalid interface name %q", name)
+	}
+	return nil
+}
+
+// targetIface determines the name of an interface of the source database src in the
+// merged database. Explicit renames take precedence over the prefix
+func targetIface(src, iface string, renames []ifaceRename, prefix bool) string {
+	for _, rename := range renames {
+		if rename.iface != iface {
+			continue
+		}
+		if rename.src == "" || filepath.Clean(rename.src) == filepath.Clean(src) || rename.src == filepath.Base(src) {
+			return rename.name
+		}
+	}
+	if prefix {
+		return filepath.Base(filepath.Clean(src)) + "_" + iface
+	}
+	return iface
+}
+
+// mergeDBs merges all interfaces of the source databases into the destination database
+// (all accessed through store) and updates its summary accordingly
+func mergeDBs(srcs []string, dst string, renames []ifaceRename, prefix bool, encoderType encoders.Type, store storage.Store) error {
+	merged := make(map[string]struct{})
+	for _, src := range srcs {
+		ifaces, err := store.ReadDir(src)
+		if err != nil {
+			return err
+		}
+
+		for _, iface := range ifaces {
+			target := targetIface(src, iface, renames, prefix)
+			if err := validateIfaceName(target); err != nil {
+				return err
+			}
+
+			result, err := goDB.MergeInterface(src, iface, dst, target, encoderType, goDB.WithStore(store))
+			if err != nil {
+				return fmt.Errorf("database merge failed: %s", err)
+			}
+			merged[target] = struct{}{}
+
+			fmt.Printf("Merged %s/%s into %s: %d days copied, %d days merged (%d blocks re-aggregated)\n",
+				src, iface, target, result.DaysCopied, result.DaysMerged, result.BlocksMerged)
+		}
+	}
+
+	summaries := make(map[string]goDB.InterfaceSummary)
+	for iface := range merged {
+		summ, err := goDB.RebuildInterfaceSummary(store, dst, iface)
+		if err != nil {
+			return err
+		}
+		summaries[iface] = summ
+	}
+
+	return goDB.ModifyDBSummary(store, dst, 10*time.Second, func(summ *goDB.DBSummary) (*goDB.DBSummary, error) {
+		for iface, ifaceSumm := range summaries {
+			summ.Interfaces[iface] = ifaceSumm
+		}
+		return summ, nil
+	})
+}
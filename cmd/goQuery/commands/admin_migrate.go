@@ -0,0 +1,114 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var migrateParams struct {
+	dryRun   bool
+	list     bool
+	keyFiles []string
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the on-disk format of the database to the current version",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// check if DB exists at path
+		if err := query.CheckDBExists(subcmdLineParams.DBPath); err != nil {
+			return err
+		}
+
+		keyring, err := readKeyring(migrateParams.keyFiles)
+		if err != nil {
+			return err
+		}
+
+		if migrateParams.list {
+			return listVersions(subcmdLineParams.DBPath, keyring)
+		}
+
+		verb := "Migrating"
+		if migrateParams.dryRun {
+			verb = "Checking migration of"
+		}
+		result, err := goDB.Migrate(subcmdLineParams.DBPath, keyring, migrateParams.dryRun, func(day goDB.DayFormat, resumed bool) {
+			var suffix string
+			if resumed {
+				suffix = " (resuming interrupted migration)"
+			}
+			fmt.Printf("%s %s from version %d to %d%s\n", verb, day.Path(), day.Version, goDB.FormatVersion, suffix)
+		})
+		for _, warning := range result.Warnings {
+			fmt.Printf("Warning: %s\n", warning)
+		}
+		for _, dir := range result.ArchivedDirs {
+			fmt.Printf("Skipped archived directory %s\n", dir)
+		}
+		if err != nil {
+			return fmt.Errorf("database migration failed: %s", err)
+		}
+
+		verb = "Migrated"
+		if migrateParams.dryRun {
+			verb = "Would migrate"
+		}
+		fmt.Printf("%s %d days, %d days already at version %d\n", verb, result.DaysMigrated, result.DaysCurrent, goDB.FormatVersion)
+		return nil
+	},
+}
+
+func init() {
+	migrateCmd.Flags().BoolVarP(&migrateParams.dryRun, "dry-run", "", false, "Perform the conversions without modifying the database")
+	migrateCmd.Flags().BoolVarP(&migrateParams.list, "list", "", false, "List the format versions of all interfaces and the available migrations")
+	migrateCmd.Flags().StringSliceVarP(&migrateParams.keyFiles, "key-file", "", nil, "Key file(s) required to read encrypted blocks, new blocks are encrypted with the first one")
+}
+
+// listVersions prints the number of days per format version of each interface
+func listVersions(dbPath string, keyring *encryption.Keyring) error {
+	formats, err := goDB.DetectVersions(dbPath, keyring)
+	if err != nil {
+		return err
+	}
+
+	var ifaces []string
+	versions := make(map[string]map[int]int)
+	for _, format := range formats {
+		if _, exists := versions[format.Iface]; !exists {
+			versions[format.Iface] = make(map[int]int)
+			ifaces = append(ifaces, format.Iface)
+		}
+		if !format.Archived {
+			versions[format.Iface][format.Version]++
+		}
+	}
+	sort.Strings(ifaces)
+
+	fmt.Printf("Current format version: %d\n", goDB.FormatVersion)
+	for _, iface := range ifaces {
+		var counts []string
+		for version := 0; version <= goDB.FormatVersion; version++ {
+			if n := versions[iface][version]; n > 0 {
+				counts = append(counts, fmt.Sprintf("%d days at version %d", n, version))
+			}
+		}
+		if len(counts) == 0 {
+			counts = append(counts, "no local days")
+		}
+		fmt.Printf("  %s: %s\n", iface, strings.Join(counts, ", "))
+	}
+
+	fmt.Println("Available migrations:")
+	for _, m := range goDB.Migrations() {
+		fmt.Printf("  %d -> %d: %s\n", m.From, m.To, m.Description)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var recompressParams struct {
+	encoder  string
+	before   string
+	ifaces   string
+	keyFiles []string
+	dryRun   bool
+}
+
+var recompressCmd = &cobra.Command{
+	Use:   "recompress",
+	Short: "Re-compress existing database blocks with a different encoder",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if recompressParams.encoder == "" {
+			return errors.New("recompress requires an encoder (--encoder)")
+		}
+		encoderType, err := encoders.GetTypeByString(recompressParams.encoder)
+		if err != nil {
+			return err
+		}
+		if encoderType.Codec() != encoders.CodecNone {
+			return errors.New("recompress only supports plain compressors (column codecs are retained)")
+		}
+
+		// the current day is still being written to by goProbe, hence it is never rewritten
+		filter := dbFileFilter{before: goDB.DayTimestamp(time.Now().Unix())}
+		if recompressParams.before != "" {
+			before, err := goDB.ParseTimeArgument(recompressParams.before)
+			if err != nil {
+				return fmt.Errorf("failed to set recompression date: %s", err)
+			}
+			if before = goDB.DayTimestamp(before); before < filter.before {
+				filter.before = before
+			}
+		}
+		if recompressParams.ifaces != "" {
+			filter.ifaces = make(map[string]struct{})
+			for _, iface := range strings.Split(recompressParams.ifaces, ",") {
+				filter.ifaces[strings.TrimSpace(iface)] = struct{}{}
+			}
+		}
+
+		// check if DB exists at path
+		if err = query.CheckDBExists(subcmdLineParams.DBPath); err != nil {
+			return err
+		}
+
+		keyring, err := encryption.ReadKeyring(recompressParams.keyFiles...)
+		if err != nil {
+			return err
+		}
+
+		result, err := recompressDB(subcmdLineParams.DBPath, filter, encoderType, keyring, recompressParams.dryRun)
+		printRecompressResult(result, encoderType, recompressParams.dryRun)
+		if err != nil {
+			return fmt.Errorf("database recompression failed: %s", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	recompressCmd.Flags().StringVarP(&recompressParams.encoder, "encoder", "", "", "Encoder / compressor to convert the blocks to (e.g. zstd)")
+	recompressCmd.Flags().StringVarP(&recompressParams.before, "before", "", "", "Only recompress days before this date (the current day is always skipped)")
+	recompressCmd.Flags().StringVarP(&recompressParams.ifaces, "ifaces", "i", "", "Comma separated list of interfaces to recompress (default: all)")
+	recompressCmd.Flags().StringSliceVarP(&recompressParams.keyFiles, "key-file", "", nil, "Key file(s) required to decrypt encrypted blocks")
+	recompressCmd.Flags().BoolVarP(&recompressParams.dryRun, "dry-run", "", false, "Estimate the savings without modifying the database")
+}
+
+type recompressResult struct {
+	gpfile.RecompressResult
+	numFiles     int
+	archivedDirs []string
+}
+
+// recompressDB recompresses all GPFiles of the database matching the filter. Days archived
+// to object storage cannot be rewritten and are skipped
+func recompressDB(dbPath string, filter dbFileFilter, encoderType encoders.Type, keyring *encryption.Keyring, dryRun bool) (result recompressResult, err error) {
+	result.archivedDirs, err = walkDBFiles(dbPath, filter, func(path string) error {
+		fileResult, err := gpfile.Recompress(path, encoderType, keyring, dryRun)
+		if err != nil {
+			return err
+		}
+		if fileResult.NumBlocks > 0 {
+			result.numFiles++
+			result.Add(fileResult)
+		}
+		return nil
+	})
+	return result, err
+}
+
+func printRecompressResult(result recompressResult, encoderType encoders.Type, dryRun bool) {
+	var f query.TextFormatter
+
+	verb := "Recompressed"
+	if dryRun {
+		verb = "Would recompress"
+	}
+	fmt.Printf("%s %d blocks in %d files to %s\n", verb, result.NumBlocks, result.numFiles, encoderType)
+
+	if result.OldSize > 0 {
+		saved := result.OldSize - result.NewSize
+		prefix := "Saved"
+		if dryRun {
+			prefix = "Estimated savings:"
+		}
+		if saved >= 0 {
+			fmt.Printf("%s %s (%s -> %s, %.1f%%)\n", prefix, f.Size(uint64(saved)), f.Size(uint64(result.OldSize)), f.Size(uint64(result.NewSize)), 100*float64(saved)/float64(result.OldSize))
+		} else {
+			fmt.Printf("%s none, data grows by %s (%s -> %s)\n", prefix, f.Size(uint64(-saved)), f.Size(uint64(result.OldSize)), f.Size(uint64(result.NewSize)))
+		}
+	}
+	for _, dir := range result.archivedDirs {
+		fmt.Printf("Skipped archived directory %s\n", dir)
+	}
+}
@@ -0,0 +1,62 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var reindexParams struct {
+	ifaces   string
+	keyFiles []string
+}
+
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the block time indices of the database from the stored blocks",
+	RunE: func(cmd *cobra.Command, args []string) error {
+
+		// check if DB exists at path
+		if err := query.CheckDBExists(subcmdLineParams.DBPath); err != nil {
+			return err
+		}
+
+		store, err := keyedStore(reindexParams.keyFiles)
+		if err != nil {
+			return err
+		}
+
+		var ifaces []string
+		if reindexParams.ifaces != "" {
+			for _, iface := range strings.Split(reindexParams.ifaces, ",") {
+				ifaces = append(ifaces, strings.TrimSpace(iface))
+			}
+		} else {
+			summary, err := goDB.ReadDBSummary(store, subcmdLineParams.DBPath)
+			if err != nil {
+				return err
+			}
+			for iface := range summary.Interfaces {
+				ifaces = append(ifaces, iface)
+			}
+		}
+
+		for _, iface := range ifaces {
+			index, err := goDB.RebuildTimeIndex(store, filepath.Join(subcmdLineParams.DBPath, iface))
+			if err != nil {
+				return fmt.Errorf("failed to rebuild time index of %s: %s", iface, err)
+			}
+			fmt.Printf("Indexed %d blocks in %d days for %s\n", index.Len(), index.Days(), iface)
+		}
+		return nil
+	},
+}
+
+func init() {
+	reindexCmd.Flags().StringVarP(&reindexParams.ifaces, "ifaces", "i", "", "Comma separated list of interfaces to reindex (default: all)")
+	reindexCmd.Flags().StringSliceVarP(&reindexParams.keyFiles, "key-file", "", nil, "Key file(s) required to read encrypted blocks")
+}
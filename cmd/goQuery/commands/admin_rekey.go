@@ -0,0 +1,103 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var rekeyParams struct {
+	keyFiles   []string
+	newKeyFile string
+	decrypt    bool
+}
+
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt all database blocks with a new key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (rekeyParams.newKeyFile == "") == !rekeyParams.decrypt {
+			return errors.New("rekey requires exactly one of --new-key-file or --decrypt")
+		}
+
+		// check if DB exists at path
+		err := query.CheckDBExists(subcmdLineParams.DBPath)
+		if err != nil {
+			return err
+		}
+
+		var newKey *encryption.Key
+		if rekeyParams.newKeyFile != "" {
+			if newKey, err = readOrGenerateKeyFile(rekeyParams.newKeyFile); err != nil {
+				return err
+			}
+		}
+
+		var oldKeys []*encryption.Key
+		for _, path := range rekeyParams.keyFiles {
+			key, err := encryption.ReadKeyFile(path)
+			if err != nil {
+				return err
+			}
+			oldKeys = append(oldKeys, key)
+		}
+
+		result, err := rekeyDB(subcmdLineParams.DBPath, encryption.NewKeyring(newKey, oldKeys...))
+		fmt.Printf("Rewrote %d blocks in %d files\n", result.numBlocks, result.numFiles)
+		for _, dir := range result.archivedDirs {
+			fmt.Printf("Skipped archived directory %s (its blocks remain encrypted with the previous keys)\n", dir)
+		}
+		if err != nil {
+			return fmt.Errorf("database rekey failed: %s", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rekeyCmd.Flags().StringSliceVarP(&rekeyParams.keyFiles, "key-file", "", nil, "Key file(s) the blocks are currently encrypted with")
+	rekeyCmd.Flags().StringVarP(&rekeyParams.newKeyFile, "new-key-file", "", "", "Key file the blocks are encrypted with (generated if it does not exist)")
+	rekeyCmd.Flags().BoolVarP(&rekeyParams.decrypt, "decrypt", "", false, "Decrypt all blocks instead of encrypting them with a new key")
+}
+
+// readOrGenerateKeyFile reads the key stored at path, generating a new key file if
+// there is none yet
+func readOrGenerateKeyFile(path string) (*encryption.Key, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		key, err := encryption.GenerateKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Generated new key %s in %s\n", key.ID(), path)
+		return key, nil
+	}
+	return encryption.ReadKeyFile(path)
+}
+
+type rekeyResult struct {
+	numFiles     int
+	numBlocks    int
+	archivedDirs []string
+}
+
+// rekeyDB re-encrypts all GPFiles of the database with the primary key of the keyring.
+// Days archived to object storage cannot be rewritten and are skipped
+func rekeyDB(dbPath string, keyring *encryption.Keyring) (result rekeyResult, err error) {
+	result.archivedDirs, err = walkDBFiles(dbPath, dbFileFilter{}, func(path string) error {
+		numBlocks, err := gpfile.Rekey(path, keyring)
+		if err != nil {
+			return err
+		}
+		if numBlocks > 0 {
+			result.numFiles++
+			result.numBlocks += numBlocks
+		}
+		return nil
+	})
+	return result, err
+}
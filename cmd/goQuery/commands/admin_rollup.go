@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/s3"
+	"github.com/els0r/goProbe/pkg/query"
+	"github.com/spf13/cobra"
+)
+
+var rollupParams struct {
+	ifaces   string
+	encoder  string
+	keyFiles []string
+}
+
+var rollupCmd = &cobra.Command{
+	Use:   "rollup",
+	Short: "Build the per-day rollup tables used by queries over whole days",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		encoderType, err := encoders.GetTypeByString(rollupParams.encoder)
+		if err != nil {
+			return err
+		}
+
+		// check if DB exists at path
+		if err := query.CheckDBExists(subcmdLineParams.DBPath); err != nil {
+			return err
+		}
+
+		store, err := keyedStore(rollupParams.keyFiles)
+		if err != nil {
+			return err
+		}
+
+		var ifaces []string
+		if rollupParams.ifaces != "" {
+			for _, iface := range strings.Split(rollupParams.ifaces, ",") {
+				ifaces = append(ifaces, strings.TrimSpace(iface))
+			}
+		} else {
+			summary, err := goDB.ReadDBSummary(store, subcmdLineParams.DBPath)
+			if err != nil {
+				return err
+			}
+			for iface := range summary.Interfaces {
+				ifaces = append(ifaces, iface)
+			}
+		}
+
+		// the current day is still being written to by goProbe, hence its tables would be
+		// outdated right away
+		today := goDB.DayTimestamp(time.Now().Unix())
+
+		var failed int
+		for _, iface := range ifaces {
+			ifacePath := filepath.Join(subcmdLineParams.DBPath, iface)
+			days, err := store.ReadDir(ifacePath)
+			if err != nil {
+				return fmt.Errorf("failed to read days of %s: %s", iface, err)
+			}
+
+			var built int
+			for _, day := range days {
+				dayTimestamp, err := strconv.ParseInt(day, 10, 64)
+				if err != nil || dayTimestamp >= today {
+					continue
+				}
+				if _, err := os.Stat(filepath.Join(ifacePath, day, s3.StubFileName)); err == nil {
+					continue
+				}
+				if err := goDB.BuildRollups(store, filepath.Join(ifacePath, day), encoderType); err != nil {
+					fmt.Fprintf(os.Stderr, "Failed to build rollup tables: %s\n", err)
+					failed++
+					continue
+				}
+				built++
+			}
+			fmt.Printf("Built rollup tables of %d days for %s\n", built, iface)
+		}
+		if failed > 0 {
+			return fmt.Errorf("failed to build rollup tables of %d days", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rollupCmd.Flags().StringVarP(&rollupParams.ifaces, "ifaces", "i", "", "Comma separated list of interfaces to build rollup tables for (default: all)")
+	rollupCmd.Flags().StringVarP(&rollupParams.encoder, "encoder", "", "lz4", "Encoder / compressor used to write the rollup tables")
+	rollupCmd.Flags().StringSliceVarP(&rollupParams.keyFiles, "key-file", "", nil, "Key file(s) of encrypted data (rollup tables are encrypted with the first one)")
+}
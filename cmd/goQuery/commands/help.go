@@ -16,10 +16,17 @@ var helpBaseLong = helpBase + `
     Available columns:
       sip (or src)   source ip
       dip (or dst)   destination ip
+      snet           source network. Use snet/<len> or snet/<len4>/<len6>
+                     (e.g. snet/16, snet/48, snet/24/56) to set the prefix
+                     lengths of IPv4 and IPv6 addresses (default: /24 and /64).
+                     A single length above 32 applies to IPv6 addresses
+      dnet           destination network, parametrized like snet
       dport          destination port
       iface          interface
       proto          protocol (e.g. UDP, TCP)
-      time           timestamp
+      ipv            IP version (4 or 6)
+      time           timestamp of the 5 minute blocks. Use time:<resolution>
+                     (e.g. time:1h, time:1d) to aggregate into larger buckets
 
   QUERY_TYPE
     Type of query to perform (top talkers or top applications). This allows you to
@@ -149,6 +156,11 @@ ATTRIBUTES:
 
     EXAMPLE: "dport = 22 & proto = TCP"
 
+  IP version:
+    ipv         IP version (4 or 6), only supports "=" and "!="
+
+    EXAMPLE: "ipv = 6 & dport = 443"
+
 COMPARATIVE OPERATORS:
 
   Base    Description            Other representations
@@ -166,6 +178,25 @@ All of the items under "Other representations" (except for "===" and
   NOTE: In case the attribute involves an IP address, only "=" and "!="
         are supported.
 
+SETS:
+
+Instead of a single value, an attribute can be checked against a set of
+values via "in" and "!in" (or "not in"). Sets are enclosed by braces and
+may contain IPs, networks in CIDR notation, ports, protocols and ranges
+of ports or protocols, e.g.:
+
+    dport in {80, 443, 8000-8080}
+    dport !in 0-1023
+    sip in {10.0.0.0/8, 192.168.1.1, 2001:db8::/32}
+
+Long lists can be read from files via "@<path>", which may be mixed with
+other values. Values in a file are separated by whitespace or commas and
+"#" starts a comment, e.g.:
+
+    host in @/etc/goquery/blocklist.txt
+
+Host names and the "ipv" attribute are not supported in sets.
+
 Individual conditions can be chained together via logical operators,
 e.g.
 
@@ -225,6 +256,32 @@ and ( dport   le 1024 or dport   ge 443 )
   * { dport -leq 1024 || dport -geq 443 }
 
 and any other combination of the allowed representations.
+`,
+	"Having": `Filter on the aggregated counters, applied before sorting and limiting the
+results. Uses the syntax of the conditional, with the counters
+
+    bytes, packets                  counters of the selected direction
+                                    (in, out or in+out)
+    bytes_rcvd, bytes_sent          received / sent bytes
+    packets_rcvd, packets_sent      received / sent packets
+
+as attributes. Values may have a unit suffix k, M, G, T or P, which are
+powers of 1024 for bytes (e.g. 1.5G or 1.5GB) and of 1000 for packets.
+
+    EXAMPLE: --having "bytes_sent > 1G & packets < 100"
+`,
+	"GroupBy": `Group the aggregated entries by a subset of the query's attributes
+(e.g. sip or iface,sip), showing the top entries of each group. The groups
+are ranked by the sum of their entries' counters (chronologically if
+grouped by time) and limited by --limit, the entries of each group by
+--limit-per-group. The group attributes are printed in the first columns.
+
+    EXAMPLE: top 5 destination ports of each of the top 10 source IPs
+
+        goQuery -i eth0 -n 10 --group-by sip --limit-per-group 5 sip,dport
+`,
+	"LimitPerGroup": `Maximum number of entries to show per group of --group-by. By default,
+all entries of a group are shown.
 `,
 	"DBPath": `Path to goDB database directory <db-path>. By default,
 the database path from the configuration file is used.
@@ -272,13 +329,44 @@ The lookup is performed for the first '--resolve-rows' rows
 of output.
 Beware: The lookup is carried out at query time; DNS data may have been
 different when the packets were captured.
+`,
+	"Resolution": `Size of the buckets the "time" column is aggregated into, e.g.
+15m, 1h, 6h, 1d or 7d. Equivalent to specifying "time:<resolution>" in
+the query. Must be a multiple of 5 minutes and either divide a day or be
+a multiple of a day. Buckets are aligned to the wall clock of --time-zone
+(e.g. daily buckets start at midnight) and labeled with their start.
+`,
+	"TimeZone": `Time zone the time buckets are aligned to and timestamps are printed
+in (e.g. UTC, Europe/Zurich). Defaults to the local time zone.
+`,
+	"ZeroFill": `Print empty time buckets with zero counters, making the time series
+continuous between the first and last block covered by the query (e.g.
+for plotting). Requires a time resolution. The limit (-n) then applies to
+the number of series, i.e. the top series by --sort-by are shown with all
+their buckets.
 `,
 	"ResolveTimeout": `Timeout in seconds for (reverse) DNS lookups
+`,
+	"Timeout": `Abort the query if it hasn't finished after the given duration
+(e.g. 30s, 5m). Queries can be aborted with Ctrl-C as well. By default,
+queries run until they are done.
 `,
 	"Output": `Set the output to path (file). By default, results are written to stdout.
 `,
 	"MaxMemPct": `Maximum amount of memory that can be used for the query
-(in % of available memory)
+(in % of available memory). Above 75% of it, the aggregated flows are
+spilled to temporary files (in $TMPDIR) and merged at the end, so large
+queries complete at the expense of speed
+`,
+	"ArchiveCacheDir": `Directory days archived to object storage are cached in. It is
+created if required and must only be accessible by the current user
+(default: goprobe_archive_cache in the user's cache directory, e.g.
+~/.cache/goprobe_archive_cache)
+`,
+	"KeyFiles": `Key file(s) used to decrypt encrypted database blocks. Can be
+specified multiple times (or as comma-separated list) if the blocks
+are encrypted with different keys, e.g. during key rotation.
+Queries on encrypted blocks fail if their key is not provided.
 `,
 	"ResolveRows": `Maximum number of output rows to perform DNS resolution against. Before
 setting this to some high value (e.g. 1000), consider that this may incur
@@ -297,4 +385,68 @@ COMMANDS
   wipe
       Wipe all database entries from disk.
       Handle with utmost care, all changes are permanent and cannot be undone!
+
+  rekey --new-key-file <file> [--key-file <file>...]
+  rekey --decrypt --key-file <file> [--key-file <file>...]
+      Re-encrypt all database blocks with the key stored in <file> (which
+      is generated if it does not exist yet), or store them unencrypted.
+      The keys the blocks are currently encrypted with have to be provided
+      via --key-file. Days archived to object storage are skipped.
+      goProbe should be stopped during the rotation and configured with
+      the new key afterwards.
+
+  recompress --encoder <encoder> [--before <date>] [-i <ifaces>] [--dry-run]
+      Re-compress all database blocks (before <date> and of the given
+      interfaces, if specified) with <encoder>, e.g. zstd. Column codecs
+      and encryption of the blocks are retained (encrypted blocks require
+      --key-file). The current day is always skipped. Files are replaced
+      atomically. With --dry-run, only the savings are estimated.
+
+  merge [--rename [<src>:]<iface>=<name>...] [--prefix] <src...> <dst>
+      Merge the interfaces of the source databases into the database
+      <dst> (which is created if required). Days not yet present in <dst>
+      are copied, otherwise blocks with identical timestamps are
+      re-aggregated. Interfaces can be renamed (optionally only those of
+      one source) or prefixed with the name of their source directory
+      (e.g. probeA_eth0) to avoid collisions. Merged days are written
+      using --encoder (default: lz4) and are encrypted with the first
+      --key-file, if provided. The summary of <dst> is updated.
+
+  export -i <ifaces> [-f <timestamp>] [-l <timestamp>] -o <bundle>
+      Export the blocks of the given interfaces (or "any") within the
+      time range to a portable bundle file (conventionally ending in
+      .gpdb), including the metadata and a manifest with the checksums of
+      all files. Blocks are exported as stored, i.e. encrypted blocks
+      remain encrypted. Bundles can be queried directly by passing them
+      via -d. Days archived to object storage are skipped.
+
+  import [--rename <iface>=<name>...] [--prefix <prefix>] <bundle>
+  import --verify <bundle>
+      Verify the checksums of a bundle and merge it into the database
+      (which is created if required), in the same way as merge does.
+      With --verify, the bundle is only verified and its content listed.
+
+  migrate [--dry-run] [--list] [--key-file <file>...]
+      Upgrade all days of the database to the current on-disk format
+      version, verifying each migrated day. Interrupted migrations are
+      resumed when running the command again. With --list, the format
+      versions of all interfaces and the available migrations are shown.
+      Encrypted databases require their key file(s), columns added by a
+      migration are encrypted with the first --key-file. goProbe should
+      be stopped during the migration.
+
+  reindex [-i <ifaces>] [--key-file <file>...]
+      Rebuild the block time index of the given interfaces (or all
+      interfaces) from the block headers of their days. The index is
+      used for query planning and is maintained by goProbe, so this is
+      only required after modifying the database manually.
+
+  rollup [-i <ifaces>] [--encoder <encoder>] [--key-file <file>...]
+      Build the rollup tables of all past days of the given interfaces
+      (or all interfaces). The tables hold the flows of a day aggregated
+      by "dport,proto" and "sip,dip" and are used by queries covering
+      whole days which only reference these attributes. goProbe builds
+      them once a day has been completed, so this is only required for
+      days written by older versions or modified afterwards (e.g. by
+      merge or import). Outdated tables are ignored by queries.
 `
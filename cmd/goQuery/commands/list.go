@@ -4,11 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"text/tabwriter"
 	"time"
 
 	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/bundle"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
 	"github.com/els0r/goProbe/pkg/query"
 	"github.com/els0r/goProbe/pkg/util"
 	"github.com/spf13/cobra"
@@ -25,7 +29,18 @@ var listCmd = &cobra.Command{
 // List interfaces for which data is available and show how many flows and
 // how much traffic was observed for each one.
 func listInterfaces(dbPath string, external bool) error {
-	summary, err := goDB.ReadDBSummary(dbPath)
+	var store storage.Store = gpfile.NewStore()
+	if bundle.IsBundle(dbPath) {
+		// bundles are listed in place (read-only)
+		b, err := bundle.Open(dbPath)
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		store = b
+	}
+
+	summary, err := goDB.ReadDBSummary(store, dbPath)
 	if err != nil {
 		return err
 	}
@@ -38,8 +53,8 @@ func listInterfaces(dbPath string, external bool) error {
 
 		wtxt := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', tabwriter.AlignRight)
 		fmt.Fprintln(wtxt, "")
-		fmt.Fprintln(wtxt, "Iface\t# of flows\tTraffic\tFrom\tUntil\t")
-		fmt.Fprintln(wtxt, "---------\t----------\t---------\t-------------------\t-------------------\t")
+		fmt.Fprintln(wtxt, "Iface\t# of flows\tTraffic\tFrom\tUntil\tDays\tCoverage\t")
+		fmt.Fprintln(wtxt, "---------\t----------\t---------\t-------------------\t-------------------\t----\t--------\t")
 
 		tunnelInfos := util.TunnelInfos()
 
@@ -62,19 +77,27 @@ func listInterfaces(dbPath string, external bool) error {
 
 			is := summary.Interfaces[iface]
 
+			// the coverage is reported based on the time index (if available)
+			days, coverage := "-", "-"
+			if index, err := goDB.ReadTimeIndex(store, filepath.Join(dbPath, iface)); err == nil {
+				days, coverage = fmt.Sprint(index.Days()), fmt.Sprintf("%.1f%%", 100*index.Coverage())
+			}
+
 			tf := query.NewTextFormatter()
-			fmt.Fprintf(wtxt, "%s\t%s\t%s\t%s\t%s\t\n",
+			fmt.Fprintf(wtxt, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
 				ifaceDesc,
 				tf.Count(is.FlowCount),
 				tf.Size(is.Traffic),
 				time.Unix(is.Begin, 0).Format("2006-01-02 15:04:05"),
-				time.Unix(is.End, 0).Format("2006-01-02 15:04:05"))
+				time.Unix(is.End, 0).Format("2006-01-02 15:04:05"),
+				days,
+				coverage)
 			totalFlowCount += is.FlowCount
 			totalTraffic += is.Traffic
 		}
 		tf := query.NewTextFormatter()
-		fmt.Fprintln(wtxt, "\t \t \t \t \t")
-		fmt.Fprintf(wtxt, "Total\t%s\t%s\t\t\t\n",
+		fmt.Fprintln(wtxt, "\t \t \t \t \t \t \t")
+		fmt.Fprintf(wtxt, "Total\t%s\t%s\t\t\t\t\t\n",
 			tf.Count(totalFlowCount),
 			tf.Size(totalTraffic))
 		wtxt.Flush()
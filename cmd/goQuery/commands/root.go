@@ -1,11 +1,14 @@
 package commands
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/els0r/goProbe/pkg/query"
@@ -29,18 +32,36 @@ var subRootCmd = &cobra.Command{}
 
 // Execute is the main entrypoint and runs the CLI tool
 func Execute() {
-	err := rootCmd.Execute()
+	var err error
+
+	// commands other than queries parse their own arguments and flags
+	if len(os.Args) > 1 && isSubcommand(os.Args[1]) {
+		subRootCmd.SetArgs(os.Args[1:])
+		err = subRootCmd.Execute()
+	} else {
+		err = rootCmd.Execute()
+	}
 	if err != nil {
 		os.Exit(1)
 	}
 	os.Exit(0)
 }
 
+func isSubcommand(name string) bool {
+	for _, c := range subRootCmd.Commands() {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
 // globally accessible variable for other packages
 var (
 	cmdLineParams    = &query.Args{}
 	subcmdLineParams = &query.Args{}
 	argsLocation     string // for stored queries
+	queryTimeout     time.Duration
 )
 
 func init() {
@@ -49,6 +70,14 @@ func init() {
 	subRootCmd.PersistentFlags().StringVarP(&subcmdLineParams.DBPath, "db-path", "d", query.DefaultDBPath, helpMap["DBPath"])
 	subRootCmd.PersistentFlags().BoolVarP(&subcmdLineParams.External, "external", "x", false, helpMap["External"])
 
+	// attach subcommands
+	subRootCmd.AddCommand(
+		adminCmd,
+		exampleCmd,
+		listCmd,
+		versionCmd,
+	)
+
 	// help commands
 	rootCmd.InitDefaultHelpCmd()
 	rootCmd.InitDefaultHelpFlag()
@@ -63,24 +92,35 @@ func init() {
 	rootCmd.Flags().BoolVarP(&cmdLineParams.Resolve, "resolve", "", false, helpMap["Resolve"])
 	rootCmd.Flags().BoolVarP(&cmdLineParams.SortAscending, "ascending", "a", false, helpMap["SortAscending"])
 	rootCmd.Flags().BoolVarP(&cmdLineParams.Sum, "sum", "", false, helpMap["Sum"])
+	rootCmd.Flags().BoolVarP(&cmdLineParams.ZeroFill, "zero-fill", "", false, helpMap["ZeroFill"])
 	rootCmd.Flags().BoolVarP(&cmdLineParams.Version, "version", "v", false, "Print version information and exit\n")
 
 	// Strings
+	rootCmd.Flags().StringVarP(&cmdLineParams.ArchiveCacheDir, "archive-cache-dir", "", "", helpMap["ArchiveCacheDir"])
 	rootCmd.Flags().StringVarP(&cmdLineParams.Condition, "condition", "c", "", helpMap["Condition"])
 	rootCmd.Flags().StringVarP(&cmdLineParams.DBPath, "db-path", "d", query.DefaultDBPath, helpMap["DBPath"])
 	rootCmd.Flags().StringVarP(&cmdLineParams.First, "first", "f", time.Now().AddDate(0, -1, 0).Format(time.ANSIC), helpMap["First"])
 	rootCmd.Flags().StringVarP(&cmdLineParams.Format, "format", "e", query.DefaultFormat, helpMap["Format"])
+	rootCmd.Flags().StringVarP(&cmdLineParams.GroupBy, "group-by", "", "", helpMap["GroupBy"])
+	rootCmd.Flags().StringVarP(&cmdLineParams.Having, "having", "", "", helpMap["Having"])
 	rootCmd.Flags().StringVarP(&cmdLineParams.Ifaces, "ifaces", "i", "", helpMap["Ifaces"])
 	rootCmd.Flags().StringVarP(&cmdLineParams.Last, "last", "l", time.Now().Format(time.ANSIC), "Show flows no later than --last. See help for --first for more info\n")
 	rootCmd.Flags().StringVarP(&cmdLineParams.Output, "set-output", "o", "", helpMap["Output"])
+	rootCmd.Flags().StringVarP(&cmdLineParams.Resolution, "resolution", "", "", helpMap["Resolution"])
 	rootCmd.Flags().StringVarP(&argsLocation, "stored-query", "", "", "Load JSON serialized query arguments from disk and run them")
 	rootCmd.Flags().StringVarP(&cmdLineParams.SortBy, "sort-by", "s", query.DefaultSortBy, helpMap["SortBy"])
+	rootCmd.Flags().StringVarP(&cmdLineParams.TimeZone, "time-zone", "", "", helpMap["TimeZone"])
+	rootCmd.Flags().StringSliceVarP(&cmdLineParams.KeyFiles, "key-file", "", nil, helpMap["KeyFiles"])
 
 	// Integers
 	rootCmd.Flags().IntVarP(&cmdLineParams.NumResults, "limit", "n", query.DefaultNumResults, helpMap["NumResults"])
+	rootCmd.Flags().IntVarP(&cmdLineParams.LimitPerGroup, "limit-per-group", "", 0, helpMap["LimitPerGroup"])
 	rootCmd.Flags().IntVarP(&cmdLineParams.ResolveRows, "resolve-rows", "", query.DefaultResolveRows, helpMap["ResolveRows"])
 	rootCmd.Flags().IntVarP(&cmdLineParams.ResolveTimeout, "resolve-timeout", "", query.DefaultResolveTimeout, helpMap["ResolveTimeout"])
 	rootCmd.Flags().IntVarP(&cmdLineParams.MaxMemPct, "max-mem", "", query.DefaultMaxMemPct, helpMap["MaxMemPct"])
+
+	// Durations
+	rootCmd.Flags().DurationVarP(&queryTimeout, "timeout", "", 0, helpMap["Timeout"])
 }
 
 // main program entrypoint
@@ -135,14 +175,6 @@ func entrypoint(cmd *cobra.Command, args []string) error {
 			return nil
 		}
 
-		// attach subcommands
-		subRootCmd.AddCommand(
-			adminCmd,
-			exampleCmd,
-			listCmd,
-			versionCmd,
-		)
-
 		// execute subcommands if possible
 		for _, c := range subRootCmd.Commands() {
 			if c.Name() == args[0] {
@@ -158,18 +190,43 @@ func entrypoint(cmd *cobra.Command, args []string) error {
 
 	queryArgs.Caller = os.Args[0] // take the full path of called binary
 
+	// the query is aborted upon timeout or interrupt
+	ctx, cancel := queryContext(queryTimeout)
+	defer cancel()
+
 	// convert the command line parameters
-	query, err := queryArgs.Prepare()
+	query, err := queryArgs.Prepare(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Query preparation failed: %s\n", err)
 		return err
 	}
 
 	// run the query
-	err = query.Execute()
+	err = query.Execute(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Query execution failed: %s\n", err)
 		return err
 	}
 	return nil
 }
+
+// queryContext returns a context which is canceled after timeout (if non-zero) or once an
+// interrupt is received. Further interrupts terminate goQuery right away
+func queryContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
+}
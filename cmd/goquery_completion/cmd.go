@@ -181,7 +181,12 @@ func bashCompletion(args []string) {
 		return
 	case "-n":
 		return
-	case "-resolve-rows", "-resolve-timeout":
+	case "-group-by", "-having", "-limit-per-group", "-resolve-rows", "-resolve-timeout", "-timeout":
+		return
+	case "-resolution":
+		printlns(filterPrefix(last(args), "15m", "1h", "6h", "1d", "7d"))
+		return
+	case "-time-zone":
 		return
 	case "-s":
 		printlns(filterPrefix(last(args), "bytes", "packets", "time"))
@@ -53,6 +53,7 @@ func nextAll(prevprev, prev string, openParens int) []suggestion {
 			s("net", false),
 			s("dport", false),
 			s("proto", false),
+			s("ipv", false),
 		}
 	case "!":
 		return []suggestion{
@@ -67,8 +68,9 @@ func nextAll(prevprev, prev string, openParens int) []suggestion {
 			s("net", false),
 			s("dport", false),
 			s("proto", false),
+			s("ipv", false),
 		}
-	case "dip", "sip", "dnet", "snet", "dst", "src", "host", "net":
+	case "dip", "sip", "dnet", "snet", "dst", "src", "host", "net", "ipv":
 		return []suggestion{
 			s("=", false),
 			s("!=", false),
@@ -90,6 +92,11 @@ func nextAll(prevprev, prev string, openParens int) []suggestion {
 				result = append(result, suggestion{name, name + " ...", openParens == 0})
 			}
 			return result
+		case "ipv":
+			return []suggestion{
+				{"4", "4 ...", openParens == 0},
+				{"6", "6 ...", openParens == 0},
+			}
 		default:
 			return nil
 		}
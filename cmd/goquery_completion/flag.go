@@ -21,16 +21,23 @@ var flags = map[string]suggestion{
 	"-l":               {"-l", "-l <end time>", true},
 	"-h":               {"-h", "-h (show help)", true},
 	"-help":            {"-help", "-help (show help)", true},
+	"-group-by":        {"-group-by", "-group-by <attributes>", true},
+	"-having":          {"-having", "-having <counter filter>", true},
 	"-i":               {"-i", "-i <interface(s)>", true},
 	"-in":              {"-in", "-in (only incoming)", true},
+	"-limit-per-group": {"-limit-per-group", "-limit-per-group <# of results per group>", true},
 	"-list":            {"-list", "-list (list interfaces)", true},
 	"-n":               {"-n", "-n <# of results to print>", true},
 	"-out":             {"-out", "-out (only outgoing)", true},
+	"-resolution":      {"-resolution", "-resolution <time bucket size>", true},
 	"-resolve":         {"-resolve", "-resolve (run RDNS)", true},
 	"-resolve-rows":    {"-resolve-rows", "-resolve-rows", true},
 	"-resolve-timeout": {"-resolve-timeout", "-resolve-timeout", true},
 	"-s":               {"-s", "-s <sort by>", true},
 	"-sum":             {"-sum", "-sum (sum incoming & outgoing)", true},
+	"-time-zone":       {"-time-zone", "-time-zone <time zone>", true},
+	"-timeout":         {"-timeout", "-timeout <duration>", true},
+	"-zero-fill":       {"-zero-fill", "-zero-fill (print empty time buckets)", true},
 }
 
 func flag(args []string) []string {
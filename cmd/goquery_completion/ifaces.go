@@ -15,6 +15,7 @@ import (
 	"strings"
 
 	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
 	"github.com/els0r/goProbe/pkg/util"
 )
 
@@ -46,7 +47,7 @@ func ifaces(args []string) []string {
 
 	dbpath := dbPath(args)
 
-	summ, err := goDB.ReadDBSummary(dbpath)
+	summ, err := goDB.ReadDBSummary(gpfile.NewStore(), dbpath)
 	if err != nil {
 		return nil
 	}
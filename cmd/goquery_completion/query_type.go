@@ -29,9 +29,19 @@ func queryType(args []string) []string {
 			"dip":   true,
 			"dport": true,
 			"proto": true,
+			"ipv":   true,
+			"snet":  true,
+			"dnet":  true,
 		}
 
 		for _, attrib := range attribs {
+			if strings.HasPrefix(attrib, "time:") {
+				attrib = "time"
+			}
+			// networks may be followed by their prefix lengths (e.g. snet/24)
+			if i := strings.IndexByte(attrib, '/'); i >= 0 {
+				attrib = attrib[:i]
+			}
 			switch attrib {
 			case "talk_conv", "talk_src", "talk_dst", "apps_port", "agg_talk_port", "raw":
 				return nil
@@ -41,6 +51,18 @@ func queryType(args []string) []string {
 				attrib = "dip"
 			}
 			attribUnused[attrib] = false
+
+			// an address and its network can't be combined
+			switch attrib {
+			case "sip":
+				attribUnused["snet"] = false
+			case "snet":
+				attribUnused["sip"] = false
+			case "dip":
+				attribUnused["dnet"] = false
+			case "dnet":
+				attribUnused["dip"] = false
+			}
 		}
 
 		var result []string
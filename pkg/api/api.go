@@ -54,6 +54,12 @@ type Server struct {
 
 	// discovery config update
 	discoveryConfigUpdate chan *discovery.Config
+
+	// key files used to decrypt the database
+	dbKeyFiles []string
+
+	// directory archived days are cached in
+	archiveCacheDir string
 }
 
 // Keys allows for quick key validation
@@ -128,6 +134,12 @@ func New(port string, manager *capture.Manager, opts ...Option) (*Server, error)
 	if s.discoveryConfigUpdate != nil {
 		v1Options = append(v1Options, v1.WithDiscoveryConfigUpdate(s.discoveryConfigUpdate))
 	}
+	if len(s.dbKeyFiles) > 0 {
+		v1Options = append(v1Options, v1.WithDBKeyFiles(s.dbKeyFiles...))
+	}
+	if s.archiveCacheDir != "" {
+		v1Options = append(v1Options, v1.WithArchiveCacheDir(s.archiveCacheDir))
+	}
 
 	s.apis = append(s.apis,
 		v1.New(manager, v1Options...),
@@ -62,3 +62,17 @@ func WithDiscoveryConfigUpdate(update chan *discovery.Config) Option {
 		s.discoveryConfigUpdate = update
 	}
 }
+
+// WithDBKeyFiles sets the key files used to decrypt the database for queries via the API
+func WithDBKeyFiles(paths ...string) Option {
+	return func(s *Server) {
+		s.dbKeyFiles = paths
+	}
+}
+
+// WithArchiveCacheDir sets the directory archived days are cached in for queries via the API
+func WithArchiveCacheDir(dir string) Option {
+	return func(s *Server) {
+		s.archiveCacheDir = dir
+	}
+}
@@ -36,12 +36,28 @@ func WithDiscoveryConfigUpdate(update chan *discovery.Config) Option {
 	}
 }
 
+// WithDBKeyFiles sets the key files used to decrypt the database for queries
+func WithDBKeyFiles(paths ...string) Option {
+	return func(a *API) {
+		a.dbKeyFiles = paths
+	}
+}
+
+// WithArchiveCacheDir sets the directory archived days are cached in for queries
+func WithArchiveCacheDir(dir string) Option {
+	return func(a *API) {
+		a.archiveCacheDir = dir
+	}
+}
+
 // API holds access to goProbe's internal capture routines
 type API struct {
 	c                     *capture.Manager
 	discoveryConfigUpdate chan *discovery.Config
 	logger                log.Logger
 	errorHandler          errors.Handler
+	dbKeyFiles            []string
+	archiveCacheDir       string
 }
 
 // New creates a new API
@@ -30,6 +30,13 @@ func (a *API) handleQuery(w http.ResponseWriter, r *http.Request) {
 	// make sure that the caller variable is always the API
 	args.Caller = callerString
 
+	// only the keys configured for goProbe may be used, the caller must not be able
+	// to read arbitrary files
+	args.KeyFiles = a.dbKeyFiles
+
+	// the same holds for the directory archived days are cached in
+	args.ArchiveCacheDir = a.archiveCacheDir
+
 	// do not allow the caller to set more than the default
 	// maximum memory use. The API should not be an entrypoint
 	// to exhaust host resources
@@ -37,15 +44,17 @@ func (a *API) handleQuery(w http.ResponseWriter, r *http.Request) {
 		args.MaxMemPct = query.DefaultMaxMemPct
 	}
 
-	// prepare the query
-	stmt, err := args.Prepare(w)
+	// prepare the query. Queries are aborted once the request is done, e.g. because the
+	// client disconnected or the request timed out
+	ctx := r.Context()
+	stmt, err := args.Prepare(ctx, w)
 	if err != nil {
 		a.errorHandler.Handle(w, http.StatusBadRequest, err, "failed to prepare query. Invalid arguments provided")
 		return
 	}
 
 	// execute query
-	if err = stmt.Execute(); err != nil {
+	if err = stmt.Execute(ctx); err != nil {
 		a.errorHandler.Handle(w, http.StatusInternalServerError, err, "failed to execute query")
 		return
 	}
@@ -21,11 +21,12 @@ import (
 // GPFlow stores a goProbe flow
 type GPFlow struct {
 	// Hash Map Key variables
-	sip      [16]byte
-	dip      [16]byte
-	sport    [2]byte
-	dport    [2]byte
-	protocol byte
+	sip       [16]byte
+	dip       [16]byte
+	sport     [2]byte
+	dport     [2]byte
+	protocol  byte
+	ipVersion byte
 
 	// Hash Map Value variables
 	nBytesRcvd      uint64
@@ -51,8 +52,8 @@ func (f *GPFlow) MarshalJSON() ([]byte, error) {
 			NPktsRcvd  uint64 `json:"packetsRcvd"`
 			NPktsSent  uint64 `json:"packetsSent"`
 		}{
-			goDB.RawIPToString(f.sip[:]),
-			goDB.RawIPToString(f.dip[:]),
+			goDB.RawIPToString(f.sip[:], f.ipVersion),
+			goDB.RawIPToString(f.dip[:], f.ipVersion),
 			uint16(uint16(f.sport[0])<<8 | uint16(f.sport[1])),
 			uint16(uint16(f.dport[0])<<8 | uint16(f.dport[1])),
 			protocols.GetIPProto(int(f.protocol)),
@@ -94,7 +95,7 @@ func NewGPFlow(packet *GPPacket) *GPFlow {
 	// try to get the packet direction
 	directionSet := updateDirection(packet)
 
-	return &GPFlow{packet.sip, packet.dip, packet.sport, packet.dport, packet.protocol, bytesRcvd, bytesSent, pktsRcvd, pktsSent, directionSet}
+	return &GPFlow{packet.sip, packet.dip, packet.sport, packet.dport, packet.protocol, packet.ipVersion, bytesRcvd, bytesSent, pktsRcvd, pktsSent, directionSet}
 }
 
 // UpdateFlow increments flow counters if the packet belongs to an existing flow
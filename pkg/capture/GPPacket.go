@@ -17,6 +17,7 @@ package capture
 import (
 	"fmt"
 
+	"github.com/els0r/goProbe/pkg/goDB"
 	"github.com/fako1024/gopacket"
 	"github.com/fako1024/gopacket/layers"
 )
@@ -42,12 +43,13 @@ type EPHash [37]byte
 // GPPacket stores all relevant packet details for a flow
 type GPPacket struct {
 	// core fields
-	sip      [16]byte
-	dip      [16]byte
-	sport    [2]byte
-	dport    [2]byte
-	protocol byte
-	numBytes uint16
+	sip       [16]byte
+	dip       [16]byte
+	sport     [2]byte
+	dport     [2]byte
+	protocol  byte
+	ipVersion byte
+	numBytes  uint16
 
 	// direction indicator fields
 	tcpFlags byte
@@ -136,6 +138,7 @@ func (p *GPPacket) Populate(srcPacket gopacket.Packet) error {
 		switch srcPacket.NetworkLayer().LayerType() {
 		case layers.LayerTypeIPv4:
 
+			p.ipVersion = goDB.IPv4
 			p.protocol = nwL[9]
 
 			// only run the fragmentation checks on fragmented TCP/UDP packets. For
@@ -158,6 +161,7 @@ func (p *GPPacket) Populate(srcPacket gopacket.Packet) error {
 				}
 			}
 		case layers.LayerTypeIPv6:
+			p.ipVersion = goDB.IPv6
 			p.protocol = nwL[6]
 		}
 
@@ -217,6 +221,7 @@ func (p *GPPacket) reset() {
 	p.dport = byteArray2Zeros
 	p.sport = byteArray2Zeros
 	p.protocol = byteArray1Zeros
+	p.ipVersion = byteArray1Zeros
 	p.numBytes = uint16(0)
 	p.tcpFlags = byteArray1Zeros
 	p.epHash = byteArray37Zeros
@@ -82,9 +82,9 @@ func (f *FlowLog) TablePrint(w *tabwriter.Writer) error {
 
 		fmt.Fprintf(w, fmtStr,
 			prefix,
-			goDB.RawIPToString(g.sip[:]),
+			goDB.RawIPToString(g.sip[:], g.ipVersion),
 			uint16(uint16(g.sport[0])<<8|uint16(g.sport[1])),
-			goDB.RawIPToString(g.dip[:]),
+			goDB.RawIPToString(g.dip[:], g.ipVersion),
 			uint16(uint16(g.dport[0])<<8|uint16(g.dport[1])),
 			protocols.GetIPProto(int(g.protocol)),
 			g.nBytesRcvd, g.nBytesSent, g.nPktsRcvd, g.nPktsSent)
@@ -141,6 +141,7 @@ func (f *FlowLog) transferAndAggregate() (newFlowMap map[EPHash]*GPFlow, agg goD
 				tdip,
 				[2]byte{v.dport[0], v.dport[1]},
 				v.protocol,
+				v.ipVersion,
 			}
 
 			if toUpdate, exists := agg[tempkey]; exists {
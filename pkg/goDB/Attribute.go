@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/els0r/goProbe/pkg/goDB/protocols"
 )
@@ -43,7 +44,7 @@ func (SipAttribute) Name() string {
 
 // ExtractStrings converts the sip byte slice into a human-readable IP address
 func (SipAttribute) ExtractStrings(key *ExtraKey) []string {
-	return []string{RawIPToString(key.Sip[:])}
+	return []string{RawIPToString(key.Sip[:], key.Version())}
 }
 
 func (SipAttribute) attributeMarker() {}
@@ -58,7 +59,7 @@ func (DipAttribute) Name() string {
 
 // ExtractStrings converts the dip byte slice into a human-readable IP address
 func (DipAttribute) ExtractStrings(key *ExtraKey) []string {
-	return []string{RawIPToString(key.Dip[:])}
+	return []string{RawIPToString(key.Dip[:], key.Version())}
 }
 func (DipAttribute) attributeMarker() {}
 
@@ -92,9 +93,124 @@ func (DportAttribute) ExtractStrings(key *ExtraKey) []string {
 
 func (DportAttribute) attributeMarker() {}
 
+// IPVersionAttribute implements the IP version attribute
+type IPVersionAttribute struct{}
+
+// Name returns the attribute's name
+func (IPVersionAttribute) Name() string {
+	return "ipv"
+}
+
+// ExtractStrings converts the IP version into a string (e.g. "6")
+func (IPVersionAttribute) ExtractStrings(key *ExtraKey) []string {
+	return []string{strconv.Itoa(int(key.Version()))}
+}
+
+func (IPVersionAttribute) attributeMarker() {}
+
+// Default prefix lengths of the snet and dnet attributes
+const (
+	DefaultNetPrefixLenV4 = 24
+	DefaultNetPrefixLenV6 = 64
+)
+
+// PrefixLengths denotes the lengths of the prefixes IPv4 and IPv6 addresses are masked to
+// by the network attributes
+type PrefixLengths struct {
+	V4, V6 int
+}
+
+// parsePrefixLengths parses the prefix lengths of a network attribute, i.e. the parts
+// following its name in "snet", "snet/<len>" or "snet/<IPv4 len>/<IPv6 len>". Missing
+// lengths take their defaults. A single length longer than 32 bits applies to IPv6
+// addresses, keeping the default for IPv4 addresses
+func parsePrefixLengths(parts []string) (PrefixLengths, error) {
+	lengths := PrefixLengths{DefaultNetPrefixLenV4, DefaultNetPrefixLenV6}
+
+	values := make([]int, len(parts))
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 || value > 128 {
+			return lengths, fmt.Errorf("Invalid prefix length: '%s'", part)
+		}
+		values[i] = value
+	}
+	switch {
+	case len(values) == 0:
+	case len(values) == 1 && values[0] <= 32:
+		lengths.V4 = values[0]
+	case len(values) == 1:
+		lengths.V6 = values[0]
+	case len(values) == 2 && values[0] <= 32:
+		lengths.V4, lengths.V6 = values[0], values[1]
+	default:
+		return lengths, fmt.Errorf("Invalid prefix lengths: '%s'", strings.Join(parts, "/"))
+	}
+	return lengths, nil
+}
+
+// of returns the prefix length of addresses of the given IP version
+func (l PrefixLengths) of(ipVersion byte) int {
+	if ipVersion == IPv4 {
+		return l.V4
+	}
+	return l.V6
+}
+
+// SnetAttribute implements the source network attribute, i.e. the source IP masked to the
+// prefix length of its IP version (e.g. "snet/24")
+type SnetAttribute struct {
+	PrefixLengths
+}
+
+// Name returns the attribute's name
+func (SnetAttribute) Name() string {
+	return "snet"
+}
+
+// ExtractStrings converts the masked sip byte slice into a network in CIDR notation
+// (e.g. 10.1.2.0/24)
+func (a SnetAttribute) ExtractStrings(key *ExtraKey) []string {
+	return []string{RawIPToString(key.Sip[:], key.Version()) + "/" + strconv.Itoa(a.of(key.Version()))}
+}
+
+func (SnetAttribute) attributeMarker() {}
+
+// DnetAttribute implements the destination network attribute, i.e. the destination IP
+// masked to the prefix length of its IP version (e.g. "dnet/16")
+type DnetAttribute struct {
+	PrefixLengths
+}
+
+// Name returns the attribute's name
+func (DnetAttribute) Name() string {
+	return "dnet"
+}
+
+// ExtractStrings converts the masked dip byte slice into a network in CIDR notation
+// (e.g. 2001:db8:1::/48)
+func (a DnetAttribute) ExtractStrings(key *ExtraKey) []string {
+	return []string{RawIPToString(key.Dip[:], key.Version()) + "/" + strconv.Itoa(a.of(key.Version()))}
+}
+
+func (DnetAttribute) attributeMarker() {}
+
 // NewAttribute returns an Attribute for the given name. If no such attribute
-// exists, an error is returned.
+// exists, an error is returned. The network attributes snet and dnet take the
+// lengths of their prefixes as parameters, e.g. "snet/24", "dnet/48" or
+// "snet/16/48" (see parsePrefixLengths).
 func NewAttribute(name string) (Attribute, error) {
+	if parts := strings.Split(name, "/"); parts[0] == "snet" || parts[0] == "dnet" {
+		lengths, err := parsePrefixLengths(parts[1:])
+		if err != nil {
+			return nil, err
+		}
+		if parts[0] == "snet" {
+			return SnetAttribute{lengths}, nil
+		}
+		return DnetAttribute{lengths}, nil
+	}
+
 	switch name {
 	case "sip", "src": // src is an alias for sip
 		return SipAttribute{}, nil
@@ -104,6 +220,8 @@ func NewAttribute(name string) (Attribute, error) {
 		return ProtoAttribute{}, nil
 	case "dport":
 		return DportAttribute{}, nil
+	case "ipv":
+		return IPVersionAttribute{}, nil
 	default:
 		return nil, fmt.Errorf("Unknown attribute name: '%s'", name)
 	}
@@ -118,7 +236,10 @@ func NewAttribute(name string) (Attribute, error) {
 // time attribute is present. (time is never a part of the returned
 // attribute list.) The time attribute is present for the query type
 // 'raw', or if it is explicitly mentioned in a list of attribute
-// names.
+// names. The time attribute may specify the size of its buckets, e.g.
+// "time:1h" (see QueryTimeResolution). An address attribute cannot be
+// combined with the network attribute of the same direction (e.g. sip and
+// snet/24).
 func ParseQueryType(queryType string) (attributes []Attribute, hasAttrTime, hasAttrIface bool, err error) {
 	switch queryType {
 	case "talk_conv":
@@ -137,7 +258,7 @@ func ParseQueryType(queryType string) (attributes []Attribute, hasAttrTime, hasA
 	// We didn't match any of the preset query types, so we are dealing with
 	// a comma separated list of attribute names.
 	attributeNames := strings.Split(queryType, ",")
-	attributeSet := make(map[string]struct{})
+	attributeSet := make(map[string]Attribute)
 	for _, attributeName := range attributeNames {
 		switch attributeName {
 		case "time":
@@ -147,21 +268,62 @@ func ParseQueryType(queryType string) (attributes []Attribute, hasAttrTime, hasA
 			hasAttrIface = true
 			continue
 		}
+		if strings.HasPrefix(attributeName, "time:") {
+			if _, err := ParseTimeResolution(strings.TrimPrefix(attributeName, "time:")); err != nil {
+				return nil, false, false, err
+			}
+			hasAttrTime = true
+			continue
+		}
 
 		attribute, err := NewAttribute(attributeName)
 		if err != nil {
 			return nil, false, false, err
 		}
-		if _, exists := attributeSet[attribute.Name()]; !exists {
-			attributeSet[attribute.Name()] = struct{}{}
-			attributes = append(attributes, attribute)
+		if existing, exists := attributeSet[attribute.Name()]; exists {
+			if existing != attribute {
+				return nil, false, false, fmt.Errorf("Attribute '%s' specified with different prefix lengths", attribute.Name())
+			}
+			continue
+		}
+		attributeSet[attribute.Name()] = attribute
+		attributes = append(attributes, attribute)
+	}
+
+	// an address is either aggregated as is or masked to its network
+	if _, exists := attributeSet["snet"]; exists {
+		if _, exists := attributeSet["sip"]; exists {
+			return nil, false, false, fmt.Errorf("Attributes 'sip' and 'snet' cannot be combined")
+		}
+	}
+	if _, exists := attributeSet["dnet"]; exists {
+		if _, exists := attributeSet["dip"]; exists {
+			return nil, false, false, fmt.Errorf("Attributes 'dip' and 'dnet' cannot be combined")
 		}
 	}
 	return
 }
 
+// QueryTimeResolution returns the bucket size of the time attribute of the given query
+// type, e.g. one hour for "time:1h,dport". Zero is returned if the query type doesn't
+// specify a resolution
+func QueryTimeResolution(queryType string) (resolution time.Duration, err error) {
+	for _, attributeName := range strings.Split(queryType, ",") {
+		if !strings.HasPrefix(attributeName, "time:") {
+			continue
+		}
+		if resolution != 0 {
+			return 0, fmt.Errorf("Time resolution specified more than once in query type '%s'", queryType)
+		}
+		if resolution, err = ParseTimeResolution(strings.TrimPrefix(attributeName, "time:")); err != nil {
+			return 0, err
+		}
+	}
+	return resolution, nil
+}
+
 // HasDNSAttributes finds out if any of the attributes are usable for a reverse DNS lookup
-// (e.g. check for IP attributes)
+// (e.g. check for IP attributes). Networks (snet, dnet) are never resolved
 func HasDNSAttributes(attributes []Attribute) bool {
 	for _, attr := range attributes {
 		if attr.Name() == "sip" || attr.Name() == "dip" {
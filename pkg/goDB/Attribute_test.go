@@ -34,6 +34,7 @@ var tests = []struct {
 	{DipAttribute{}, "dip", []string{"301:401:509:206:503:508:907:903"}},
 	{DportAttribute{}, "dport", []string{"52209"}},
 	{ProtoAttribute{}, "proto", []string{"TCP"}},
+	{IPVersionAttribute{}, "ipv", []string{"6"}},
 }
 
 func TestAttributes(t *testing.T) {
@@ -47,8 +48,57 @@ func TestAttributes(t *testing.T) {
 	}
 }
 
+func TestIPVersionAttributes(t *testing.T) {
+	// 2001:db8:: and 10.0.0.1 used to be indistinguishable from IPv4 addresses
+	var tests = []struct {
+		name    string
+		key     Key
+		sip     string
+		dip     string
+		version string
+	}{
+		{"IPv4", Key{Sip: [16]byte{10, 0, 0, 1}, Dip: [16]byte{10, 0, 0, 2}, IPVersion: IPv4}, "10.0.0.1", "10.0.0.2", "4"},
+		{"IPv6 trailing zeros", Key{Sip: [16]byte{0x20, 0x01, 0x0d, 0xb8}, Dip: [16]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, IPVersion: IPv6}, "2001:db8::", "2001:db8::1", "6"},
+		{"IPv6 both trailing zeros", Key{Sip: [16]byte{10, 0, 0, 1}, Dip: [16]byte{0x20, 0x01, 0x0d, 0xb8}, IPVersion: IPv6}, "a00:1::", "2001:db8::", "6"},
+		{"inferred IPv4", Key{Sip: [16]byte{10, 0, 0, 1}, Dip: [16]byte{10, 0, 0, 2}}, "10.0.0.1", "10.0.0.2", "4"},
+		{"inferred IPv6", Key{Sip: [16]byte{0x20, 0x01, 0x0d, 0xb8}, Dip: [16]byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}}, "2001:db8::", "2001:db8::1", "6"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := ExtraKey{Key: test.key}
+			have := []string{
+				SipAttribute{}.ExtractStrings(&key)[0],
+				DipAttribute{}.ExtractStrings(&key)[0],
+				IPVersionAttribute{}.ExtractStrings(&key)[0],
+			}
+			if want := []string{test.sip, test.dip, test.version}; !reflect.DeepEqual(have, want) {
+				t.Fatalf("want %v, have %v", want, have)
+			}
+		})
+	}
+}
+
+func TestNetworkAttributes(t *testing.T) {
+	var tests = []struct {
+		attribute Attribute
+		key       Key
+		want      string
+	}{
+		{SnetAttribute{PrefixLengths{24, 64}}, Key{Sip: [16]byte{10, 1, 2}, IPVersion: IPv4}, "10.1.2.0/24"},
+		{SnetAttribute{PrefixLengths{24, 48}}, Key{Sip: [16]byte{0x20, 0x01, 0x0d, 0xb8}, IPVersion: IPv6}, "2001:db8::/48"},
+		{DnetAttribute{PrefixLengths{0, 0}}, Key{IPVersion: IPv4}, "0.0.0.0/0"},
+		{DnetAttribute{PrefixLengths{16, 64}}, Key{Dip: [16]byte{0xfe, 0x80}, IPVersion: IPv6}, "fe80::/64"},
+	}
+	for _, test := range tests {
+		key := ExtraKey{Key: test.key}
+		if have := test.attribute.ExtractStrings(&key)[0]; have != test.want {
+			t.Fatalf("%s: want %s, have %s", test.attribute.Name(), test.want, have)
+		}
+	}
+}
+
 func TestNewAttribute(t *testing.T) {
-	for _, name := range []string{"sip", "dip", "dport", "proto"} {
+	for _, name := range []string{"sip", "dip", "dport", "proto", "ipv"} {
 		attrib, err := NewAttribute(name)
 		if err != nil {
 			t.Fatalf("Unexpected error: %s", err)
@@ -78,6 +128,37 @@ func TestNewAttribute(t *testing.T) {
 	if err == nil {
 		t.Fatalf("Expected error")
 	}
+
+	var networkTests = []struct {
+		name    string
+		want    Attribute
+		success bool
+	}{
+		{"snet", SnetAttribute{PrefixLengths{DefaultNetPrefixLenV4, DefaultNetPrefixLenV6}}, true},
+		{"snet/16", SnetAttribute{PrefixLengths{16, DefaultNetPrefixLenV6}}, true},
+		{"dnet/48", DnetAttribute{PrefixLengths{DefaultNetPrefixLenV4, 48}}, true},
+		{"dnet/32/56", DnetAttribute{PrefixLengths{32, 56}}, true},
+		{"snet/0/0", SnetAttribute{PrefixLengths{0, 0}}, true},
+		{"snet/", nil, false},
+		{"snet/129", nil, false},
+		{"snet/33/64", nil, false},
+		{"snet/24/129", nil, false},
+		{"snet/-1", nil, false},
+		{"snet/24/64/8", nil, false},
+		{"dnet/x", nil, false},
+	}
+	for _, test := range networkTests {
+		attrib, err := NewAttribute(test.name)
+		if test.success && err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+		if !test.success && err == nil {
+			t.Fatalf("%s: expected error", test.name)
+		}
+		if attrib != test.want {
+			t.Fatalf("%s: want %v, have %v", test.name, test.want, attrib)
+		}
+	}
 }
 
 var parseQueryTypeTests = []struct {
@@ -100,6 +181,16 @@ var parseQueryTypeTests = []struct {
 	{"talk_src,dip", []Attribute{SipAttribute{}, DipAttribute{}, DportAttribute{}}, false, false, false},
 	{"talk_src,src", []Attribute{SipAttribute{}, DipAttribute{}, DportAttribute{}}, false, false, false},
 	{"raw", []Attribute{SipAttribute{}, DipAttribute{}, DportAttribute{}, ProtoAttribute{}}, true, true, true},
+	{"time:1h,dport", []Attribute{DportAttribute{}}, true, false, true},
+	{"dport,time:7d", []Attribute{DportAttribute{}}, true, false, true},
+	{"time:7m,dport", nil, false, false, false},
+	{"time:,dport", nil, false, false, false},
+	{"snet/24,dport", []Attribute{SnetAttribute{PrefixLengths{24, 64}}, DportAttribute{}}, false, false, true},
+	{"snet/24,dnet/48,snet/24", []Attribute{SnetAttribute{PrefixLengths{24, 64}}, DnetAttribute{PrefixLengths{24, 48}}}, false, false, true},
+	{"snet/24,dip", []Attribute{SnetAttribute{PrefixLengths{24, 64}}, DipAttribute{}}, false, false, true},
+	{"snet/24,snet/16", nil, false, false, false},
+	{"sip,snet/24", nil, false, false, false},
+	{"dnet/16,dst", nil, false, false, false},
 }
 
 func TestParseQueryType(t *testing.T) {
@@ -21,6 +21,7 @@
 package goDB
 
 import (
+	"context"
 	"fmt"
 	"time"
 )
@@ -28,6 +29,12 @@ import (
 // ParseAndInstrumentConditional parses and instruments the given conditional string for evaluation.
 // This is the main external function related to conditionals.
 func ParseAndInstrumentConditional(conditional string, dnsTimeout time.Duration) (Node, error) {
+	return ParseAndInstrumentConditionalContext(context.Background(), conditional, dnsTimeout)
+}
+
+// ParseAndInstrumentConditionalContext is like ParseAndInstrumentConditional, but aborts
+// resolving the host names referenced by the conditional once ctx is done
+func ParseAndInstrumentConditionalContext(ctx context.Context, conditional string, dnsTimeout time.Duration) (Node, error) {
 	tokenList, err := TokenizeConditional(conditional)
 	if err != nil {
 		return nil, err
@@ -43,7 +50,7 @@ func ParseAndInstrumentConditional(conditional string, dnsTimeout time.Duration)
 			return nil, err
 		}
 
-		if conditionalNode, err = resolve(conditionalNode, dnsTimeout); err != nil {
+		if conditionalNode, err = resolve(ctx, conditionalNode, dnsTimeout); err != nil {
 			return nil, err
 		}
 
@@ -73,20 +80,34 @@ type Node interface {
 	// instrument before calling this.
 	evaluate(*ExtraKey) bool
 
+	// Evaluates the conditional for all numEntries flows of a block at once,
+	// overwriting sel with the flows satisfying it. Temporary selections are
+	// taken from scratch. Make sure that you called instrument before calling this.
+	selectRows(blocks *[ColIdxCount][]byte, numEntries int, sel selection, scratch *selectionScratch)
+
 	// Returns the set of attributes used in the conditional.
 	attributes() map[string]struct{}
+
+	// Conservatively evaluates the conditional against the statistics of a
+	// block. Returns false only if no flow in the block can satisfy the
+	// conditional. Make sure that you called instrument before calling this.
+	mayMatch(*blockStats) bool
 }
 
 type conditionNode struct {
-	attribute    string
-	comparator   string
-	value        string
-	currentValue []byte
-	compareValue func(*ExtraKey) bool
+	attribute  string
+	comparator string
+	value      string
+	// values of the set of membership conditions ("in" and "!in")
+	values        []string
+	currentValue  []byte
+	compareValue  func(*ExtraKey) bool
+	selectValues  func(*[ColIdxCount][]byte, int, selection)
+	mayMatchStats func(*blockStats) bool
 }
 
 func newConditionNode(attribute, comparator, value string) conditionNode {
-	return conditionNode{attribute, comparator, value, nil, nil}
+	return conditionNode{attribute, comparator, value, nil, nil, nil, nil, nil}
 }
 func (n conditionNode) String() string {
 	return fmt.Sprintf("%s %s %s", n.attribute, n.comparator, n.value)
@@ -104,11 +125,23 @@ func (n conditionNode) instrument() (Node, error) {
 func (n conditionNode) evaluate(comparisonValue *ExtraKey) bool {
 	return n.compareValue(comparisonValue)
 }
+func (n conditionNode) selectRows(blocks *[ColIdxCount][]byte, numEntries int, sel selection, scratch *selectionScratch) {
+	for w := range sel {
+		sel[w] = 0
+	}
+	n.selectValues(blocks, numEntries, sel)
+}
 func (n conditionNode) attributes() map[string]struct{} {
 	return map[string]struct{}{
 		n.attribute: struct{}{},
 	}
 }
+func (n conditionNode) mayMatch(stats *blockStats) bool {
+	if n.mayMatchStats == nil {
+		return true
+	}
+	return n.mayMatchStats(stats)
+}
 
 type notNode struct {
 	node Node
@@ -131,9 +164,23 @@ func (n notNode) transform(transformer func(conditionNode) (Node, error)) (Node,
 func (n notNode) evaluate(comparisonValue *ExtraKey) bool {
 	return !n.node.evaluate(comparisonValue)
 }
+func (n notNode) selectRows(blocks *[ColIdxCount][]byte, numEntries int, sel selection, scratch *selectionScratch) {
+	n.node.selectRows(blocks, numEntries, sel, scratch)
+	for w := range sel {
+		sel[w] = ^sel[w]
+	}
+	if len(sel) > 0 {
+		sel[len(sel)-1] &= lastWordMask(numEntries)
+	}
+}
 func (n notNode) attributes() map[string]struct{} {
 	return n.node.attributes()
 }
+func (n notNode) mayMatch(stats *blockStats) bool {
+	// the negation of "may match" is not conservative, hence nothing can be ruled out.
+	// This case does not occur for conditionals in negation normal form
+	return true
+}
 
 type andNode struct {
 	left  Node
@@ -166,6 +213,22 @@ func (n andNode) transform(transformer func(conditionNode) (Node, error)) (Node,
 func (n andNode) evaluate(comparisonValue *ExtraKey) bool {
 	return n.left.evaluate(comparisonValue) && n.right.evaluate(comparisonValue)
 }
+func (n andNode) selectRows(blocks *[ColIdxCount][]byte, numEntries int, sel selection, scratch *selectionScratch) {
+	n.left.selectRows(blocks, numEntries, sel, scratch)
+	// no need to evaluate the right side if no flow is left
+	if sel.empty() {
+		return
+	}
+	right := scratch.get(numEntries)
+	n.right.selectRows(blocks, numEntries, right, scratch)
+	for w := range sel {
+		sel[w] &= right[w]
+	}
+	scratch.put(right)
+}
+func (n andNode) mayMatch(stats *blockStats) bool {
+	return n.left.mayMatch(stats) && n.right.mayMatch(stats)
+}
 func (n andNode) attributes() map[string]struct{} {
 	result := n.left.attributes()
 	for attribute := range n.right.attributes() {
@@ -205,6 +268,18 @@ func (n orNode) transform(transformer func(conditionNode) (Node, error)) (Node,
 func (n orNode) evaluate(comparisonValue *ExtraKey) bool {
 	return n.left.evaluate(comparisonValue) || n.right.evaluate(comparisonValue)
 }
+func (n orNode) selectRows(blocks *[ColIdxCount][]byte, numEntries int, sel selection, scratch *selectionScratch) {
+	n.left.selectRows(blocks, numEntries, sel, scratch)
+	right := scratch.get(numEntries)
+	n.right.selectRows(blocks, numEntries, right, scratch)
+	for w := range sel {
+		sel[w] |= right[w]
+	}
+	scratch.put(right)
+}
+func (n orNode) mayMatch(stats *blockStats) bool {
+	return n.left.mayMatch(stats) || n.right.mayMatch(stats)
+}
 func (n orNode) attributes() map[string]struct{} {
 	result := n.left.attributes()
 	for attribute := range n.right.attributes() {
@@ -242,6 +317,10 @@ func negationNormalForm(node Node) Node {
 					node.comparator = ">"
 				case ">=":
 					node.comparator = "<"
+				case "in":
+					node.comparator = "!in"
+				case "!in":
+					node.comparator = "in"
 				}
 				return node
 			}
@@ -14,15 +14,15 @@
 package goDB
 
 import (
+	"context"
 	"encoding/binary"
-	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"time"
 
-	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
 	"github.com/els0r/log"
 )
 
@@ -40,6 +40,10 @@ type DBWorkload struct {
 	query   *Query
 	workDir string
 	load    []int64
+
+	// rollup denotes the rollup table which may serve the workload instead of its blocks
+	// (if the query covers the whole day)
+	rollup *RollupTable
 }
 
 // DBWorkManager schedules parallel processing of blocks relevant for a query
@@ -49,11 +53,12 @@ type DBWorkManager struct {
 	workloads          []DBWorkload
 	numProcessingUnits int
 
+	store  storage.Store
 	logger log.Logger
 }
 
 // NewDBWorkManager sets up a new work manager for executing queries
-func NewDBWorkManager(dbpath string, iface string, numProcessingUnits int) (*DBWorkManager, error) {
+func NewDBWorkManager(dbpath string, iface string, numProcessingUnits int, opts ...Option) (*DBWorkManager, error) {
 	// whenever a new workload is created the logging facility is set up. Make sure to honor environments where syslog may not be available
 	loggerStr := os.Getenv("GODB_LOGGER")
 	if !(loggerStr == "devnull" || loggerStr == "console") {
@@ -64,7 +69,9 @@ func NewDBWorkManager(dbpath string, iface string, numProcessingUnits int) (*DBW
 		return nil, err
 	}
 
-	return &DBWorkManager{filepath.Join(dbpath, iface), iface, []DBWorkload{}, numProcessingUnits, l}, nil
+	o := applyOptions(opts)
+
+	return &DBWorkManager{filepath.Join(dbpath, iface), iface, []DBWorkload{}, numProcessingUnits, o.store, l}, nil
 }
 
 // GetNumWorkers returns the number of workloads available to the outside world for loop bounds etc.
@@ -86,60 +93,63 @@ func (w *DBWorkManager) GetCoveredTimeInterval() (time.Time, time.Time) {
 
 // CreateWorkerJobs sets up all workloads for query execution
 func (w *DBWorkManager) CreateWorkerJobs(tfirst int64, tlast int64, query *Query) (nonempty bool, err error) {
-	// Get list of files in directory
-	var dirList []os.FileInfo
+	// Get list of directories in the interface directory
+	var dirList []string
 
-	if dirList, err = ioutil.ReadDir(w.dbIfaceDir); err != nil {
+	if dirList, err = w.store.ReadDir(w.dbIfaceDir); err != nil {
 		return false, err
 	}
 
-	// loop over directory list in order to create the timestamp pairs
-	var (
-		infoFile *gpfile.GPFile
-		dirName  string
-	)
+	// the block timestamps are taken from the time index of the interface (if available),
+	// so that the column files only have to be opened for days missing from the index
+	index, indexErr := ReadTimeIndex(w.store, w.dbIfaceDir)
+	if indexErr != nil && !os.IsNotExist(indexErr) {
+		w.logger.Warnf("Failed to read time index of %s, scanning block headers: %s", w.iface, indexErr)
+	}
+
+	// days covered completely by the time range may be read from a rollup table
+	rollup := query.rollupTable()
 
 	// make sure to start with zero workloads as the number of assigned
 	// workloads depends on how many directories have to be read
 	numDirs := 0
-	for _, file := range dirList {
-		if file.IsDir() && (file.Name() != "./" || file.Name() != "../") {
-			dirName = file.Name()
-			tempdirTstamp, _ := strconv.ParseInt(dirName, 10, 64)
-
-			// check if the directory is within time frame of interest
-			if tfirst < tempdirTstamp+EpochDay && tempdirTstamp < tlast+DBWriteInterval {
-				numDirs++
-
-				// create new workload for the directory
-				workload := DBWorkload{query: query, workDir: dirName, load: []int64{}}
-
-				// retrieve all the relevant timestamps from one of the database files.
-				path := filepath.Join(w.dbIfaceDir, dirName, "bytes_rcvd.gpf")
-				if infoFile, err = gpfile.New(path, gpfile.ModeRead); err != nil {
-					return false, fmt.Errorf("Could not read file: %s: %s", path, err)
-				}
+	for _, dirName := range dirList {
+		tempdirTstamp, _ := strconv.ParseInt(dirName, 10, 64)
+
+		// check if the directory is within time frame of interest
+		if tfirst < tempdirTstamp+EpochDay && tempdirTstamp < tlast+DBWriteInterval {
+			numDirs++
 
-				// add the relevant timestamps to the workload's list
-				blockHeader, err := infoFile.Blocks()
+			// create new workload for the directory
+			workload := DBWorkload{query: query, workDir: dirName, load: []int64{}}
+			if tfirst < tempdirTstamp && tempdirTstamp+EpochDay <= tlast+DBWriteInterval {
+				workload.rollup = rollup
+			}
+
+			// add the relevant timestamps to the workload's list
+			var indexed bool
+			if indexErr == nil {
+				workload.load, indexed = index.DayBlocks(tempdirTstamp, tfirst, tlast)
+			}
+			if !indexed {
+				timestamps, err := dayBlockTimestamps(w.store, filepath.Join(w.dbIfaceDir, dirName))
 				if err != nil {
-					return false, fmt.Errorf("Could not get blocks from file: %s: %s", path, err)
+					return false, err
 				}
-				for _, block := range blockHeader.OrderedList() {
-					if tfirst < block.Timestamp && block.Timestamp < tlast+DBWriteInterval {
-						workload.load = append(workload.load, block.Timestamp)
+				for _, ts := range timestamps {
+					if tfirst < ts && ts < tlast+DBWriteInterval {
+						workload.load = append(workload.load, ts)
 					}
 				}
-				infoFile.Close()
-
-				// Assume we have a directory with timestamp td.
-				// Assume that the first block in the directory has timestamp td + 10.
-				// When tlast = td + 5, we have to scan the directory for blocks and create
-				// a workload that has an empty load list. The rest of the code assumes
-				// that the load isn't empty, so we check for this case here.
-				if len(workload.load) > 0 {
-					w.workloads = append(w.workloads, workload)
-				}
+			}
+
+			// Assume we have a directory with timestamp td.
+			// Assume that the first block in the directory has timestamp td + 10.
+			// When tlast = td + 5, we have to scan the directory for blocks and create
+			// a workload that has an empty load list. The rest of the code assumes
+			// that the load isn't empty, so we check for this case here.
+			if len(workload.load) > 0 {
+				w.workloads = append(w.workloads, workload)
 			}
 		}
 	}
@@ -147,8 +157,16 @@ func (w *DBWorkManager) CreateWorkerJobs(tfirst int64, tlast int64, query *Query
 	return 0 < len(w.workloads), err
 }
 
+// readJob denotes a workload along with the work manager (i.e. interface) it belongs to
+// and the ID the interface is interned as in the compact keys of the flows
+type readJob struct {
+	w        *DBWorkManager
+	ifaceID  int
+	workload DBWorkload
+}
+
 // main query processing
-func (w *DBWorkManager) grabAndProcessWorkload(workloadChan <-chan DBWorkload, mapChan chan map[ExtraKey]Val, cancel <-chan struct{}) <-chan struct{} {
+func processReadJobs(ctx context.Context, jobChan <-chan readJob, mapChan chan map[CompactKey]*Val) <-chan struct{} {
 
 	done := make(chan struct{})
 
@@ -160,20 +178,24 @@ func (w *DBWorkManager) grabAndProcessWorkload(workloadChan <-chan DBWorkload, m
 		// parse conditions
 		var err error
 
-		var workload DBWorkload
+		var job readJob
 		for chanOpen := true; chanOpen; {
 			select {
-			case <-cancel:
+			case <-ctx.Done():
 				return
-			case workload, chanOpen = <-workloadChan:
+			case job, chanOpen = <-jobChan:
 				if chanOpen {
 					// create the map in which the workload will store the aggregations
-					resultMap := make(map[ExtraKey]Val)
+					resultMap := make(map[CompactKey]*Val)
 
 					// if there is an error during one of the read jobs, throw a syslog message and terminate
-					if err = w.readBlocksAndEvaluate(workload, resultMap); err != nil {
+					if err = job.w.readBlocksAndEvaluate(ctx, job.workload, job.ifaceID, resultMap); err != nil {
 
-						w.logger.Error(err.Error())
+						// a canceled query is reported by ExecuteReadJobs
+						if ctx.Err() != nil {
+							return
+						}
+						job.w.logger.Error(err.Error())
 						mapChan <- nil
 						return
 					}
@@ -187,58 +209,91 @@ func (w *DBWorkManager) grabAndProcessWorkload(workloadChan <-chan DBWorkload, m
 	return done
 }
 
-type workerCommunication struct {
-	cancel chan struct{}
-	done   <-chan struct{}
+// ExecuteWorkerReadJobs runs the query concurrently with multiple sprocessing units. The
+// workers are stopped if ctx is done or a memory error is received, in which case the
+// respective error is returned once all of them have finished
+func (w *DBWorkManager) ExecuteWorkerReadJobs(ctx context.Context, mapChan chan map[CompactKey]*Val, memErrors <-chan error) error {
+	return ExecuteReadJobs(ctx, []*DBWorkManager{w}, w.numProcessingUnits, mapChan, memErrors)
 }
 
-// ExecuteWorkerReadJobs runs the query concurrently with multiple sprocessing units
-func (w *DBWorkManager) ExecuteWorkerReadJobs(mapChan chan map[ExtraKey]Val, memErrors <-chan error) error {
-
-	workloadChan := make(chan DBWorkload, len(w.workloads))
-
-	var controlChannels []workerCommunication
-
-	for i := 0; i < w.numProcessingUnits; i++ {
-		comms := workerCommunication{cancel: make(chan struct{}, 1)}
+// ExecuteReadJobs runs the queries of several work managers (e.g. one per interface) in a
+// shared pool of numWorkers processing units. The workloads of the work managers are
+// scheduled in turns, so all interfaces progress at the same pace and small ones don't
+// wait for large ones to finish. The workers are stopped if ctx is done or a memory error
+// is received, in which case the respective error is returned once all of them have finished.
+// The interface of each flow is interned as the index of its work manager in workManagers
+// (see Query.DecodeKey)
+func ExecuteReadJobs(ctx context.Context, workManagers []*DBWorkManager, numWorkers int, mapChan chan map[CompactKey]*Val, memErrors <-chan error) error {
+	if len(workManagers) == 0 {
+		return ctx.Err()
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	logger := workManagers[0].logger
 
-		// start worker up
-		comms.done = w.grabAndProcessWorkload(workloadChan, mapChan, comms.cancel)
+	// workerCtx allows to stop the workers upon memory errors as well
+	workerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-		controlChannels = append(controlChannels, comms)
+	// push the workloads onto the channel, taking turns between the work managers
+	var numJobs int
+	for _, w := range workManagers {
+		numJobs += len(w.workloads)
+	}
+	jobChan := make(chan readJob, numJobs)
+	for i := 0; numJobs > 0; i++ {
+		for ifaceID, w := range workManagers {
+			if i < len(w.workloads) {
+				jobChan <- readJob{w: w, ifaceID: ifaceID, workload: w.workloads[i]}
+				numJobs--
+			}
+		}
 	}
+	close(jobChan)
 
-	// push the workloads onto the channel
-	for _, workload := range w.workloads {
-		workloadChan <- workload
+	var doneChannels []<-chan struct{}
+	for i := 0; i < numWorkers; i++ {
+		// start worker up
+		doneChannels = append(doneChannels, processReadJobs(workerCtx, jobChan, mapChan))
 	}
-	close(workloadChan)
 
-	// check if the workers are done and also monitor memory
+	// check if the workers are done and also monitor memory and cancellation
 	var (
 		err       error
 		completed int
+		ctxDone   = ctx.Done()
 	)
 	for {
-		for i, c := range controlChannels {
+		for i, done := range doneChannels {
 			select {
-			case err = <-memErrors:
-				if err != nil {
+			case memErr := <-memErrors:
+				if memErr != nil && err == nil {
 					// log the memory error and assign type memory breach
 					// for callers of this function
-					w.logger.Error(err)
+					logger.Error(memErr)
+					err = memErr
 
-					// send cancel to all workers
-					for _, c := range controlChannels {
-						c.cancel <- struct{}{}
-					}
+					// cancel all workers
+					cancel()
 				}
-			case <-c.done:
+			case <-ctxDone:
+				// stop watching the (closed) channel
+				ctxDone = nil
+				if err == nil {
+					err = ctx.Err()
+				}
+				cancel()
+			case <-done:
 				completed++
-				w.logger.Debugf("worker %d finished, %d/%d are done", i, completed, w.numProcessingUnits)
+				logger.Debugf("worker %d finished, %d/%d are done", i, completed, numWorkers)
 
-				// return once done with processing
-				if completed == w.numProcessingUnits {
+				// return once done with processing. Workers may finish before the
+				// cancellation has been observed above
+				if completed == numWorkers {
+					if err == nil {
+						err = ctx.Err()
+					}
 					return err
 				}
 			}
@@ -261,61 +316,194 @@ var copyToKeyFns = [ColIdxAttributeCount]func(int, *ExtraKey, []byte){
 	func(i int, key *ExtraKey, bytes []byte) {
 		copy(key.Dport[:], bytes[i*DportSizeof:i*DportSizeof+DportSizeof])
 	},
+	func(i int, key *ExtraKey, bytes []byte) {
+		key.IPVersion = bytes[i]
+	},
 }
 
-// Block evaluation and aggregation -----------------------------------------------------
-// this is where the actual reading and aggregation magic happens
-func (w *DBWorkManager) readBlocksAndEvaluate(workload DBWorkload, resultMap map[ExtraKey]Val) error {
-	var err error
+// blockReader reads the blocks of a workload one after another, skipping blocks which
+// cannot satisfy the conditional of the query or which are broken
+type blockReader struct {
+	ctx      context.Context
+	w        *DBWorkManager
+	workload DBWorkload
+
+	// err holds the reason reading stopped early (i.e. the context being done)
+	err error
+
+	// columns holds the columns read for each block, i.e. the ones of the query and the
+	// addresses required for inferring unknown IP versions
+	columns     []columnIndex
+	columnFiles [ColIdxCount]storage.Backend
+	releasers   [ColIdxCount]storage.BlockReleaser
+	statsFile   storage.Backend
+
+	// pos denotes the position of the next block in the workload's load
+	pos        int
+	numSkipped int
+
+	// tstamp, blocks and numEntries describe the current block
+	tstamp     int64
+	blocks     [ColIdxCount][]byte
+	numEntries int
+
+	// unknownIPVersions provides a block of zeros for blocks lacking the IP versions of
+	// their flows (in days which haven't been migrated yet), indicating that the versions
+	// have to be inferred from the addresses. Address columns which aren't part of the
+	// query are only read for such blocks
+	readsIPVersions, ipVersionsUnknown bool
+	unknownIPVersions                  []byte
+
+	// rollup holds the rollup table read instead of the blocks (if available)
+	rollupFile storage.Backend
+	rollup     *rollupBlock
+}
 
+// newBlockReader opens the backends of the columns required by the workload's query.
+// Reading stops once ctx is done
+func (w *DBWorkManager) newBlockReader(ctx context.Context, workload DBWorkload) (*blockReader, error) {
 	var (
+		err   error
+		r     = &blockReader{ctx: ctx, w: w, workload: workload}
 		query = workload.query
-		dir   = workload.workDir
 	)
 
-	var key, comparisonValue ExtraKey
+	// Whole days are read from a rollup table if possible. Tables which are missing or
+	// outdated are skipped in favor of the blocks
+	if workload.rollup != nil {
+		if r.rollupFile, r.rollup, err = w.readRollup(workload); err == nil {
+			w.logger.Debugf("[D %s] Reading rollup table %s", workload.workDir, workload.rollup.Name)
+			return r, nil
+		}
+		w.logger.Debugf("[D %s] Rollup table %s unavailable: %s", workload.workDir, workload.rollup.Name, err)
+	}
 
-	// Load the GPFiles corresponding to the columns we need for the query. Each file is loaded at most once.
-	var columnFiles [ColIdxCount]*gpfile.GPFile
+	// Load the backends corresponding to the columns we need for the query. Each backend is loaded at most once.
+	r.columns = append(r.columns, query.columnIndizes...)
 	for _, colIdx := range query.columnIndizes {
-		if columnFiles[colIdx], err = gpfile.New(filepath.Join(w.dbIfaceDir, dir, columnFileNames[colIdx]+".gpf"), gpfile.ModeRead); err == nil {
-			defer columnFiles[colIdx].Close()
-		} else {
+		r.readsIPVersions = r.readsIPVersions || colIdx == IPVersionColIdx
+		if err = r.openColumn(colIdx); err != nil {
+			// days written before the IP version was stored explicitly lack its column
+			if colIdx == IPVersionColIdx {
+				w.logger.Debugf("[D %s] IP versions unavailable, inferring them from the addresses: %s", workload.workDir, err)
+				continue
+			}
+			r.close()
+			return nil, err
+		}
+	}
+
+	// Load the block statistics if there is a conditional that may allow to skip blocks.
+	// Older databases do not provide them, in which case all blocks are processed
+	if query.Conditional != nil {
+		if r.statsFile, err = w.store.Open(filepath.Join(w.dbIfaceDir, workload.workDir, BlockStatsFileName+".gpf"), storage.ModeRead, encoders.EncoderTypeLZ4); err != nil {
+			r.statsFile = nil
+		}
+	}
+
+	return r, nil
+}
+
+// openColumn opens the backend of the column of the workload's day
+func (r *blockReader) openColumn(colIdx columnIndex) (err error) {
+	backend, err := r.w.store.Open(filepath.Join(r.w.dbIfaceDir, r.workload.workDir, columnFileNames[colIdx]+".gpf"), storage.ModeRead, encoders.EncoderTypeLZ4)
+	if err != nil {
+		return err
+	}
+	r.columnFiles[colIdx] = backend
+	r.releasers[colIdx], _ = backend.(storage.BlockReleaser)
+	return nil
+}
+
+// readAddressBlocks reads the blocks of the address columns which aren't part of the
+// query, which are required for inferring the IP versions of the flows of the block.
+// Their backends are opened on first use
+func (r *blockReader) readAddressBlocks(tstamp int64) (err error) {
+	for _, colIdx := range []columnIndex{SipColIdx, DipColIdx} {
+		if r.columnFiles[colIdx] != nil {
+			continue
+		}
+		if err = r.openColumn(colIdx); err != nil {
+			return err
+		}
+		r.columns = append(r.columns, colIdx)
+		if r.blocks[colIdx], err = r.columnFiles[colIdx].ReadBlock(tstamp); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	// Process the workload
-	// The workload consists of timestamps whose blocks we should process.
-	for b, tstamp := range workload.load {
+// next advances to the next block of the workload. The buffers of the current block are
+// handed back to the backends they were read from (if they are pooled). Returns false
+// once all blocks have been read or reading has been canceled (see err)
+func (r *blockReader) next() bool {
+	r.releaseBlocks()
+	if r.err = r.ctx.Err(); r.err != nil {
+		return false
+	}
+
+	var (
+		err   error
+		w     = r.w
+		query = r.workload.query
+		dir   = r.workload.workDir
+	)
 
-		var (
-			blocks      [ColIdxCount][]byte
-			blockBroken = false
-		)
+	// a rollup table replaces all blocks of the workload
+	if r.rollup != nil {
+		if r.pos == len(r.workload.load) {
+			return false
+		}
+		r.pos = len(r.workload.load)
+		r.tstamp, r.numEntries, r.blocks = r.workload.load[r.pos-1], r.rollup.numEntries, r.rollup.blocks
+		return true
+	}
+
+	for ; r.pos < len(r.workload.load); r.pos++ {
+		b, tstamp := r.pos, r.workload.load[r.pos]
+
+		if r.statsFile != nil && !w.blockMayMatch(r.statsFile, tstamp, query.Conditional) {
+			r.numSkipped++
+			continue
+		}
 
-		for _, colIdx := range query.columnIndizes {
+		blockBroken := false
+		for _, colIdx := range r.columns {
+			if r.columnFiles[colIdx] == nil {
+				continue
+			}
 
 			// Read the block from the file
-			if blocks[colIdx], err = columnFiles[colIdx].ReadBlock(tstamp); err != nil {
+			if r.blocks[colIdx], err = r.columnFiles[colIdx].ReadBlock(tstamp); err != nil {
+				// blocks written before the IP version was stored explicitly lack it
+				if colIdx == IPVersionColIdx {
+					r.blocks[colIdx] = nil
+					continue
+				}
 				blockBroken = true
 				w.logger.Warnf("[D %s; B %d] Failed to read %s.gpf: %s", dir, tstamp, columnFileNames[colIdx], err.Error())
 				break
 			}
 		}
 
-		if query.hasAttrTime {
-			key.Time = tstamp
-		}
-
-		if query.hasAttrIface {
-			key.Iface = w.iface
-		}
-
 		// Check whether all blocks have matching number of entries
-		numEntries := int(len(blocks[BytesRcvdColIdx]) / 8)
-		for _, colIdx := range query.columnIndizes {
-			l := len(blocks[colIdx])
+		numEntries := int(len(r.blocks[BytesRcvdColIdx]) / 8)
+		if r.readsIPVersions && r.blocks[IPVersionColIdx] == nil && !blockBroken {
+			if err = r.readAddressBlocks(tstamp); err != nil {
+				blockBroken = true
+				w.logger.Warnf("[D %s; B %d] Failed to read addresses for inferring IP versions: %s", dir, tstamp, err.Error())
+			}
+			if cap(r.unknownIPVersions) < numEntries {
+				r.unknownIPVersions = make([]byte, numEntries)
+			}
+			r.blocks[IPVersionColIdx], r.ipVersionsUnknown = r.unknownIPVersions[:numEntries], true
+		}
+		for _, colIdx := range r.columns {
+			if blockBroken {
+				break
+			}
+			l := len(r.blocks[colIdx])
 			if l/columnSizeofs[colIdx] != numEntries {
 				blockBroken = true
 				w.logger.Warnf("[Bl %d] Incorrect number of entries in file [%s.gpf]. Expected %d, found %d", b, columnFileNames[colIdx], numEntries, l/columnSizeofs[colIdx])
@@ -330,49 +518,142 @@ func (w *DBWorkManager) readBlocksAndEvaluate(workload DBWorkload, resultMap map
 
 		// In case any error was observed during above sanity checks, skip this whole block
 		if blockBroken {
+			r.releaseBlocks()
 			continue
 		}
 
-		// Iterate over block entries
-		for i := 0; i < numEntries; i++ {
-			// Populate key for current entry
-			for _, colIdx := range query.queryAttributeIndizes {
-				copyToKeyFns[colIdx](i, &key, blocks[colIdx])
-			}
+		r.tstamp, r.numEntries = tstamp, numEntries
+		r.pos++
+		return true
+	}
+	return false
+}
 
-			// Check whether conditional is satisfied for current entry
-			var conditionalSatisfied bool
-			if query.Conditional == nil {
-				conditionalSatisfied = true
-			} else {
-				// Populate comparison value for current entry
-				for _, colIdx := range query.conditionalAttributeIndizes {
-					copyToKeyFns[colIdx](i, &comparisonValue, blocks[colIdx])
-				}
+func (r *blockReader) releaseBlocks() {
+	// the columns of a rollup table are released along with the table
+	if r.rollup != nil {
+		r.blocks = [ColIdxCount][]byte{}
+		return
+	}
+	if r.ipVersionsUnknown {
+		r.blocks[IPVersionColIdx], r.ipVersionsUnknown = nil, false
+	}
+	for colIdx := range r.blocks {
+		if r.releasers[colIdx] != nil && r.blocks[colIdx] != nil {
+			r.releasers[colIdx].ReleaseBlock(r.blocks[colIdx])
+		}
+		r.blocks[colIdx] = nil
+	}
+}
+
+// close releases the current block and closes all backends
+func (r *blockReader) close() {
+	r.releaseBlocks()
+	for _, backend := range r.columnFiles {
+		if backend != nil {
+			backend.Close()
+		}
+	}
+	if r.statsFile != nil {
+		r.statsFile.Close()
+	}
+	if r.rollupFile != nil {
+		releaseRollup(r.rollupFile, r.rollup.data)
+	}
+	if r.numSkipped > 0 {
+		r.w.logger.Debugf("[D %s] Skipped %d of %d blocks based on block statistics", r.workload.workDir, r.numSkipped, len(r.workload.load))
+	}
+}
+
+// Block evaluation and aggregation -----------------------------------------------------
+// this is where the actual reading and aggregation magic happens
+func (w *DBWorkManager) readBlocksAndEvaluate(ctx context.Context, workload DBWorkload, ifaceID int, resultMap map[CompactKey]*Val) error {
+	query := workload.query
+
+	r, err := w.newBlockReader(ctx, workload)
+	if err != nil {
+		return err
+	}
+	defer r.close()
+
+	var (
+		// the key of a flow is assembled in keyBuf, starting with the prefix shared by
+		// all flows of a block
+		keyBuf    []byte
+		prefixLen int
+
+		// the aggregated values are allocated in chunks rather than one by one
+		vals []Val
 
-				conditionalSatisfied = query.Conditional.evaluate(&comparisonValue)
+		// the conditional is evaluated for all flows of a block at once
+		sel     selection
+		scratch selectionScratch
+	)
+
+	blocks := &r.blocks
+	aggregateEntry := func(i int) {
+		// Populate key for current entry
+		keyBuf = query.appendKeyAttributes(keyBuf[:prefixLen], blocks, i)
+
+		// Update aggregates. Looking up the key doesn't allocate, only adding it does
+		nBytesRcvd := binary.BigEndian.Uint64(blocks[BytesRcvdColIdx][i*8 : i*8+8])
+		nBytesSent := binary.BigEndian.Uint64(blocks[BytesSentColIdx][i*8 : i*8+8])
+		nPktsRcvd := binary.BigEndian.Uint64(blocks[PacketsRcvdColIdx][i*8 : i*8+8])
+		nPktsSent := binary.BigEndian.Uint64(blocks[PacketsSentColIdx][i*8 : i*8+8])
+
+		if val, exists := resultMap[CompactKey(keyBuf)]; exists {
+			val.NBytesRcvd += nBytesRcvd
+			val.NBytesSent += nBytesSent
+			val.NPktsRcvd += nPktsRcvd
+			val.NPktsSent += nPktsSent
+		} else {
+			if len(vals) == cap(vals) {
+				vals = make([]Val, 0, 1024)
 			}
+			vals = append(vals, Val{NBytesRcvd: nBytesRcvd, NBytesSent: nBytesSent, NPktsRcvd: nPktsRcvd, NPktsSent: nPktsSent})
+			resultMap[CompactKey(keyBuf)] = &vals[len(vals)-1]
+		}
+	}
 
-			if conditionalSatisfied {
-				// Update aggregates
-				var delta Val
-
-				delta.NBytesRcvd = binary.BigEndian.Uint64(blocks[BytesRcvdColIdx][i*8 : i*8+8])
-				delta.NBytesSent = binary.BigEndian.Uint64(blocks[BytesSentColIdx][i*8 : i*8+8])
-				delta.NPktsRcvd = binary.BigEndian.Uint64(blocks[PacketsRcvdColIdx][i*8 : i*8+8])
-				delta.NPktsSent = binary.BigEndian.Uint64(blocks[PacketsSentColIdx][i*8 : i*8+8])
-
-				if val, exists := resultMap[key]; exists {
-					val.NBytesRcvd += delta.NBytesRcvd
-					val.NBytesSent += delta.NBytesSent
-					val.NPktsRcvd += delta.NPktsRcvd
-					val.NPktsSent += delta.NPktsSent
-					resultMap[key] = val
-				} else {
-					resultMap[key] = delta
-				}
+	// Process the workload
+	// The workload consists of timestamps whose blocks we should process.
+	for r.next() {
+		keyBuf = query.appendKeyPrefix(keyBuf[:0], query.timeBucket(r.tstamp), ifaceID)
+		prefixLen = len(keyBuf)
+
+		if query.Conditional == nil {
+			for i := 0; i < r.numEntries; i++ {
+				aggregateEntry(i)
 			}
+			continue
 		}
+
+		// Select the entries satisfying the conditional and aggregate only those
+		sel = sel.resize(r.numEntries)
+		query.Conditional.selectRows(blocks, r.numEntries, sel, &scratch)
+		sel.forEach(aggregateEntry)
 	}
-	return nil
+
+	return r.err
+}
+
+// blockMayMatch checks whether the conditional may be satisfied by any flow in the block
+// stored at tstamp. If the block statistics are missing or cannot be read, the block has
+// to be processed
+func (w *DBWorkManager) blockMayMatch(statsFile storage.Backend, tstamp int64, conditional Node) bool {
+	data, err := statsFile.ReadBlock(tstamp)
+	if err != nil || len(data) == 0 {
+		return true
+	}
+	if releaser, ok := statsFile.(storage.BlockReleaser); ok {
+		defer releaser.ReleaseBlock(data)
+	}
+	stats, err := unmarshalBlockStats(data)
+	if err != nil {
+		return true
+	}
+	if stats.numFlows == 0 {
+		return false
+	}
+	return conditional.mayMatch(stats)
 }
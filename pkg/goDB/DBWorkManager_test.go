@@ -0,0 +1,255 @@
+package goDB
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+const (
+	benchmarkDays          = 14
+	benchmarkBlocksPerDay  = 48
+	benchmarkFlowsPerBlock = 500
+	benchmarkStart         = int64(1456358400)
+)
+
+// writeBenchmarkDB writes a database spanning benchmarkDays days to dbPath
+func writeBenchmarkDB(tb testing.TB, dbPath string) {
+	w := NewDBWriter(dbPath, "eth0", encoders.EncoderTypeLZ4)
+	for day := 0; day < benchmarkDays; day++ {
+		for block := 0; block < benchmarkBlocksPerDay; block++ {
+			ts := benchmarkStart + int64(day)*EpochDay + int64(block+1)*EpochDay/benchmarkBlocksPerDay
+
+			flowmap := make(AggFlowMap, benchmarkFlowsPerBlock)
+			for i := 0; i < benchmarkFlowsPerBlock; i++ {
+				var K Key
+				copy(K.Sip[:], net.IPv4(10, 0, byte(i/256), byte(i)).To4())
+				copy(K.Dip[:], net.IPv4(192, 168, byte(block), byte(i%64)).To4())
+				K.Dport = [2]byte{byte(i % 8), 80}
+				K.Protocol = 6
+				flowmap[K] = &Val{NBytesRcvd: uint64(i), NBytesSent: uint64(block), NPktsRcvd: 1, NPktsSent: 1}
+			}
+			if _, err := w.Write(flowmap, BlockMetadata{Timestamp: ts}, ts); err != nil {
+				tb.Fatalf("Failed to write flows: %s", err)
+			}
+		}
+	}
+}
+
+// evaluateRange runs a query for the given time range against the database at dbPath
+func evaluateRange(tb testing.TB, dbPath string, query *Query, tfirst, tlast int64, opts ...Option) map[ExtraKey]Val {
+	wm, err := NewDBWorkManager(dbPath, "eth0", 1, opts...)
+	if err != nil {
+		tb.Fatalf("Failed to create work manager: %s", err)
+	}
+	if _, err := wm.CreateWorkerJobs(tfirst, tlast, query); err != nil {
+		tb.Fatalf("Failed to create worker jobs: %s", err)
+	}
+
+	resultMap := make(map[CompactKey]*Val)
+	for _, workload := range wm.workloads {
+		if err := wm.readBlocksAndEvaluate(context.Background(), workload, 0, resultMap); err != nil {
+			tb.Fatalf("Failed to evaluate blocks: %s", err)
+		}
+	}
+	results := make(map[ExtraKey]Val)
+	addResults(results, query, resultMap, []string{"eth0"})
+	return results
+}
+
+// addResults decodes the compact keys of the aggregated flows and adds them to results
+func addResults(results map[ExtraKey]Val, query *Query, resultMap map[CompactKey]*Val, ifaces []string) {
+	for k, v := range resultMap {
+		key := query.DecodeKey(k, ifaces)
+		val := results[key]
+		val.NBytesRcvd += v.NBytesRcvd
+		val.NBytesSent += v.NBytesSent
+		val.NPktsRcvd += v.NPktsRcvd
+		val.NPktsSent += v.NPktsSent
+		results[key] = val
+	}
+}
+
+func benchmarkQuery(tb testing.TB, queryType string) *Query {
+	attributes, hasAttrTime, hasAttrIface, err := ParseQueryType(queryType)
+	if err != nil {
+		tb.Fatalf("Failed to parse query type: %s", err)
+	}
+	return NewQuery(attributes, nil, hasAttrTime, hasAttrIface)
+}
+
+func TestReadBlocksMmap(t *testing.T) {
+	dbPath := t.TempDir()
+	writeBenchmarkDB(t, dbPath)
+
+	query := benchmarkQuery(t, "sip,dip,time")
+	var (
+		tlast = benchmarkStart + 2*EpochDay
+		want  = evaluateRange(t, dbPath, query, benchmarkStart, tlast)
+		have  = evaluateRange(t, dbPath, query, benchmarkStart, tlast, WithStore(gpfile.NewStore(gpfile.WithMmap())))
+	)
+	if len(want) != 2*benchmarkBlocksPerDay*benchmarkFlowsPerBlock {
+		t.Fatalf("Unexpected number of results: %d", len(want))
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("Results differ between read modes")
+	}
+}
+
+// BenchmarkReadBlocksAndEvaluate evaluates queries over increasingly long time ranges,
+// reading the blocks either via seek / read calls or from memory-mapped files
+func BenchmarkReadBlocksAndEvaluate(b *testing.B) {
+	dbPath := b.TempDir()
+	writeBenchmarkDB(b, dbPath)
+
+	query := benchmarkQuery(b, "dport")
+	for _, mode := range []struct {
+		name string
+		opts []Option
+	}{
+		{"read", nil},
+		{"mmap", []Option{WithStore(gpfile.NewStore(gpfile.WithMmap()))}},
+	} {
+		for _, days := range []int{1, 7, benchmarkDays} {
+			b.Run(fmt.Sprintf("%s/%dd", mode.name, days), func(b *testing.B) {
+				b.ReportAllocs()
+				for n := 0; n < b.N; n++ {
+					evaluateRange(b, dbPath, query, benchmarkStart, benchmarkStart+int64(days)*EpochDay, mode.opts...)
+				}
+			})
+		}
+	}
+}
+
+func TestExecuteReadJobs(t *testing.T) {
+	dbPath := t.TempDir()
+
+	// interfaces of different sizes, the first one spanning several days
+	ifaces := map[string]int{"eth0": 3 * benchmarkBlocksPerDay, "eth1": 2, "t4_1": 5}
+	for iface, numBlocks := range ifaces {
+		w := NewDBWriter(dbPath, iface, encoders.EncoderTypeLZ4)
+		for block := 0; block < numBlocks; block++ {
+			ts := benchmarkStart + int64(block+1)*EpochDay/benchmarkBlocksPerDay
+			flowmap := make(AggFlowMap)
+			for i := 0; i < 20; i++ {
+				var K Key
+				copy(K.Sip[:], net.IPv4(10, 0, 0, byte(i)).To4())
+				K.Dport, K.Protocol, K.IPVersion = [2]byte{0, 80}, 6, IPv4
+				flowmap[K] = &Val{NBytesRcvd: uint64(block), NBytesSent: 1, NPktsRcvd: 1, NPktsSent: 1}
+			}
+			if _, err := w.Write(flowmap, BlockMetadata{Timestamp: ts}, ts); err != nil {
+				t.Fatalf("Failed to write flows: %s", err)
+			}
+		}
+	}
+
+	query := benchmarkQuery(t, "iface,sip")
+	var (
+		tlast        = benchmarkStart + 4*EpochDay
+		want         = make(map[ExtraKey]Val)
+		workManagers []*DBWorkManager
+		ifaceNames   []string
+	)
+	for iface := range ifaces {
+		wm, err := NewDBWorkManager(dbPath, iface, 1)
+		if err != nil {
+			t.Fatalf("Failed to create work manager: %s", err)
+		}
+		if _, err := wm.CreateWorkerJobs(benchmarkStart, tlast, query); err != nil {
+			t.Fatalf("Failed to create worker jobs: %s", err)
+		}
+		resultMap := make(map[CompactKey]*Val)
+		for _, workload := range wm.workloads {
+			if err := wm.readBlocksAndEvaluate(context.Background(), workload, len(workManagers), resultMap); err != nil {
+				t.Fatalf("Failed to evaluate blocks: %s", err)
+			}
+		}
+		workManagers, ifaceNames = append(workManagers, wm), append(ifaceNames, iface)
+		addResults(want, query, resultMap, ifaceNames)
+	}
+
+	for _, numWorkers := range []int{1, 4} {
+		mapChan := make(chan map[CompactKey]*Val, 1024)
+		if err := ExecuteReadJobs(context.Background(), workManagers, numWorkers, mapChan, nil); err != nil {
+			t.Fatalf("Failed to execute read jobs: %s", err)
+		}
+		close(mapChan)
+
+		have := make(map[ExtraKey]Val)
+		for m := range mapChan {
+			addResults(have, query, m, ifaceNames)
+		}
+		if len(want) != len(ifaces)*20 || !reflect.DeepEqual(have, want) {
+			t.Fatalf("Results of %d shared workers differ from processing the interfaces one by one", numWorkers)
+		}
+	}
+}
+
+// BenchmarkExecuteReadJobs compares processing the interfaces of the bundled test database
+// in a shared pool of workers with processing them one after another, each in a pool of
+// its own (as done before the pool was shared). The pools hold GOMAXPROCS workers, e.g.:
+//
+//	go test -run=^$ -bench=BenchmarkExecuteReadJobs -cpu=1,4
+func BenchmarkExecuteReadJobs(b *testing.B) {
+	ifaces, err := gpfile.NewStore().ReadDir(testDBPath)
+	if err != nil {
+		b.Fatalf("Failed to read test database: %s", err)
+	}
+
+	query := benchmarkQuery(b, "iface,sip,dip,dport")
+	var workManagers []*DBWorkManager
+	for _, iface := range ifaces {
+		wm, err := NewDBWorkManager(testDBPath, iface, runtime.GOMAXPROCS(0))
+		if err != nil {
+			b.Fatalf("Failed to create work manager: %s", err)
+		}
+		if _, err := wm.CreateWorkerJobs(0, benchmarkStart+4*EpochDay, query); err != nil {
+			b.Fatalf("Failed to create worker jobs: %s", err)
+		}
+		workManagers = append(workManagers, wm)
+	}
+
+	// run executes the read jobs of the work managers, aggregating their results into
+	// result like a query does
+	run := func(b *testing.B, workManagers []*DBWorkManager, result map[CompactKey]*Val) {
+		mapChan := make(chan map[CompactKey]*Val, 1024)
+		done := make(chan struct{})
+		go func() {
+			for m := range mapChan {
+				for k, v := range m {
+					if val, exists := result[k]; exists {
+						addVal(val, v)
+					} else {
+						result[k] = v
+					}
+				}
+			}
+			close(done)
+		}()
+		if err := ExecuteReadJobs(context.Background(), workManagers, runtime.GOMAXPROCS(0), mapChan, nil); err != nil {
+			b.Fatalf("Failed to execute read jobs: %s", err)
+		}
+		close(mapChan)
+		<-done
+	}
+
+	b.Run("shared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			run(b, workManagers, make(map[CompactKey]*Val))
+		}
+	})
+	b.Run("per_interface", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			result := make(map[CompactKey]*Val)
+			for _, wm := range workManagers {
+				run(b, []*DBWorkManager{wm}, result)
+			}
+		}
+	})
+}
@@ -11,7 +11,12 @@
 
 package goDB
 
-import "fmt"
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"unicode"
+)
 
 // Returns a desugared version of the receiver.
 func desugar(node Node) (Node, error) {
@@ -19,26 +24,42 @@ func desugar(node Node) (Node, error) {
 }
 
 func desugarConditionNode(node conditionNode) (Node, error) {
-	helper := func(name, src, dst, comparator, value string) (Node, error) {
+	helper := func(name, src, dst string, node conditionNode) (Node, error) {
 		var result Node
-		if comparator != "=" && comparator != "!=" {
-			return result, fmt.Errorf("Invalid comparison operator in %s condition: %s", name, comparator)
-		}
-
-		result = orNode{
-			left: conditionNode{
-				attribute:  src,
-				comparator: "=",
-				value:      value,
-			},
-			right: conditionNode{
-				attribute:  dst,
-				comparator: "=",
-				value:      value,
-			},
+		switch node.comparator {
+		case "=", "!=":
+			result = orNode{
+				left: conditionNode{
+					attribute:  src,
+					comparator: "=",
+					value:      node.value,
+				},
+				right: conditionNode{
+					attribute:  dst,
+					comparator: "=",
+					value:      node.value,
+				},
+			}
+		case "in", "!in":
+			result = orNode{
+				left: conditionNode{
+					attribute:  src,
+					comparator: "in",
+					value:      node.value,
+					values:     node.values,
+				},
+				right: conditionNode{
+					attribute:  dst,
+					comparator: "in",
+					value:      node.value,
+					values:     node.values,
+				},
+			}
+		default:
+			return result, fmt.Errorf("Invalid comparison operator in %s condition: %s", name, node.comparator)
 		}
 
-		if comparator == "!=" {
+		if node.comparator == "!=" || node.comparator == "!in" {
 			result = notNode{
 				node: result,
 			}
@@ -47,18 +68,68 @@ func desugarConditionNode(node conditionNode) (Node, error) {
 		return result, nil
 	}
 
+	// sets may reference files listing their values
+	if node.comparator == "in" || node.comparator == "!in" {
+		var err error
+		if node.values, err = expandListFiles(node.values); err != nil {
+			return nil, err
+		}
+	}
+
 	switch node.attribute {
 	case "src":
 		node.attribute = "sip"
 	case "dst":
 		node.attribute = "dip"
 	case "host":
-		return helper("host", "sip", "dip", node.comparator, node.value)
+		return helper("host", "sip", "dip", node)
 	case "net":
-		return helper("net", "snet", "dnet", node.comparator, node.value)
+		return helper("net", "snet", "dnet", node)
 	default:
 		// nothing to do
 	}
 
 	return node, nil
 }
+
+// expandListFiles replaces the references to list files among the values of a set
+// (e.g. "@/tmp/cidrs.txt") by the values listed in the files
+func expandListFiles(values []string) ([]string, error) {
+	var expanded []string
+	for _, value := range values {
+		if !strings.HasPrefix(value, "@") {
+			expanded = append(expanded, value)
+			continue
+		}
+
+		listed, err := readListFile(strings.TrimPrefix(value, "@"))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, listed...)
+	}
+	return expanded, nil
+}
+
+// readListFile reads the values listed in a file, separated by white space or commas.
+// Everything following a '#' on a line is treated as a comment
+func readListFile(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read list file: %s", err)
+	}
+
+	var values []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		values = append(values, strings.FieldsFunc(strings.ToLower(line), func(r rune) bool {
+			return unicode.IsSpace(r) || r == ','
+		})...)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("List file %s does not contain any values", path)
+	}
+	return values, nil
+}
@@ -10,7 +10,12 @@
 
 package goDB
 
-import "testing"
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
 
 var desugarTests = []struct {
 	inTokens []string
@@ -32,6 +37,21 @@ var desugarTests = []struct {
 		"!((sip = 192.168.178.1 & dip != 1.2.3.4))",
 		true,
 	},
+	{
+		[]string{"host", "in", "(", "192.168.178.1", ",", "10.0.0.1", ")", "&", "net", "!", "in", "10.0.0.0/8"},
+		"((sip in {192.168.178.1, 10.0.0.1} | dip in {192.168.178.1, 10.0.0.1}) & !((snet in {10.0.0.0/8} | dnet in {10.0.0.0/8})))",
+		true,
+	},
+	{
+		[]string{"src", "!", "in", "(", "192.168.178.1", ")"},
+		"sip !in {192.168.178.1}",
+		true,
+	},
+	{
+		[]string{"dport", "in", "@/nonexistent/ports.txt"},
+		"",
+		false,
+	},
 	{
 		[]string{"host", "<", "192.168.178.1/24"},
 		"",
@@ -69,3 +89,35 @@ func TestDesugar(t *testing.T) {
 		}
 	}
 }
+
+func TestDesugarListFiles(t *testing.T) {
+	dir := t.TempDir()
+	ports, empty := filepath.Join(dir, "ports.txt"), filepath.Join(dir, "empty.txt")
+	if err := ioutil.WriteFile(ports, []byte("# web\n80, 443\n8000-8080 # proxies\n\n\tSSH\n"), 0644); err != nil {
+		t.Fatalf("Failed to write list file: %s", err)
+	}
+	if err := ioutil.WriteFile(empty, []byte("# nothing\n"), 0644); err != nil {
+		t.Fatalf("Failed to write list file: %s", err)
+	}
+
+	node, err := parseConditional([]string{"dport", "in", "(", "22", ",", "@" + ports, ")"})
+	if err != nil {
+		t.Fatalf("Parsing unexpectly failed: %s", err)
+	}
+	desugaredNode, err := desugar(node)
+	if err != nil {
+		t.Fatalf("Unexpectedly failed to desugar: %s", err)
+	}
+	expected := []string{"22", "80", "443", "8000-8080", "ssh"}
+	if values := desugaredNode.(conditionNode).values; !reflect.DeepEqual(values, expected) {
+		t.Fatalf("Expected values: %v. Actual values: %v", expected, values)
+	}
+
+	node, err = parseConditional([]string{"dport", "in", "@" + empty})
+	if err != nil {
+		t.Fatalf("Parsing unexpectly failed: %s", err)
+	}
+	if _, err = desugar(node); err == nil {
+		t.Fatalf("Expected to fail on empty list file but didn't.")
+	}
+}
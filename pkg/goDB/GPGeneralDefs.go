@@ -73,25 +73,20 @@ func itod(i uint) string {
 
 /// END GOOGLE ///
 
-// RawIPToString converts the ip byte arrays to string. The formatting logic for IPv6
-// is directly copied over from the go IP package in order to save an
+// RawIPToString converts the ip byte arrays to string. ipVersion denotes whether the
+// address is an IPv4 address (stored in the first four bytes) or an IPv6 address. If it
+// is unknown (0), the version is inferred from the address. The formatting logic for
+// IPv6 is directly copied over from the go IP package in order to save an
 // additional import just for string operations
-func RawIPToString(ip []byte) string {
-	var (
-		numZeros uint8
-		iplen    = len(ip)
-	)
-
-	// count zeros in order to determine whether the address
-	// is IPv4 or IPv6
-	for i := 4; i < iplen; i++ {
-		if (ip[i] & 0xFF) == 0x00 {
-			numZeros++
-		}
+func RawIPToString(ip []byte, ipVersion byte) string {
+	iplen := len(ip)
+
+	if ipVersion == 0 {
+		ipVersion = inferIPVersion(ip, ip)
 	}
 
 	// construct ipv4 string
-	if numZeros == 12 {
+	if ipVersion == IPv4 {
 		return itod(uint(ip[0])) + "." +
 			itod(uint(ip[1])) + "." +
 			itod(uint(ip[2])) + "." +
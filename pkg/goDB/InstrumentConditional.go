@@ -18,6 +18,7 @@ package goDB
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"net"
 	"strconv"
@@ -48,10 +49,58 @@ func generateCompareValue(condition *conditionNode) error {
 		err     error
 	)
 
+	// sets are matched by lookups instead of comparisons
+	if isMembership(condition.comparator) {
+		return generateMembershipFns(condition)
+	}
+
 	if value, netmask, err = conditionBytesAndNetmask(*condition); err != nil {
 		return err
 	}
 
+	// allows skipping blocks based on their statistics
+	generateMayMatch(condition, value, netmask)
+
+	if err = generateCompareFn(condition, value, netmask); err != nil {
+		return err
+	}
+	if err = generateSelectFn(condition, value, netmask); err != nil {
+		return err
+	}
+
+	// addresses (and networks) only match if they are of the same IP version
+	switch condition.attribute {
+	case "sip", "dip", "snet", "dnet":
+		matchIPVersion(condition, conditionIPVersion(condition.value))
+		selectIPVersion(condition, conditionIPVersion(condition.value))
+	}
+	return nil
+}
+
+// matchIPVersion restricts the comparison of the condition to keys of the given IP version
+func matchIPVersion(condition *conditionNode, ipVersion byte) {
+	compareAddress := condition.compareValue
+	if condition.comparator == "=" {
+		condition.compareValue = func(currentValue *ExtraKey) bool {
+			return currentValue.Version() == ipVersion && compareAddress(currentValue)
+		}
+	} else {
+		condition.compareValue = func(currentValue *ExtraKey) bool {
+			return currentValue.Version() != ipVersion || compareAddress(currentValue)
+		}
+	}
+}
+
+// conditionIPVersion returns the IP version of the address or network of a condition
+func conditionIPVersion(value string) byte {
+	if strings.Contains(strings.Split(value, "/")[0], ".") {
+		return IPv4
+	}
+	return IPv6
+}
+
+// generateCompareFn generates the comparison of the condition's attribute with its value
+func generateCompareFn(condition *conditionNode, value []byte, netmask int) error {
 	// generate the function based on which attribute was provided. For a small
 	// amount of bytes, the check is performed directly in order to avoid the
 	// overhead induced by a for loop
@@ -272,11 +321,221 @@ func generateCompareValue(condition *conditionNode) error {
 		default:
 			return errors.New("Comparator \"" + condition.comparator + "\" not allowed for attribute \"" + condition.attribute + "\"")
 		}
+	case "ipv":
+		switch condition.comparator {
+		case "=":
+			condition.compareValue = func(currentValue *ExtraKey) bool {
+				return currentValue.Version() == value[0]
+			}
+			return nil
+		case "!=":
+			condition.compareValue = func(currentValue *ExtraKey) bool {
+				return currentValue.Version() != value[0]
+			}
+			return nil
+		default:
+			return errors.New("Comparator \"" + condition.comparator + "\" not allowed for attribute \"" + condition.attribute + "\"")
+		}
 	default:
 		return errors.New("Unknown attribute \"" + condition.attribute + "\"")
 	}
 }
 
+// isMembership checks whether the comparator tests the membership in a set of values
+func isMembership(comparator string) bool {
+	return comparator == "in" || comparator == "!in"
+}
+
+// generateMembershipFns generates the closures of a condition checking whether the value
+// of its attribute is in (or not in) a set of values, e.g. "dport in {80, 1000-2000}" or
+// "snet in {10.0.0.0/8, 192.168.1.1}". Ports and protocols are looked up in a bitmap of
+// all possible values, addresses in a radix tree of networks per IP version. Hence, the
+// evaluation is independent of the size of the set, allowing for long lists of values
+func generateMembershipFns(condition *conditionNode) error {
+	negate := condition.comparator == "!in"
+
+	switch condition.attribute {
+	case "dport", "proto":
+		max := uint64(0xffff)
+		if condition.attribute == "proto" {
+			max = 0xff
+		}
+
+		bitmap := make([]uint64, (max+64)/64)
+		lo, hi := max, uint64(0)
+		for _, value := range condition.values {
+			first, last, err := valueRange(condition.attribute, value)
+			if err != nil {
+				return err
+			}
+			for v := first; v <= last; v++ {
+				bitmap[v/64] |= 1 << (v % 64)
+			}
+			if first < lo {
+				lo = first
+			}
+			if last > hi {
+				hi = last
+			}
+		}
+		contains := func(v uint64) bool {
+			return bitmap[v/64]&(1<<(v%64)) != 0
+		}
+
+		if condition.attribute == "dport" {
+			condition.compareValue = func(currentValue *ExtraKey) bool {
+				return contains(uint64(binary.BigEndian.Uint16(currentValue.Dport[:]))) != negate
+			}
+			condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+				col := blocks[DportColIdx]
+				for i := 0; i < numEntries; i++ {
+					if contains(uint64(binary.BigEndian.Uint16(col[i*2:i*2+2]))) != negate {
+						sel[i>>6] |= 1 << uint(i&63)
+					}
+				}
+			}
+			if !negate {
+				loBytes, hiBytes := []byte{byte(lo >> 8), byte(lo)}, []byte{byte(hi >> 8), byte(hi)}
+				condition.mayMatchStats = func(s *blockStats) bool {
+					return rangeMayMatch("=", loBytes, hiBytes, s.dportMin[:], s.dportMax[:])
+				}
+			}
+			return nil
+		}
+
+		condition.compareValue = func(currentValue *ExtraKey) bool {
+			return contains(uint64(currentValue.Protocol)) != negate
+		}
+		condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+			col := blocks[ProtoColIdx]
+			for i := 0; i < numEntries; i++ {
+				if contains(uint64(col[i])) != negate {
+					sel[i>>6] |= 1 << uint(i&63)
+				}
+			}
+		}
+		if !negate {
+			loBytes, hiBytes := []byte{byte(lo)}, []byte{byte(hi)}
+			condition.mayMatchStats = func(s *blockStats) bool {
+				return rangeMayMatch("=", loBytes, hiBytes, []byte{s.protoMin}, []byte{s.protoMax})
+			}
+		}
+		return nil
+	case "sip", "dip", "snet", "dnet":
+		var (
+			networksV4, networksV6 = newPrefixSet(), newPrefixSet()
+			lo, hi                 []byte
+		)
+		for _, value := range condition.values {
+			network, prefixLen, err := networkBytesAndPrefixLen(value)
+			if err != nil {
+				return err
+			}
+			if conditionIPVersion(value) == IPv4 {
+				networksV4.insert(network[:4], prefixLen)
+			} else {
+				networksV6.insert(network, prefixLen)
+			}
+
+			first, last := netRange(network, prefixLen)
+			if lo == nil || bytes.Compare(first, lo) < 0 {
+				lo = first
+			}
+			if hi == nil || bytes.Compare(last, hi) > 0 {
+				hi = last
+			}
+		}
+
+		isSource := condition.attribute == "sip" || condition.attribute == "snet"
+		colIdx := SipColIdx
+		if !isSource {
+			colIdx = DipColIdx
+		}
+
+		condition.compareValue = func(currentValue *ExtraKey) bool {
+			addr := currentValue.Dip
+			if isSource {
+				addr = currentValue.Sip
+			}
+			if currentValue.Version() == IPv4 {
+				return networksV4.contains(addr[:4]) != negate
+			}
+			return networksV6.contains(addr[:]) != negate
+		}
+		condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+			col := blocks[colIdx]
+			for i := 0; i < numEntries; i++ {
+				var contained bool
+				if rowIPVersion(blocks, i) == IPv4 {
+					contained = networksV4.contains(col[i*16 : i*16+4])
+				} else {
+					contained = networksV6.contains(col[i*16 : i*16+16])
+				}
+				if contained != negate {
+					sel[i>>6] |= 1 << uint(i&63)
+				}
+			}
+		}
+		if !negate {
+			condition.mayMatchStats = func(s *blockStats) bool {
+				if isSource {
+					return rangeMayMatch("=", lo, hi, s.sipMin[:], s.sipMax[:])
+				}
+				return rangeMayMatch("=", lo, hi, s.dipMin[:], s.dipMax[:])
+			}
+		}
+		return nil
+	default:
+		return errors.New("Comparator \"" + condition.comparator + "\" not allowed for attribute \"" + condition.attribute + "\"")
+	}
+}
+
+// valueRange parses a port or protocol value of a set, which is either a single value or
+// a range of values (e.g. "1000-2000"), into the first and last value covered
+func valueRange(attribute, value string) (first, last uint64, err error) {
+	// protocol names may contain dashes themselves (e.g. "ipv6-icmp")
+	bounds := []string{value}
+	if _, _, err := conditionBytesAndNetmask(newConditionNode(attribute, "=", value)); err != nil && strings.Contains(value, "-") {
+		bounds = strings.SplitN(value, "-", 2)
+	}
+
+	var parsed [2]uint64
+	for i, bound := range bounds {
+		b, _, err := conditionBytesAndNetmask(newConditionNode(attribute, "=", bound))
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, v := range b {
+			parsed[i] = parsed[i]<<8 | uint64(v)
+		}
+	}
+	if len(bounds) == 1 {
+		return parsed[0], parsed[0], nil
+	}
+	if parsed[0] > parsed[1] {
+		return 0, 0, errors.New("Invalid range " + value + ": the lower bound exceeds the upper bound")
+	}
+	return parsed[0], parsed[1], nil
+}
+
+// networkBytesAndPrefixLen parses an address or network (in CIDR notation) of a set into
+// the network's (masked) address and its prefix length. Addresses are treated as networks
+// covering a single address
+func networkBytesAndPrefixLen(value string) ([]byte, int, error) {
+	if strings.Contains(value, "/") {
+		return conditionBytesAndNetmask(newConditionNode("snet", "=", value))
+	}
+
+	addr, _, err := conditionBytesAndNetmask(newConditionNode("sip", "=", value))
+	if err != nil {
+		return nil, 0, err
+	}
+	if conditionIPVersion(value) == IPv4 {
+		return addr, 32, nil
+	}
+	return addr, 128, nil
+}
+
 // conditionBytesAndNetmask returns the database's binary representation of the
 // value of the given condition. It also validates the condition using attribute specific
 // validation logic  (e.g. no IPv4 address with digits greater than 255).
@@ -361,6 +620,15 @@ func conditionBytesAndNetmask(condition conditionNode) ([]byte, int, error) {
 			}
 
 			condBytes = []byte{uint8(num >> 8), uint8(num & 0xff)}
+		case "ipv":
+			switch value {
+			case "4":
+				condBytes = []byte{IPv4}
+			case "6":
+				condBytes = []byte{IPv6}
+			default:
+				return nil, 0, errors.New("Could not parse ipv value: expected 4 or 6")
+			}
 		default:
 			return nil, 0, errors.New("Unknown attribute: " + attribute)
 		}
@@ -373,18 +641,26 @@ func conditionBytesAndNetmask(condition conditionNode) ([]byte, int, error) {
 
 // IPStringToBytes creates a goDB compatible bytes slice from an IP address string
 func IPStringToBytes(ip string) ([]byte, error) {
+	ipaddr, _, err := ParseIP(ip)
+	return ipaddr, err
+}
+
+// ParseIP creates a goDB compatible bytes slice from an IP address string and returns
+// it along with the IP version of the address
+func ParseIP(ip string) ([]byte, byte, error) {
 	var isIPv4 = strings.Contains(ip, ".")
 
 	ipaddr := net.ParseIP(ip)
 	if len(ipaddr) == 0 {
-		return nil, errors.New("IP parse: incorrect format")
+		return nil, 0, errors.New("IP parse: incorrect format")
 	}
 
 	if isIPv4 {
 		ipaddr[0], ipaddr[1], ipaddr[2], ipaddr[3] = ipaddr[12], ipaddr[13], ipaddr[14], ipaddr[15]
 		ipaddr[12], ipaddr[13], ipaddr[14], ipaddr[15] = 0, 0, 0, 0
 		ipaddr[10], ipaddr[11] = 0, 0 // Zero out v4InV6Prefix set by net.ParseIP
+		return ipaddr, IPv4, nil
 	}
 
-	return ipaddr, nil
+	return ipaddr, IPv6, nil
 }
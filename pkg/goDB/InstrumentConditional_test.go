@@ -11,6 +11,7 @@
 package goDB
 
 import (
+	"math/rand"
 	"reflect"
 	"testing"
 )
@@ -128,6 +129,12 @@ var conditionBytesAndNetmaskTests = []struct {
 
 	// wrong attribute
 	{conditionNode{attribute: "proto", comparator: "=", value: "leagueoflegends"}, nil, 0, false},
+
+	// ip version
+	{conditionNode{attribute: "ipv", comparator: "=", value: "4"}, []byte{4}, 0, true},
+	{conditionNode{attribute: "ipv", comparator: "!=", value: "6"}, []byte{6}, 0, true},
+	{conditionNode{attribute: "ipv", comparator: "=", value: "5"}, nil, 0, false},
+	{conditionNode{attribute: "ipv", comparator: "=", value: "ipv6"}, nil, 0, false},
 }
 
 func TestConditionBytesAndNetmask(t *testing.T) {
@@ -150,3 +157,257 @@ func TestConditionBytesAndNetmask(t *testing.T) {
 		}
 	}
 }
+
+var selectRowsConditionals = []string{
+	"dport = 80",
+	"dport != 80",
+	"dport < 443",
+	"dport <= 0",
+	"dport > 65535",
+	"dport >= 443",
+	"proto = tcp",
+	"proto < 17 | proto > 100",
+	"proto >= 255",
+	"sip = 10.0.0.1",
+	"dip != 10.0.0.1",
+	"sip = 2001:db8::",
+	"dip != 2001:db8::1",
+	"snet = 10.0.0.0/8",
+	"dnet != 10.128.0.0/9",
+	"snet = 0.0.0.0/0",
+	"dnet != 0.0.0.0/0",
+	"snet = 2001:db8::/32",
+	"dnet = 2001:db8:1::/63",
+	"snet != ::/0",
+	"ipv = 4",
+	"ipv != 4",
+	"ipv = 6",
+	"host = 10.0.0.1",
+	"net != 2001:db8::/48",
+	"!(dport = 80 & proto = 6) | snet = 10.0.0.0/9",
+	"(sip = 10.0.0.1 | dip = 10.0.0.2) & (dport = 443 | ipv = 6)",
+	"!(host = 192.168.1.1 | ipv = 6) & dport < 1024",
+	"dport in (22, 80, 1000-9000)",
+	"dport !in 0-1023",
+	"proto in (tcp, udp, ipv6-icmp)",
+	"sip in (10.0.0.0/8, 2001:db8::)",
+	"dnet !in (10.128.0.0/9, fe80::/10)",
+	"!(host in (192.168.1.1, 2001:db8::1) & dport in (80, 443))",
+}
+
+// membershipConditionals pairs conditionals on sets with equivalent ones using comparisons
+var membershipConditionals = []struct {
+	membership, comparisons string
+}{
+	{"dport in (80, 443)", "dport = 80 | dport = 443"},
+	{"dport in (22, 1000-2000, 8080)", "dport = 22 | (dport >= 1000 & dport <= 2000) | dport = 8080"},
+	{"dport !in (0-1023, 65535)", "dport > 1023 & dport != 65535"},
+	{"proto in (icmp, 6-17)", "proto = 1 | (proto >= 6 & proto <= 17)"},
+	{"sip in (10.0.0.1, 2001:db8::)", "sip = 10.0.0.1 | sip = 2001:db8::"},
+	{"dip !in (10.0.0.0/9, 192.168.1.1)", "dnet != 10.0.0.0/9 & dip != 192.168.1.1"},
+	{"snet in (10.0.0.0/8, 10.128.0.0/9, 2001:db8::/33)", "snet = 10.0.0.0/8 | snet = 2001:db8::/33"},
+	{"net in (2001:db8::/48, 192.168.0.0/16)", "net = 2001:db8::/48 | net = 192.168.0.0/16"},
+}
+
+func TestMembership(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	var scratch selectionScratch
+	for _, test := range membershipConditionals {
+		membership, err := ParseAndInstrumentConditional(test.membership, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse conditional %q: %s", test.membership, err)
+		}
+		comparisons, err := ParseAndInstrumentConditional(test.comparisons, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse conditional %q: %s", test.comparisons, err)
+		}
+
+		numEntries := 1000
+		blocks := randomBlocks(rng, numEntries)
+		want, have := selection(nil).resize(numEntries), selection(nil).resize(numEntries)
+		comparisons.selectRows(blocks, numEntries, want, &scratch)
+		membership.selectRows(blocks, numEntries, have, &scratch)
+		if !reflect.DeepEqual(want, have) {
+			t.Fatalf("Conditional %q selects different flows than %q", test.membership, test.comparisons)
+		}
+		if want.empty() {
+			t.Fatalf("Conditional %q doesn't select any flows", test.comparisons)
+		}
+	}
+}
+
+func TestMembershipErrors(t *testing.T) {
+	for _, conditional := range []string{
+		"ipv in (4, 6)",
+		"dport in 2000-1000",
+		"dport in (80, 70000)",
+		"proto in (tcp, nonexistent)",
+		"sip in (www.example.com)",
+		"snet in (10.0.0.0/33)",
+		"dport in (80",
+	} {
+		if _, err := ParseAndInstrumentConditional(conditional, 0); err == nil {
+			t.Fatalf("Expected conditional %q to fail", conditional)
+		}
+	}
+}
+
+// randomBlocks generates the columns of a block of numEntries flows drawn from a small
+// set of values, so that most conditionals are satisfied by some of them. The IP version
+// of some flows is unknown and has to be inferred from their addresses
+func randomBlocks(rng *rand.Rand, numEntries int) *[ColIdxCount][]byte {
+	addresses := []string{
+		"10.0.0.1", "10.0.0.2", "10.128.3.4", "192.168.1.1",
+		"2001:db8::", "2001:db8::1", "2001:db8:1::5", "fe80::1",
+	}
+	ports := []uint16{0, 22, 80, 443, 1023, 8080, 65535}
+	protos := []byte{1, 6, 17, 58, 255}
+
+	var blocks [ColIdxCount][]byte
+	for i := 0; i < numEntries; i++ {
+		sip, sipVersion, _ := ParseIP(addresses[rng.Intn(len(addresses))])
+		dip, dipVersion, _ := ParseIP(addresses[rng.Intn(len(addresses))])
+		port := ports[rng.Intn(len(ports))]
+
+		var version byte
+		if sipVersion == dipVersion && rng.Intn(3) > 0 {
+			version = sipVersion
+		}
+
+		blocks[SipColIdx] = append(blocks[SipColIdx], sip...)
+		blocks[DipColIdx] = append(blocks[DipColIdx], dip...)
+		blocks[DportColIdx] = append(blocks[DportColIdx], byte(port>>8), byte(port))
+		blocks[ProtoColIdx] = append(blocks[ProtoColIdx], protos[rng.Intn(len(protos))])
+		blocks[IPVersionColIdx] = append(blocks[IPVersionColIdx], version)
+	}
+	return &blocks
+}
+
+func TestSelectRows(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	var scratch selectionScratch
+	for _, conditional := range selectRowsConditionals {
+		node, err := ParseAndInstrumentConditional(conditional, 0)
+		if err != nil {
+			t.Fatalf("Failed to parse conditional %q: %s", conditional, err)
+		}
+
+		for _, numEntries := range []int{0, 1, 63, 64, 65, 1000} {
+			blocks := randomBlocks(rng, numEntries)
+
+			sel := selection(nil).resize(numEntries)
+			node.selectRows(blocks, numEntries, sel, &scratch)
+
+			var numSelected int
+			for i := 0; i < numEntries; i++ {
+				var key ExtraKey
+				for colIdx := columnIndex(0); colIdx < ColIdxAttributeCount; colIdx++ {
+					copyToKeyFns[colIdx](i, &key, blocks[colIdx])
+				}
+				if selected := sel[i/64]&(1<<uint(i%64)) != 0; selected != node.evaluate(&key) {
+					t.Fatalf("Conditional %q: selection of flow %v differs from its evaluation (selected: %v)", conditional, key, selected)
+				}
+				if node.evaluate(&key) {
+					numSelected++
+				}
+			}
+
+			// bits beyond the last flow must not be set
+			var numBits int
+			sel.forEach(func(int) { numBits++ })
+			if numBits != numSelected {
+				t.Fatalf("Conditional %q: selected %d flows, expected %d", conditional, numBits, numSelected)
+			}
+		}
+	}
+}
+
+// BenchmarkSelectRows compares evaluating a conditional flow by flow with evaluating it
+// for all flows of a block at once
+func BenchmarkSelectRows(b *testing.B) {
+	const numEntries = 500
+
+	node, err := ParseAndInstrumentConditional("(snet = 10.0.0.0/8 | dip = 192.168.1.1) & dport = 443 & proto = tcp", 0)
+	if err != nil {
+		b.Fatalf("Failed to parse conditional: %s", err)
+	}
+	blocks := randomBlocks(rand.New(rand.NewSource(1)), numEntries)
+	conditionalColumns := []columnIndex{SipColIdx, DipColIdx, DportColIdx, ProtoColIdx, IPVersionColIdx}
+
+	b.Run("rows", func(b *testing.B) {
+		var key ExtraKey
+		for n := 0; n < b.N; n++ {
+			for i := 0; i < numEntries; i++ {
+				for _, colIdx := range conditionalColumns {
+					copyToKeyFns[colIdx](i, &key, blocks[colIdx])
+				}
+				node.evaluate(&key)
+			}
+		}
+	})
+	b.Run("columns", func(b *testing.B) {
+		var (
+			sel     = selection(nil).resize(numEntries)
+			scratch selectionScratch
+		)
+		for n := 0; n < b.N; n++ {
+			sel = sel.resize(numEntries)
+			node.selectRows(blocks, numEntries, sel, &scratch)
+		}
+	})
+}
+
+// BenchmarkSelectRowsTestDB compares both evaluators on the blocks of the bundled test
+// database (also used by the benchgen benchmarks of goQuery), using the nested condition
+// of benchgen. The IP version column is omitted since it is missing on its older days, so
+// the IP versions are inferred
+func BenchmarkSelectRowsTestDB(b *testing.B) {
+	conditional, err := SanitizeUserInput("((dport eq 443 || dport eq 80) and dport neq 8080) and ! (dnet eq 127.0.0.0/8 or dnet eq 10.0.0.0/8 or dnet eq 172.16.0.0/12 or dnet eq 192.168.0.0/16)")
+	if err != nil {
+		b.Fatalf("Failed to sanitize conditional: %s", err)
+	}
+	node, err := ParseAndInstrumentConditional(conditional, 0)
+	if err != nil {
+		b.Fatalf("Failed to parse conditional: %s", err)
+	}
+	columns := loadTestDBColumns(b)
+	conditionalColumns := []columnIndex{SipColIdx, DipColIdx, DportColIdx, ProtoColIdx}
+
+	var blocks []*[ColIdxCount][]byte
+	for i := range columns[DportColIdx] {
+		var block [ColIdxCount][]byte
+		for _, colIdx := range conditionalColumns {
+			block[colIdx] = columns[colIdx][i]
+		}
+		blocks = append(blocks, &block)
+	}
+
+	b.Run("rows", func(b *testing.B) {
+		var key ExtraKey
+		for n := 0; n < b.N; n++ {
+			for _, block := range blocks {
+				for i := 0; i < len(block[DportColIdx])/DportSizeof; i++ {
+					for _, colIdx := range conditionalColumns {
+						copyToKeyFns[colIdx](i, &key, block[colIdx])
+					}
+					node.evaluate(&key)
+				}
+			}
+		}
+	})
+	b.Run("columns", func(b *testing.B) {
+		var (
+			sel     selection
+			scratch selectionScratch
+		)
+		for n := 0; n < b.N; n++ {
+			for _, block := range blocks {
+				numEntries := len(block[DportColIdx]) / DportSizeof
+				sel = sel.resize(numEntries)
+				node.selectRows(block, numEntries, sel, &scratch)
+			}
+		}
+	})
+}
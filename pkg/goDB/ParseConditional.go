@@ -30,7 +30,10 @@
 
 package goDB
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Parses the given conditional into an AST.
 //
@@ -66,12 +69,17 @@ func parseConditional(tokens []string) (conditionalNode Node, err error) {
 //     conjunction -> negation ('&' negation)*
 //     negation -> '!' primitive | primitive
 //     primitive -> '(' disjunction ')' | condition
-//     condition -> attribute comparator value
+//     condition -> attribute comparator value | attribute membership set
 //     comparator -> '=' | '!=' | '<' | '>' | '<=' | '>='
+//     membership -> 'in' | '!' 'in'
+//     set -> '(' value (',' value)* ')' | value
 // (Terminal symbols are written in single quotes)
 // (A rule part written with a star is meant to be repeated zero or more times)
+// Values of sets may also be ranges (e.g. "1000-2000") or references to files listing
+// values (e.g. "@/tmp/cidrs.txt"), which are expanded during desugaring.
 // We observe that this grammar is in LL(1), i.e. the parser can always decide which
-// production it should use by looking ahead a single token.
+// production it should use by looking ahead a single token. The only exception is the
+// negated membership '!' 'in', which requires looking ahead two tokens.
 // As a result we can translate the grammar into code almost one-to-one; furthermore,
 // the resulting parser runs in O(n).
 type parser struct {
@@ -295,6 +303,13 @@ func (p *parser) condition() (result Node) {
 	if !p.success() {
 		return
 	}
+	if membership, isMembership := p.membership(); isMembership {
+		condition.comparator = membership
+		condition.values = p.set()
+		condition.value = "{" + strings.Join(condition.values, ", ") + "}"
+		result = condition
+		return
+	}
 	condition.comparator = p.comparator()
 	if !p.success() {
 		return
@@ -307,7 +322,7 @@ func (p *parser) condition() (result Node) {
 // Corresponds to grammar rule "attribute"
 func (p *parser) attribute() (result string) {
 	attributes := []string{
-		"dip", "sip", "dnet", "snet", "dport", "proto", // non-sugar
+		"dip", "sip", "dnet", "snet", "dport", "proto", "ipv", // non-sugar
 		"dst", "src", "host", "net", // sugar
 	}
 	for _, attrib := range attributes {
@@ -361,6 +376,45 @@ func (p *parser) comparator() (result string) {
 	return
 }
 
+// Corresponds to grammar rule "membership". Returns false if the parser isn't positioned
+// at a membership operator
+func (p *parser) membership() (result string, isMembership bool) {
+	if p.accept("in") {
+		return "in", true
+	}
+	if p.pos+1 < len(p.tokens) && p.tokens[p.pos] == "!" && p.tokens[p.pos+1] == "in" {
+		p.pos += 2
+		return "!in", true
+	}
+	return "", false
+}
+
+// Corresponds to grammar rule "set"
+func (p *parser) set() (result []string) {
+	if !p.accept("(") {
+		return []string{p.setValue()}
+	}
+	for {
+		result = append(result, p.setValue())
+		if !p.success() || !p.accept(",") {
+			break
+		}
+	}
+	if p.success() {
+		p.expect(")")
+	}
+	return
+}
+
+// Corresponds to grammar rule "value" within sets, which excludes delimiters
+func (p *parser) setValue() (result string) {
+	if !p.eof() && startsDelimiter(p.tokens[p.pos][0]) {
+		p.die("Expected value")
+		return
+	}
+	return p.value()
+}
+
 // Corresponds to grammar rule "value"
 func (p *parser) value() (result string) {
 	result = p.advance()
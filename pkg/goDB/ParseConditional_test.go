@@ -39,6 +39,24 @@ var parseConditionalTests = []struct {
 	{[]string{"sip", "=", "192.168.1.1", "|", "sip", "=", "192.168.1.2", "|", "sip", "=", "192.168.1.3", "|", "sip", "=", "192.168.1.4"},
 		"(sip = 192.168.1.1 | (sip = 192.168.1.2 | (sip = 192.168.1.3 | sip = 192.168.1.4)))",
 		true},
+	{[]string{"ipv", "=", "6", "&", "dport", "=", "443"},
+		"(ipv = 6 & dport = 443)",
+		true},
+	{[]string{"dport", "in", "(", "80", ",", "443", ",", "1000-2000", ")"},
+		"dport in {80, 443, 1000-2000}",
+		true},
+	{[]string{"dport", "in", "1000-2000", "&", "sip", "!", "in", "@/tmp/cidrs.txt"},
+		"(dport in {1000-2000} & sip !in {@/tmp/cidrs.txt})",
+		true},
+	{[]string{"!", "snet", "in", "(", "10.0.0.0/8", ")"},
+		"!(snet in {10.0.0.0/8})",
+		true},
+	{[]string{"dport", "in", "(", ")"}, "", false},
+	{[]string{"dport", "in", "(", "80", ",", ")"}, "", false},
+	{[]string{"dport", "in", "(", "80", "443", ")"}, "", false},
+	{[]string{"dport", "in", "(", "80"}, "", false},
+	{[]string{"dport", "in"}, "", false},
+	{[]string{"dport", "!", "80"}, "", false},
 }
 
 func TestParseConditional(t *testing.T) {
@@ -15,6 +15,8 @@
 
 package goDB
 
+import "time"
+
 type columnIndex int
 
 // Indizes for all column types
@@ -24,6 +26,7 @@ const (
 	DipColIdx, _
 	ProtoColIdx, _
 	DportColIdx, _
+	IPVersionColIdx, _
 
 	// ... and then the columns we aggregate
 	BytesRcvdColIdx, ColIdxAttributeCount
@@ -39,6 +42,7 @@ const (
 	DipSizeof         int = 16
 	ProtoSizeof       int = 1
 	DportSizeof       int = 2
+	IPVersionSizeof   int = 1
 	BytesRcvdSizeof   int = 8
 	BytesSentSizeof   int = 8
 	PacketsRcvdSizeof int = 8
@@ -46,11 +50,11 @@ const (
 )
 
 var columnSizeofs = [ColIdxCount]int{
-	SipSizeof, DipSizeof, ProtoSizeof, DportSizeof,
+	SipSizeof, DipSizeof, ProtoSizeof, DportSizeof, IPVersionSizeof,
 	BytesRcvdSizeof, BytesSentSizeof, PacketsRcvdSizeof, PacketsSentSizeof}
 
 var columnFileNames = [ColIdxCount]string{
-	"sip", "dip", "proto", "dport",
+	"sip", "dip", "proto", "dport", "ipv",
 	"bytes_rcvd", "bytes_sent", "pkts_rcvd", "pkts_sent"}
 
 // Query stores all relevant parameters for data selection
@@ -62,6 +66,11 @@ type Query struct {
 
 	hasAttrTime, hasAttrIface bool
 
+	// size of the buckets the time attribute is aggregated into (zero for the blocks'
+	// timestamps) and the time zone they are aligned to
+	timeResolution time.Duration
+	location       *time.Location
+
 	// Each of the following slices represents a set in the sense that each column index can occur at most once in each slice.
 	// They are populated during the call to NewQuery
 
@@ -76,67 +85,91 @@ type Query struct {
 	// {BytesSentColIdx, PacketsRcvdColIdx, PacketsSentColIdx, ColIdxCount}.
 	// The latter four elements are needed for every query since they contain the variables we aggregate.
 	columnIndizes []columnIndex
-}
 
-// Computes a columnIndex from a column name. In principle we could merge
-// this function with conditionalAttributeNameToColumnIndex; however, then
-// we wouldn't "fail early" if an snet or dnet entry somehow made it into
-// the condition attributes.
-func queryAttributeNameToColumnIndex(name string) (colIdx columnIndex) {
-	colIdx, ok := map[string]columnIndex{
-		"sip":   SipColIdx,
-		"dip":   DipColIdx,
-		"proto": ProtoColIdx,
-		"dport": DportColIdx}[name]
-	if !ok {
-		panic("Unknown query attribute " + name)
-	}
-	return
+	// isKeyColumn denotes the columns stored in the compact keys of the query, i.e. the
+	// members of queryAttributeIndizes
+	isKeyColumn [ColIdxAttributeCount]bool
+
+	// masks applied to the source and destination addresses of the keys of queries by
+	// network (snet, dnet). nil if the addresses are aggregated as is
+	sipMask, dipMask *netmask
 }
 
-// Computes a columnIndex from a column name. Different from queryAttributeNameToColumnIndex
-// because snet and dnet are only allowed in conditionals.
-func conditionalAttributeNameToColumnIndex(name string) (colIdx columnIndex) {
+// Computes a columnIndex from the name of an attribute of a query or a conditional. The
+// networks snet and dnet are stored in the sip and dip columns
+func attributeNameToColumnIndex(name string) (colIdx columnIndex) {
 	colIdx, ok := map[string]columnIndex{
 		"sip":   SipColIdx,
 		"snet":  SipColIdx,
 		"dip":   DipColIdx,
 		"dnet":  DipColIdx,
 		"proto": ProtoColIdx,
-		"dport": DportColIdx}[name]
+		"dport": DportColIdx,
+		"ipv":   IPVersionColIdx}[name]
 	if !ok {
-		panic("Unknown conditional attribute " + name)
+		panic("Unknown attribute " + name)
 	}
 	return
 }
 
+// QueryOption allows to set optional parameters of a Query
+type QueryOption func(*Query)
+
+// WithTimeResolution aggregates the time attribute into buckets of the given resolution,
+// aligned to the wall clock of loc (see TimeBucket)
+func WithTimeResolution(resolution time.Duration, loc *time.Location) QueryOption {
+	return func(q *Query) {
+		q.timeResolution, q.location = resolution, loc
+	}
+}
+
 // NewQuery creates a new Query object based on the parsed command line parameters
-func NewQuery(attributes []Attribute, conditional Node, hasAttrTime, hasAttrIface bool) *Query {
+func NewQuery(attributes []Attribute, conditional Node, hasAttrTime, hasAttrIface bool, opts ...QueryOption) *Query {
 	q := &Query{
 		Attributes:   attributes,
 		Conditional:  conditional,
 		hasAttrTime:  hasAttrTime,
 		hasAttrIface: hasAttrIface,
+		location:     time.Local,
+	}
+	for _, opt := range opts {
+		opt(q)
 	}
 
-	// Compute index sets
-	var isAttributeIndex [ColIdxAttributeCount]bool // temporary variable for computing set union
-
+	// Compute index sets. IP addresses can only be interpreted in conjunction with their
+	// IP version, which is hence read along with them
+	var isQueryIndex, isConditionalIndex [ColIdxAttributeCount]bool // temporary variables for computing set union
 	for _, attrib := range q.Attributes {
-		colIdx := queryAttributeNameToColumnIndex(attrib.Name())
-		q.queryAttributeIndizes = append(q.queryAttributeIndizes, colIdx)
-		isAttributeIndex[colIdx] = true
+		switch a := attrib.(type) {
+		case SnetAttribute:
+			q.sipMask = newNetmask(a.PrefixLengths)
+		case DnetAttribute:
+			q.dipMask = newNetmask(a.PrefixLengths)
+		}
+
+		colIdx := attributeNameToColumnIndex(attrib.Name())
+		q.queryAttributeIndizes = appendColumnIndex(q.queryAttributeIndizes, &isQueryIndex, colIdx)
+		if colIdx == SipColIdx || colIdx == DipColIdx {
+			q.queryAttributeIndizes = appendColumnIndex(q.queryAttributeIndizes, &isQueryIndex, IPVersionColIdx)
+		}
 	}
 
 	if q.Conditional != nil {
 		for attribName := range q.Conditional.attributes() {
-			colIdx := conditionalAttributeNameToColumnIndex(attribName)
-			q.conditionalAttributeIndizes = append(q.conditionalAttributeIndizes, colIdx)
-			isAttributeIndex[colIdx] = true
+			colIdx := attributeNameToColumnIndex(attribName)
+			q.conditionalAttributeIndizes = appendColumnIndex(q.conditionalAttributeIndizes, &isConditionalIndex, colIdx)
+			if colIdx == SipColIdx || colIdx == DipColIdx {
+				q.conditionalAttributeIndizes = appendColumnIndex(q.conditionalAttributeIndizes, &isConditionalIndex, IPVersionColIdx)
+			}
 		}
 	}
+	q.isKeyColumn = isQueryIndex
+	isColumnIndex := isConditionalIndex
+	for colIdx := columnIndex(0); colIdx < ColIdxAttributeCount; colIdx++ {
+		isColumnIndex[colIdx] = isColumnIndex[colIdx] || isQueryIndex[colIdx]
+	}
 	for colIdx := columnIndex(0); colIdx < ColIdxAttributeCount; colIdx++ {
-		if isAttributeIndex[colIdx] {
+		if isColumnIndex[colIdx] {
 			q.columnIndizes = append(q.columnIndizes, colIdx)
 		}
 	}
@@ -145,3 +178,21 @@ func NewQuery(attributes []Attribute, conditional Node, hasAttrTime, hasAttrIfac
 
 	return q
 }
+
+// timeBucket returns the value of the time attribute of the block written at tstamp
+func (q *Query) timeBucket(tstamp int64) int64 {
+	if q.timeResolution == 0 {
+		return tstamp
+	}
+	return TimeBucket(tstamp, q.timeResolution, q.location)
+}
+
+// appendColumnIndex appends colIdx to the set of column indizes unless it is contained
+// already
+func appendColumnIndex(indizes []columnIndex, isIndex *[ColIdxAttributeCount]bool, colIdx columnIndex) []columnIndex {
+	if isIndex[colIdx] {
+		return indizes
+	}
+	isIndex[colIdx] = true
+	return append(indizes, colIdx)
+}
@@ -11,6 +11,7 @@
 package goDB
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"regexp"
@@ -25,13 +26,13 @@ type lookupHostResult struct {
 	err      error
 }
 
-// Returns a resolved version of node.
-func resolve(node Node, timeout time.Duration) (Node, error) {
+// Returns a resolved version of node. Resolution is aborted after timeout or once ctx is done.
+func resolve(ctx context.Context, node Node, timeout time.Duration) (Node, error) {
 	// Find all hostnames
 	hostnames := make(map[string]struct{})
 	_, err := node.transform(func(node conditionNode) (Node, error) {
-		// We only expect a hostname in sip or dip attributes
-		if node.attribute != "sip" && node.attribute != "dip" {
+		// We only expect a hostname in sip or dip attributes. Sets are limited to IPs
+		if (node.attribute != "sip" && node.attribute != "dip") || isMembership(node.comparator) {
 			return node, nil
 		}
 
@@ -54,34 +55,44 @@ func resolve(node Node, timeout time.Duration) (Node, error) {
 	}
 
 	// Resolve them asynchronously with a timeout
-	timer := time.NewTimer(timeout)
-	resultChan := make(chan lookupHostResult, 10)
+	lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	resultChan := make(chan lookupHostResult, len(hostnames))
 
 	for hostname := range hostnames {
 		hostname := hostname
 		go func() {
-			addrs, err := net.LookupHost(hostname)
+			addrs, err := net.DefaultResolver.LookupHost(lookupCtx, hostname)
 			resultChan <- lookupHostResult{hostname, addrs, err}
 		}()
 	}
 
 	lookups := make(map[string][]string)
 	for count := 0; count < len(hostnames); count++ {
+		var result lookupHostResult
 		select {
-		case <-timer.C:
+		case <-lookupCtx.Done():
+		case result = <-resultChan:
+		}
+
+		// lookups aborted by the timeout or cancellation fail as well, hence the context is
+		// checked first
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if lookupCtx.Err() != nil {
 			return nil, fmt.Errorf("Timeout while resolving hostnames in conditional")
-		case result := <-resultChan:
-			if result.err != nil {
-				return nil, result.err
-			}
-			lookups[result.hostname] = result.addrs
 		}
+		if result.err != nil {
+			return nil, result.err
+		}
+		lookups[result.hostname] = result.addrs
 	}
 
 	// Rewrite all conditions involving hostnames to use IPs
 	return node.transform(func(node conditionNode) (Node, error) {
 		// We only expect a domain in sip or dip attributes
-		if node.attribute != "sip" && node.attribute != "dip" {
+		if (node.attribute != "sip" && node.attribute != "dip") || isMembership(node.comparator) {
 			return node, nil
 		}
 
@@ -11,6 +11,8 @@
 package goDB
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -83,7 +85,7 @@ func TestResolveInConditional(t *testing.T) {
 			t.Fatalf("Parsing %v unexpectly failed. Error:\n%v", tokens, err)
 		}
 
-		resolvedNode, err := resolve(node, test.timeout)
+		resolvedNode, err := resolve(context.Background(), node, test.timeout)
 		if !test.success {
 			if err == nil {
 				fmt.Println(resolvedNode)
@@ -104,3 +106,18 @@ func TestResolveInConditional(t *testing.T) {
 		}
 	}
 }
+
+func TestResolveCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := ParseAndInstrumentConditionalContext(ctx, "sip = google-public-dns-a.google.com", time.Minute)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected resolution to be canceled, got: %v", err)
+	}
+
+	// conditionals without host names don't need to be resolved
+	if _, err := ParseAndInstrumentConditionalContext(ctx, "sip = 8.8.8.8", time.Minute); err != nil {
+		t.Fatalf("Unexpectedly failed: %s", err)
+	}
+}
@@ -42,6 +42,8 @@ func NewStringKeyParser(kind string) StringKeyParser {
 		return &DportStringParser{}
 	case "proto":
 		return &ProtoStringParser{}
+	case "ipv":
+		return &IPVersionStringParser{}
 	case "iface":
 		return &IfaceStringParser{}
 	case "time":
@@ -84,6 +86,9 @@ type DportStringParser struct{}
 // ProtoStringParser parses proto strings
 type ProtoStringParser struct{}
 
+// IPVersionStringParser parses IP version strings
+type IPVersionStringParser struct{}
+
 // extra attributes
 
 // TimeStringParser parses time strings
@@ -118,21 +123,23 @@ func (n *NOPStringParser) ParseVal(element string, val *Val) error {
 
 // ParseKey parses a source IP string and writes it to the source IP key slice
 func (s *SipStringParser) ParseKey(element string, key *ExtraKey) error {
-	ipBytes, err := IPStringToBytes(element)
+	ipBytes, ipVersion, err := ParseIP(element)
 	if err != nil {
 		return errors.New("Could not parse 'sip' attribute: " + err.Error())
 	}
 	copy(key.Sip[:], ipBytes[:])
+	key.IPVersion = ipVersion
 	return nil
 }
 
 // ParseKey parses a destination IP string and writes it to the desintation IP key slice
 func (d *DipStringParser) ParseKey(element string, key *ExtraKey) error {
-	ipBytes, err := IPStringToBytes(element)
+	ipBytes, ipVersion, err := ParseIP(element)
 	if err != nil {
 		return errors.New("Could not parse 'dip' attribute: " + err.Error())
 	}
 	copy(key.Dip[:], ipBytes[:])
+	key.IPVersion = ipVersion
 	return nil
 }
 
@@ -166,6 +173,20 @@ func (p *ProtoStringParser) ParseKey(element string, key *ExtraKey) error {
 	return nil
 }
 
+// ParseKey parses an IP version string (4 or 6) and writes it to the IP version key.
+// Since the version is also set when parsing the addresses, the column is optional
+func (i *IPVersionStringParser) ParseKey(element string, key *ExtraKey) error {
+	switch element {
+	case "4":
+		key.IPVersion = IPv4
+	case "6":
+		key.IPVersion = IPv6
+	default:
+		return errors.New("Could not parse 'ipv' attribute: unknown IP version " + element)
+	}
+	return nil
+}
+
 // ParseKey parses a time string and writes it to the Time key
 func (t *TimeStringParser) ParseKey(element string, key *ExtraKey) error {
 	// parse into number
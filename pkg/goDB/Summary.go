@@ -16,6 +16,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/els0r/goProbe/pkg/goDB/storage"
 	jsoniter "github.com/json-iterator/go"
 )
 
@@ -61,64 +62,58 @@ func NewDBSummary() *DBSummary {
 
 // LockDBSummary tries to acquire a lockfile for the database summary.
 // Its return values indicate whether it successfully acquired the lock
-// and whether a file system error occurred.
-func LockDBSummary(dbpath string) (acquired bool, err error) {
-	f, err := os.OpenFile(filepath.Join(dbpath, SummaryLockFileName), os.O_EXCL|os.O_CREATE, 0666)
+// and whether a storage error occurred.
+func LockDBSummary(store storage.Store, dbpath string) (acquired bool, err error) {
+	err = store.CreateFile(filepath.Join(dbpath, SummaryLockFileName))
 	if err != nil {
 		if os.IsExist(err) {
 			return false, nil
 		}
 		return false, err
 	}
-	f.Close()
 	return true, nil
 }
 
 // UnlockDBSummary removes the lockfile for the database summary.
-// Its return values indicates whether a file system error occurred.
-func UnlockDBSummary(dbpath string) (err error) {
-	err = os.Remove(filepath.Join(dbpath, SummaryLockFileName))
+// Its return values indicates whether a storage error occurred.
+func UnlockDBSummary(store storage.Store, dbpath string) (err error) {
+	err = store.RemoveAll(filepath.Join(dbpath, SummaryLockFileName))
 	return
 }
 
-// ReadDBSummary reads the summary of the given database.
+// ReadDBSummary reads the summary of the given database from the store.
 // If multiple processes might be operating on
 // the summary simultaneously, you should lock it first.
-func ReadDBSummary(dbpath string) (*DBSummary, error) {
-	result := NewDBSummary()
-	var err error
-
-	f, err := os.Open(filepath.Join(dbpath, SummaryFileName))
+func ReadDBSummary(store storage.Store, dbpath string) (*DBSummary, error) {
+	data, err := store.ReadFile(filepath.Join(dbpath, SummaryFileName))
 	if err != nil {
-		return result, err
+		return NewDBSummary(), err
 	}
-	defer f.Close()
 
-	err = jsoniter.NewDecoder(f).Decode(result)
-	if err != nil {
-		return result, err
+	result := NewDBSummary()
+	if err = jsoniter.Unmarshal(data, result); err != nil {
+		return NewDBSummary(), err
 	}
 	if result.Interfaces == nil {
 		result = NewDBSummary()
 	}
-
-	return result, err
+	return result, nil
 }
 
-// WriteDBSummary writes a new summary for the given database.
+// WriteDBSummary writes a new summary for the given database to the store.
 // If multiple processes might be operating on
 // the summary simultaneously, you should lock it first.
-func WriteDBSummary(dbpath string, summ *DBSummary) error {
-	f, err := os.Create(filepath.Join(dbpath, SummaryFileName))
+func WriteDBSummary(store storage.Store, dbpath string, summ *DBSummary) error {
+	data, err := jsoniter.Marshal(summ)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	return jsoniter.NewEncoder(f).Encode(summ)
+	// keep the output identical to the one of an encoder
+	return store.WriteFile(filepath.Join(dbpath, SummaryFileName), append(data, '\n'))
 }
 
-// ModifyDBSummary safely modifies the database summary when there are multiple processes accessing it.
+// ModifyDBSummary safely modifies the database summary in the store when there are multiple processes accessing it.
 //
 // If no lock can be acquired after (roughly) timeout time, returns an error.
 //
@@ -127,14 +122,14 @@ func WriteDBSummary(dbpath string, summ *DBSummary) error {
 // * modify returns the summary to be written (must be non-nil) and an error.
 // * Since the summary is locked while modify is
 //   running, modify shouldn't take longer than roughly half a second.
-func ModifyDBSummary(dbpath string, timeout time.Duration, modify func(*DBSummary) (*DBSummary, error)) (modErr error) {
+func ModifyDBSummary(store storage.Store, dbpath string, timeout time.Duration, modify func(*DBSummary) (*DBSummary, error)) (modErr error) {
 	// Back off exponentially in case of failure.
 	// Retry for at most timeout time.
 	wait := 50 * time.Millisecond
 	waited := time.Duration(0)
 	for {
 		// lock
-		acquired, err := LockDBSummary(dbpath)
+		acquired, err := LockDBSummary(store, dbpath)
 		if err != nil {
 			return err
 		}
@@ -152,13 +147,13 @@ func ModifyDBSummary(dbpath string, timeout time.Duration, modify func(*DBSummar
 
 		// deferred unlock
 		defer func() {
-			if err := UnlockDBSummary(dbpath); err != nil {
+			if err := UnlockDBSummary(store, dbpath); err != nil {
 				modErr = err
 			}
 		}()
 
 		// read
-		summ, err := ReadDBSummary(dbpath)
+		summ, err := ReadDBSummary(store, dbpath)
 		if err != nil {
 			if os.IsNotExist(err) {
 				summ = NewDBSummary()
@@ -174,7 +169,7 @@ func ModifyDBSummary(dbpath string, timeout time.Duration, modify func(*DBSummar
 		}
 
 		// write
-		return WriteDBSummary(dbpath, summ)
+		return WriteDBSummary(store, dbpath, summ)
 	}
 
 	return fmt.Errorf("Failed to acquire database summary lockfile")
@@ -21,15 +21,25 @@ import (
 	"bufio"
 	"bytes"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
+var (
+	// listFileRegexp matches references to list files in conditionals, e.g. "@/tmp/cidrs.txt"
+	listFileRegexp = regexp.MustCompile(`@[^\s!=<>|&(){}\[\],]+`)
+
+	// listFileIndexRegexp matches the references replaced by their index during sanitization
+	listFileIndexRegexp = regexp.MustCompile(`@[0-9]+`)
+)
+
 // SanitizeUserInput sanitizes a conditional string provided by the user. Its main purpose
 // is to convert other forms of precedence and logical operators to the condition grammar
 // used.
 // For example, some people may prefer a more verbose forms such as "dport=443 or dport=8080"
 // or exotic forms such as "{dport=443 || dport=8080}". These should be caught and converted
-// to the grammar-conforming expression "(dport=443|dport=8080)"
+// to the grammar-conforming expression "(dport=443|dport=8080)". References to list files
+// (e.g. "@/tmp/Blocklist.txt") are kept as they are.
 //
 // Input:
 //  conditional: string containing the conditional specified in "user grammar"
@@ -66,6 +76,15 @@ func SanitizeUserInput(conditional string) (string, error) {
 		"<":  []string{"\\s+l\\s+", "\\s+\\-l\\s+", "\\s+lt\\s+", "\\s+\\-lt\\s+", "\\s+less\\s+"},
 	}
 
+	// the paths of list files are kept as they are, hence they are replaced by their
+	// index during the conversion
+	listFiles := listFileRegexp.FindAllString(conditional, -1)
+	index := 0
+	conditional = listFileRegexp.ReplaceAllStringFunc(conditional, func(string) string {
+		index++
+		return "@" + strconv.Itoa(index-1)
+	})
+
 	// first, convert everything to lower case
 	r, err = regexp.Compile(".*")
 	if err != nil {
@@ -86,12 +105,17 @@ func SanitizeUserInput(conditional string) (string, error) {
 		}
 	}
 
+	sanitized = listFileIndexRegexp.ReplaceAllStringFunc(sanitized, func(ref string) string {
+		index, _ := strconv.Atoi(ref[1:])
+		return listFiles[index]
+	})
+
 	return sanitized, err
 }
 
 func startsDelimiter(char byte) bool {
 	switch char {
-	case '!', '=', '<', '>', '|', '&', '(', ')', ' ', '\n', '\r', '\t':
+	case '!', '=', '<', '>', '|', '&', '(', ')', ',', ' ', '\n', '\r', '\t':
 		return true
 	default:
 		return false
@@ -115,7 +139,7 @@ func delimiterSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err
 	}
 
 	switch data[0] {
-	case '=', '|', '&', '(', ')':
+	case '=', '|', '&', '(', ')', ',':
 		advance = 1
 		token = data[0:1]
 		return
@@ -167,9 +191,11 @@ func wordSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err erro
 // Split function for tokenization of the conditionalData. (For more info, see bufio.SplitFunc)
 // The conditional grammar consits of two types of tokens:
 // * Word tokens are attribute names (e.g. "sip" or "dnet"), protocol names (e.g. "UDP")
-//   numbers, ip addresses (e.g. "fe80::abcd:ce23"), and CIDR records (e.g. "10.0.0.0/8").
+//   numbers, ip addresses (e.g. "fe80::abcd:ce23"), CIDR records (e.g. "10.0.0.0/8"),
+//   ranges (e.g. "1000-2000"), and references to list files (e.g. "@/tmp/cidrs.txt").
 // * Delimiter tokens delimit other tokens (word tokens and delimiter tokens). Delimiter tokens
-//   consist of all logical operators, comparison operators, parentheses, and white space characters.
+//   consist of all logical operators, comparison operators, parentheses, the commas separating
+//   the values of sets, and white space characters.
 func conditionalSplitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if len(data) == 0 {
 		return
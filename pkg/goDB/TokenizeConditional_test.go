@@ -44,6 +44,9 @@ var sanitizeUserInputTests = []struct {
 	{"not dport g 80", "!dport>80"},
 	{"dport<443& not{dport g 80}", "dport<443&!(dport>80)"},
 	{"dport<443& not[dport g 80]", "dport<443&!(dport>80)"},
+	// Sets and the paths of list files are kept
+	{"dport not in {80, 443}", "dport!in (80, 443)"},
+	{"sip in @/tmp/Block+List.txt or dip IN [@/tmp/A.txt,10.0.0.1]", "sip in @/tmp/Block+List.txt|dip in (@/tmp/A.txt,10.0.0.1)"},
 }
 
 func TestSanitizeUserInput(t *testing.T) {
@@ -98,6 +101,9 @@ var wordSplitFuncTests = []splitFuncTest{
 	{[]byte("a<"), false, 1, []byte("a")},
 	{[]byte("a>"), false, 1, []byte("a")},
 	{[]byte("a "), false, 1, []byte("a")},
+	{[]byte("a,"), false, 1, []byte("a")},
+	{[]byte("1000-2000,"), false, 9, []byte("1000-2000")},
+	{[]byte("@/tmp/cidrs.txt)"), false, 15, []byte("@/tmp/cidrs.txt")},
 	{[]byte("asd.123:ef/12 "), false, 13, []byte("asd.123:ef/12")},
 	{[]byte("asd.123:ef/12"), true, 13, []byte("asd.123:ef/12")},
 	{[]byte("example.com "), false, 11, []byte("example.com")},
@@ -121,6 +127,7 @@ var delimiterSplitFuncTests = []splitFuncTest{
 	{[]byte("=x"), false, 1, []byte("=")},
 	{[]byte("(1"), false, 1, []byte("(")},
 	{[]byte(")"), false, 1, []byte(")")},
+	{[]byte(",1"), false, 1, []byte(",")},
 	{[]byte(" "), false, 1, []byte(" ")},
 	{[]byte("\t"), false, 1, []byte(" ")},
 	{[]byte("\n"), false, 1, []byte(" ")},
@@ -166,6 +173,8 @@ var TokenizeConditionalTests = []struct {
 	{"sip = 2a00:db0:7:c08:e4d:e9ff:fea4:88e9 & dip = 2a00::e4d:e9ff:fea4:88e9", []string{"sip", "=", "2a00:db0:7:c08:e4d:e9ff:fea4:88e9", "&", "dip", "=", "2a00::e4d:e9ff:fea4:88e9"}},
 	{"sip = 2a00:db0:7:c08:e4d:: & dip = 2a00::e4d:e9ff:fea4:88e9", []string{"sip", "=", "2a00:db0:7:c08:e4d::", "&", "dip", "=", "2a00::e4d:e9ff:fea4:88e9"}},
 	{"sip = example.com.  & dip =sub-domain.open.ch", []string{"sip", "=", "example.com.", "&", "dip", "=", "sub-domain.open.ch"}},
+	{"dport in (80,443, 1000-2000)", []string{"dport", "in", "(", "80", ",", "443", ",", "1000-2000", ")"}},
+	{"sip !in @/tmp/cidrs.txt", []string{"sip", "!", "in", "@/tmp/cidrs.txt"}},
 	// TokenizeConditional also tokenizes incorrect conditionals. It's the parser's job to catch those.
 	{"dport =< 80", []string{"dport", "=", "<", "80"}},
 	{"dport << 80", []string{"dport", "<", "<", "80"}},
@@ -0,0 +1,223 @@
+package goDB
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// BlockStatsFileName is the name of the per-day sidecar file (stored like a column,
+// i.e. as blockstats.gpf) holding the statistics of each block written to the other
+// columns. It allows queries to skip blocks which cannot match the conditional
+const BlockStatsFileName = "blockstats"
+
+const blockStatsVersion = 1
+
+// blockStats summarizes the attribute values of the flows stored in a block: zone maps
+// (min / max) for all attributes and bloom filters for the IP addresses
+type blockStats struct {
+	numFlows uint64
+
+	protoMin, protoMax byte
+	dportMin, dportMax [DportSizeof]byte
+	sipMin, sipMax     [SipSizeof]byte
+	dipMin, dipMax     [DipSizeof]byte
+
+	sip, dip *bloomFilter
+}
+
+func newBlockStats(flowmap AggFlowMap) *blockStats {
+	s := &blockStats{
+		numFlows: uint64(len(flowmap)),
+		sip:      newBloomFilter(len(flowmap)),
+		dip:      newBloomFilter(len(flowmap)),
+	}
+
+	first := true
+	for K := range flowmap {
+		s.sip.add(K.Sip[:])
+		s.dip.add(K.Dip[:])
+
+		if first {
+			s.protoMin, s.protoMax = K.Protocol, K.Protocol
+			s.dportMin, s.dportMax = K.Dport, K.Dport
+			s.sipMin, s.sipMax = K.Sip, K.Sip
+			s.dipMin, s.dipMax = K.Dip, K.Dip
+			first = false
+			continue
+		}
+
+		if K.Protocol < s.protoMin {
+			s.protoMin = K.Protocol
+		}
+		if K.Protocol > s.protoMax {
+			s.protoMax = K.Protocol
+		}
+		updateMinMax(K.Dport[:], s.dportMin[:], s.dportMax[:])
+		updateMinMax(K.Sip[:], s.sipMin[:], s.sipMax[:])
+		updateMinMax(K.Dip[:], s.dipMin[:], s.dipMax[:])
+	}
+
+	return s
+}
+
+func updateMinMax(v, min, max []byte) {
+	if bytes.Compare(v, min) < 0 {
+		copy(min, v)
+	}
+	if bytes.Compare(v, max) > 0 {
+		copy(max, v)
+	}
+}
+
+// Layout: version (1 byte), number of flows (uvarint). For non-empty blocks followed by
+// proto min / max, dport min / max, sip min / max, dip min / max and the sip and dip
+// bloom filters
+func (s *blockStats) marshal() []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+
+	buf := []byte{blockStatsVersion}
+	buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf[:], s.numFlows)]...)
+	if s.numFlows == 0 {
+		return buf
+	}
+
+	buf = append(buf, s.protoMin, s.protoMax)
+	buf = append(buf, s.dportMin[:]...)
+	buf = append(buf, s.dportMax[:]...)
+	buf = append(buf, s.sipMin[:]...)
+	buf = append(buf, s.sipMax[:]...)
+	buf = append(buf, s.dipMin[:]...)
+	buf = append(buf, s.dipMax[:]...)
+	buf = s.sip.marshal(buf)
+	return s.dip.marshal(buf)
+}
+
+var errCorruptBlockStats = errors.New("Corrupt block statistics")
+
+func unmarshalBlockStats(data []byte) (*blockStats, error) {
+	if len(data) < 1 {
+		return nil, errCorruptBlockStats
+	}
+	if data[0] != blockStatsVersion {
+		return nil, errors.New("Unsupported block statistics version")
+	}
+
+	s := new(blockStats)
+	var n int
+	if s.numFlows, n = binary.Uvarint(data[1:]); n <= 0 {
+		return nil, errCorruptBlockStats
+	}
+	data = data[1+n:]
+	if s.numFlows == 0 {
+		return s, nil
+	}
+
+	const zoneMapsLen = 2 + 2*DportSizeof + 2*SipSizeof + 2*DipSizeof
+	if len(data) < zoneMapsLen {
+		return nil, errCorruptBlockStats
+	}
+	s.protoMin, s.protoMax = data[0], data[1]
+	data = data[2:]
+	for _, field := range [][]byte{s.dportMin[:], s.dportMax[:], s.sipMin[:], s.sipMax[:], s.dipMin[:], s.dipMax[:]} {
+		data = data[copy(field, data):]
+	}
+
+	var err error
+	if s.sip, data, err = unmarshalBloomFilter(data); err != nil {
+		return nil, err
+	}
+	if s.dip, data, err = unmarshalBloomFilter(data); err != nil {
+		return nil, err
+	}
+	if len(data) != 0 {
+		return nil, errCorruptBlockStats
+	}
+
+	return s, nil
+}
+
+// Generates a closure telling whether any flow summarized by the block statistics
+// may satisfy the condition. The closure has to be conservative: it may only return
+// false if no flow in the block can satisfy the condition
+func generateMayMatch(condition *conditionNode, value []byte, netmask int) {
+	comparator := condition.comparator
+
+	switch condition.attribute {
+	case "sip":
+		condition.mayMatchStats = func(s *blockStats) bool {
+			return ipMayMatch(comparator, value, s.sipMin[:], s.sipMax[:], s.sip)
+		}
+	case "dip":
+		condition.mayMatchStats = func(s *blockStats) bool {
+			return ipMayMatch(comparator, value, s.dipMin[:], s.dipMax[:], s.dip)
+		}
+	case "snet":
+		lo, hi := netRange(value, netmask)
+		condition.mayMatchStats = func(s *blockStats) bool {
+			return rangeMayMatch(comparator, lo, hi, s.sipMin[:], s.sipMax[:])
+		}
+	case "dnet":
+		lo, hi := netRange(value, netmask)
+		condition.mayMatchStats = func(s *blockStats) bool {
+			return rangeMayMatch(comparator, lo, hi, s.dipMin[:], s.dipMax[:])
+		}
+	case "dport":
+		condition.mayMatchStats = func(s *blockStats) bool {
+			return rangeMayMatch(comparator, value, value, s.dportMin[:], s.dportMax[:])
+		}
+	case "proto":
+		condition.mayMatchStats = func(s *blockStats) bool {
+			return rangeMayMatch(comparator, value, value, []byte{s.protoMin}, []byte{s.protoMax})
+		}
+	}
+}
+
+// ipMayMatch additionally consults the bloom filter for equality conditions
+func ipMayMatch(comparator string, value, min, max []byte, filter *bloomFilter) bool {
+	if !rangeMayMatch(comparator, value, value, min, max) {
+		return false
+	}
+	if comparator == "=" && filter != nil {
+		return filter.mayContain(value[:len(min)])
+	}
+	return true
+}
+
+// rangeMayMatch checks whether any value in [min, max] may satisfy the comparison
+// with the value range [lo, hi]. For single values, lo and hi are identical. For
+// networks, "=" is satisfied by all addresses within [lo, hi]
+func rangeMayMatch(comparator string, lo, hi, min, max []byte) bool {
+	lo, hi = lo[:len(min)], hi[:len(min)]
+	switch comparator {
+	case "=":
+		return bytes.Compare(max, lo) >= 0 && bytes.Compare(min, hi) <= 0
+	case "!=":
+		// only fails if all values of the block are within the range
+		return !(bytes.Compare(min, lo) >= 0 && bytes.Compare(max, hi) <= 0)
+	case "<":
+		return bytes.Compare(min, lo) < 0
+	case "<=":
+		return bytes.Compare(min, lo) <= 0
+	case ">":
+		return bytes.Compare(max, hi) > 0
+	case ">=":
+		return bytes.Compare(max, hi) >= 0
+	}
+	return true
+}
+
+// netRange returns the lowest and highest address of the network described by the
+// (already masked) network address and netmask. Since the evaluation only compares the
+// bytes covered by the netmask, all host bits are set in the highest address
+func netRange(network []byte, netmask int) (lo, hi []byte) {
+	lo = make([]byte, len(network))
+	hi = make([]byte, len(network))
+	copy(lo, network)
+	copy(hi, network)
+
+	for bit := netmask; bit < 8*len(network); bit++ {
+		hi[bit/8] |= 0x80 >> uint(bit%8)
+	}
+	return lo, hi
+}
@@ -0,0 +1,289 @@
+package goDB
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/memory"
+)
+
+func TestBloomFilter(t *testing.T) {
+	const numElements = 10000
+
+	r := rand.New(rand.NewSource(42))
+	f := newBloomFilter(numElements)
+
+	var elements [][]byte
+	for i := 0; i < numElements; i++ {
+		element := make([]byte, 16)
+		r.Read(element)
+		elements = append(elements, element)
+		f.add(element)
+	}
+	for _, element := range elements {
+		if !f.mayContain(element) {
+			t.Fatalf("False negative detected for %x", element)
+		}
+	}
+
+	var falsePositives int
+	for i := 0; i < numElements; i++ {
+		element := make([]byte, 16)
+		r.Read(element)
+		if f.mayContain(element) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / numElements; rate > 0.02 {
+		t.Fatalf("False positive rate too high: %.4f", rate)
+	}
+}
+
+func testStatsKey(sip, dip string, dport uint16, proto byte) Key {
+	var key Key
+	sipBytes, _ := IPStringToBytes(sip)
+	dipBytes, _ := IPStringToBytes(dip)
+	copy(key.Sip[:], sipBytes)
+	copy(key.Dip[:], dipBytes)
+	binary.BigEndian.PutUint16(key.Dport[:], dport)
+	key.Protocol = proto
+	return key
+}
+
+func TestBlockStatsMarshal(t *testing.T) {
+	flowmap := AggFlowMap{
+		testStatsKey("10.0.0.1", "192.168.1.1", 443, 6):    &Val{},
+		testStatsKey("10.0.0.2", "2a02:1:2::3", 53, 17):    &Val{},
+		testStatsKey("fe80::1", "192.168.1.200", 8080, 58): &Val{},
+	}
+
+	for _, fm := range []AggFlowMap{flowmap, AggFlowMap{}} {
+		stats := newBlockStats(fm)
+		data := stats.marshal()
+
+		unmarshaled, err := unmarshalBlockStats(data)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal block statistics: %s", err)
+		}
+		if unmarshaled.numFlows != uint64(len(fm)) {
+			t.Fatalf("Unexpected number of flows: %d", unmarshaled.numFlows)
+		}
+		if len(fm) == 0 {
+			continue
+		}
+
+		if unmarshaled.protoMin != 6 || unmarshaled.protoMax != 58 ||
+			binary.BigEndian.Uint16(unmarshaled.dportMin[:]) != 53 || binary.BigEndian.Uint16(unmarshaled.dportMax[:]) != 8080 {
+			t.Fatalf("Unexpected zone maps after round-trip: %+v", unmarshaled)
+		}
+		for K := range fm {
+			if !unmarshaled.sip.mayContain(K.Sip[:]) || !unmarshaled.dip.mayContain(K.Dip[:]) {
+				t.Fatalf("Bloom filters lost flow %s during round-trip", K)
+			}
+		}
+
+		// truncated statistics have to be detected
+		for i := 0; i < len(data); i++ {
+			if _, err := unmarshalBlockStats(data[:i]); err == nil {
+				t.Fatalf("Expected unmarshaling of %d out of %d bytes to fail, but it didn't", i, len(data))
+			}
+		}
+	}
+}
+
+func TestMayMatch(t *testing.T) {
+	stats := newBlockStats(AggFlowMap{
+		testStatsKey("10.0.0.1", "192.168.1.1", 443, 6):  &Val{},
+		testStatsKey("10.0.0.2", "192.168.1.20", 53, 17): &Val{},
+		testStatsKey("10.0.5.3", "192.168.1.1", 8080, 6): &Val{},
+	})
+
+	var tests = []struct {
+		conditional string
+		mayMatch    bool
+	}{
+		{"dport = 443", true},
+		{"dport = 22", false},
+		{"dport != 443", true},
+		{"dport < 53", false},
+		{"dport <= 53", true},
+		{"dport > 8080", false},
+		{"dport >= 8080", true},
+		{"proto = udp", true},
+		{"proto = icmp", false},
+		{"proto < 6", false},
+		{"proto > 17", false},
+		{"sip = 10.0.0.2", true},
+		{"sip = 10.0.0.3", false},
+		{"sip = 11.0.0.1", false},
+		{"dip = 192.168.1.20", true},
+		{"dip = 192.168.1.21", false},
+		{"dip != 192.168.1.1", true},
+		{"snet = 10.0.0.0/8", true},
+		{"snet = 11.0.0.0/8", false},
+		{"snet != 10.0.0.0/24", true},
+		{"snet != 10.0.0.0/16", false},
+		{"snet != 10.0.0.0/8", false},
+		{"dnet = 192.168.1.0/27", true},
+		{"dnet = 192.168.2.0/24", false},
+		{"dnet = 2a02::/16", false},
+		{"dnet = ::/0", true},
+		{"host = 10.0.5.3", true},
+		{"host = 10.0.5.4", false},
+		{"dport = 22 | dport = 53", true},
+		{"dport = 443 & proto = udp", true},
+		{"dport = 443 & proto = icmp", false},
+		{"!(dport != 22)", false},
+		{"!(dport = 22 | dport = 443)", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.conditional, func(t *testing.T) {
+			conditional, err := ParseAndInstrumentConditional(test.conditional, time.Second)
+			if err != nil {
+				t.Fatalf("Failed to parse conditional: %s", err)
+			}
+			if mayMatch := conditional.mayMatch(stats); mayMatch != test.mayMatch {
+				t.Fatalf("Unexpected result: want %t, have %t", test.mayMatch, mayMatch)
+			}
+		})
+	}
+}
+
+// TestMayMatchConservative makes sure that no block is ruled out by its statistics
+// which contains a flow satisfying the conditional
+func TestMayMatchConservative(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	var conditionals []Node
+	for _, s := range []string{
+		"dport = 80", "dport < 1024", "dport >= 1000", "proto = tcp", "proto != udp",
+		"sip = 10.0.0.7", "dip != 10.0.1.3", "snet = 10.0.0.0/29", "dnet != 10.0.0.0/30",
+		"dnet = 10.0.1.0/25", "sip = 10.0.0.1 | dport = 22", "!(dport > 512 & proto = 17)",
+	} {
+		conditional, err := ParseAndInstrumentConditional(s, time.Second)
+		if err != nil {
+			t.Fatalf("Failed to parse conditional %q: %s", s, err)
+		}
+		conditionals = append(conditionals, conditional)
+	}
+
+	for i := 0; i < 1000; i++ {
+		flowmap := make(AggFlowMap)
+		for j := 0; j < 1+r.Intn(4); j++ {
+			var key Key
+			key.Sip[0], key.Sip[3] = 10, byte(r.Intn(16))
+			key.Dip[0], key.Dip[2], key.Dip[3] = 10, byte(r.Intn(2)), byte(r.Intn(256))
+			binary.BigEndian.PutUint16(key.Dport[:], uint16(r.Intn(2048)))
+			key.Protocol = []byte{1, 6, 17}[r.Intn(3)]
+			flowmap[key] = &Val{}
+		}
+		stats := newBlockStats(flowmap)
+
+		for _, conditional := range conditionals {
+			for K := range flowmap {
+				if conditional.evaluate(&ExtraKey{Key: K}) && !conditional.mayMatch(stats) {
+					t.Fatalf("%s: block containing matching flow %s was ruled out", conditional, K)
+				}
+			}
+		}
+	}
+}
+
+// countingStore counts the blocks read from the sip column
+type countingStore struct {
+	storage.Store
+	numReads int
+}
+
+type countingBackend struct {
+	storage.Backend
+	store *countingStore
+}
+
+func (s *countingStore) Open(path string, mode storage.Mode, encoderType encoders.Type) (storage.Backend, error) {
+	backend, err := s.Store.Open(path, mode, encoderType)
+	if err != nil || filepath.Base(path) != columnFileNames[SipColIdx]+".gpf" {
+		return backend, err
+	}
+	return &countingBackend{backend, s}, nil
+}
+
+func (b *countingBackend) ReadBlock(timestamp int64) ([]byte, error) {
+	b.store.numReads++
+	return b.Backend.ReadBlock(timestamp)
+}
+
+func TestSkipBlocks(t *testing.T) {
+	const (
+		dbPath    = "/db"
+		timestamp = int64(1456428600)
+	)
+
+	store := &countingStore{Store: memory.NewStore()}
+
+	w := NewDBWriter(dbPath, "eth0", encoders.EncoderTypeLZ4, WithStore(store))
+	for i, dport := range []uint16{22, 80, 443} {
+		flowmap := AggFlowMap{
+			testStatsKey("10.0.0.1", "10.0.0.2", dport, 6): &Val{NBytesRcvd: 1, NBytesSent: 2, NPktsRcvd: 3, NPktsSent: 4},
+		}
+		if _, err := w.Write(flowmap, BlockMetadata{}, timestamp+int64(i)*DBWriteInterval); err != nil {
+			t.Fatalf("Failed to write flows: %s", err)
+		}
+	}
+
+	var tests = []struct {
+		conditional string
+		numReads    int
+		numFlows    int
+	}{
+		{"dport = 80", 1, 1},
+		{"dport = 8080", 0, 0},
+		{"dport > 22", 2, 2},
+		{"dip = 10.0.0.2", 3, 3},
+		{"dip = 10.0.0.3", 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.conditional, func(t *testing.T) {
+			conditional, err := ParseAndInstrumentConditional(test.conditional, time.Second)
+			if err != nil {
+				t.Fatalf("Failed to parse conditional: %s", err)
+			}
+			attributes, hasAttrTime, hasAttrIface, err := ParseQueryType("sip,dip,dport,proto,time")
+			if err != nil {
+				t.Fatalf("Failed to parse query type: %s", err)
+			}
+			query := NewQuery(attributes, conditional, hasAttrTime, hasAttrIface)
+
+			wm, err := NewDBWorkManager(dbPath, "eth0", 1, WithStore(store))
+			if err != nil {
+				t.Fatalf("Failed to create work manager: %s", err)
+			}
+			if _, err := wm.CreateWorkerJobs(timestamp-1, timestamp+EpochDay, query); err != nil {
+				t.Fatalf("Failed to create worker jobs: %s", err)
+			}
+
+			store.numReads = 0
+			resultMap := make(map[CompactKey]*Val)
+			for _, workload := range wm.workloads {
+				if err := wm.readBlocksAndEvaluate(context.Background(), workload, 0, resultMap); err != nil {
+					t.Fatalf("Failed to evaluate blocks: %s", err)
+				}
+			}
+
+			if store.numReads != test.numReads {
+				t.Fatalf("Unexpected number of blocks read: want %d, have %d", test.numReads, store.numReads)
+			}
+			if len(resultMap) != test.numFlows {
+				t.Fatalf("Unexpected number of flows: want %d, have %d", test.numFlows, len(resultMap))
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package goDB
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// bits per element and number of hash functions chosen for a false positive
+	// rate of approximately 1%
+	bloomBitsPerElement = 10
+	bloomNumHashes      = 7
+
+	// minimum size of a bloom filter in bytes
+	bloomMinBytes = 8
+)
+
+type bloomFilter struct {
+	k    uint8
+	bits []byte
+}
+
+func newBloomFilter(numElements int) *bloomFilter {
+	numBytes := (numElements*bloomBitsPerElement + 7) / 8
+	if numBytes < bloomMinBytes {
+		numBytes = bloomMinBytes
+	}
+	return &bloomFilter{k: bloomNumHashes, bits: make([]byte, numBytes)}
+}
+
+// bloomHashes computes two independent 64 bit hashes (FNV-1a with different offset
+// bases), from which all k hashes are derived by means of double hashing
+func bloomHashes(data []byte) (h1, h2 uint64) {
+	const prime = 1099511628211
+	h1, h2 = 14695981039346656037, 0x9e3779b97f4a7c15
+	for _, b := range data {
+		h1 = (h1 ^ uint64(b)) * prime
+		h2 = (h2 ^ uint64(b)) * prime
+	}
+	return h1, h2 | 1
+}
+
+func (f *bloomFilter) add(data []byte) {
+	h1, h2 := bloomHashes(data)
+	numBits := uint64(len(f.bits)) * 8
+	for i := uint64(0); i < uint64(f.k); i++ {
+		pos := (h1 + i*h2) % numBits
+		f.bits[pos/8] |= 1 << (pos % 8)
+	}
+}
+
+// mayContain returns false if data is definitely not contained in the filter
+func (f *bloomFilter) mayContain(data []byte) bool {
+	h1, h2 := bloomHashes(data)
+	numBits := uint64(len(f.bits)) * 8
+	for i := uint64(0); i < uint64(f.k); i++ {
+		pos := (h1 + i*h2) % numBits
+		if f.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Layout: number of hashes (1 byte), length of bit array (uvarint), bit array
+func (f *bloomFilter) marshal(buf []byte) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	buf = append(buf, f.k)
+	buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(f.bits)))]...)
+	return append(buf, f.bits...)
+}
+
+var errCorruptBloomFilter = errors.New("Corrupt bloom filter")
+
+func unmarshalBloomFilter(data []byte) (*bloomFilter, []byte, error) {
+	if len(data) < 1 {
+		return nil, nil, errCorruptBloomFilter
+	}
+	k := data[0]
+	numBytes, n := binary.Uvarint(data[1:])
+	if n <= 0 || k == 0 || numBytes == 0 || uint64(len(data)-1-n) < numBytes {
+		return nil, nil, errCorruptBloomFilter
+	}
+	data = data[1+n:]
+	return &bloomFilter{k: k, bits: data[:numBytes]}, data[numBytes:], nil
+}
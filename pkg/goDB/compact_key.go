@@ -0,0 +1,179 @@
+package goDB
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// CompactKey is the query specific representation of the key of an aggregated flow. It
+// only holds the attributes of the query, in the following order: time (uvarint),
+// interface (interned as a uvarint ID), IP version, source and destination address (4
+// bytes each for IPv4 flows, 16 otherwise, masked to their networks for queries by snet or
+// dnet), protocol and destination port. A dport query
+// hence aggregates its flows by two bytes instead of a full ExtraKey, and the key of an
+// IPv4 conversation fits into the 16 bytes the runtime allocates in its smallest class.
+//
+// Use Query.DecodeKey to convert a key back into an ExtraKey
+type CompactKey string
+
+// appendKeyPrefix appends the attributes shared by all flows of a block to buf
+func (q *Query) appendKeyPrefix(buf []byte, tstamp int64, ifaceID int) []byte {
+	var varint [binary.MaxVarintLen64]byte
+	if q.hasAttrTime {
+		buf = append(buf, varint[:binary.PutUvarint(varint[:], uint64(tstamp))]...)
+	}
+	if q.hasAttrIface {
+		buf = append(buf, varint[:binary.PutUvarint(varint[:], uint64(ifaceID))]...)
+	}
+	return buf
+}
+
+// appendKeyAttributes appends the attributes of the i-th flow of the blocks to buf
+func (q *Query) appendKeyAttributes(buf []byte, blocks *[ColIdxCount][]byte, i int) []byte {
+	var ipVersion byte
+	if q.isKeyColumn[IPVersionColIdx] {
+		// the IP version of flows written before it was stored explicitly is inferred from
+		// the addresses, so that they share their keys with flows of more recent blocks
+		ipVersion = rowIPVersion(blocks, i)
+		buf = append(buf, ipVersion)
+	}
+	if q.isKeyColumn[SipColIdx] {
+		buf = appendKeyAddress(buf, blocks[SipColIdx][i*SipSizeof:i*SipSizeof+SipSizeof], ipVersion, q.sipMask)
+	}
+	if q.isKeyColumn[DipColIdx] {
+		buf = appendKeyAddress(buf, blocks[DipColIdx][i*DipSizeof:i*DipSizeof+DipSizeof], ipVersion, q.dipMask)
+	}
+	if q.isKeyColumn[ProtoColIdx] {
+		buf = append(buf, blocks[ProtoColIdx][i])
+	}
+	if q.isKeyColumn[DportColIdx] {
+		buf = append(buf, blocks[DportColIdx][i*DportSizeof:i*DportSizeof+DportSizeof]...)
+	}
+	return buf
+}
+
+// appendKeyAddress appends an address, omitting the trailing zeros of IPv4 addresses.
+// Addresses of unknown IP version are kept in full. The address is masked to its
+// network unless mask is nil
+func appendKeyAddress(buf []byte, ip []byte, ipVersion byte, mask *netmask) []byte {
+	n := len(buf)
+	if ipVersion == IPv4 {
+		buf = append(buf, ip[:4]...)
+	} else {
+		buf = append(buf, ip...)
+	}
+	if mask != nil {
+		mask.apply(buf[n:], ipVersion)
+	}
+	return buf
+}
+
+// netmask holds the masks of IPv4 and IPv6 addresses of a network attribute
+type netmask struct {
+	v4, v6 [16]byte
+}
+
+func newNetmask(lengths PrefixLengths) *netmask {
+	var m netmask
+	copy(m.v4[:], net.CIDRMask(lengths.V4, 32))
+	copy(m.v6[:], net.CIDRMask(lengths.V6, 128))
+	return &m
+}
+
+// apply masks the address (of the given IP version) in place
+func (m *netmask) apply(ip []byte, ipVersion byte) {
+	mask := &m.v6
+	if ipVersion == IPv4 {
+		mask = &m.v4
+	}
+	for i := range ip {
+		ip[i] &= mask[i]
+	}
+}
+
+// maskNetworks masks the addresses of the key to the networks of the query (if any)
+func (q *Query) maskNetworks(key *Key) {
+	ipVersion := key.Version()
+	if q.sipMask != nil {
+		q.sipMask.apply(key.Sip[:], ipVersion)
+	}
+	if q.dipMask != nil {
+		q.dipMask.apply(key.Dip[:], ipVersion)
+	}
+}
+
+// EncodeKey returns the compact key of the query for the given key, interning its
+// interface as ifaceID
+func (q *Query) EncodeKey(key *ExtraKey, ifaceID int) CompactKey {
+	blocks := [ColIdxCount][]byte{
+		SipColIdx:       key.Sip[:],
+		DipColIdx:       key.Dip[:],
+		ProtoColIdx:     {key.Protocol},
+		DportColIdx:     key.Dport[:],
+		IPVersionColIdx: {key.IPVersion},
+	}
+	return CompactKey(q.appendKeyAttributes(q.appendKeyPrefix(nil, key.Time, ifaceID), &blocks, 0))
+}
+
+// DecodeKey converts a compact key of the query back into an ExtraKey. ifaces maps the
+// interface IDs to their names, i.e. it holds the interfaces of the work managers passed
+// to ExecuteReadJobs in the same order
+func (q *Query) DecodeKey(k CompactKey, ifaces []string) ExtraKey {
+	var key ExtraKey
+	if q.hasAttrTime {
+		t, n := binary.Uvarint([]byte(k))
+		key.Time = int64(t)
+		k = k[n:]
+	}
+	if q.hasAttrIface {
+		id, n := binary.Uvarint([]byte(k))
+		if int(id) < len(ifaces) {
+			key.Iface = ifaces[id]
+		}
+		k = k[n:]
+	}
+	if q.isKeyColumn[IPVersionColIdx] {
+		key.IPVersion = k[0]
+		k = k[1:]
+	}
+	addressLen := SipSizeof
+	if key.IPVersion == IPv4 {
+		addressLen = 4
+	}
+	if q.isKeyColumn[SipColIdx] {
+		copy(key.Sip[:], k[:addressLen])
+		k = k[addressLen:]
+	}
+	if q.isKeyColumn[DipColIdx] {
+		copy(key.Dip[:], k[:addressLen])
+		k = k[addressLen:]
+	}
+	if q.isKeyColumn[ProtoColIdx] {
+		key.Protocol = k[0]
+		k = k[1:]
+	}
+	if q.isKeyColumn[DportColIdx] {
+		copy(key.Dport[:], k[:DportSizeof])
+	}
+	return key
+}
+
+// KeyTime returns the time attribute of a compact key of the query (or zero if the query
+// doesn't aggregate by time)
+func (q *Query) KeyTime(k CompactKey) int64 {
+	if !q.hasAttrTime {
+		return 0
+	}
+	t, _ := binary.Uvarint([]byte(k))
+	return int64(t)
+}
+
+// SeriesKey returns the compact key without its time attribute, i.e. the key shared by
+// the keys of all time buckets of a time series
+func (q *Query) SeriesKey(k CompactKey) CompactKey {
+	if !q.hasAttrTime {
+		return k
+	}
+	_, n := binary.Uvarint([]byte(k))
+	return k[n:]
+}
@@ -0,0 +1,143 @@
+package goDB
+
+import (
+	"testing"
+)
+
+func testCompactKey(t *testing.T, sip, dip string, ipVersion byte) ExtraKey {
+	sipBytes, err := IPStringToBytes(sip)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %s", sip, err)
+	}
+	dipBytes, err := IPStringToBytes(dip)
+	if err != nil {
+		t.Fatalf("Failed to parse %s: %s", dip, err)
+	}
+
+	key := ExtraKey{Time: 1456358400, Iface: "eth1"}
+	copy(key.Sip[:], sipBytes)
+	copy(key.Dip[:], dipBytes)
+	key.Dport, key.Protocol, key.IPVersion = [2]byte{0x01, 0xbb}, 6, ipVersion
+	return key
+}
+
+func TestCompactKey(t *testing.T) {
+	var tests = []struct {
+		queryType string
+		key       ExtraKey
+		keyLen    int
+	}{
+		{"dport", testCompactKey(t, "10.0.0.1", "10.0.0.2", IPv4), 2},
+		{"talk_conv", testCompactKey(t, "10.0.0.1", "10.0.0.2", IPv4), 1 + 4 + 4},
+		{"talk_conv", testCompactKey(t, "2001:db8::1", "fe80::1", IPv6), 1 + 16 + 16},
+		{"talk_conv", testCompactKey(t, "10.0.0.1", "10.0.0.2", 0), 1 + 4 + 4},
+		{"talk_conv", testCompactKey(t, "2001:db8::1", "fe80::1", 0), 1 + 16 + 16},
+		{"sip,dport,proto", testCompactKey(t, "10.0.0.1", "10.0.0.2", IPv4), 1 + 4 + 2 + 1},
+		{"ipv,proto", testCompactKey(t, "2001:db8::1", "fe80::1", IPv6), 1 + 1},
+		{"time,iface,dip", testCompactKey(t, "2001:db8::1", "fe80::1", IPv6), 5 + 1 + 1 + 16},
+		{"raw", testCompactKey(t, "10.0.0.1", "10.0.0.2", IPv4), 5 + 1 + 1 + 4 + 4 + 1 + 2},
+		{"snet/32/128,dnet/32/128", testCompactKey(t, "10.0.0.1", "10.0.0.2", IPv4), 1 + 4 + 4},
+		{"snet/32/128,dnet/32/128", testCompactKey(t, "2001:db8::1", "fe80::1", IPv6), 1 + 16 + 16},
+	}
+
+	ifaces := []string{"eth0", "eth1"}
+	for _, test := range tests {
+		attributes, hasAttrTime, hasAttrIface, err := ParseQueryType(test.queryType)
+		if err != nil {
+			t.Fatalf("Failed to parse query type %s: %s", test.queryType, err)
+		}
+		query := NewQuery(attributes, nil, hasAttrTime, hasAttrIface)
+
+		k := query.EncodeKey(&test.key, 1)
+		if len(k) != test.keyLen {
+			t.Fatalf("%s: unexpected key length: want %d, have %d", test.queryType, test.keyLen, len(k))
+		}
+
+		// only the attributes of the query are retained, unknown IP versions are inferred
+		var want ExtraKey
+		if hasAttrTime {
+			want.Time = test.key.Time
+		}
+		if hasAttrIface {
+			want.Iface = test.key.Iface
+		}
+		for _, colIdx := range query.queryAttributeIndizes {
+			copyToKeyFns[colIdx](0, &want, [ColIdxAttributeCount][]byte{
+				SipColIdx:       test.key.Sip[:],
+				DipColIdx:       test.key.Dip[:],
+				ProtoColIdx:     {test.key.Protocol},
+				DportColIdx:     test.key.Dport[:],
+				IPVersionColIdx: {test.key.Version()},
+			}[colIdx])
+		}
+		if have := query.DecodeKey(k, ifaces); have != want {
+			t.Fatalf("%s: unexpected decoded key: want %v, have %v", test.queryType, want, have)
+		}
+		if query.KeyTime(k) != want.Time {
+			t.Fatalf("%s: unexpected time: want %d, have %d", test.queryType, want.Time, query.KeyTime(k))
+		}
+	}
+}
+
+func TestCompactKeyUnknownIPVersion(t *testing.T) {
+	for _, queryType := range []string{"sip", "dip", "talk_conv", "ipv", "snet,dnet", "raw"} {
+		attributes, hasAttrTime, hasAttrIface, err := ParseQueryType(queryType)
+		if err != nil {
+			t.Fatalf("Failed to parse query type %s: %s", queryType, err)
+		}
+		query := NewQuery(attributes, nil, hasAttrTime, hasAttrIface)
+
+		// flows written before the IP version was stored share the keys of current ones
+		for _, addrs := range [][2]string{{"10.0.0.1", "10.0.0.2"}, {"2001:db8::1", "fe80::1"}} {
+			legacy, current := testCompactKey(t, addrs[0], addrs[1], 0), testCompactKey(t, addrs[0], addrs[1], 0)
+			current.IPVersion = current.Version()
+			if query.EncodeKey(&legacy, 1) != query.EncodeKey(&current, 1) {
+				t.Fatalf("%s: keys of %v differ depending on the stored IP version", queryType, addrs)
+			}
+		}
+	}
+}
+
+func TestCompactKeyNetworks(t *testing.T) {
+	var tests = []struct {
+		queryType string
+		key       ExtraKey
+		snet      string
+		dnet      string
+	}{
+		{"snet/24,dnet/16", testCompactKey(t, "10.1.2.3", "192.168.1.1", IPv4), "10.1.2.0/24", "192.168.0.0/16"},
+		{"snet/48,dnet/8/64", testCompactKey(t, "2001:db8:1:2::1", "fe80::1:2", IPv6), "2001:db8:1::/48", "fe80::/64"},
+		{"snet/0/0,dnet/32/128", testCompactKey(t, "10.1.2.3", "192.168.1.1", IPv4), "0.0.0.0/0", "192.168.1.1/32"},
+
+		// the IP version is inferred from the addresses if unknown
+		{"snet/8/16,dnet/8/16", testCompactKey(t, "10.1.2.3", "192.168.1.1", 0), "10.0.0.0/8", "192.0.0.0/8"},
+		{"snet/8/16,dnet/8/16", testCompactKey(t, "2001:db8::1", "fe80::1", 0), "2001::/16", "fe80::/16"},
+	}
+
+	for _, test := range tests {
+		attributes, hasAttrTime, hasAttrIface, err := ParseQueryType(test.queryType)
+		if err != nil {
+			t.Fatalf("Failed to parse query type %s: %s", test.queryType, err)
+		}
+		query := NewQuery(attributes, nil, hasAttrTime, hasAttrIface)
+
+		key := query.DecodeKey(query.EncodeKey(&test.key, 0), nil)
+		if snet := attributes[0].ExtractStrings(&key)[0]; snet != test.snet {
+			t.Fatalf("%s: unexpected source network: want %s, have %s", test.queryType, test.snet, snet)
+		}
+		if dnet := attributes[1].ExtractStrings(&key)[0]; dnet != test.dnet {
+			t.Fatalf("%s: unexpected destination network: want %s, have %s", test.queryType, test.dnet, dnet)
+		}
+
+		// flows from the same source network share their key
+		sibling := test.key
+		if sibling.Version() == IPv4 {
+			sibling.Sip[3]++
+		} else {
+			sibling.Sip[15]++
+		}
+		if query.EncodeKey(&sibling, 0) != query.EncodeKey(&test.key, 0) {
+			t.Fatalf("%s: flows from the same source network have different keys", test.queryType)
+		}
+	}
+}
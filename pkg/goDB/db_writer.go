@@ -13,13 +13,12 @@ package goDB
 import (
 	"encoding/binary"
 	"fmt"
-	"os"
 	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
-	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
 )
 
 const (
@@ -29,6 +28,14 @@ const (
 	MetadataFileName = "meta.json"
 )
 
+// Column-aware codecs applied to the columns if column encoding is enabled: IP addresses
+// repeat within a block, protocols and IP versions are small integers and ports / counters
+// are mostly far smaller than their fixed-width representation suggests. The selection is based on the
+// compression ratios measured on the bundled test database (see BenchmarkColumnEncodingTestDB)
+var columnCodecs = [ColIdxCount]encoders.Codec{
+	encoders.CodecDict, encoders.CodecDict, encoders.CodecBitpack, encoders.CodecVarint, encoders.CodecBitpack,
+	encoders.CodecVarint, encoders.CodecVarint, encoders.CodecVarint, encoders.CodecVarint}
+
 // DayTimestamp returns timestamp rounded down to the nearest day
 func DayTimestamp(timestamp int64) int64 {
 	return (timestamp / EpochDay) * EpochDay
@@ -43,11 +50,17 @@ type DBWriter struct {
 	encoderType  encoders.Type
 
 	metadata *Metadata
+
+	store          storage.Store
+	columnEncoding bool
+
+	timeIndex *TimeIndex
 }
 
 // NewDBWriter initializes a new DBWriter
-func NewDBWriter(dbpath string, iface string, encoderType encoders.Type) (w *DBWriter) {
-	return &DBWriter{dbpath, iface, 0, encoderType, new(Metadata)}
+func NewDBWriter(dbpath string, iface string, encoderType encoders.Type, opts ...Option) (w *DBWriter) {
+	o := applyOptions(opts)
+	return &DBWriter{dbpath, iface, 0, encoderType, new(Metadata), o.store, o.columnEncoding, nil}
 }
 
 func (w *DBWriter) dailyDir(timestamp int64) (path string) {
@@ -56,67 +69,175 @@ func (w *DBWriter) dailyDir(timestamp int64) (path string) {
 	return
 }
 
-func (w *DBWriter) writeMetadata(timestamp int64, meta BlockMetadata) error {
+// loadMetadata makes sure the metadata of the day of timestamp is loaded
+func (w *DBWriter) loadMetadata(timestamp int64) {
 	if w.dayTimestamp != DayTimestamp(timestamp) {
 		w.metadata = nil
 		w.dayTimestamp = DayTimestamp(timestamp)
 	}
 
-	path := filepath.Join(w.dailyDir(timestamp), MetadataFileName)
-
 	if w.metadata == nil {
-		w.metadata = TryReadMetadata(path)
+		w.metadata = tryReadMetadataFrom(w.store, filepath.Join(w.dailyDir(timestamp), MetadataFileName))
 	}
+}
 
-	w.metadata.Blocks = append(w.metadata.Blocks, meta)
+// writtenBlock returns the metadata of the block written at timestamp, or nil if there is
+// none. Blocks without metadata haven't been written completely (the metadata is written
+// last) and are hence overwritten rather than merged
+func (w *DBWriter) writtenBlock(timestamp int64) *BlockMetadata {
+	w.loadMetadata(timestamp)
+	for i := range w.metadata.Blocks {
+		if w.metadata.Blocks[i].Timestamp == timestamp {
+			return &w.metadata.Blocks[i]
+		}
+	}
+	return nil
+}
+
+func (w *DBWriter) writeMetadata(timestamp int64, meta BlockMetadata) error {
+	w.loadMetadata(timestamp)
+
+	// the metadata of a merged block replaces the existing one
+	var (
+		blocks   = w.metadata.Blocks[:0]
+		replaced bool
+	)
+	for _, block := range w.metadata.Blocks {
+		if block.Timestamp != timestamp {
+			blocks = append(blocks, block)
+		} else if !replaced {
+			blocks = append(blocks, meta)
+			replaced = true
+		}
+	}
+	if !replaced {
+		blocks = append(blocks, meta)
+	}
+	w.metadata.Blocks = blocks
 
-	return WriteMetadata(path, w.metadata)
+	return writeMetadataTo(w.store, filepath.Join(w.dailyDir(timestamp), MetadataFileName), w.metadata)
 }
 
-func (w *DBWriter) writeBlock(timestamp int64, column string, data []byte) error {
-	path := filepath.Join(w.dailyDir(timestamp), column+".gpf")
-	gpfile, err := gpfile.New(path, gpfile.ModeWrite, gpfile.WithEncoder(w.encoderType))
+func (w *DBWriter) writeBlock(timestamp int64, colIdx columnIndex, data []byte) error {
+	encoderType := w.encoderType
+	if w.columnEncoding {
+		encoderType = encoders.Chain(columnCodecs[colIdx], columnSizeofs[colIdx], w.encoderType)
+	}
+
+	path := filepath.Join(w.dailyDir(timestamp), columnFileNames[colIdx]+".gpf")
+	backend, err := w.store.Open(path, storage.ModeWrite, encoderType)
 	if err != nil {
 		return err
 	}
-	defer gpfile.Close()
+	defer backend.Close()
 
-	if err := gpfile.WriteBlock(timestamp, data); err != nil {
+	if err := backend.WriteBlock(timestamp, data); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (w *DBWriter) createQueryLog() error {
-	var (
-		err     error
-		logfile *os.File
-	)
-	qlogPath := filepath.Join(w.dbpath, QueryLogFile)
-	logfile, err = os.OpenFile(qlogPath, os.O_CREATE, 0666)
+// writeBlockStats stores the statistics of the block written at timestamp in the block
+// statistics sidecar file
+func (w *DBWriter) writeBlockStats(timestamp int64, stats *blockStats) error {
+	path := filepath.Join(w.dailyDir(timestamp), BlockStatsFileName+".gpf")
+	backend, err := w.store.Open(path, storage.ModeWrite, w.encoderType)
 	if err != nil {
-		err = fmt.Errorf("failed to create query log: %s", err)
 		return err
 	}
-	logfile.Close()
-	err = os.Chmod(qlogPath, 0666)
+	defer backend.Close()
+
+	return backend.WriteBlock(timestamp, stats.marshal())
+}
+
+// updateTimeIndex adds the block written at timestamp to the time index of the interface.
+// Upon the first update, the index is loaded and reconciled with the blocks of the day
+// (which may be missing if the previous writer was interrupted), or rebuilt if it is
+// missing or damaged. If the index cannot be updated, it is invalidated so that queries
+// fall back to reading the block headers instead of missing the block
+func (w *DBWriter) updateTimeIndex(timestamp int64) (err error) {
+	ifacePath := filepath.Join(w.dbpath, w.iface)
+	defer func() {
+		if err != nil {
+			w.timeIndex = nil
+			w.store.WriteFile(filepath.Join(ifacePath, TimeIndexFileName), nil)
+		}
+	}()
+
+	if w.timeIndex != nil {
+		if w.timeIndex.contains(timestamp) {
+			return nil
+		}
+		return w.timeIndex.appendTimestamp(w.store, ifacePath, timestamp)
+	}
+
+	index, err := ReadTimeIndex(w.store, ifacePath)
+	if err != nil {
+		w.timeIndex, err = RebuildTimeIndex(w.store, ifacePath)
+		return err
+	}
+	timestamps, err := dayBlockTimestamps(w.store, w.dailyDir(timestamp))
 	if err != nil {
-		err = fmt.Errorf("failed to set query log permissions: %s", err)
 		return err
 	}
+	for _, ts := range timestamps {
+		if index.contains(ts) {
+			continue
+		}
+		if err = index.appendTimestamp(w.store, ifacePath, ts); err != nil {
+			return err
+		}
+	}
+	w.timeIndex = index
 	return nil
 }
 
-// Write takes an aggregated flow map and its metadata and writes it to disk for a given timestamp
+func (w *DBWriter) createQueryLog() error {
+
+	// appending nothing creates the query log (with the appropriate permissions)
+	// if it doesn't exist yet
+	if err := w.store.AppendFile(filepath.Join(w.dbpath, QueryLogFile), nil); err != nil {
+		return fmt.Errorf("failed to create query log: %s", err)
+	}
+	return nil
+}
+
+// mergeWrittenBlock merges the flows and metadata with those of the block written at
+// timestamp. Returns the merged flows and metadata, as well as the number of flows and
+// traffic of the existing block (which are already accounted for in the summary)
+func (w *DBWriter) mergeWrittenBlock(flowmap AggFlowMap, meta BlockMetadata, timestamp int64, written *BlockMetadata) (AggFlowMap, BlockMetadata, InterfaceSummaryUpdate, error) {
+	var existing InterfaceSummaryUpdate
+
+	flows, err := readDayBlock(w.store, w.dailyDir(timestamp), timestamp)
+	if err != nil {
+		return nil, meta, existing, err
+	}
+	for _, V := range flows {
+		existing.FlowCount++
+		existing.Traffic += V.NBytesRcvd + V.NBytesSent
+	}
+
+	block := &dayBlock{flows: flows, meta: *written}
+	block.merge(&dayBlock{flows: flowmap, meta: meta})
+
+	return block.flows, block.meta, existing, nil
+}
+
+// Write takes an aggregated flow map and its metadata and writes it to disk for a given timestamp.
+// If a block has been written for the timestamp already (e.g. after a restart of goProbe
+// within the same write interval, or when importing overlapping data), the flows are merged
+// with the existing ones and the block is replaced. The returned summary update only covers
+// the flows and traffic added to the database
 func (w *DBWriter) Write(flowmap AggFlowMap, meta BlockMetadata, timestamp int64) (InterfaceSummaryUpdate, error) {
 	var (
-		dbdata [ColIdxCount][]byte
-		update InterfaceSummaryUpdate
-		err    error
+		dbdata   [ColIdxCount][]byte
+		update   InterfaceSummaryUpdate
+		existing InterfaceSummaryUpdate
+		err      error
 	)
 
-	err = os.MkdirAll(w.dailyDir(timestamp), 0755)
+	err = w.store.MkdirAll(w.dailyDir(timestamp))
 	if err != nil {
 		err = fmt.Errorf("Could not create daily directory: %s", err.Error())
 		return update, err
@@ -128,20 +249,36 @@ func (w *DBWriter) Write(flowmap AggFlowMap, meta BlockMetadata, timestamp int64
 		return update, err
 	}
 
+	if written := w.writtenBlock(timestamp); written != nil {
+		if flowmap, meta, existing, err = w.mergeWrittenBlock(flowmap, meta, timestamp, written); err != nil {
+			return update, fmt.Errorf("Could not merge with existing block %d: %s", timestamp, err)
+		}
+	}
+
 	dbdata, update = dbData(w.iface, timestamp, flowmap)
 
 	for i := columnIndex(0); i < ColIdxCount; i++ {
-		if err = w.writeBlock(timestamp, columnFileNames[i], dbdata[i]); err != nil {
+		if err = w.writeBlock(timestamp, i, dbdata[i]); err != nil {
 			return update, err
 		}
 	}
 
+	if err = w.writeBlockStats(timestamp, newBlockStats(flowmap)); err != nil {
+		return update, err
+	}
+
 	meta.FlowCount = update.FlowCount
 	meta.Traffic = update.Traffic
 
 	if err = w.writeMetadata(timestamp, meta); err != nil {
 		return update, err
 	}
+	update.FlowCount -= existing.FlowCount
+	update.Traffic -= existing.Traffic
+
+	if err = w.updateTimeIndex(timestamp); err != nil {
+		return update, fmt.Errorf("Could not update time index: %s", err)
+	}
 
 	return update, err
 }
@@ -184,6 +321,7 @@ func dbData(iface string, timestamp int64, aggFlowMap AggFlowMap) ([ColIdxCount]
 		dbData[SipColIdx] = append(dbData[SipColIdx], K.Sip[:]...)
 		dbData[DportColIdx] = append(dbData[DportColIdx], K.Dport[:]...)
 		dbData[ProtoColIdx] = append(dbData[ProtoColIdx], K.Protocol)
+		dbData[IPVersionColIdx] = append(dbData[IPVersionColIdx], K.IPVersion)
 	}
 
 	return dbData, *summUpdate
@@ -0,0 +1,108 @@
+package goDB
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+func TestWriteDuplicateBlock(t *testing.T) {
+	const timestamp = int64(1456428600)
+
+	var (
+		shared = testStatsKey("10.0.0.1", "10.0.0.2", 443, 6)
+		other  = testStatsKey("10.0.0.3", "10.0.0.4", 53, 17)
+	)
+	shared.IPVersion, other.IPVersion = IPv4, IPv4
+
+	var tests = []struct {
+		name string
+
+		// interrupted denotes that the metadata of the first block is lost, i.e. that
+		// it hasn't been written completely
+		interrupted bool
+
+		wantFlows AggFlowMap
+		wantMeta  BlockMetadata
+	}{
+		{"merge", false,
+			AggFlowMap{shared: &Val{NBytesRcvd: 11, NBytesSent: 22}, other: &Val{NBytesRcvd: 5}},
+			BlockMetadata{Timestamp: timestamp, PcapPacketsReceived: 15, PcapPacketsDropped: 1, PacketsLogged: 7, FlowCount: 2, Traffic: 38}},
+		{"overwrite incomplete block", true,
+			AggFlowMap{shared: &Val{NBytesRcvd: 10, NBytesSent: 20}, other: &Val{NBytesRcvd: 5}},
+			BlockMetadata{Timestamp: timestamp, PcapPacketsReceived: 5, PcapPacketsDropped: -1, PacketsLogged: 4, FlowCount: 2, Traffic: 35}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "dbwriter")
+			if err != nil {
+				t.Fatalf("Failed to create temporary directory: %s", err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			var (
+				summary = NewDBSummary()
+				store   = gpfile.NewStore()
+				dayDir  = filepath.Join(tmpDir, "eth0", "1456358400")
+			)
+			write := func(w *DBWriter, flowmap AggFlowMap, meta BlockMetadata) {
+				t.Helper()
+				update, err := w.Write(flowmap, meta, timestamp)
+				if err != nil {
+					t.Fatalf("Failed to write flows: %s", err)
+				}
+				summary.Update(update)
+			}
+
+			write(NewDBWriter(tmpDir, "eth0", encoders.EncoderTypeLZ4),
+				AggFlowMap{shared: &Val{NBytesRcvd: 1, NBytesSent: 2}},
+				BlockMetadata{Timestamp: timestamp, PcapPacketsReceived: 10, PcapPacketsDropped: 1, PacketsLogged: 3})
+			if test.interrupted {
+				if err = os.Remove(filepath.Join(dayDir, MetadataFileName)); err != nil {
+					t.Fatalf("Failed to remove metadata: %s", err)
+				}
+				summary = NewDBSummary()
+			}
+
+			// the block is written again, e.g. by a restarted goProbe
+			write(NewDBWriter(tmpDir, "eth0", encoders.EncoderTypeLZ4),
+				AggFlowMap{shared: &Val{NBytesRcvd: 10, NBytesSent: 20}, other: &Val{NBytesRcvd: 5}},
+				BlockMetadata{Timestamp: timestamp, PcapPacketsReceived: 5, PcapPacketsDropped: -1, PacketsLogged: 4})
+
+			blocks, err := readDayBlocks(store, dayDir)
+			if err != nil {
+				t.Fatalf("Failed to read day: %s", err)
+			}
+			if len(blocks) != 1 {
+				t.Fatalf("Unexpected number of blocks: %d", len(blocks))
+			}
+			if !reflect.DeepEqual(blocks[timestamp].flows, test.wantFlows) {
+				t.Fatalf("Unexpected flows: want %v, have %v", test.wantFlows, blocks[timestamp].flows)
+			}
+
+			meta := TryReadMetadata(filepath.Join(dayDir, MetadataFileName))
+			if len(meta.Blocks) != 1 || meta.Blocks[0] != test.wantMeta {
+				t.Fatalf("Unexpected metadata: want %+v, have %+v", test.wantMeta, meta.Blocks)
+			}
+
+			// the summary updates account for each flow and byte exactly once
+			summ, err := RebuildInterfaceSummary(store, tmpDir, "eth0")
+			if err != nil {
+				t.Fatalf("Failed to rebuild summary: %s", err)
+			}
+			if summary.Interfaces["eth0"] != summ {
+				t.Fatalf("Unexpected summary: want %+v, have %+v", summ, summary.Interfaces["eth0"])
+			}
+
+			index, err := ReadTimeIndex(store, filepath.Join(tmpDir, "eth0"))
+			if err != nil || index.Len() != 1 {
+				t.Fatalf("Unexpected time index: %v (%v)", index, err)
+			}
+		})
+	}
+}
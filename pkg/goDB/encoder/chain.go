@@ -0,0 +1,88 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/codec"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+)
+
+// chainEncoder transforms column data with a column-aware codec before compressing it.
+// The length of the transformed data precedes the compressed data (as uvarint), since
+// the compressors require it for decompression
+type chainEncoder struct {
+	t          encoders.Type
+	compressor Encoder
+}
+
+func newChain(t encoders.Type) (Encoder, error) {
+	if t.Codec() > encoders.MaxCodec {
+		return nil, fmt.Errorf("Unsupported codec: %d", t.Codec())
+	}
+	compressor, err := New(t.Compressor())
+	if err != nil {
+		return nil, err
+	}
+	return &chainEncoder{t: t, compressor: compressor}, nil
+}
+
+// Type will return the type of encoder
+func (e *chainEncoder) Type() encoders.Type {
+	return e.t
+}
+
+// Compress transforms and compresses the input data and writes it to dst
+func (e *chainEncoder) Compress(data []byte, dst io.Writer) (n int, err error) {
+	transformed, err := codec.Encode(e.t.Codec(), e.t.Width(), data)
+	if err != nil {
+		return 0, err
+	}
+
+	var lenBuf [binary.MaxVarintLen64]byte
+	if n, err = dst.Write(lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(transformed)))]); err != nil {
+		return n, err
+	}
+	if len(transformed) == 0 {
+		return n, nil
+	}
+
+	nCompressed, err := e.compressor.Compress(transformed, dst)
+	return n + nCompressed, err
+}
+
+// Decompress reads compressed bytes from src into in, decompresses and reverts the
+// transformation into out
+func (e *chainEncoder) Decompress(in, out []byte, src io.Reader) (n int, err error) {
+	if _, err = io.ReadFull(src, in); err != nil {
+		return 0, err
+	}
+
+	transformedLen, nRead := binary.Uvarint(in)
+	if nRead <= 0 {
+		return 0, errors.New("Invalid length of transformed data detected during decompression")
+	}
+
+	transformed := make([]byte, transformedLen)
+	if transformedLen > 0 {
+
+		// the compressed payload is already in memory, so it is "read" onto itself
+		payload := in[nRead:]
+		nDecompressed, err := e.compressor.Decompress(payload, transformed, bytes.NewReader(payload))
+		if err != nil {
+			return 0, err
+		}
+		if nDecompressed != len(transformed) {
+			return 0, errors.New("Unexpected amount of transformed data after decompression")
+		}
+	}
+
+	if err = codec.Decode(e.t.Codec(), e.t.Width(), transformed, out); err != nil {
+		return 0, err
+	}
+
+	return len(out), nil
+}
@@ -0,0 +1,83 @@
+package codec
+
+// encodeBitpack stores each element as its offset from the smallest element of the block,
+// using as few bits per element as required by the largest offset.
+// Layout: bits per element (1 byte), minimum (width bytes), packed offsets
+func encodeBitpack(width int, data []byte) []byte {
+	n := len(data) / width
+
+	var min, max uint64
+	for i := 0; i < n; i++ {
+		v := uint64At(data, i, width)
+		if i == 0 || v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+
+	bits := 0
+	for r := max - min; r > 0; r >>= 1 {
+		bits++
+	}
+
+	out := make([]byte, 1+width+(n*bits+7)/8)
+	out[0] = byte(bits)
+	putUint64At(out[1:], 0, width, min)
+
+	if bits > 0 {
+		packed := out[1+width:]
+		for i, bitPos := 0, 0; i < n; i, bitPos = i+1, bitPos+bits {
+			putBits(packed, bitPos, bits, uint64At(data, i, width)-min)
+		}
+	}
+
+	return out
+}
+
+func decodeBitpack(width int, in, out []byte) error {
+	n := len(out) / width
+	if len(in) < 1+width {
+		return errCorrupt
+	}
+	bits := int(in[0])
+	if bits > 8*width || len(in) != 1+width+(n*bits+7)/8 {
+		return errCorrupt
+	}
+	min := uint64At(in[1:], 0, width)
+
+	packed := in[1+width:]
+	for i, bitPos := 0, 0; i < n; i, bitPos = i+1, bitPos+bits {
+		putUint64At(out, i, width, min+getBits(packed, bitPos, bits))
+	}
+
+	return nil
+}
+
+// putBits writes the lowest n bits of v to buf, starting at bit position pos (MSB first)
+func putBits(buf []byte, pos, n int, v uint64) {
+	for n > 0 {
+		avail := 8 - pos%8
+		take := avail
+		if n < take {
+			take = n
+		}
+		buf[pos/8] |= (byte(v>>uint(n-take)) & (1<<uint(take) - 1)) << uint(avail-take)
+		pos, n = pos+take, n-take
+	}
+}
+
+// getBits reads n bits from buf, starting at bit position pos (MSB first)
+func getBits(buf []byte, pos, n int) (v uint64) {
+	for n > 0 {
+		avail := 8 - pos%8
+		take := avail
+		if n < take {
+			take = n
+		}
+		v = v<<uint(take) | uint64((buf[pos/8]>>uint(avail-take))&(1<<uint(take)-1))
+		pos, n = pos+take, n-take
+	}
+	return
+}
@@ -0,0 +1,107 @@
+// Package codec implements column-aware transformations of goDB column data. Column
+// elements are fixed-width, big-endian values (e.g. 16 byte IP addresses or 8 byte
+// counters), which are transformed into a more compact representation before being
+// compressed
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+)
+
+// maxIntegerWidth denotes the maximum element width supported by integer codecs
+const maxIntegerWidth = 8
+
+// Encode transforms the column data, consisting of elements of the given width, using
+// the codec c
+func Encode(c encoders.Codec, width int, data []byte) ([]byte, error) {
+	if err := check(c, width, len(data)); err != nil {
+		return nil, err
+	}
+
+	switch c {
+	case encoders.CodecDict:
+		return encodeDict(width, data), nil
+	case encoders.CodecBitpack:
+		return encodeBitpack(width, data), nil
+	case encoders.CodecVarint:
+		return encodeVarint(width, data, false), nil
+	case encoders.CodecDelta:
+		return encodeVarint(width, data, true), nil
+	default:
+		return nil, fmt.Errorf("Unsupported codec: %v", c)
+	}
+}
+
+// Decode reverts the transformation of in performed by the codec c, writing the column
+// data to out. It is the responsibility of the caller to ensure that out is properly sized
+func Decode(c encoders.Codec, width int, in, out []byte) error {
+	if err := check(c, width, len(out)); err != nil {
+		return err
+	}
+
+	switch c {
+	case encoders.CodecDict:
+		return decodeDict(width, in, out)
+	case encoders.CodecBitpack:
+		return decodeBitpack(width, in, out)
+	case encoders.CodecVarint:
+		return decodeVarint(width, in, out, false)
+	case encoders.CodecDelta:
+		return decodeVarint(width, in, out, true)
+	default:
+		return fmt.Errorf("Unsupported codec: %v", c)
+	}
+}
+
+func check(c encoders.Codec, width, dataLen int) error {
+	if width < 1 {
+		return fmt.Errorf("Invalid element width for codec %s: %d", c, width)
+	}
+	if c != encoders.CodecDict && width > maxIntegerWidth {
+		return fmt.Errorf("Codec %s does not support elements wider than %d bytes, have %d", c, maxIntegerWidth, width)
+	}
+	if dataLen%width != 0 {
+		return fmt.Errorf("Element width %d does not evenly divide column data of length %d", width, dataLen)
+	}
+	return nil
+}
+
+// uint64At reads the big-endian integer of the given width located at data[i*width:]
+func uint64At(data []byte, i, width int) (v uint64) {
+	switch width {
+	case 1:
+		return uint64(data[i])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(data[i*2:]))
+	case 8:
+		return binary.BigEndian.Uint64(data[i*8:])
+	}
+	for _, b := range data[i*width : (i+1)*width] {
+		v = v<<8 | uint64(b)
+	}
+	return
+}
+
+// putUint64At writes v as big-endian integer of the given width to data[i*width:]
+func putUint64At(data []byte, i, width int, v uint64) {
+	switch width {
+	case 1:
+		data[i] = byte(v)
+		return
+	case 2:
+		binary.BigEndian.PutUint16(data[i*2:], uint16(v))
+		return
+	case 8:
+		binary.BigEndian.PutUint64(data[i*8:], v)
+		return
+	}
+	for j := (i+1)*width - 1; j >= i*width; j-- {
+		data[j] = byte(v)
+		v >>= 8
+	}
+}
+
+var errCorrupt = fmt.Errorf("Corrupt codec data detected during decoding")
@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+)
+
+var testCodecs = []encoders.Codec{
+	encoders.CodecDict,
+	encoders.CodecBitpack,
+	encoders.CodecVarint,
+	encoders.CodecDelta,
+}
+
+func TestRoundtrip(t *testing.T) {
+	r := rand.New(rand.NewSource(42))
+
+	var tests = []struct {
+		name  string
+		width int
+		data  []byte
+	}{
+		{"empty", 8, []byte{}},
+		{"single element", 2, []byte{0x1f, 0x90}},
+		{"constant", 1, bytes.Repeat([]byte{6}, 100)},
+		{"protocols", 1, []byte{6, 17, 6, 6, 1, 17, 58, 6}},
+		{"ports", 2, randomIntegers(r, 2, 1000, 1<<16)},
+		{"counters", 8, randomIntegers(r, 8, 1000, 1<<20)},
+		{"large counters", 8, append(randomIntegers(r, 8, 10, 1<<20), 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)},
+		{"odd width", 3, randomIntegers(r, 3, 100, 1<<24)},
+	}
+
+	for _, c := range testCodecs {
+		for _, test := range tests {
+			t.Run(c.String()+"/"+test.name, func(t *testing.T) {
+				encoded, err := Encode(c, test.width, test.data)
+				if err != nil {
+					t.Fatalf("Failed to encode data: %s", err)
+				}
+
+				out := make([]byte, len(test.data))
+				if err := Decode(c, test.width, encoded, out); err != nil {
+					t.Fatalf("Failed to decode data: %s", err)
+				}
+				if !bytes.Equal(out, test.data) {
+					t.Fatalf("Invalid data detected after round-trip")
+				}
+			})
+		}
+	}
+}
+
+func TestDictIPs(t *testing.T) {
+	var (
+		ips  = [][]byte{ipv4(10, 0, 0, 1), ipv4(10, 0, 0, 2), ipv4(192, 168, 1, 1)}
+		data []byte
+	)
+	for i := 0; i < 300; i++ {
+		data = append(data, ips[i%len(ips)]...)
+	}
+
+	encoded, err := Encode(encoders.CodecDict, 16, data)
+	if err != nil {
+		t.Fatalf("Failed to encode data: %s", err)
+	}
+
+	// 1 byte entry count, 1 byte index width, 3 entries, 1 byte index per element
+	if expected := 1 + 1 + 3*16 + 300; len(encoded) != expected {
+		t.Fatalf("Unexpected size of dictionary encoded data, want %d, have %d", expected, len(encoded))
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	var tests = []struct {
+		name  string
+		codec encoders.Codec
+		width int
+		data  []byte
+	}{
+		{"zero width", encoders.CodecDict, 0, []byte{1}},
+		{"uneven data", encoders.CodecBitpack, 2, []byte{1, 2, 3}},
+		{"too wide for integers", encoders.CodecVarint, 16, make([]byte, 16)},
+		{"unsupported codec", encoders.CodecNone, 1, []byte{1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Encode(test.codec, test.width, test.data); err == nil {
+				t.Fatalf("Expected encoding to fail, but it didn't")
+			}
+		})
+	}
+
+	// truncated data must be detected instead of producing garbage
+	data := randomIntegers(rand.New(rand.NewSource(1)), 8, 100, 1<<30)
+	for _, c := range testCodecs {
+		encoded, err := Encode(c, 8, data)
+		if err != nil {
+			t.Fatalf("Failed to encode data: %s", err)
+		}
+		if err := Decode(c, 8, encoded[:len(encoded)-1], make([]byte, len(data))); err == nil {
+			t.Fatalf("Expected decoding of truncated %s data to fail, but it didn't", c)
+		}
+	}
+}
+
+func randomIntegers(r *rand.Rand, width, n int, max int64) []byte {
+	var (
+		data = make([]byte, n*width)
+		buf  = make([]byte, 8)
+	)
+	for i := 0; i < n; i++ {
+		binary.BigEndian.PutUint64(buf, uint64(r.Int63n(max)))
+		copy(data[i*width:(i+1)*width], buf[8-width:])
+	}
+	return data
+}
+
+func ipv4(a, b, c, d byte) []byte {
+	return []byte{a, b, c, d, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+}
@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"encoding/binary"
+)
+
+// encodeDict replaces each element by its index into a dictionary of all distinct
+// elements of the block. Layout: uvarint(#entries), index width (1 byte), entries,
+// indices (big-endian, index width bytes each)
+func encodeDict(width int, data []byte) []byte {
+	var (
+		n       = len(data) / width
+		indices = make(map[string]uint32)
+		entries = make([]byte, 0)
+		idx     = make([]uint32, n)
+	)
+	for i := 0; i < n; i++ {
+		elem := data[i*width : (i+1)*width]
+		index, exists := indices[string(elem)]
+		if !exists {
+			index = uint32(len(indices))
+			indices[string(elem)] = index
+			entries = append(entries, elem...)
+		}
+		idx[i] = index
+	}
+
+	indexWidth := 1
+	if len(indices) > 0 {
+		indexWidth = bytesRequired(uint64(len(indices) - 1))
+	}
+
+	out := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+1+len(entries)+n*indexWidth)
+	out = append(out[:binary.PutUvarint(out, uint64(len(indices)))], byte(indexWidth))
+	out = append(out, entries...)
+
+	start := len(out)
+	out = out[:start+n*indexWidth]
+	for i, index := range idx {
+		putUint64At(out[start:], i, indexWidth, uint64(index))
+	}
+
+	return out
+}
+
+func decodeDict(width int, in, out []byte) error {
+	nEntries, nRead := binary.Uvarint(in)
+	if nRead <= 0 || len(in) < nRead+1 {
+		return errCorrupt
+	}
+	indexWidth := int(in[nRead])
+	in = in[nRead+1:]
+
+	n := len(out) / width
+	if indexWidth < 1 || indexWidth > 4 || uint64(len(in)) != nEntries*uint64(width)+uint64(n*indexWidth) {
+		return errCorrupt
+	}
+	entries, idx := in[:int(nEntries)*width], in[int(nEntries)*width:]
+
+	for i := 0; i < n; i++ {
+		index := int(uint64At(idx, i, indexWidth))
+		if index >= int(nEntries) {
+			return errCorrupt
+		}
+		copy(out[i*width:(i+1)*width], entries[index*width:(index+1)*width])
+	}
+
+	return nil
+}
+
+// bytesRequired returns the number of bytes required to represent v (at least one)
+func bytesRequired(v uint64) int {
+	n := 1
+	for v > 0xff {
+		v >>= 8
+		n++
+	}
+	return n
+}
@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"encoding/binary"
+)
+
+// encodeVarint stores each element as unsigned varint. In delta mode, the (zig-zag encoded)
+// difference to the previous element is stored instead, which pays off for columns with
+// locally similar values
+func encodeVarint(width int, data []byte, delta bool) []byte {
+	var (
+		n    = len(data) / width
+		out  = make([]byte, 0, n*2)
+		buf  = make([]byte, binary.MaxVarintLen64)
+		prev uint64
+	)
+	for i := 0; i < n; i++ {
+		v := uint64At(data, i, width)
+		if delta {
+			v, prev = zigzag(v-prev), v
+		}
+		out = append(out, buf[:binary.PutUvarint(buf, v)]...)
+	}
+
+	return out
+}
+
+func decodeVarint(width int, in, out []byte, delta bool) error {
+	var (
+		n    = len(out) / width
+		prev uint64
+	)
+	for i := 0; i < n; i++ {
+		v, nRead := binary.Uvarint(in)
+		if nRead <= 0 {
+			return errCorrupt
+		}
+		in = in[nRead:]
+
+		if delta {
+			v = prev + unzigzag(v)
+			prev = v
+		}
+		putUint64At(out, i, width, v)
+	}
+	if len(in) != 0 {
+		return errCorrupt
+	}
+
+	return nil
+}
+
+// zigzag maps a two's complement difference to an unsigned integer such that small
+// negative and positive differences both result in small values
+func zigzag(d uint64) uint64 {
+	return (d << 1) ^ uint64(int64(d)>>63)
+}
+
+func unzigzag(v uint64) uint64 {
+	return (v >> 1) ^ -(v & 1)
+}
@@ -23,8 +23,13 @@ type Encoder interface {
 	Decompress(in, out []byte, src io.Reader) (n int, err error)
 }
 
-// New creates a new encoder based on an encoder type
+// New creates a new encoder based on an encoder type. Chained encoder types yield an
+// encoder applying the column codec prior to compression
 func New(t encoders.Type) (Encoder, error) {
+	if t.Codec() != encoders.CodecNone {
+		return newChain(t)
+	}
+
 	switch t {
 	case encoders.EncoderTypeNull:
 		return null.New(), nil
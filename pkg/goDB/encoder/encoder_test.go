@@ -11,6 +11,10 @@ var testEncoders = []encoders.Type{
 	encoders.EncoderTypeNull,
 	encoders.EncoderTypeLZ4,
 	encoders.EncoderTypeZSTD,
+	encoders.Chain(encoders.CodecDict, 1, encoders.EncoderTypeLZ4),
+	encoders.Chain(encoders.CodecBitpack, 1, encoders.EncoderTypeNull),
+	encoders.Chain(encoders.CodecVarint, 1, encoders.EncoderTypeZSTD),
+	encoders.Chain(encoders.CodecDelta, 1, encoders.EncoderTypeLZ4),
 }
 
 func TestNewByString(t *testing.T) {
@@ -28,6 +32,11 @@ func TestNewByString(t *testing.T) {
 		{"zstd encoder", "zstd", encoders.EncoderTypeZSTD, false},
 		{"zstd encoder (uppercase)", "ZSTD", encoders.EncoderTypeZSTD, false},
 		{"unsupported encoder", "iwillneverbesupported", encoders.EncoderTypeNull, true},
+		{"chained dict encoder", "dict16+lz4", encoders.Chain(encoders.CodecDict, 16, encoders.EncoderTypeLZ4), false},
+		{"chained varint encoder", "VARINT8+ZSTD", encoders.Chain(encoders.CodecVarint, 8, encoders.EncoderTypeZSTD), false},
+		{"chained encoder without width", "bitpack+lz4", encoders.EncoderTypeNull, true},
+		{"chained encoder with unsupported codec", "rle2+lz4", encoders.EncoderTypeNull, true},
+		{"chained encoder with unsupported compressor", "dict16+brotli", encoders.EncoderTypeNull, true},
 	}
 
 	for _, test := range tests {
@@ -50,6 +59,18 @@ func TestNewByString(t *testing.T) {
 	}
 }
 
+func TestChainedTypeString(t *testing.T) {
+	for _, encType := range testEncoders {
+		parsed, err := encoders.GetTypeByString(encType.String())
+		if err != nil {
+			t.Fatalf("Failed to parse encoder type %s: %s", encType, err)
+		}
+		if parsed != encType {
+			t.Fatalf("have: %v; expect: %v", parsed, encType)
+		}
+	}
+}
+
 func TestCompressionDecompression(t *testing.T) {
 	var nBytes = int64(len(encodingCorpus))
 
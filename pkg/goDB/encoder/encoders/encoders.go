@@ -2,6 +2,7 @@ package encoders
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -18,20 +19,91 @@ const (
 	MaxEncoderType = EncoderTypeZSTD
 )
 
+// Codec denotes a column-aware transformation that is applied to the raw column data
+// before it is handed to the compressor
+type Codec int
+
+// Enumeration of column codecs
+const (
+	CodecNone    Codec = iota // no transformation (plain compression)
+	CodecDict                 // per-block dictionary of distinct values (e.g. IP addresses)
+	CodecBitpack              // frame-of-reference bit packing of small integers (e.g. ports, protocols)
+	CodecVarint               // variable-length encoding of unsigned integers (e.g. counters)
+	CodecDelta                // variable-length encoding of the differences between consecutive integers
+
+	// should always be the last entry
+	MaxCodec = CodecDelta
+)
+
+// A chained encoder type carries the compressor in the lowest byte, the codec in the
+// second and the width of a column element (in bytes) in the third byte. Plain encoder
+// types hence remain unchanged
+const (
+	codecShift = 8
+	widthShift = 16
+	fieldMask  = 0xff
+)
+
 var encoderNames = map[Type]string{
 	EncoderTypeLZ4:  "lz4",
 	EncoderTypeNull: "null",
 	EncoderTypeZSTD: "zstd",
 }
 
+var codecNames = map[Codec]string{
+	CodecNone:    "",
+	CodecDict:    "dict",
+	CodecBitpack: "bitpack",
+	CodecVarint:  "varint",
+	CodecDelta:   "delta",
+}
+
+// Chain returns the encoder type transforming column elements of the given width with
+// the codec before compressing them with the compressor
+func Chain(codec Codec, width int, compressor Type) Type {
+	if codec == CodecNone {
+		return compressor.Compressor()
+	}
+	return compressor.Compressor() | Type(codec)<<codecShift | Type(width&fieldMask)<<widthShift
+}
+
+// Compressor returns the compressor used by the encoder type
+func (t Type) Compressor() Type {
+	return t & fieldMask
+}
+
+// Codec returns the column codec used by the encoder type
+func (t Type) Codec() Codec {
+	return Codec((t >> codecShift) & fieldMask)
+}
+
+// Width returns the width of a column element processed by the codec
+func (t Type) Width() int {
+	return int((t >> widthShift) & fieldMask)
+}
+
 // String returns a string representation of the encoding type
 func (t Type) String() string {
-	return encoderNames[t]
+	if t.Codec() == CodecNone {
+		return encoderNames[t]
+	}
+	return fmt.Sprintf("%s%d+%s", t.Codec(), t.Width(), encoderNames[t.Compressor()])
+}
+
+// String returns a string representation of the codec
+func (c Codec) String() string {
+	return codecNames[c]
 }
 
-// GetTypeByString returns the encoder type based on a named string
+// GetTypeByString returns the encoder type based on a named string. Chained
+// types are denoted as <codec><width>+<compressor>, e.g. "dict16+lz4"
 func GetTypeByString(t string) (Type, error) {
-	switch strings.ToLower(t) {
+	t = strings.ToLower(t)
+	if i := strings.IndexByte(t, '+'); i >= 0 {
+		return getChainedTypeByString(t[:i], t[i+1:])
+	}
+
+	switch t {
 	case "null", "":
 		return EncoderTypeNull, nil
 	case "lz4":
@@ -42,3 +114,23 @@ func GetTypeByString(t string) (Type, error) {
 		return EncoderTypeNull, fmt.Errorf("Unsupported encoder: %v", t)
 	}
 }
+
+func getChainedTypeByString(codecWidth, compressor string) (Type, error) {
+	compressorType, err := GetTypeByString(compressor)
+	if err != nil || strings.IndexByte(compressor, '+') >= 0 {
+		return EncoderTypeNull, fmt.Errorf("Unsupported encoder: %v+%v", codecWidth, compressor)
+	}
+
+	for codec, name := range codecNames {
+		if codec == CodecNone || !strings.HasPrefix(codecWidth, name) {
+			continue
+		}
+		width, err := strconv.Atoi(codecWidth[len(name):])
+		if err != nil || width < 1 || width > fieldMask {
+			return EncoderTypeNull, fmt.Errorf("Invalid element width in encoder: %v+%v", codecWidth, compressor)
+		}
+		return Chain(codec, width, compressorType), nil
+	}
+
+	return EncoderTypeNull, fmt.Errorf("Unsupported codec: %v", codecWidth)
+}
@@ -66,7 +66,15 @@ func (e *Encoder) Decompress(in, out []byte, src io.Reader) (n int, err error) {
 		return 0, errors.New("Incorrect number of bytes read from data source")
 	}
 
-	_, err = gozstd.Decompress(out[:0], in)
+	// gozstd only decompresses in-place if out can hold at least len(in) bytes, otherwise
+	// the data ends up in a newly allocated slice
+	res, err := gozstd.Decompress(out[:0], in)
+	if err != nil {
+		return 0, err
+	}
+	if len(res) != len(out) {
+		return 0, errors.New("Unexpected amount of bytes after decompression")
+	}
 
-	return len(out), err
+	return copy(out, res), nil
 }
@@ -0,0 +1,210 @@
+package goDB
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+const testDBPath = "../../addon/testdb"
+
+func TestColumnEncoding(t *testing.T) {
+	const timestamp = int64(1456428875)
+
+	flowmap := make(AggFlowMap)
+	for i := 0; i < 1000; i++ {
+		var key Key
+		key.Sip[0], key.Sip[3] = 10, byte(i%7)
+		key.Dip[0], key.Dip[2], key.Dip[3] = 192, byte(i/256), byte(i)
+		key.Dport[0], key.Dport[1] = byte(i%3), byte(i%5)
+		key.Protocol = []byte{6, 17}[i%2]
+		flowmap[key] = &Val{NBytesRcvd: uint64(i * 100), NBytesSent: uint64(i), NPktsRcvd: 1, NPktsSent: 1 << 40}
+	}
+
+	for _, et := range []encoders.Type{encoders.EncoderTypeLZ4, encoders.EncoderTypeZSTD, encoders.EncoderTypeNull} {
+		t.Run(et.String(), func(t *testing.T) {
+			dbPath := t.TempDir()
+
+			w := NewDBWriter(dbPath, "eth0", et, WithColumnEncoding())
+			if _, err := w.Write(flowmap, BlockMetadata{}, timestamp); err != nil {
+				t.Fatalf("Failed to write flows: %s", err)
+			}
+
+			// the column files have to be readable without knowing the codecs in advance
+			var columns [ColIdxCount][]byte
+			for colIdx := columnIndex(0); colIdx < ColIdxCount; colIdx++ {
+				path := filepath.Join(dbPath, "eth0", strconv.FormatInt(DayTimestamp(timestamp), 10), columnFileNames[colIdx]+".gpf")
+				gpf, err := gpfile.New(path, gpfile.ModeRead)
+				if err != nil {
+					t.Fatalf("Failed to open column %s: %s", columnFileNames[colIdx], err)
+				}
+
+				blocks, _ := gpf.Blocks()
+				if blocks.Blocks[timestamp].EncoderType.Codec() != columnCodecs[colIdx] {
+					t.Fatalf("Unexpected encoder type for column %s: %s", columnFileNames[colIdx], blocks.Blocks[timestamp].EncoderType)
+				}
+
+				if columns[colIdx], err = gpf.ReadBlock(timestamp); err != nil {
+					t.Fatalf("Failed to read column %s: %s", columnFileNames[colIdx], err)
+				}
+				if len(columns[colIdx]) != len(flowmap)*columnSizeofs[colIdx] {
+					t.Fatalf("Unexpected length of column %s: %d", columnFileNames[colIdx], len(columns[colIdx]))
+				}
+				gpf.Close()
+			}
+
+			for i := 0; i < len(flowmap); i++ {
+				var key Key
+				copy(key.Sip[:], columns[SipColIdx][i*SipSizeof:])
+				copy(key.Dip[:], columns[DipColIdx][i*DipSizeof:])
+				copy(key.Dport[:], columns[DportColIdx][i*DportSizeof:])
+				key.Protocol = columns[ProtoColIdx][i]
+
+				val, exists := flowmap[key]
+				if !exists {
+					t.Fatalf("Unexpected flow in row %d: %s", i, key)
+				}
+				if binary.BigEndian.Uint64(columns[BytesRcvdColIdx][i*8:]) != val.NBytesRcvd ||
+					binary.BigEndian.Uint64(columns[BytesSentColIdx][i*8:]) != val.NBytesSent ||
+					binary.BigEndian.Uint64(columns[PacketsRcvdColIdx][i*8:]) != val.NPktsRcvd ||
+					binary.BigEndian.Uint64(columns[PacketsSentColIdx][i*8:]) != val.NPktsSent {
+					t.Fatalf("Unexpected counters in row %d: %s", i, key)
+				}
+			}
+		})
+	}
+}
+
+// encodingScheme describes how the columns are encoded in the benchmarks below
+type encodingScheme struct {
+	name           string
+	compressor     encoders.Type
+	columnEncoding bool
+}
+
+func (s encodingScheme) encoderType(colIdx columnIndex) encoders.Type {
+	if s.columnEncoding {
+		return encoders.Chain(columnCodecs[colIdx], columnSizeofs[colIdx], s.compressor)
+	}
+	return s.compressor
+}
+
+var benchmarkSchemes = []encodingScheme{
+	{"lz4", encoders.EncoderTypeLZ4, false},
+	{"column+lz4", encoders.EncoderTypeLZ4, true},
+	{"zstd", encoders.EncoderTypeZSTD, false},
+	{"column+zstd", encoders.EncoderTypeZSTD, true},
+}
+
+// loadTestDBColumns reads the raw blocks of all columns of the test database
+func loadTestDBColumns(b *testing.B) (columns [ColIdxCount][][]byte) {
+	store := gpfile.NewStore()
+
+	ifaces, err := store.ReadDir(testDBPath)
+	if err != nil {
+		b.Skipf("Test database not available: %s", err)
+	}
+	for _, iface := range ifaces {
+		days, err := store.ReadDir(filepath.Join(testDBPath, iface))
+		if err != nil {
+			b.Fatalf("Failed to list days of %s: %s", iface, err)
+		}
+		for _, day := range days {
+			for colIdx := columnIndex(0); colIdx < ColIdxCount; colIdx++ {
+				// older days lack the IP version column
+				filename := filepath.Join(testDBPath, iface, day, columnFileNames[colIdx]+".gpf")
+				if _, err := os.Stat(filename + gpfile.HeaderFileSuffix); os.IsNotExist(err) {
+					continue
+				}
+				backend, err := store.Open(filename, storage.ModeRead, encoders.EncoderTypeLZ4)
+				if err != nil {
+					b.Fatalf("Failed to open column: %s", err)
+				}
+				blocks, _ := backend.Blocks()
+				for _, block := range blocks.OrderedList() {
+					data, err := backend.ReadBlock(block.Timestamp)
+					if err != nil {
+						b.Fatalf("Failed to read block: %s", err)
+					}
+					if len(data) > 0 {
+						columns[colIdx] = append(columns[colIdx], data)
+					}
+				}
+				backend.Close()
+			}
+		}
+	}
+
+	return
+}
+
+// BenchmarkColumnEncodingTestDB compresses / decompresses all blocks of the bundled test
+// database, reporting the compression ratio (raw / compressed size) per column
+func BenchmarkColumnEncodingTestDB(b *testing.B) {
+	columns := loadTestDBColumns(b)
+
+	for _, scheme := range benchmarkSchemes {
+		for colIdx := columnIndex(0); colIdx < ColIdxCount; colIdx++ {
+			// the days of the test database predating the IP version column lack it
+			if len(columns[colIdx]) == 0 {
+				continue
+			}
+			enc, err := encoder.New(scheme.encoderType(colIdx))
+			if err != nil {
+				b.Fatalf("Failed to instantiate encoder: %s", err)
+			}
+
+			var (
+				rawSize    int
+				compressed = make([][]byte, len(columns[colIdx]))
+			)
+			for i, block := range columns[colIdx] {
+				buf := bytes.NewBuffer(nil)
+				if _, err := enc.Compress(block, buf); err != nil {
+					b.Fatalf("Failed to compress block: %s", err)
+				}
+				compressed[i] = buf.Bytes()
+				rawSize += len(block)
+			}
+
+			b.Run(scheme.name+"/compress/"+columnFileNames[colIdx], func(b *testing.B) {
+				b.SetBytes(int64(rawSize))
+				buf := bytes.NewBuffer(nil)
+				for n := 0; n < b.N; n++ {
+					for _, block := range columns[colIdx] {
+						buf.Reset()
+						enc.Compress(block, buf)
+					}
+				}
+			})
+
+			b.Run(scheme.name+"/decompress/"+columnFileNames[colIdx], func(b *testing.B) {
+				var compSize int
+				for _, block := range compressed {
+					compSize += len(block)
+				}
+				b.SetBytes(int64(rawSize))
+				b.ResetTimer()
+
+				for n := 0; n < b.N; n++ {
+					for i, block := range compressed {
+						in := make([]byte, len(block))
+						out := make([]byte, len(columns[colIdx][i]))
+						if _, err := enc.Decompress(in, out, bytes.NewReader(block)); err != nil {
+							b.Fatalf("Failed to decompress block: %s", err)
+						}
+					}
+				}
+				b.ReportMetric(float64(rawSize)/float64(compSize), "ratio")
+			})
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package encryption provides authenticated encryption (AES-GCM) of goDB block data.
+// Keys are 256 bit AES keys stored hex-encoded in key files. Each key is identified by
+// an ID derived from the key itself, which is recorded alongside each encrypted block
+// in order to select the correct key for decryption
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// KeySize denotes the size of a key in bytes (AES-256)
+	KeySize = 32
+
+	// keyIDSize denotes the number of bytes of the key hash used as key ID
+	keyIDSize = 8
+
+	// keyFilePermissions denotes the permissions of generated key files
+	keyFilePermissions = 0600
+)
+
+// Key denotes an AES-256 key used to encrypt / decrypt blocks
+type Key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewKey creates a key from its raw bytes
+func NewKey(raw []byte) (*Key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("Invalid key size: want %d bytes, have %d", KeySize, len(raw))
+	}
+
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(raw)
+	return &Key{id: hex.EncodeToString(hash[:keyIDSize]), aead: aead}, nil
+}
+
+// ID returns the identifier of the key, which is safe to be stored alongside the data
+func (k *Key) ID() string {
+	return k.id
+}
+
+// Seal encrypts and authenticates plaintext as well as additionalData (which is not
+// encrypted, but has to be provided identically upon decryption). The random nonce is
+// prepended to the returned ciphertext
+func (k *Key) Seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(plaintext)+k.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("Could not generate nonce: %s", err)
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts and authenticates ciphertext produced by Seal
+func (k *Key) Open(ciphertext, additionalData []byte) ([]byte, error) {
+	nonceSize := k.aead.NonceSize()
+	if len(ciphertext) < nonceSize+k.aead.Overhead() {
+		return nil, errors.New("Ciphertext too short")
+	}
+
+	plaintext, err := k.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt data with key %s (data corrupted or tampered with): %s", k.id, err)
+	}
+	return plaintext, nil
+}
+
+// Overhead returns the number of bytes added to the plaintext by Seal
+func (k *Key) Overhead() int {
+	return k.aead.NonceSize() + k.aead.Overhead()
+}
+
+// GenerateKey creates a new random key, returning it along with its raw bytes
+func GenerateKey() (*Key, []byte, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, nil, fmt.Errorf("Could not generate key: %s", err)
+	}
+	key, err := NewKey(raw)
+	return key, raw, err
+}
+
+// ReadKeyFile reads the hex-encoded key stored in the file at path
+func ReadKeyFile(path string) (*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read key file: %s", err)
+	}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode key file %s: %s", path, err)
+	}
+
+	key, err := NewKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid key in %s: %s", path, err)
+	}
+	return key, nil
+}
+
+// GenerateKeyFile creates a new random key and stores it in a new file at path, which
+// is only accessible by its owner
+func GenerateKeyFile(path string) (*Key, error) {
+	key, raw, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, keyFilePermissions)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create key file: %s", err)
+	}
+	if _, err = f.WriteString(hex.EncodeToString(raw) + "\n"); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Could not write key file: %s", err)
+	}
+	if err = f.Close(); err != nil {
+		return nil, fmt.Errorf("Could not write key file: %s", err)
+	}
+	return key, nil
+}
+
+// Keyring holds the keys available for decryption, as well as the (primary) key used
+// for encryption
+type Keyring struct {
+	primary *Key
+	keys    map[string]*Key
+}
+
+// NewKeyring creates a keyring encrypting data with the primary key (which may be nil
+// in order to store data unencrypted) and decrypting data with any of the given keys
+func NewKeyring(primary *Key, keys ...*Key) *Keyring {
+	r := &Keyring{primary: primary, keys: make(map[string]*Key)}
+	for _, key := range append(keys, primary) {
+		if key != nil {
+			r.keys[key.ID()] = key
+		}
+	}
+	return r
+}
+
+// ReadKeyring creates a keyring decrypting data with the keys stored in the given
+// key files. It does not encrypt data
+func ReadKeyring(paths ...string) (*Keyring, error) {
+	var keys []*Key
+	for _, path := range paths {
+		key, err := ReadKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(nil, keys...), nil
+}
+
+// Primary returns the key used for encryption. If nil, data is stored unencrypted
+func (r *Keyring) Primary() *Key {
+	if r == nil {
+		return nil
+	}
+	return r.primary
+}
+
+// Key returns the key with the given ID
+func (r *Keyring) Key(id string) (*Key, error) {
+	if r != nil {
+		if key, exists := r.keys[id]; exists {
+			return key, nil
+		}
+	}
+	return nil, &MissingKeyError{ID: id}
+}
+
+// MissingKeyError is returned if data was encrypted with a key which is not available
+type MissingKeyError struct {
+	ID string
+}
+
+func (e *MissingKeyError) Error() string {
+	return fmt.Sprintf("Data is encrypted with key %s, which was not provided", e.ID)
+}
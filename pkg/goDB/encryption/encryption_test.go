@@ -0,0 +1,132 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, _, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	other, _, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	plaintext := []byte("some block data")
+	ciphertext, err := key.Seal(plaintext, []byte{1})
+	if err != nil {
+		t.Fatalf("Failed to seal data: %s", err)
+	}
+	if len(ciphertext) != len(plaintext)+key.Overhead() {
+		t.Fatalf("Unexpected ciphertext length: want %d, have %d", len(plaintext)+key.Overhead(), len(ciphertext))
+	}
+
+	decrypted, err := key.Open(ciphertext, []byte{1})
+	if err != nil {
+		t.Fatalf("Failed to open data: %s", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("Unexpected plaintext: want %q, have %q", plaintext, decrypted)
+	}
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)-1] ^= 1
+
+	var tests = []struct {
+		name           string
+		key            *Key
+		ciphertext     []byte
+		additionalData []byte
+	}{
+		{"tampered ciphertext", key, tampered, []byte{1}},
+		{"wrong additional data", key, ciphertext, []byte{2}},
+		{"wrong key", other, ciphertext, []byte{1}},
+		{"truncated ciphertext", key, ciphertext[:key.Overhead()-1], []byte{1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.key.Open(test.ciphertext, test.additionalData); err == nil {
+				t.Fatalf("Expected an error opening the data, got none")
+			}
+		})
+	}
+}
+
+func TestKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encryption")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "db.key")
+	key, err := GenerateKeyFile(path)
+	if err != nil {
+		t.Fatalf("Failed to generate key file: %s", err)
+	}
+	if _, err := GenerateKeyFile(path); err == nil {
+		t.Fatalf("Expected an error overwriting an existing key file, got none")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat key file: %s", err)
+	}
+	if info.Mode().Perm() != keyFilePermissions {
+		t.Fatalf("Unexpected key file permissions: %v", info.Mode().Perm())
+	}
+
+	read, err := ReadKeyFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read key file: %s", err)
+	}
+	if read.ID() != key.ID() {
+		t.Fatalf("Unexpected key ID: want %s, have %s", key.ID(), read.ID())
+	}
+
+	invalidPath := filepath.Join(dir, "invalid.key")
+	for _, content := range []string{"not hex", "abcd"} {
+		if err := ioutil.WriteFile(invalidPath, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write key file: %s", err)
+		}
+		if _, err := ReadKeyFile(invalidPath); err == nil {
+			t.Fatalf("Expected an error reading invalid key file content %q, got none", content)
+		}
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	primary, _, _ := GenerateKey()
+	old, _, _ := GenerateKey()
+	unknown, _, _ := GenerateKey()
+
+	keyring := NewKeyring(primary, old)
+	if keyring.Primary() != primary {
+		t.Fatalf("Unexpected primary key")
+	}
+	for _, key := range []*Key{primary, old} {
+		if k, err := keyring.Key(key.ID()); err != nil || k != key {
+			t.Fatalf("Failed to look up key %s: %v", key.ID(), err)
+		}
+	}
+
+	var missingKeyErr *MissingKeyError
+	if _, err := keyring.Key(unknown.ID()); !errors.As(err, &missingKeyErr) || missingKeyErr.ID != unknown.ID() {
+		t.Fatalf("Expected missing key error, got %v", err)
+	}
+
+	// a nil keyring neither encrypts nor decrypts
+	var nilKeyring *Keyring
+	if nilKeyring.Primary() != nil {
+		t.Fatalf("Unexpected primary key of nil keyring")
+	}
+	if _, err := nilKeyring.Key(primary.ID()); !errors.As(err, &missingKeyErr) {
+		t.Fatalf("Expected missing key error, got %v", err)
+	}
+}
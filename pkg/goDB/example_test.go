@@ -0,0 +1,85 @@
+package goDB_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+func ExampleReader() {
+	r, err := goDB.NewReader("../../addon/testdb",
+		goDB.WithIfaces("eth1"),
+		goDB.WithCondition("dport = 443", time.Second),
+		goDB.WithColumns("sip", "dip"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	var flows, traffic uint64
+	for r.Next() {
+		row := r.Row()
+		if flows == 0 {
+			fmt.Println(row.Time, row.Iface, goDB.RawIPToString(row.Sip[:], row.Version()), goDB.RawIPToString(row.Dip[:], row.Version()))
+		}
+		flows++
+		traffic += row.NBytesRcvd + row.NBytesSent
+	}
+	if err := r.Err(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(flows, traffic)
+	// Output:
+	// 1456428875 eth1 154.203.92.203 51.143.39.145
+	// 1279 943394841
+}
+
+func ExampleWriter() {
+	dbPath, err := ioutil.TempDir("", "example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dbPath)
+
+	var key goDB.Key
+	copy(key.Sip[:], net.ParseIP("10.0.0.1").To4())
+	copy(key.Dip[:], net.ParseIP("10.0.0.2").To4())
+	key.Dport = [2]byte{0, 53}
+	key.Protocol = 17
+	key.IPVersion = goDB.IPv4
+
+	w := goDB.NewWriter(dbPath, encoders.EncoderTypeLZ4)
+	if err := w.Write("eth0", 1456428600, goDB.AggFlowMap{key: &goDB.Val{NBytesRcvd: 100, NPktsRcvd: 1}}); err != nil {
+		log.Fatal(err)
+	}
+
+	r, err := goDB.NewReader(dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+	for r.Next() {
+		row := r.Row()
+		fmt.Println(row.Time, row.Iface, row.Key, row.NBytesRcvd)
+	}
+	if err := r.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	summary, err := goDB.ReadDBSummary(gpfile.NewStore(), dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(summary.Interfaces["eth0"].FlowCount, summary.Interfaces["eth0"].Traffic)
+	// Output:
+	// 1456428600 eth0 10.0.0.1,10.0.0.2,53,UDP 100
+	// 1 100
+}
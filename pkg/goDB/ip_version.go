@@ -0,0 +1,172 @@
+package goDB
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+// IPVersionFormatVersion denotes the format version of day directories which store the IP
+// version of each flow explicitly (in the ipv column)
+const IPVersionFormatVersion = gpfile.HeaderVersion + 1
+
+func init() {
+	RegisterMigration(Migration{
+		From:        gpfile.HeaderVersion,
+		To:          IPVersionFormatVersion,
+		Description: "Store the IP version of each flow explicitly, inferring it from the addresses of existing flows",
+		Upgrade:     addIPVersionColumn,
+		Verify:      verifyIPVersionColumn,
+	})
+}
+
+// ipVersionColumnPath returns the path of the IP version column of a day directory
+func ipVersionColumnPath(dayPath string) string {
+	return filepath.Join(dayPath, columnFileNames[IPVersionColIdx]+".gpf")
+}
+
+// hasIPVersionColumn checks whether the day directory located at dayPath stores the IP
+// version of all its blocks. Days written by older versions of goProbe lack the column
+// completely, days written partially by older versions lack some of its blocks
+func hasIPVersionColumn(dayPath string, keyring *encryption.Keyring) (bool, error) {
+	if _, err := os.Stat(ipVersionColumnPath(dayPath) + gpfile.HeaderFileSuffix); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	counters, err := gpfile.New(filepath.Join(dayPath, columnFileNames[BytesRcvdColIdx]+".gpf"), gpfile.ModeRead, gpfile.WithKeyring(keyring))
+	if err != nil {
+		return false, err
+	}
+	defer counters.Close()
+	versions, err := gpfile.New(ipVersionColumnPath(dayPath), gpfile.ModeRead, gpfile.WithKeyring(keyring))
+	if err != nil {
+		return false, err
+	}
+	defer versions.Close()
+
+	counterBlocks, err := counters.Blocks()
+	if err != nil {
+		return false, err
+	}
+	versionBlocks, err := versions.Blocks()
+	if err != nil {
+		return false, err
+	}
+	for ts, block := range counterBlocks.Blocks {
+		versionBlock, exists := versionBlocks.Blocks[ts]
+		if !exists || versionBlock.RawLen*BytesRcvdSizeof != block.RawLen {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// addIPVersionColumn (re-)writes the IP version column of the day directory located at
+// dayPath. Versions which have been stored explicitly are kept, all others are inferred
+// from the addresses of the flows. Blocks whose addresses cannot be read are stored with
+// unknown versions (and reported as warnings), since they cannot be queried anyway. Like
+// the other columns, the new column is encrypted with the primary key of keyring (if any)
+func addIPVersionColumn(dayPath string, keyring *encryption.Keyring, dryRun bool) (warnings []string, err error) {
+	var columns [ColIdxCount]*gpfile.GPFile
+	defer func() {
+		for _, column := range columns {
+			if column != nil {
+				column.Close()
+			}
+		}
+	}()
+	for _, colIdx := range []columnIndex{BytesRcvdColIdx, SipColIdx, DipColIdx, IPVersionColIdx} {
+		if columns[colIdx], err = gpfile.New(filepath.Join(dayPath, columnFileNames[colIdx]+".gpf"), gpfile.ModeRead, gpfile.WithKeyring(keyring)); err != nil {
+			// the IP version column is missing (or only partially written) if the day
+			// hasn't been migrated yet
+			if colIdx == IPVersionColIdx {
+				columns[colIdx], err = nil, nil
+				continue
+			}
+			return nil, err
+		}
+	}
+
+	header, err := columns[BytesRcvdColIdx].Blocks()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		timestamps []int64
+		blocks     = make(map[int64][]byte, len(header.Blocks))
+	)
+	for _, block := range header.OrderedList() {
+		ts := block.Timestamp
+		timestamps = append(timestamps, ts)
+
+		versions, err := blockIPVersions(columns, ts)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("Could not determine the IP versions of block %d of %s: %s", ts, dayPath, err))
+			versions = make([]byte, block.RawLen/BytesRcvdSizeof)
+		}
+		blocks[ts] = versions
+	}
+
+	if dryRun {
+		return warnings, nil
+	}
+	return warnings, gpfile.Create(ipVersionColumnPath(dayPath), func(write func(int64, []byte) error) error {
+		for _, ts := range timestamps {
+			if err := write(ts, blocks[ts]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, gpfile.WithKeyring(keyring))
+}
+
+// blockIPVersions determines the IP versions of all flows of the block stored at ts
+func blockIPVersions(columns [ColIdxCount]*gpfile.GPFile, ts int64) ([]byte, error) {
+	sip, err := columns[SipColIdx].ReadBlock(ts)
+	if err != nil {
+		return nil, err
+	}
+	dip, err := columns[DipColIdx].ReadBlock(ts)
+	if err != nil {
+		return nil, err
+	}
+	if len(sip)%SipSizeof != 0 || len(dip) != len(sip) {
+		return nil, fmt.Errorf("Incorrect number of addresses")
+	}
+
+	// versions stored explicitly take precedence
+	numEntries := len(sip) / SipSizeof
+	versions := make([]byte, numEntries)
+	if columns[IPVersionColIdx] != nil {
+		if stored, err := columns[IPVersionColIdx].ReadBlock(ts); err == nil && len(stored) == numEntries {
+			copy(versions, stored)
+		}
+	}
+	for i := range versions {
+		if versions[i] == 0 {
+			versions[i] = inferIPVersion(sip[i*SipSizeof:(i+1)*SipSizeof], dip[i*DipSizeof:(i+1)*DipSizeof])
+		}
+	}
+	return versions, nil
+}
+
+// verifyIPVersionColumn checks that all column files of the day directory located at
+// dayPath can be read and that the IP versions of all flows are stored
+func verifyIPVersionColumn(dayPath string, keyring *encryption.Keyring) error {
+	if err := verifyColumns(gpfile.HeaderVersion)(dayPath, keyring); err != nil {
+		return err
+	}
+
+	complete, err := hasIPVersionColumn(dayPath, keyring)
+	if err != nil {
+		return err
+	}
+	if !complete {
+		return fmt.Errorf("IP versions of %s are incomplete", dayPath)
+	}
+	return nil
+}
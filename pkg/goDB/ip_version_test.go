@@ -0,0 +1,209 @@
+package goDB
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+func TestIPVersionMigration(t *testing.T) {
+	const timestamp = int64(1456428600)
+
+	var (
+		dbPath = t.TempDir()
+		dayDir = filepath.Join(dbPath, "eth0", "1456358400")
+
+		// 2001:db8:: -> 2001:db9:: used to be taken for an IPv4 flow
+		v4Key = testStatsKey("10.0.0.1", "10.0.0.2", 443, 6)
+		v6Key = testStatsKey("2001:db8::", "2001:db9::", 443, 6)
+
+		// flows of unambiguous IPv6 addresses were stored without IP version as well
+		legacyV6Key = testStatsKey("2001:db8::1", "2001:db9::1", 443, 6)
+	)
+	if inferIPVersion(v6Key.Sip[:], v6Key.Dip[:]) != IPv4 {
+		t.Fatalf("IPv6 flow is not ambiguous")
+	}
+	v6Key.IPVersion = IPv6
+
+	// the first block is written by an older version of goProbe, i.e. without the IP
+	// version column, the second one (of the same day) by the current version
+	w := NewDBWriter(dbPath, "eth0", encoders.EncoderTypeLZ4)
+	if _, err := w.Write(AggFlowMap{v4Key: &Val{NBytesRcvd: 1}, legacyV6Key: &Val{NBytesRcvd: 4}}, BlockMetadata{Timestamp: timestamp}, timestamp); err != nil {
+		t.Fatalf("Failed to write flows: %s", err)
+	}
+	for _, name := range []string{"ipv.gpf", "ipv.gpf" + gpfile.HeaderFileSuffix} {
+		if err := os.Remove(filepath.Join(dayDir, name)); err != nil {
+			t.Fatalf("Failed to remove IP version column: %s", err)
+		}
+	}
+	if _, err := w.Write(AggFlowMap{v6Key: &Val{NBytesRcvd: 2}}, BlockMetadata{Timestamp: timestamp + DBWriteInterval}, timestamp+DBWriteInterval); err != nil {
+		t.Fatalf("Failed to write flows: %s", err)
+	}
+
+	query := func(queryType, conditional string) map[string]uint64 {
+		t.Helper()
+		cond, err := ParseAndInstrumentConditional(conditional, time.Second)
+		if err != nil {
+			t.Fatalf("Failed to parse conditional: %s", err)
+		}
+		attributes, _, _, _ := ParseQueryType(queryType)
+		result := make(map[string]uint64)
+		for key, val := range evaluateRange(t, dbPath, NewQuery(attributes, cond, false, false), timestamp-DBWriteInterval, timestamp+DBWriteInterval) {
+			result[key.Key.String()+"/"+IPVersionAttribute{}.ExtractStrings(&key)[0]] = val.NBytesRcvd
+		}
+		return result
+	}
+	checkQueries := func() {
+		t.Helper()
+		var tests = []struct {
+			queryType   string
+			conditional string
+			want        map[string]uint64
+		}{
+			{"sip,ipv", "", map[string]uint64{"10.0.0.1,0.0.0.0,0,HOPOPT/4": 1, "2001:db8::,::,0,HOPOPT/6": 2, "2001:db8::1,::,0,HOPOPT/6": 4}},
+			{"sip,ipv", "ipv = 6", map[string]uint64{"2001:db8::,::,0,HOPOPT/6": 2, "2001:db8::1,::,0,HOPOPT/6": 4}},
+			{"sip,ipv", "ipv != 6", map[string]uint64{"10.0.0.1,0.0.0.0,0,HOPOPT/4": 1}},
+			{"sip,ipv", "snet = 32.0.0.0/8", map[string]uint64{}},
+			{"sip,ipv", "snet = 2000::/8", map[string]uint64{"2001:db8::,::,0,HOPOPT/6": 2, "2001:db8::1,::,0,HOPOPT/6": 4}},
+			{"sip,ipv", "sip != 10.0.0.1", map[string]uint64{"2001:db8::,::,0,HOPOPT/6": 2, "2001:db8::1,::,0,HOPOPT/6": 4}},
+
+			// the IP version is inferred even if the addresses aren't part of the query
+			{"ipv", "", map[string]uint64{"0.0.0.0,0.0.0.0,0,HOPOPT/4": 1, "::,::,0,HOPOPT/6": 6}},
+			{"proto,ipv", "ipv = 6", map[string]uint64{"::,::,0,TCP/6": 6}},
+		}
+		for _, test := range tests {
+			if have := query(test.queryType, test.conditional); len(have) != len(test.want) {
+				t.Fatalf("Unexpected result of %q: want %v, have %v", test.conditional, test.want, have)
+			} else {
+				for k, v := range test.want {
+					if have[k] != v {
+						t.Fatalf("Unexpected result of %q: want %v, have %v", test.conditional, test.want, have)
+					}
+				}
+			}
+		}
+
+		// rows are evaluated on the IP versions inferred from both addresses as well
+		r, err := NewReader(dbPath, WithColumns("sip", "ipv"), WithCondition("ipv = 6", time.Second))
+		if err != nil {
+			t.Fatalf("Failed to create reader: %s", err)
+		}
+		defer r.Close()
+		var nBytes uint64
+		for r.Next() {
+			if row := r.Row(); row.Key.IPVersion != IPv6 {
+				t.Fatalf("Unexpected IP version of row %s: %d", row.Key, row.Key.IPVersion)
+			} else {
+				nBytes += row.Val.NBytesRcvd
+			}
+		}
+		if err := r.Err(); err != nil || nBytes != 6 {
+			t.Fatalf("Unexpected rows of IPv6 flows: %d bytes (%v)", nBytes, err)
+		}
+	}
+
+	// the IP versions of the first block are inferred before the migration
+	if version, err := DayVersion(dayDir, nil); err != nil || version != gpfile.HeaderVersion {
+		t.Fatalf("Unexpected version before migration: %d (%v)", version, err)
+	}
+	checkQueries()
+
+	if _, err := Migrate(dbPath, nil, false, nil); err != nil {
+		t.Fatalf("Failed to migrate: %s", err)
+	}
+	if version, err := DayVersion(dayDir, nil); err != nil || version != IPVersionFormatVersion {
+		t.Fatalf("Unexpected version after migration: %d (%v)", version, err)
+	}
+	checkQueries()
+
+	blocks, err := readDayBlocks(gpfile.NewStore(), dayDir)
+	if err != nil {
+		t.Fatalf("Failed to read day: %s", err)
+	}
+	v4Key.IPVersion = IPv4
+	if _, exists := blocks[timestamp].flows[v4Key]; !exists {
+		t.Fatalf("IP version of migrated flow not stored: %v", blocks[timestamp].flows)
+	}
+	if _, exists := blocks[timestamp+DBWriteInterval].flows[v6Key]; !exists {
+		t.Fatalf("IP version of current flow not retained: %v", blocks[timestamp+DBWriteInterval].flows)
+	}
+}
+
+func TestQueryColumnIndizes(t *testing.T) {
+	var tests = []struct {
+		queryType string
+		want      []columnIndex
+	}{
+		{"sip", []columnIndex{SipColIdx, IPVersionColIdx}},
+		{"talk_src", []columnIndex{SipColIdx, IPVersionColIdx}},
+		{"ipv", []columnIndex{IPVersionColIdx}},
+		{"sip,dip", []columnIndex{SipColIdx, DipColIdx, IPVersionColIdx}},
+		{"dport,proto", []columnIndex{ProtoColIdx, DportColIdx}},
+	}
+	for _, test := range tests {
+		attributes, _, _, err := ParseQueryType(test.queryType)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", test.queryType, err)
+		}
+		// the counters are read by all queries
+		want := append(test.want, BytesRcvdColIdx, BytesSentColIdx, PacketsRcvdColIdx, PacketsSentColIdx)
+		if have := NewQuery(attributes, nil, false, false).columnIndizes; !reflect.DeepEqual(have, want) {
+			t.Fatalf("Unexpected columns of %s: want %v, have %v", test.queryType, want, have)
+		}
+	}
+}
+
+func TestIPVersionMigrationEncrypted(t *testing.T) {
+	const timestamp = int64(1456428600)
+
+	var (
+		dbPath = t.TempDir()
+		dayDir = filepath.Join(dbPath, "eth0", "1456358400")
+	)
+	key, _, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	keyring := encryption.NewKeyring(key)
+
+	w := NewDBWriter(dbPath, "eth0", encoders.EncoderTypeLZ4, WithStore(gpfile.NewStore(gpfile.WithKeyring(keyring))))
+	if _, err := w.Write(AggFlowMap{testStatsKey("10.0.0.1", "10.0.0.2", 443, 6): &Val{NBytesRcvd: 1}}, BlockMetadata{Timestamp: timestamp}, timestamp); err != nil {
+		t.Fatalf("Failed to write flows: %s", err)
+	}
+	for _, name := range []string{"ipv.gpf", "ipv.gpf" + gpfile.HeaderFileSuffix} {
+		if err := os.Remove(filepath.Join(dayDir, name)); err != nil {
+			t.Fatalf("Failed to remove IP version column: %s", err)
+		}
+	}
+
+	// without the key, the day can neither be inspected nor migrated
+	if _, err := Migrate(dbPath, nil, false, nil); err == nil {
+		t.Fatalf("Expected migration without key to fail")
+	}
+	if _, err := Migrate(dbPath, keyring, false, nil); err != nil {
+		t.Fatalf("Failed to migrate: %s", err)
+	}
+	if version, err := DayVersion(dayDir, keyring); err != nil || version != IPVersionFormatVersion {
+		t.Fatalf("Unexpected version after migration: %d (%v)", version, err)
+	}
+
+	// the new column is encrypted like the rest of the day
+	gpf, err := gpfile.New(filepath.Join(dayDir, "ipv.gpf"), gpfile.ModeRead, gpfile.WithKeyring(keyring))
+	if err != nil {
+		t.Fatalf("Failed to open IP version column: %s", err)
+	}
+	defer gpf.Close()
+	blocks, err := gpf.Blocks()
+	if err != nil {
+		t.Fatalf("Failed to read blocks: %s", err)
+	}
+	if block := blocks.Blocks[timestamp]; block.KeyID != key.ID() {
+		t.Fatalf("Unexpected key ID of IP version block: want %q, have %q", key.ID(), block.KeyID)
+	}
+}
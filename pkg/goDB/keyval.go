@@ -20,12 +20,46 @@ import (
 	jsoniter "github.com/json-iterator/go"
 )
 
+// Versions of the Internet Protocol a flow can be based on
+const (
+	IPv4 byte = 4
+	IPv6 byte = 6
+)
+
 // Key stores the 5-tuple which defines a goProbe flow
 type Key struct {
 	Sip      [16]byte
 	Dip      [16]byte
 	Dport    [2]byte
 	Protocol byte
+
+	// IPVersion denotes the IP version of the addresses (IPv4 or IPv6). IPv4 addresses
+	// occupy the first four bytes of Sip / Dip. Keys read from databases predating the
+	// explicit version have version 0, in which case the version is inferred from the
+	// addresses (see Version)
+	IPVersion byte
+}
+
+// Version returns the IP version of the key, inferring it from the addresses if it hasn't
+// been stored explicitly
+func (k *Key) Version() byte {
+	if k.IPVersion != 0 {
+		return k.IPVersion
+	}
+	return inferIPVersion(k.Sip[:], k.Dip[:])
+}
+
+// inferIPVersion guesses the IP version of a flow from its addresses, which is how older
+// versions of goDB determined it: a flow is considered to be IPv4 if bytes 4 to 15 of
+// both addresses are zero. This is ambiguous for IPv6 addresses ending in zeros (e.g.
+// 2001:db8::), which is why the version is stored explicitly
+func inferIPVersion(sip, dip []byte) byte {
+	for i := 4; i < len(sip); i++ {
+		if sip[i] != 0 || dip[i] != 0 {
+			return IPv6
+		}
+	}
+	return IPv4
 }
 
 // ExtraKey is a Key with time and interface information
@@ -52,8 +86,8 @@ type AggFlowMap map[Key]*Val
 // String prints the key as a comma separated attribute list
 func (k Key) String() string {
 	return fmt.Sprintf("%s,%s,%d,%s",
-		RawIPToString(k.Sip[:]),
-		RawIPToString(k.Dip[:]),
+		RawIPToString(k.Sip[:], k.Version()),
+		RawIPToString(k.Dip[:], k.Version()),
 		int(uint16(k.Dport[0])<<8|uint16(k.Dport[1])),
 		protocols.GetIPProto(int(k.Protocol)),
 	)
@@ -68,8 +102,8 @@ func (k Key) MarshalJSON() ([]byte, error) {
 			Dport uint16 `json:"dport"`
 			Proto string `json:"ip_protocol"`
 		}{
-			RawIPToString(k.Sip[:]),
-			RawIPToString(k.Dip[:]),
+			RawIPToString(k.Sip[:], k.Version()),
+			RawIPToString(k.Dip[:], k.Version()),
 			uint16(uint16(k.Dport[0])<<8 | uint16(k.Dport[1])),
 			protocols.GetIPProto(int(k.Protocol)),
 		},
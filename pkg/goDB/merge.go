@@ -0,0 +1,371 @@
+package goDB
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+// mergeDirSuffix denotes the suffix of the temporary directories used while merging
+const mergeDirSuffix = ".merge"
+
+// MergeResult summarizes the merge of an interface into another database
+type MergeResult struct {
+	DaysCopied   int // number of days copied verbatim (not present in the destination)
+	DaysMerged   int // number of days present in both databases
+	BlocksMerged int // number of blocks present in both databases (re-aggregated)
+}
+
+// MergeInterface merges all days of interface srcIface of the database at srcPath into
+// interface dstIface of the database at dstPath. Days not present in the destination are
+// copied as they are. Otherwise, the blocks of both days are read and written anew, with
+// the flows of blocks sharing the same timestamp being aggregated. Blocks are written
+// using encoderType and the DB writer options (which also govern how blocks are read and
+// the store both databases are accessed through). The database summary is not modified
+// (see RebuildInterfaceSummary)
+func MergeInterface(srcPath, srcIface, dstPath, dstIface string, encoderType encoders.Type, opts ...Option) (result MergeResult, err error) {
+	o := applyOptions(opts)
+
+	srcDir, dstDir := filepath.Join(srcPath, srcIface), filepath.Join(dstPath, dstIface)
+	days, err := dayDirs(o.store, srcDir)
+	if err != nil {
+		return result, err
+	}
+	if err = o.store.MkdirAll(dstDir); err != nil {
+		return result, err
+	}
+	dstDays, err := dayDirs(o.store, dstDir)
+	if err != nil {
+		return result, err
+	}
+	isDstDay := make(map[string]bool, len(dstDays))
+	for _, day := range dstDays {
+		isDstDay[day] = true
+	}
+
+	for _, day := range days {
+		srcDay, dstDay := filepath.Join(srcDir, day), filepath.Join(dstDir, day)
+
+		if !isDstDay[day] {
+			if err = copyDayDir(o.store, srcDay, dstDay); err != nil {
+				return result, fmt.Errorf("Could not copy %s: %s", srcDay, err)
+			}
+			result.DaysCopied++
+			continue
+		}
+
+		numMerged, err := mergeDayDirs(o.store, srcDay, dstPath, dstIface, day, encoderType, opts)
+		if err != nil {
+			return result, fmt.Errorf("Could not merge %s into %s: %s", srcDay, dstDay, err)
+		}
+		result.DaysMerged++
+		result.BlocksMerged += numMerged
+	}
+
+	if _, err = RebuildTimeIndex(o.store, dstDir); err != nil {
+		return result, fmt.Errorf("Could not rebuild time index of %s: %s", dstDir, err)
+	}
+	return result, nil
+}
+
+// RebuildInterfaceSummary computes the summary of an interface from the block metadata
+// of all its days in the store
+func RebuildInterfaceSummary(store storage.Store, dbPath, iface string) (summ InterfaceSummary, err error) {
+	days, err := dayDirs(store, filepath.Join(dbPath, iface))
+	if err != nil {
+		return summ, err
+	}
+
+	first := true
+	for _, day := range days {
+		meta := tryReadMetadataFrom(store, filepath.Join(dbPath, iface, day, MetadataFileName))
+		for _, block := range meta.Blocks {
+			summ.FlowCount += block.FlowCount
+			summ.Traffic += block.Traffic
+			if first || block.Timestamp < summ.Begin {
+				summ.Begin = block.Timestamp
+			}
+			if first || block.Timestamp > summ.End {
+				summ.End = block.Timestamp
+			}
+			first = false
+		}
+	}
+
+	return summ, nil
+}
+
+// dayDirs returns the names of all day directories below dir in ascending order
+func dayDirs(store storage.Store, dir string) ([]string, error) {
+	dirs, err := store.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var days []string
+	for _, name := range dirs {
+		// a directory whose name isn't an int64 wasn't created by goProbe
+		if dayTimestamp, err := strconv.ParseInt(name, 10, 64); err == nil && strconv.FormatInt(dayTimestamp, 10) == name {
+			days = append(days, name)
+		}
+	}
+	return days, nil
+}
+
+// copyDayDir copies all files of the day directory src to dst. The copy is assembled
+// in a temporary directory and moved to dst once complete
+func copyDayDir(store storage.Store, src, dst string) error {
+	tmpDir := dst + mergeDirSuffix
+	if err := store.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+	if err := store.CopyDir(src, tmpDir); err != nil {
+		store.RemoveAll(tmpDir)
+		return err
+	}
+
+	return store.Rename(tmpDir, dst)
+}
+
+// dayBlock holds the flows and metadata of a block read from a day directory
+type dayBlock struct {
+	flows AggFlowMap
+	meta  BlockMetadata
+}
+
+// mergeDayDirs merges the blocks of the day directory srcDay into the day of interface
+// dstIface of the database at dstPath. The merged day is written to a temporary database
+// first and replaces the existing day once complete. Returns the number of blocks present
+// in both days
+func mergeDayDirs(store storage.Store, srcDay, dstPath, dstIface, day string, encoderType encoders.Type, opts []Option) (int, error) {
+	dstDay := filepath.Join(dstPath, dstIface, day)
+
+	blocks, err := readDayBlocks(store, dstDay)
+	if err != nil {
+		return 0, err
+	}
+	srcBlocks, err := readDayBlocks(store, srcDay)
+	if err != nil {
+		return 0, err
+	}
+
+	var numMerged int
+	for ts, srcBlock := range srcBlocks {
+		block, exists := blocks[ts]
+		if !exists {
+			blocks[ts] = srcBlock
+			continue
+		}
+		numMerged++
+		block.merge(srcBlock)
+	}
+
+	timestamps := make([]int64, 0, len(blocks))
+	for ts := range blocks {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	// write the merged day to a temporary database next to the destination day
+	tmpPath := dstDay + mergeDirSuffix
+	if err = store.RemoveAll(tmpPath); err != nil {
+		return 0, err
+	}
+	defer store.RemoveAll(tmpPath)
+
+	w := NewDBWriter(tmpPath, dstIface, encoderType, opts...)
+	for _, ts := range timestamps {
+		if _, err = w.Write(blocks[ts].flows, blocks[ts].meta, ts); err != nil {
+			return 0, err
+		}
+	}
+
+	// swap the days, keeping the previous one until the merged one is in place
+	oldDay := dstDay + ".old"
+	if err = store.RemoveAll(oldDay); err != nil {
+		return 0, err
+	}
+	if err = store.Rename(dstDay, oldDay); err != nil {
+		return 0, err
+	}
+	if err = store.Rename(filepath.Join(tmpPath, dstIface, day), dstDay); err != nil {
+		store.Rename(oldDay, dstDay)
+		return 0, err
+	}
+
+	return numMerged, store.RemoveAll(oldDay)
+}
+
+// merge aggregates the flows and packet statistics of src into the block. The flow
+// count and traffic of the metadata are not updated
+func (b *dayBlock) merge(src *dayBlock) {
+	for K, V := range src.flows {
+		// flows of unknown IP version are aggregated with those of the inferred one
+		if K.IPVersion == 0 {
+			K.IPVersion = K.Version()
+		}
+		if val, exists := b.flows[K]; exists {
+			addVal(val, V)
+		} else {
+			b.flows[K] = V
+		}
+	}
+	b.meta.PcapPacketsReceived = addPcapStat(b.meta.PcapPacketsReceived, src.meta.PcapPacketsReceived)
+	b.meta.PcapPacketsDropped = addPcapStat(b.meta.PcapPacketsDropped, src.meta.PcapPacketsDropped)
+	b.meta.PcapPacketsIfDropped = addPcapStat(b.meta.PcapPacketsIfDropped, src.meta.PcapPacketsIfDropped)
+	b.meta.PacketsLogged += src.meta.PacketsLogged
+}
+
+// addPcapStat adds two pcap statistics, which are negative if they were unavailable
+func addPcapStat(a, b int) int {
+	if a < 0 {
+		return b
+	}
+	if b < 0 {
+		return a
+	}
+	return a + b
+}
+
+func addVal(val, delta *Val) {
+	val.NBytesRcvd += delta.NBytesRcvd
+	val.NBytesSent += delta.NBytesSent
+	val.NPktsRcvd += delta.NPktsRcvd
+	val.NPktsSent += delta.NPktsSent
+}
+
+// readDayBlocks reads the flows and metadata of all blocks stored in a day directory
+func readDayBlocks(store storage.Store, dayDir string) (map[int64]*dayBlock, error) {
+	columns, err := openDayColumns(store, dayDir)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDayColumns(columns)
+
+	header, err := columns[BytesRcvdColIdx].Blocks()
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := make(map[int64]*dayBlock, len(header.Blocks))
+	for ts := range header.Blocks {
+		flows, err := readBlockFlows(columns, ts)
+		if err != nil {
+			return nil, err
+		}
+		blocks[ts] = &dayBlock{flows: flows, meta: BlockMetadata{Timestamp: ts}}
+	}
+
+	// blocks without metadata (e.g. due to an interrupted write) keep the empty metadata
+	meta := tryReadMetadataFrom(store, filepath.Join(dayDir, MetadataFileName))
+	for _, blockMeta := range meta.Blocks {
+		if block, exists := blocks[blockMeta.Timestamp]; exists {
+			block.meta = blockMeta
+		}
+	}
+
+	return blocks, nil
+}
+
+// readDayBlock reads the flows of the block stored at timestamp in a day directory
+func readDayBlock(store storage.Store, dayDir string, timestamp int64) (AggFlowMap, error) {
+	columns, err := openDayColumns(store, dayDir)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDayColumns(columns)
+
+	return readBlockFlows(columns, timestamp)
+}
+
+// openDayColumns opens all columns of a day directory for reading. The IP version column
+// is nil for days written before the IP version was stored explicitly
+func openDayColumns(store storage.Store, dayDir string) (columns [ColIdxCount]storage.Backend, err error) {
+	for colIdx := columnIndex(0); colIdx < ColIdxCount; colIdx++ {
+		if columns[colIdx], err = store.Open(filepath.Join(dayDir, columnFileNames[colIdx]+".gpf"), storage.ModeRead, encoders.EncoderTypeLZ4); err != nil {
+			if colIdx == IPVersionColIdx {
+				columns[colIdx] = nil
+				continue
+			}
+			closeDayColumns(columns)
+			return columns, err
+		}
+	}
+	return columns, nil
+}
+
+func closeDayColumns(columns [ColIdxCount]storage.Backend) {
+	for _, backend := range columns {
+		if backend != nil {
+			backend.Close()
+		}
+	}
+}
+
+// readBlockFlows reads the block stored at timestamp from all columns and decodes its flows
+func readBlockFlows(columns [ColIdxCount]storage.Backend, ts int64) (AggFlowMap, error) {
+	var (
+		data [ColIdxCount][]byte
+		err  error
+	)
+	for colIdx := columnIndex(0); colIdx < ColIdxCount; colIdx++ {
+		if columns[colIdx] == nil {
+			continue
+		}
+		if data[colIdx], err = columns[colIdx].ReadBlock(ts); err != nil {
+			// blocks written before the IP version was stored explicitly lack it
+			if colIdx == IPVersionColIdx {
+				data[colIdx] = nil
+				continue
+			}
+			return nil, fmt.Errorf("Failed to read block %d of %s.gpf: %s", ts, columnFileNames[colIdx], err)
+		}
+	}
+
+	// blocks lacking the IP version are handled like unknown IP versions (0), which are
+	// inferred from the addresses
+	numEntries := len(data[BytesRcvdColIdx]) / 8
+	if data[IPVersionColIdx] == nil {
+		data[IPVersionColIdx] = make([]byte, numEntries)
+	}
+	for colIdx := columnIndex(0); colIdx < ColIdxCount; colIdx++ {
+		if len(data[colIdx]) != numEntries*columnSizeofs[colIdx] {
+			return nil, fmt.Errorf("Incorrect number of entries in block %d of %s.gpf", ts, columnFileNames[colIdx])
+		}
+	}
+
+	flows := make(AggFlowMap, numEntries)
+	for i := 0; i < numEntries; i++ {
+		var K Key
+		copy(K.Sip[:], data[SipColIdx][i*SipSizeof:])
+		copy(K.Dip[:], data[DipColIdx][i*DipSizeof:])
+		copy(K.Dport[:], data[DportColIdx][i*DportSizeof:])
+		K.Protocol = data[ProtoColIdx][i]
+		if K.IPVersion = data[IPVersionColIdx][i]; K.IPVersion == 0 {
+			K.IPVersion = K.Version()
+		}
+
+		V := &Val{
+			NBytesRcvd: binary.BigEndian.Uint64(data[BytesRcvdColIdx][i*8:]),
+			NBytesSent: binary.BigEndian.Uint64(data[BytesSentColIdx][i*8:]),
+			NPktsRcvd:  binary.BigEndian.Uint64(data[PacketsRcvdColIdx][i*8:]),
+			NPktsSent:  binary.BigEndian.Uint64(data[PacketsSentColIdx][i*8:]),
+		}
+
+		// blocks written by older versions may hold several entries per key (e.g.
+		// differing in their layer 7 protocol only), which are aggregated
+		if val, exists := flows[K]; exists {
+			addVal(val, V)
+		} else {
+			flows[K] = V
+		}
+	}
+	return flows, nil
+}
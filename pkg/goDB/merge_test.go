@@ -0,0 +1,224 @@
+package goDB
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/storage/memory"
+)
+
+func TestMergeInterface(t *testing.T) {
+	const timestamp = int64(1456428600)
+
+	tmpDir, err := ioutil.TempDir("", "merge")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var (
+		srcPath = filepath.Join(tmpDir, "src")
+		dstPath = filepath.Join(tmpDir, "dst")
+
+		shared = testStatsKey("10.0.0.1", "10.0.0.2", 443, 6)
+		other  = testStatsKey("10.0.0.3", "10.0.0.4", 53, 17)
+	)
+	shared.IPVersion, other.IPVersion = IPv4, IPv4
+
+	// the first block is present in both databases, the second day only in the source
+	src := NewDBWriter(srcPath, "eth0", encoders.EncoderTypeLZ4)
+	for _, ts := range []int64{timestamp, timestamp + EpochDay} {
+		flowmap := AggFlowMap{shared: &Val{NBytesRcvd: 1, NBytesSent: 2, NPktsRcvd: 3, NPktsSent: 4}}
+		if _, err := src.Write(flowmap, BlockMetadata{Timestamp: ts, PcapPacketsReceived: 10}, ts); err != nil {
+			t.Fatalf("Failed to write flows: %s", err)
+		}
+	}
+	dst := NewDBWriter(dstPath, "probeA_eth0", encoders.EncoderTypeLZ4)
+	for _, ts := range []int64{timestamp, timestamp + DBWriteInterval} {
+		flowmap := AggFlowMap{
+			shared: &Val{NBytesRcvd: 10, NBytesSent: 20, NPktsRcvd: 30, NPktsSent: 40},
+			other:  &Val{NBytesRcvd: 5},
+		}
+		if _, err := dst.Write(flowmap, BlockMetadata{Timestamp: ts, PcapPacketsReceived: 5}, ts); err != nil {
+			t.Fatalf("Failed to write flows: %s", err)
+		}
+	}
+
+	result, err := MergeInterface(srcPath, "eth0", dstPath, "probeA_eth0", encoders.EncoderTypeZSTD)
+	if err != nil {
+		t.Fatalf("Failed to merge interface: %s", err)
+	}
+	if result != (MergeResult{DaysCopied: 1, DaysMerged: 1, BlocksMerged: 1}) {
+		t.Fatalf("Unexpected merge result: %+v", result)
+	}
+
+	day := filepath.Join(dstPath, "probeA_eth0", "1456358400")
+	blocks, err := readDayBlocks(gpfile.NewStore(), day)
+	if err != nil {
+		t.Fatalf("Failed to read merged day: %s", err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("Unexpected number of merged blocks: %d", len(blocks))
+	}
+
+	var tests = []struct {
+		timestamp int64
+		key       Key
+		val       Val
+	}{
+		{timestamp, shared, Val{NBytesRcvd: 11, NBytesSent: 22, NPktsRcvd: 33, NPktsSent: 44}},
+		{timestamp, other, Val{NBytesRcvd: 5}},
+		{timestamp + DBWriteInterval, shared, Val{NBytesRcvd: 10, NBytesSent: 20, NPktsRcvd: 30, NPktsSent: 40}},
+	}
+	for _, test := range tests {
+		val, exists := blocks[test.timestamp].flows[test.key]
+		if !exists || *val != test.val {
+			t.Fatalf("Unexpected flow %s at %d: want %+v, have %+v", test.key, test.timestamp, test.val, val)
+		}
+	}
+	if meta := blocks[timestamp].meta; meta.PcapPacketsReceived != 15 || meta.FlowCount != 2 || meta.Traffic != 38 {
+		t.Fatalf("Unexpected metadata of merged block: %+v", meta)
+	}
+
+	// the merged day is written with the requested encoder
+	gpf, err := gpfile.New(filepath.Join(day, "sip.gpf"), gpfile.ModeRead)
+	if err != nil {
+		t.Fatalf("Failed to open merged column: %s", err)
+	}
+	defer gpf.Close()
+	header, _ := gpf.Blocks()
+	if header.Blocks[timestamp].EncoderType != encoders.EncoderTypeZSTD {
+		t.Fatalf("Unexpected encoder of merged block: %v", header.Blocks[timestamp].EncoderType)
+	}
+
+	summ, err := RebuildInterfaceSummary(gpfile.NewStore(), dstPath, "probeA_eth0")
+	if err != nil {
+		t.Fatalf("Failed to rebuild summary: %s", err)
+	}
+	if want := (InterfaceSummary{FlowCount: 5, Traffic: 38 + 35 + 3, Begin: timestamp, End: timestamp + EpochDay}); summ != want {
+		t.Fatalf("Unexpected summary: want %+v, have %+v", want, summ)
+	}
+
+	// no temporary directories are left behind
+	entries, err := ioutil.ReadDir(filepath.Join(dstPath, "probeA_eth0"))
+	if err != nil {
+		t.Fatalf("Failed to read interface directory: %s", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Unexpected number of entries after merge: %d", len(entries))
+	}
+
+	// the time index covers the blocks of both databases
+	index, err := ReadTimeIndex(gpfile.NewStore(), filepath.Join(dstPath, "probeA_eth0"))
+	if err != nil {
+		t.Fatalf("Failed to read time index: %s", err)
+	}
+	if want := []int64{timestamp, timestamp + DBWriteInterval, timestamp + EpochDay}; !reflect.DeepEqual(index.timestamps, want) {
+		t.Fatalf("Unexpected timestamps in time index: want %v, have %v", want, index.timestamps)
+	}
+}
+
+func TestMergeLegacyBlock(t *testing.T) {
+	const timestamp = int64(1456428600)
+
+	var (
+		tmpDir  = t.TempDir()
+		srcPath = filepath.Join(tmpDir, "src")
+		dstPath = filepath.Join(tmpDir, "dst")
+
+		key = testStatsKey("10.0.0.1", "10.0.0.2", 443, 6)
+	)
+
+	// the source block is written by an older version of goProbe, i.e. without the IP
+	// version column
+	if _, err := NewDBWriter(srcPath, "eth0", encoders.EncoderTypeLZ4).Write(AggFlowMap{key: &Val{NBytesRcvd: 1}}, BlockMetadata{Timestamp: timestamp}, timestamp); err != nil {
+		t.Fatalf("Failed to write flows: %s", err)
+	}
+	for _, name := range []string{"ipv.gpf", "ipv.gpf" + gpfile.HeaderFileSuffix} {
+		if err := os.Remove(filepath.Join(srcPath, "eth0", "1456358400", name)); err != nil {
+			t.Fatalf("Failed to remove IP version column: %s", err)
+		}
+	}
+	key.IPVersion = IPv4
+	if _, err := NewDBWriter(dstPath, "eth0", encoders.EncoderTypeLZ4).Write(AggFlowMap{key: &Val{NBytesRcvd: 2}}, BlockMetadata{Timestamp: timestamp}, timestamp); err != nil {
+		t.Fatalf("Failed to write flows: %s", err)
+	}
+
+	if _, err := MergeInterface(srcPath, "eth0", dstPath, "eth0", encoders.EncoderTypeLZ4); err != nil {
+		t.Fatalf("Failed to merge interface: %s", err)
+	}
+	blocks, err := readDayBlocks(gpfile.NewStore(), filepath.Join(dstPath, "eth0", "1456358400"))
+	if err != nil {
+		t.Fatalf("Failed to read merged day: %s", err)
+	}
+
+	// the flow of unknown IP version is aggregated with the one of the inferred version
+	flows := blocks[timestamp].flows
+	if val, exists := flows[key]; len(flows) != 1 || !exists || val.NBytesRcvd != 3 {
+		t.Fatalf("Unexpected merged flows: %v", flows)
+	}
+}
+
+func TestMergeMemoryStore(t *testing.T) {
+	const timestamp = int64(1456428600)
+
+	key := testStatsKey("10.0.0.1", "10.0.0.2", 443, 6)
+	key.IPVersion = IPv4
+
+	// neither database exists in the file system, so any access to it fails
+	var (
+		tmpDir  = t.TempDir()
+		srcPath = filepath.Join(tmpDir, "src")
+		dstPath = filepath.Join(tmpDir, "dst")
+		store   = memory.NewStore()
+	)
+	src := NewWriter(srcPath, encoders.EncoderTypeLZ4, WithStore(store))
+	for _, ts := range []int64{timestamp, timestamp + EpochDay} {
+		if err := src.Write("eth0", ts, AggFlowMap{key: &Val{NBytesRcvd: 1}}); err != nil {
+			t.Fatalf("Failed to write flows: %s", err)
+		}
+	}
+	if err := NewWriter(dstPath, encoders.EncoderTypeLZ4, WithStore(store)).Write("eth0", timestamp, AggFlowMap{key: &Val{NBytesRcvd: 2}}); err != nil {
+		t.Fatalf("Failed to write flows: %s", err)
+	}
+
+	result, err := MergeInterface(srcPath, "eth0", dstPath, "eth0", encoders.EncoderTypeLZ4, WithStore(store))
+	if err != nil {
+		t.Fatalf("Failed to merge interface: %s", err)
+	}
+	if result != (MergeResult{DaysCopied: 1, DaysMerged: 1, BlocksMerged: 1}) {
+		t.Fatalf("Unexpected merge result: %+v", result)
+	}
+	if _, err := os.Stat(dstPath); !os.IsNotExist(err) {
+		t.Fatalf("Database unexpectedly written to the file system: %v", err)
+	}
+
+	// only the merged days remain
+	days, err := store.ReadDir(filepath.Join(dstPath, "eth0"))
+	if err != nil {
+		t.Fatalf("Failed to list days: %s", err)
+	}
+	if want := []string{"1456358400", "1456444800"}; !reflect.DeepEqual(days, want) {
+		t.Fatalf("Unexpected days: want %v, have %v", want, days)
+	}
+	blocks, err := readDayBlocks(store, filepath.Join(dstPath, "eth0", "1456358400"))
+	if err != nil {
+		t.Fatalf("Failed to read merged day: %s", err)
+	}
+	if val, exists := blocks[timestamp].flows[key]; !exists || val.NBytesRcvd != 3 {
+		t.Fatalf("Unexpected merged flows: %v", blocks[timestamp].flows)
+	}
+
+	summ, err := RebuildInterfaceSummary(store, dstPath, "eth0")
+	if err != nil {
+		t.Fatalf("Failed to rebuild summary: %s", err)
+	}
+	if want := (InterfaceSummary{FlowCount: 2, Traffic: 4, Begin: timestamp, End: timestamp + EpochDay}); summ != want {
+		t.Fatalf("Unexpected summary: want %+v, have %+v", want, summ)
+	}
+}
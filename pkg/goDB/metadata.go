@@ -13,6 +13,7 @@ package goDB
 import (
 	"os"
 
+	"github.com/els0r/goProbe/pkg/goDB/storage"
 	jsoniter "github.com/json-iterator/go"
 )
 
@@ -77,3 +78,29 @@ func WriteMetadata(path string, meta *Metadata) error {
 
 	return jsoniter.NewEncoder(f).Encode(meta)
 }
+
+// tryReadMetadataFrom attempts to read the metadata file at path from the given store.
+// If an error occurs, a fresh Metadata struct is returned.
+func tryReadMetadataFrom(store storage.Store, path string) *Metadata {
+	data, err := store.ReadFile(path)
+	if err != nil {
+		return NewMetadata()
+	}
+
+	var result Metadata
+	if err := jsoniter.Unmarshal(data, &result); err != nil {
+		return NewMetadata()
+	}
+	return &result
+}
+
+// writeMetadataTo stores the metadata at path in the given store
+func writeMetadataTo(store storage.Store, path string, meta *Metadata) error {
+	data, err := jsoniter.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	// keep the output identical to the one produced by WriteMetadata
+	return store.WriteFile(path, append(data, '\n'))
+}
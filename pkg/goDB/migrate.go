@@ -0,0 +1,353 @@
+package goDB
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/storage/s3"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// FormatVersion denotes the current on-disk format version of day directories. Up to
+	// gpfile.HeaderVersion, it is determined by the format of the column files, later
+	// versions add columns
+	FormatVersion = IPVersionFormatVersion
+
+	// MigrationStateFileName denotes the journal of an ongoing migration, which lists the
+	// days that have been upgraded only partially or haven't been verified yet
+	MigrationStateFileName = "migration.json"
+)
+
+// Migration upgrades the on-disk format of a day directory from one version to the next
+type Migration struct {
+	From, To    int
+	Description string
+
+	// Upgrade upgrades the day directory located at dayPath, reading encrypted blocks
+	// with the keys of keyring and encrypting new ones with its primary key. It must be
+	// idempotent, i.e. running it again on a (partially) upgraded directory completes the
+	// upgrade. In dry-run mode, all conversions are performed without modifying the
+	// directory. Issues which do not prevent the upgrade (e.g. corrupt blocks which have
+	// to be dropped) are returned as warnings
+	Upgrade func(dayPath string, keyring *encryption.Keyring, dryRun bool) (warnings []string, err error)
+
+	// Verify checks that the day directory located at dayPath has been upgraded correctly
+	Verify func(dayPath string, keyring *encryption.Keyring) error
+}
+
+// migrations holds all registered migrations, indexed by the version they upgrade from
+var migrations = make(map[int]Migration)
+
+// RegisterMigration registers a migration. Each migration has to upgrade exactly one
+// version, and there can only be one migration per version
+func RegisterMigration(m Migration) {
+	if m.To != m.From+1 || m.Upgrade == nil || m.Verify == nil {
+		panic(fmt.Sprintf("Invalid migration from version %d to %d", m.From, m.To))
+	}
+	if _, exists := migrations[m.From]; exists {
+		panic(fmt.Sprintf("Duplicate migration from version %d", m.From))
+	}
+	migrations[m.From] = m
+}
+
+// Migrations returns all registered migrations, ordered by version
+func Migrations() []Migration {
+	var list []Migration
+	for _, m := range migrations {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].From < list[j].From
+	})
+	return list
+}
+
+func init() {
+	RegisterMigration(Migration{
+		From:        gpfile.LegacyVersion,
+		To:          gpfile.LegacyVersion + 1,
+		Description: "Move the block index of legacy .gpf files to separate header files",
+		Upgrade: func(dayPath string, keyring *encryption.Keyring, dryRun bool) (warnings []string, err error) {
+			err = forEachColumn(dayPath, func(filename string) error {
+				result, err := gpfile.ConvertLegacy(filename, dryRun, gpfile.WithKeyring(keyring))
+				for _, ts := range result.SkippedBlocks {
+					warnings = append(warnings, fmt.Sprintf("Dropped corrupt block %d of %s", ts, filename))
+				}
+				return err
+			})
+			return warnings, err
+		},
+		Verify: verifyColumns(gpfile.LegacyVersion + 1),
+	})
+}
+
+// DayFormat denotes the format version of a day directory of an interface
+type DayFormat struct {
+	Iface   string
+	Day     string
+	Version int
+
+	// Archived denotes if the day has been archived to object storage (in which case it
+	// cannot be migrated)
+	Archived bool
+}
+
+// Path returns the path of the day directory relative to the database path
+func (d DayFormat) Path() string {
+	return filepath.Join(d.Iface, d.Day)
+}
+
+// DetectVersions determines the format version of all day directories of the database
+// located at dbPath. keyring provides the keys of encrypted blocks
+func DetectVersions(dbPath string, keyring *encryption.Keyring) ([]DayFormat, error) {
+	entries, err := ioutil.ReadDir(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var formats []DayFormat
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		days, err := dayDirs(gpfile.NewStore(), filepath.Join(dbPath, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range days {
+			format := DayFormat{Iface: entry.Name(), Day: day}
+			dayPath := filepath.Join(dbPath, format.Path())
+			if _, err := os.Stat(filepath.Join(dayPath, s3.StubFileName)); err == nil {
+				format.Archived = true
+				format.Version = FormatVersion
+			} else if format.Version, err = DayVersion(dayPath, keyring); err != nil {
+				return nil, err
+			}
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+// DayVersion returns the format version of the day directory located at dayPath, i.e.
+// the lowest version of all its column files or, if they are current, the version
+// corresponding to the columns present. keyring provides the keys of encrypted blocks
+func DayVersion(dayPath string, keyring *encryption.Keyring) (int, error) {
+	version := gpfile.HeaderVersion
+	err := forEachColumn(dayPath, func(filename string) error {
+		v, err := gpfile.Version(filename)
+		if err != nil {
+			return err
+		}
+		if v > gpfile.HeaderVersion {
+			return fmt.Errorf("Format version %d of %s is not supported (current version: %d)", v, filename, gpfile.HeaderVersion)
+		}
+		if v < version {
+			version = v
+		}
+		return nil
+	})
+	if err != nil || version < gpfile.HeaderVersion {
+		return version, err
+	}
+
+	complete, err := hasIPVersionColumn(dayPath, keyring)
+	if complete {
+		version = IPVersionFormatVersion
+	}
+	return version, err
+}
+
+// forEachColumn calls fn for each column file of the day directory located at dayPath
+func forEachColumn(dayPath string, fn func(filename string) error) error {
+	files, err := ioutil.ReadDir(dayPath)
+	if err != nil {
+		return err
+	}
+
+	// columns consisting of empty blocks only may lack a data file
+	seen := make(map[string]struct{})
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), gpfile.HeaderFileSuffix)
+		if filepath.Ext(name) != ".gpf" {
+			continue
+		}
+		if _, exists := seen[name]; exists {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		if err := fn(filepath.Join(dayPath, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyColumns returns a verification checking that all column files of a day directory
+// have the given version and can be read
+func verifyColumns(version int) func(dayPath string, keyring *encryption.Keyring) error {
+	return func(dayPath string, keyring *encryption.Keyring) error {
+		return forEachColumn(dayPath, func(filename string) error {
+			v, err := gpfile.Version(filename)
+			if err != nil {
+				return err
+			}
+			if v != version {
+				return fmt.Errorf("Unexpected format version of %s: want %d, have %d", filename, version, v)
+			}
+			return gpfile.Verify(filename, gpfile.WithKeyring(keyring))
+		})
+	}
+}
+
+// MigrationResult summarizes a migration of a database
+type MigrationResult struct {
+	DaysMigrated int // number of days upgraded (or which would be upgraded in dry-run mode)
+	DaysCurrent  int // number of days already in the current format
+
+	// Warnings lists issues encountered during the upgrades which did not prevent them
+	Warnings []string
+
+	// ArchivedDirs lists the days which have been archived to object storage and could
+	// hence not be checked
+	ArchivedDirs []string
+}
+
+// migrationState denotes the journal of an ongoing migration. It maps the (relative)
+// paths of the days being migrated to the version they are migrated from
+type migrationState struct {
+	Pending map[string]int `json:"pending"`
+}
+
+// Migrate upgrades all day directories of the database located at dbPath to the current
+// format version by running the registered migrations. Each upgraded day is verified.
+// The days being migrated are journaled, so that an interrupted migration is resumed
+// (and verified) when running it again. In dry-run mode, the database is left untouched
+// and only the first migration required by each day is tried. keyring provides the keys
+// of encrypted blocks, blocks written by the migrations are encrypted with its primary
+// key. fn (if not nil) is called before a day is migrated
+func Migrate(dbPath string, keyring *encryption.Keyring, dryRun bool, fn func(day DayFormat, resumed bool)) (result MigrationResult, err error) {
+	formats, err := DetectVersions(dbPath, keyring)
+	if err != nil {
+		return result, err
+	}
+
+	statePath := filepath.Join(dbPath, MigrationStateFileName)
+	state, err := readMigrationState(statePath)
+	if err != nil {
+		return result, err
+	}
+
+	for _, format := range formats {
+		if format.Archived {
+			result.ArchivedDirs = append(result.ArchivedDirs, filepath.Join(dbPath, format.Path()))
+			continue
+		}
+
+		// days journaled by an interrupted migration are migrated again from their
+		// original version, relying on the idempotency of the upgrades
+		from, resumed := state.Pending[format.Path()]
+		if !resumed {
+			from = format.Version
+		}
+		if from >= FormatVersion {
+			result.DaysCurrent++
+			continue
+		}
+		if fn != nil {
+			fn(DayFormat{Iface: format.Iface, Day: format.Day, Version: from}, resumed)
+		}
+
+		if !dryRun && !resumed {
+			state.Pending[format.Path()] = from
+			if err = writeMigrationState(statePath, state); err != nil {
+				return result, err
+			}
+		}
+		warnings, err := migrateDay(filepath.Join(dbPath, format.Path()), from, keyring, dryRun)
+		result.Warnings = append(result.Warnings, warnings...)
+		if err != nil {
+			return result, err
+		}
+		result.DaysMigrated++
+
+		if !dryRun {
+			delete(state.Pending, format.Path())
+			if err = writeMigrationState(statePath, state); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// migrateDay upgrades the day directory located at dayPath from version from to the
+// current version
+func migrateDay(dayPath string, from int, keyring *encryption.Keyring, dryRun bool) (warnings []string, err error) {
+	for version := from; version < FormatVersion; version++ {
+		m, exists := migrations[version]
+		if !exists {
+			return warnings, fmt.Errorf("No migration of %s from version %d available", dayPath, version)
+		}
+		stepWarnings, err := m.Upgrade(dayPath, keyring, dryRun)
+		warnings = append(warnings, stepWarnings...)
+		if err != nil {
+			return warnings, fmt.Errorf("Could not upgrade %s from version %d to %d: %s", dayPath, m.From, m.To, err)
+		}
+		if dryRun {
+			return warnings, nil
+		}
+		if err := m.Verify(dayPath, keyring); err != nil {
+			return warnings, fmt.Errorf("Verification of %s after upgrade to version %d failed: %s", dayPath, m.To, err)
+		}
+	}
+	return warnings, nil
+}
+
+func readMigrationState(path string) (*migrationState, error) {
+	state := &migrationState{Pending: make(map[string]int)}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = jsoniter.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Could not parse migration state %s: %s", path, err)
+	}
+	if state.Pending == nil {
+		state.Pending = make(map[string]int)
+	}
+	return state, nil
+}
+
+// writeMigrationState atomically writes the migration state to path. The state file
+// is removed once there are no pending days anymore
+func writeMigrationState(path string, state *migrationState) error {
+	if len(state.Pending) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := jsoniter.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,135 @@
+package goDB
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+func TestMigrate(t *testing.T) {
+	const timestamp = int64(1456428600)
+
+	tmpDir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the first day is written in the current format
+	w := NewDBWriter(tmpDir, "eth0", encoders.EncoderTypeLZ4)
+	flowmap := AggFlowMap{testStatsKey("10.0.0.1", "10.0.0.2", 443, 6): &Val{NBytesRcvd: 1}}
+	if _, err := w.Write(flowmap, BlockMetadata{Timestamp: timestamp}, timestamp); err != nil {
+		t.Fatalf("Failed to write flows: %s", err)
+	}
+
+	// the second day holds legacy files, each with a single (empty) block
+	legacyDay := filepath.Join(tmpDir, "eth0", "1456444800")
+	if err := os.MkdirAll(legacyDay, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	header := make([]byte, 3*4096)
+	binary.BigEndian.PutUint64(header, uint64(len(header)))
+	binary.BigEndian.PutUint64(header[4096:], uint64(timestamp+EpochDay))
+	for colIdx, column := range columnFileNames {
+		// legacy days predate the IP version column
+		if columnIndex(colIdx) == IPVersionColIdx {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(legacyDay, column+".gpf"), header, 0644); err != nil {
+			t.Fatalf("Failed to write legacy file: %s", err)
+		}
+	}
+
+	checkVersions := func(want map[string]int) {
+		t.Helper()
+		formats, err := DetectVersions(tmpDir, nil)
+		if err != nil {
+			t.Fatalf("Failed to detect versions: %s", err)
+		}
+		if len(formats) != len(want) {
+			t.Fatalf("Unexpected number of days: %d", len(formats))
+		}
+		for _, format := range formats {
+			if format.Version != want[format.Day] {
+				t.Fatalf("Unexpected version of %s: want %d, have %d", format.Path(), want[format.Day], format.Version)
+			}
+		}
+	}
+	checkVersions(map[string]int{"1456358400": FormatVersion, "1456444800": gpfile.LegacyVersion})
+
+	var tests = []struct {
+		name    string
+		dryRun  bool
+		pending map[string]int
+		want    MigrationResult
+		resumed bool
+	}{
+		{"dry run", true, nil, MigrationResult{DaysMigrated: 1, DaysCurrent: 1}, false},
+		{"migration", false, nil, MigrationResult{DaysMigrated: 1, DaysCurrent: 1}, false},
+		{"up to date", false, nil, MigrationResult{DaysCurrent: 2}, false},
+		{"resumed", false, map[string]int{filepath.Join("eth0", "1456444800"): gpfile.LegacyVersion}, MigrationResult{DaysMigrated: 1, DaysCurrent: 1}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// simulate an interrupted migration
+			if test.pending != nil {
+				if err := writeMigrationState(filepath.Join(tmpDir, MigrationStateFileName), &migrationState{Pending: test.pending}); err != nil {
+					t.Fatalf("Failed to write migration state: %s", err)
+				}
+			}
+
+			var resumed bool
+			result, err := Migrate(tmpDir, nil, test.dryRun, func(day DayFormat, r bool) {
+				resumed = resumed || r
+			})
+			if err != nil {
+				t.Fatalf("Failed to migrate: %s", err)
+			}
+			if result.DaysMigrated != test.want.DaysMigrated || result.DaysCurrent != test.want.DaysCurrent || len(result.Warnings) != 0 {
+				t.Fatalf("Unexpected migration result: want %+v, have %+v", test.want, result)
+			}
+			if resumed != test.resumed {
+				t.Fatalf("Unexpected resumption: want %v, have %v", test.resumed, resumed)
+			}
+			if _, err := os.Stat(filepath.Join(tmpDir, MigrationStateFileName)); !os.IsNotExist(err) {
+				t.Fatalf("Migration state left behind")
+			}
+		})
+	}
+	checkVersions(map[string]int{"1456358400": FormatVersion, "1456444800": FormatVersion})
+
+	gpf, err := gpfile.New(filepath.Join(legacyDay, "sip.gpf"), gpfile.ModeRead)
+	if err != nil {
+		t.Fatalf("Failed to open migrated file: %s", err)
+	}
+	defer gpf.Close()
+	if header, _ := gpf.Blocks(); len(header.Blocks) != 1 {
+		t.Fatalf("Unexpected number of blocks in migrated file: %d", len(header.Blocks))
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	var tests = []struct {
+		name string
+		m    Migration
+	}{
+		{"duplicate", migrations[gpfile.LegacyVersion]},
+		{"skipping a version", Migration{From: 5, To: 7, Upgrade: migrations[0].Upgrade, Verify: migrations[0].Verify}},
+		{"missing verification", Migration{From: 5, To: 6, Upgrade: migrations[0].Upgrade}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Registration succeeded unexpectedly")
+				}
+			}()
+			RegisterMigration(test.m)
+		})
+	}
+}
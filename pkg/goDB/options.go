@@ -0,0 +1,41 @@
+package goDB
+
+import (
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+// Option allows to set optional parameters of DB writers and work managers
+type Option func(*options)
+
+type options struct {
+	store          storage.Store
+	columnEncoding bool
+}
+
+// WithStore sets the storage backends are opened from. By default, the
+// GPFiles in the file system are used
+func WithStore(store storage.Store) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithColumnEncoding enables column-aware encoding of the data written by a DB writer:
+// prior to compression, each column is transformed by a codec suited for its content
+// (dictionary encoding of IPs, bit packing of ports / protocols, varint encoding of counters)
+func WithColumnEncoding() Option {
+	return func(o *options) {
+		o.columnEncoding = true
+	}
+}
+
+func applyOptions(opts []Option) options {
+	o := options{
+		store: gpfile.NewStore(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
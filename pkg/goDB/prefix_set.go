@@ -0,0 +1,65 @@
+package goDB
+
+// prefixSet is a set of IP networks of a single IP version, stored as a binary radix tree
+// over the bits of the network addresses. Looking up an address takes at most one step per
+// bit of the address regardless of the number of networks in the set, which allows
+// conditionals to check flows against long lists of networks (e.g. block lists)
+type prefixSet struct {
+	nodes []prefixNode
+}
+
+// prefixNode is a node of a prefixSet. A network ends at a terminal node, covering all
+// addresses below it, which is why terminal nodes have no children
+type prefixNode struct {
+	// indices of the child nodes for bit 0 and 1. The root is never a child, hence
+	// index 0 denotes a missing child
+	children [2]int32
+	terminal bool
+}
+
+func newPrefixSet() *prefixSet {
+	return &prefixSet{nodes: make([]prefixNode, 1)}
+}
+
+// insert adds the network with the given (masked) address and prefix length to the set
+func (s *prefixSet) insert(network []byte, prefixLen int) {
+	n := 0
+	for bit := 0; bit < prefixLen; bit++ {
+		// the network is covered by a shorter one already
+		if s.nodes[n].terminal {
+			return
+		}
+
+		b := prefixBit(network, bit)
+		if s.nodes[n].children[b] == 0 {
+			s.nodes = append(s.nodes, prefixNode{})
+			s.nodes[n].children[b] = int32(len(s.nodes) - 1)
+		}
+		n = int(s.nodes[n].children[b])
+	}
+
+	// longer networks inserted before are covered by this one
+	s.nodes[n] = prefixNode{terminal: true}
+}
+
+// contains checks whether the address is part of any network of the set
+func (s *prefixSet) contains(addr []byte) bool {
+	n := 0
+	for bit := 0; ; bit++ {
+		node := &s.nodes[n]
+		if node.terminal {
+			return true
+		}
+		if bit == 8*len(addr) {
+			return false
+		}
+		if n = int(node.children[prefixBit(addr, bit)]); n == 0 {
+			return false
+		}
+	}
+}
+
+// prefixBit returns the given bit of the address, counting from the most significant one
+func prefixBit(addr []byte, bit int) byte {
+	return addr[bit/8] >> uint(7-bit%8) & 1
+}
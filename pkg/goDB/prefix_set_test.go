@@ -0,0 +1,79 @@
+package goDB
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestPrefixSet(t *testing.T) {
+	var tests = []struct {
+		networks []string
+		addr     string
+		contains bool
+	}{
+		{nil, "10.0.0.1", false},
+		{[]string{"10.0.0.0/8"}, "10.1.2.3", true},
+		{[]string{"10.0.0.0/8"}, "11.0.0.0", false},
+		{[]string{"10.0.0.0/8", "10.1.0.0/16"}, "10.2.0.0", true},
+		{[]string{"10.1.0.0/16", "10.0.0.0/8"}, "10.2.0.0", true},
+		{[]string{"10.1.0.0/16", "10.1.2.3/32"}, "10.1.255.255", true},
+		{[]string{"10.1.2.3/32"}, "10.1.2.3", true},
+		{[]string{"10.1.2.3/32"}, "10.1.2.2", false},
+		{[]string{"192.168.0.0/23"}, "192.168.1.200", true},
+		{[]string{"192.168.0.0/23"}, "192.168.2.0", false},
+		{[]string{"0.0.0.0/0"}, "255.255.255.255", true},
+		{[]string{"2001:db8::/32"}, "2001:db8:1::1", true},
+		{[]string{"2001:db8::/32"}, "2001:db9::1", false},
+		{[]string{"2001:db8::1/128", "fe80::/10"}, "febf::1", true},
+		{[]string{"2001:db8::1/128", "fe80::/10"}, "2001:db8::2", false},
+	}
+	for _, test := range tests {
+		s := newPrefixSet()
+		for _, network := range test.networks {
+			_, ipnet, err := net.ParseCIDR(network)
+			if err != nil {
+				t.Fatalf("Failed to parse network %s: %s", network, err)
+			}
+			prefixLen, _ := ipnet.Mask.Size()
+			s.insert(ipnet.IP, prefixLen)
+		}
+
+		addr := net.ParseIP(test.addr)
+		if v4 := addr.To4(); v4 != nil {
+			addr = v4
+		}
+		if s.contains(addr) != test.contains {
+			t.Fatalf("Networks %v: expected containment of %s to be %v", test.networks, test.addr, test.contains)
+		}
+	}
+}
+
+// TestPrefixSetRandom compares the prefix set with checking all networks one by one
+func TestPrefixSetRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	var networks []*net.IPNet
+	s := newPrefixSet()
+	for i := 0; i < 1000; i++ {
+		// draw from a small address space, so that networks overlap
+		ip := net.IPv4(10, byte(rng.Intn(4)), byte(rng.Intn(256)), byte(rng.Intn(256))).To4()
+		prefixLen := 14 + rng.Intn(19)
+		network := &net.IPNet{IP: ip.Mask(net.CIDRMask(prefixLen, 32)), Mask: net.CIDRMask(prefixLen, 32)}
+
+		networks = append(networks, network)
+		s.insert(network.IP, prefixLen)
+	}
+
+	for i := 0; i < 10000; i++ {
+		addr := net.IPv4(10, byte(rng.Intn(8)), byte(rng.Intn(256)), byte(rng.Intn(256))).To4()
+
+		var contains bool
+		for _, network := range networks {
+			contains = contains || network.Contains(addr)
+		}
+		if s.contains(addr) != contains {
+			t.Fatalf("Expected containment of %s to be %v", addr, contains)
+		}
+	}
+}
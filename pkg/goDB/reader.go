@@ -0,0 +1,274 @@
+package goDB
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Row denotes a single flow stored in a block of the database
+type Row struct {
+	Time  int64  // timestamp of the block the flow is stored in
+	Iface string // interface the flow was captured on
+	Key
+	Val
+}
+
+// ReaderOption configures a Reader
+type ReaderOption func(*Reader) error
+
+// WithTimeRange restricts the rows read to the blocks written between first and last
+// (in seconds since epoch), in the same way as the time range of a query
+func WithTimeRange(first, last int64) ReaderOption {
+	return func(r *Reader) error {
+		if first > last {
+			return fmt.Errorf("Invalid time range: %d is after %d", first, last)
+		}
+		r.first, r.last = first, last
+		return nil
+	}
+}
+
+// WithIfaces restricts the rows read to the given interfaces. By default, all interfaces
+// of the database are read
+func WithIfaces(ifaces ...string) ReaderOption {
+	return func(r *Reader) error {
+		for _, iface := range ifaces {
+			if err := validateIface(iface); err != nil {
+				return err
+			}
+		}
+		r.ifaces = ifaces
+		return nil
+	}
+}
+
+// WithCondition restricts the rows read to the flows satisfying the conditional, which
+// uses the same syntax as the conditionals of goQuery (e.g. "dport = 443 & proto = tcp").
+// Host names are resolved with the given timeout
+func WithCondition(conditional string, dnsTimeout time.Duration) ReaderOption {
+	return func(r *Reader) (err error) {
+		r.conditional, err = ParseAndInstrumentConditional(conditional, dnsTimeout)
+		return err
+	}
+}
+
+// WithColumns restricts the attributes populated in the keys of the rows read to the given
+// ones (e.g. "sip", "dport" or "snet/24"), avoiding reading the remaining attribute columns. The
+// counters, time and interface of the rows are always populated
+func WithColumns(columns ...string) ReaderOption {
+	return func(r *Reader) (err error) {
+		r.attributes, _, _, err = ParseQueryType(strings.Join(columns, ","))
+		return err
+	}
+}
+
+// WithContext stops reading (with Err returning the error of ctx) once ctx is done
+func WithContext(ctx context.Context) ReaderOption {
+	return func(r *Reader) error {
+		r.ctx = ctx
+		return nil
+	}
+}
+
+// WithReaderOptions sets the DB options (e.g. WithStore) used for reading
+func WithReaderOptions(opts ...Option) ReaderOption {
+	return func(r *Reader) error {
+		r.opts = opts
+		return nil
+	}
+}
+
+// Reader iterates over the flows stored in a database, one row per flow and block. Rows
+// are returned per interface in the order of the blocks, without being aggregated:
+//
+//	r, err := goDB.NewReader("/usr/local/goProbe/db", goDB.WithIfaces("eth0"))
+//	if err != nil {
+//		return err
+//	}
+//	defer r.Close()
+//	for r.Next() {
+//		row := r.Row()
+//		...
+//	}
+//	return r.Err()
+type Reader struct {
+	ctx         context.Context
+	dbPath      string
+	ifaces      []string
+	first, last int64
+	attributes  []Attribute
+	conditional Node
+	opts        []Option
+
+	query *Query
+
+	// the iteration state: the interface / workload / block / entry read currently
+	ifaceIdx    int
+	wm          *DBWorkManager
+	workloadIdx int
+	blocks      *blockReader
+	entry       int
+
+	row    Row
+	err    error
+	closed bool
+}
+
+// NewReader returns a Reader for the database located at dbPath
+func NewReader(dbPath string, opts ...ReaderOption) (*Reader, error) {
+	r := &Reader{
+		ctx:        context.Background(),
+		dbPath:     dbPath,
+		last:       math.MaxInt64 - DBWriteInterval,
+		attributes: []Attribute{SipAttribute{}, DipAttribute{}, DportAttribute{}, ProtoAttribute{}},
+	}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.ifaces == nil {
+		ifaces, err := applyOptions(r.opts).store.ReadDir(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range ifaces {
+			if validateIface(iface) == nil {
+				r.ifaces = append(r.ifaces, iface)
+			}
+		}
+	}
+
+	r.query = NewQuery(r.attributes, r.conditional, true, true)
+	return r, nil
+}
+
+// Next advances to the next row, which is then available via Row. It returns false once
+// all rows have been read or an error occurred (see Err)
+func (r *Reader) Next() bool {
+	for r.err == nil && !r.closed {
+		if r.blocks != nil {
+			if r.nextEntry() {
+				return true
+			}
+			if r.blocks.next() {
+				r.entry = 0
+				continue
+			}
+			r.err = r.blocks.err
+			r.blocks.close()
+			r.blocks = nil
+		}
+
+		if r.wm != nil && r.workloadIdx < len(r.wm.workloads) {
+			r.blocks, r.err = r.wm.newBlockReader(r.ctx, r.wm.workloads[r.workloadIdx])
+			r.workloadIdx++
+			if r.err == nil && !r.blocks.next() {
+				r.err = r.blocks.err
+				r.blocks.close()
+				r.blocks = nil
+			}
+			r.entry = 0
+			continue
+		}
+
+		if r.ifaceIdx == len(r.ifaces) {
+			return false
+		}
+		r.openIface(r.ifaces[r.ifaceIdx])
+		r.ifaceIdx++
+	}
+	return false
+}
+
+// openIface plans reading the blocks of the interface
+func (r *Reader) openIface(iface string) {
+	r.wm, r.workloadIdx = nil, 0
+
+	wm, err := NewDBWorkManager(r.dbPath, iface, 1, r.opts...)
+	if err != nil {
+		r.err = err
+		return
+	}
+	if _, err = wm.CreateWorkerJobs(r.first, r.last, r.query); err != nil {
+		r.err = fmt.Errorf("Could not read interface %s: %s", iface, err)
+		return
+	}
+	r.wm = wm
+}
+
+// nextEntry advances to the next entry of the current block satisfying the conditional
+func (r *Reader) nextEntry() bool {
+	blocks := &r.blocks.blocks
+	for ; r.entry < r.blocks.numEntries; r.entry++ {
+		// the attributes read for evaluating the conditional are cleared afterwards unless
+		// they have been requested
+		var key ExtraKey
+		for _, colIdx := range r.query.columnIndizes {
+			if colIdx < ColIdxAttributeCount {
+				copyToKeyFns[colIdx](r.entry, &key, blocks[colIdx])
+			}
+		}
+		// unknown IP versions are inferred from both addresses, which are only part of
+		// the blocks (not necessarily of the key) in that case
+		if key.IPVersion == 0 && blocks[IPVersionColIdx] != nil {
+			key.IPVersion = rowIPVersion(blocks, r.entry)
+		}
+		if r.conditional != nil && !r.conditional.evaluate(&key) {
+			continue
+		}
+		r.row.Key = r.project(key.Key)
+		r.row.Time, r.row.Iface = r.blocks.tstamp, r.wm.iface
+
+		i := r.entry * 8
+		r.row.Val = Val{
+			NBytesRcvd: binary.BigEndian.Uint64(blocks[BytesRcvdColIdx][i : i+8]),
+			NBytesSent: binary.BigEndian.Uint64(blocks[BytesSentColIdx][i : i+8]),
+			NPktsRcvd:  binary.BigEndian.Uint64(blocks[PacketsRcvdColIdx][i : i+8]),
+			NPktsSent:  binary.BigEndian.Uint64(blocks[PacketsSentColIdx][i : i+8]),
+		}
+		r.entry++
+		return true
+	}
+	return false
+}
+
+// project clears all attributes of the key which haven't been requested and masks the
+// addresses of requested networks
+func (r *Reader) project(key Key) Key {
+	r.query.maskNetworks(&key)
+	return projectKey(key, r.query.queryAttributeIndizes)
+}
+
+// Row returns the current row
+func (r *Reader) Row() Row {
+	return r.row
+}
+
+// Err returns the error which stopped the iteration (if any)
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close releases all resources held by the reader
+func (r *Reader) Close() error {
+	if r.blocks != nil {
+		r.blocks.close()
+		r.blocks = nil
+	}
+	r.closed = true
+	return nil
+}
+
+// validateIface checks that iface is a valid name of an interface directory
+func validateIface(iface string) error {
+	if iface == "" || strings.HasPrefix(iface, ".") || strings.ContainsAny(iface, `/\`) {
+		return fmt.Errorf("Invalid interface name: %q", iface)
+	}
+	return nil
+}
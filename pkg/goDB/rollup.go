@@ -0,0 +1,286 @@
+package goDB
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+// RollupFilePrefix prefixes the names of the per-day files (stored like columns, e.g. as
+// rollup_talk_conv.gpf) holding the rollup tables of a day
+const RollupFilePrefix = "rollup_"
+
+const rollupVersion = 1
+
+// RollupTable denotes a table holding the flows of a whole day, aggregated over a subset
+// of the attributes. Queries over whole days which only reference these attributes (and
+// are not broken down by time) read the table instead of the blocks of the day
+type RollupTable struct {
+	Name string
+
+	// columns lists the attribute columns stored in the table (in the order of storage)
+	columns []columnIndex
+}
+
+// RollupTables lists the rollup tables written for each day, ordered by their (expected)
+// size. The talk_conv table also serves talk_src and talk_dst queries
+var RollupTables = []RollupTable{
+	{"apps_port", []columnIndex{DportColIdx, ProtoColIdx}},
+	{"talk_conv", []columnIndex{SipColIdx, DipColIdx, IPVersionColIdx}},
+}
+
+// FileName returns the name of the file the table is stored in (without the .gpf suffix)
+func (t *RollupTable) FileName() string {
+	return RollupFilePrefix + t.Name
+}
+
+// holds returns true if the table stores all attributes of the given columns
+func (t *RollupTable) holds(columns []columnIndex) bool {
+	for _, colIdx := range columns {
+		stored := false
+		for _, tableColIdx := range t.columns {
+			stored = stored || colIdx == tableColIdx
+		}
+		if !stored {
+			return false
+		}
+	}
+	return true
+}
+
+// rollupTable returns the smallest rollup table holding all attributes referenced by the
+// query, or nil if there is none or the query is broken down by time
+func (q *Query) rollupTable() *RollupTable {
+	if q == nil || q.hasAttrTime {
+		return nil
+	}
+	for i := range RollupTables {
+		if RollupTables[i].holds(q.queryAttributeIndizes) && RollupTables[i].holds(q.conditionalAttributeIndizes) {
+			return &RollupTables[i]
+		}
+	}
+	return nil
+}
+
+// rollupCoverage identifies the blocks a rollup table has been built from, based on the
+// metadata of its day. Tables whose coverage doesn't match the current metadata of the
+// day (e.g. because blocks have been added or merged since) are outdated
+type rollupCoverage struct {
+	numBlocks, flowCount, traffic uint64
+}
+
+func newRollupCoverage(meta *Metadata) (c rollupCoverage) {
+	for _, block := range meta.Blocks {
+		c.numBlocks++
+		c.flowCount += block.FlowCount
+		c.traffic += block.Traffic
+	}
+	return c
+}
+
+// rollupBlock holds a rollup table read from disk. Its columns are laid out like the
+// columns of a regular block, hence it can be evaluated in the same way
+type rollupBlock struct {
+	coverage   rollupCoverage
+	numEntries int
+	blocks     [ColIdxCount][]byte
+
+	// data holds the block the columns are sliced from
+	data []byte
+}
+
+// Layout: version (1 byte), coverage (number of blocks, flow count and traffic, uvarints)
+// and number of entries (uvarint), followed by the attribute columns of the table and the
+// counter columns
+func (t *RollupTable) marshal(coverage rollupCoverage, flows AggFlowMap) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+
+	buf := []byte{rollupVersion}
+	for _, v := range []uint64{coverage.numBlocks, coverage.flowCount, coverage.traffic, uint64(len(flows))} {
+		buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf[:], v)]...)
+	}
+
+	dbdata, _ := dbData("", 0, flows)
+	for _, colIdx := range t.columns {
+		buf = append(buf, dbdata[colIdx]...)
+	}
+	for colIdx := ColIdxAttributeCount; colIdx < ColIdxCount; colIdx++ {
+		buf = append(buf, dbdata[colIdx]...)
+	}
+	return buf
+}
+
+var errCorruptRollup = errors.New("Corrupt rollup table")
+
+func (t *RollupTable) unmarshal(data []byte) (*rollupBlock, error) {
+	if len(data) < 1 {
+		return nil, errCorruptRollup
+	}
+	if data[0] != rollupVersion {
+		return nil, errors.New("Unsupported rollup table version")
+	}
+	block, data := data, data[1:]
+
+	var header [4]uint64
+	for i := range header {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errCorruptRollup
+		}
+		header[i], data = v, data[n:]
+	}
+
+	r := &rollupBlock{
+		coverage:   rollupCoverage{header[0], header[1], header[2]},
+		numEntries: int(header[3]),
+		data:       block,
+	}
+	columns := append([]columnIndex{}, t.columns...)
+	for colIdx := ColIdxAttributeCount; colIdx < ColIdxCount; colIdx++ {
+		columns = append(columns, colIdx)
+	}
+	for _, colIdx := range columns {
+		l := r.numEntries * columnSizeofs[colIdx]
+		if len(data) < l {
+			return nil, errCorruptRollup
+		}
+		r.blocks[colIdx], data = data[:l:l], data[l:]
+	}
+	if len(data) != 0 {
+		return nil, errCorruptRollup
+	}
+	return r, nil
+}
+
+// projectKey clears all attributes of the key which are not stored in the given columns
+func projectKey(key Key, columns []columnIndex) (projected Key) {
+	for _, colIdx := range columns {
+		switch colIdx {
+		case SipColIdx:
+			projected.Sip = key.Sip
+		case DipColIdx:
+			projected.Dip = key.Dip
+		case ProtoColIdx:
+			projected.Protocol = key.Protocol
+		case DportColIdx:
+			projected.Dport = key.Dport
+		case IPVersionColIdx:
+			projected.IPVersion = key.IPVersion
+		}
+	}
+	return projected
+}
+
+// BuildRollups (re-)writes the rollup tables of the day directory located at dayPath from
+// the blocks stored in it. The tables are compressed using encoderType
+func BuildRollups(store storage.Store, dayPath string, encoderType encoders.Type) error {
+	dayTimestamp, err := strconv.ParseInt(filepath.Base(dayPath), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid day directory %s", dayPath)
+	}
+
+	// the metadata is written after the blocks, hence it has to be read first in order to
+	// not claim coverage of blocks which aren't part of the tables
+	coverage := newRollupCoverage(tryReadMetadataFrom(store, filepath.Join(dayPath, MetadataFileName)))
+	timestamps, err := dayBlockTimestamps(store, dayPath)
+	if err != nil {
+		return err
+	}
+	if uint64(len(timestamps)) != coverage.numBlocks {
+		return fmt.Errorf("Metadata of %s lists %d blocks, found %d", dayPath, coverage.numBlocks, len(timestamps))
+	}
+
+	columns, err := openDayColumns(store, dayPath)
+	if err != nil {
+		return err
+	}
+	defer closeDayColumns(columns)
+
+	tables := make([]AggFlowMap, len(RollupTables))
+	for i := range tables {
+		tables[i] = make(AggFlowMap)
+	}
+	for _, ts := range timestamps {
+		flows, err := readBlockFlows(columns, ts)
+		if err != nil {
+			return fmt.Errorf("Could not read block %d of %s: %s", ts, dayPath, err)
+		}
+		for K, V := range flows {
+			for i, table := range RollupTables {
+				key := projectKey(K, table.columns)
+				if existing, exists := tables[i][key]; exists {
+					addVal(existing, V)
+				} else {
+					val := *V
+					tables[i][key] = &val
+				}
+			}
+		}
+	}
+
+	for i := range RollupTables {
+		if err := writeRollup(store, dayPath, dayTimestamp, encoderType, &RollupTables[i], RollupTables[i].marshal(coverage, tables[i])); err != nil {
+			return fmt.Errorf("Could not write rollup table %s of %s: %s", RollupTables[i].Name, dayPath, err)
+		}
+	}
+	return nil
+}
+
+func writeRollup(store storage.Store, dayPath string, dayTimestamp int64, encoderType encoders.Type, table *RollupTable, data []byte) error {
+	backend, err := store.Open(filepath.Join(dayPath, table.FileName()+".gpf"), storage.ModeWrite, encoderType)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+
+	return backend.WriteBlock(dayTimestamp, data)
+}
+
+var errRollupOutdated = errors.New("Rollup table outdated")
+
+// readRollup reads the rollup table of the workload's day. It fails unless the table
+// covers exactly the blocks of the day selected by the workload. The returned backend
+// has to be closed once the table isn't referenced anymore
+func (w *DBWorkManager) readRollup(workload DBWorkload) (storage.Backend, *rollupBlock, error) {
+	dayTimestamp, err := strconv.ParseInt(workload.workDir, 10, 64)
+	if err != nil {
+		return nil, nil, err
+	}
+	dayPath := filepath.Join(w.dbIfaceDir, workload.workDir)
+
+	backend, err := w.store.Open(filepath.Join(dayPath, workload.rollup.FileName()+".gpf"), storage.ModeRead, encoders.EncoderTypeLZ4)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := backend.ReadBlock(dayTimestamp)
+	if err != nil {
+		backend.Close()
+		return nil, nil, err
+	}
+	rollup, err := workload.rollup.unmarshal(data)
+	if err == nil {
+		coverage := newRollupCoverage(tryReadMetadataFrom(w.store, filepath.Join(dayPath, MetadataFileName)))
+		if rollup.coverage != coverage || coverage.numBlocks != uint64(len(workload.load)) {
+			err = errRollupOutdated
+		}
+	}
+	if err != nil {
+		releaseRollup(backend, data)
+		return nil, nil, err
+	}
+	return backend, rollup, nil
+}
+
+// releaseRollup hands the data of a rollup table back to the backend it was read from
+// (if it is pooled) and closes the backend
+func releaseRollup(backend storage.Backend, data []byte) {
+	if releaser, ok := backend.(storage.BlockReleaser); ok {
+		releaser.ReleaseBlock(data)
+	}
+	backend.Close()
+}
@@ -0,0 +1,130 @@
+package goDB
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+func TestRollups(t *testing.T) {
+	const day = int64(1456358400)
+
+	var (
+		dbPath = t.TempDir()
+		dayDir = filepath.Join(dbPath, "eth0", strconv.FormatInt(day, 10))
+		w      = NewDBWriter(dbPath, "eth0", encoders.EncoderTypeLZ4)
+	)
+	write := func(timestamp int64, keys ...Key) {
+		t.Helper()
+		flows := make(AggFlowMap)
+		for _, K := range keys {
+			K.IPVersion = IPv4
+			flows[K] = &Val{NBytesRcvd: uint64(timestamp % 1000), NBytesSent: 1, NPktsRcvd: 2, NPktsSent: 3}
+		}
+		if _, err := w.Write(flows, BlockMetadata{Timestamp: timestamp}, timestamp); err != nil {
+			t.Fatalf("Failed to write flows: %s", err)
+		}
+	}
+	for i, ts := range []int64{day + 300, day + 600, day + 900, day + EpochDay + 300} {
+		write(ts,
+			testStatsKey("10.0.0.1", "10.0.0.2", 443, 6),
+			testStatsKey("10.0.0.1", "10.0.0.3", 53, 17),
+			testStatsKey("10.0.0."+strconv.Itoa(i+4), "10.0.0.2", 443, 6),
+		)
+	}
+
+	var tests = []struct {
+		queryType, conditional string
+		tfirst, tlast          int64
+		rollup                 string
+	}{
+		{"talk_conv", "", day - 1, day + 2*EpochDay, "talk_conv"},
+		{"apps_port", "dport = 443", day - 1, day + 2*EpochDay, "apps_port"},
+		{"talk_src", "dport = 443", day - 1, day + 2*EpochDay, ""},
+		{"talk_src", "snet = 10.0.0.0/30", day - 1, day + 2*EpochDay, "talk_conv"},
+		{"apps_port", "proto = 17", day - 1, day + 2*EpochDay, "apps_port"},
+		{"sip,dport", "", day - 1, day + 2*EpochDay, ""},
+		{"time,sip", "", day - 1, day + 2*EpochDay, ""},
+		{"talk_conv", "", day + 300, day + 2*EpochDay, ""},
+	}
+	queries := make([]*Query, len(tests))
+	for i, test := range tests {
+		attributes, hasAttrTime, hasAttrIface, err := ParseQueryType(test.queryType)
+		if err != nil {
+			t.Fatalf("Failed to parse query type: %s", err)
+		}
+		cond, err := ParseAndInstrumentConditional(test.conditional, time.Second)
+		if err != nil {
+			t.Fatalf("Failed to parse conditional: %s", err)
+		}
+		queries[i] = NewQuery(attributes, cond, hasAttrTime, hasAttrIface)
+	}
+
+	// rollupUsed checks whether the first day of the time range is read from a rollup table
+	rollupUsed := func(i int) bool {
+		t.Helper()
+		wm, err := NewDBWorkManager(dbPath, "eth0", 1)
+		if err != nil {
+			t.Fatalf("Failed to create work manager: %s", err)
+		}
+		if _, err := wm.CreateWorkerJobs(tests[i].tfirst, tests[i].tlast, queries[i]); err != nil {
+			t.Fatalf("Failed to create worker jobs: %s", err)
+		}
+		r, err := wm.newBlockReader(context.Background(), wm.workloads[0])
+		if err != nil {
+			t.Fatalf("Failed to read blocks: %s", err)
+		}
+		defer r.close()
+		return r.rollup != nil
+	}
+	check := func(expectRollups bool) {
+		t.Helper()
+		for i, test := range tests {
+			if have := rollupUsed(i); have != (expectRollups && test.rollup != "") {
+				t.Fatalf("%s (%q): rollup used: %t", test.queryType, test.conditional, have)
+			}
+			if test.rollup != "" && expectRollups && queries[i].rollupTable().Name != test.rollup {
+				t.Fatalf("%s (%q): want rollup table %s, have %s", test.queryType, test.conditional, test.rollup, queries[i].rollupTable().Name)
+			}
+		}
+	}
+	results := func() []map[ExtraKey]Val {
+		t.Helper()
+		var results []map[ExtraKey]Val
+		for i, test := range tests {
+			results = append(results, evaluateRange(t, dbPath, queries[i], test.tfirst, test.tlast))
+		}
+		return results
+	}
+
+	// without rollup tables, the blocks are read
+	check(false)
+	want := results()
+
+	if err := BuildRollups(gpfile.NewStore(), dayDir, encoders.EncoderTypeLZ4); err != nil {
+		t.Fatalf("Failed to build rollups: %s", err)
+	}
+	check(true)
+	if have := results(); !reflect.DeepEqual(want, have) {
+		t.Fatalf("Unexpected results using rollups: want %v, have %v", want, have)
+	}
+
+	// blocks written to the day after building the tables render them outdated
+	write(day+1200, testStatsKey("10.0.0.1", "10.0.0.2", 443, 6))
+	check(false)
+	want = results()
+
+	if err := BuildRollups(gpfile.NewStore(), dayDir, encoders.EncoderTypeLZ4); err != nil {
+		t.Fatalf("Failed to build rollups: %s", err)
+	}
+	check(true)
+	if have := results(); !reflect.DeepEqual(want, have) {
+		t.Fatalf("Unexpected results using rollups: want %v, have %v", want, have)
+	}
+}
@@ -0,0 +1,270 @@
+package goDB
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// selection is a bitmap of the rows of a block satisfying a conditional. Row i is
+// selected if bit i%64 of word i/64 is set
+type selection []uint64
+
+// resize returns a cleared selection for numEntries rows, reusing the receiver's memory
+// if possible
+func (s selection) resize(numEntries int) selection {
+	numWords := (numEntries + 63) / 64
+	if cap(s) < numWords {
+		return make(selection, numWords)
+	}
+	s = s[:numWords]
+	for w := range s {
+		s[w] = 0
+	}
+	return s
+}
+
+// empty checks whether no row is selected
+func (s selection) empty() bool {
+	for _, word := range s {
+		if word != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// forEach calls fn for each selected row in ascending order
+func (s selection) forEach(fn func(i int)) {
+	for w, word := range s {
+		for word != 0 {
+			fn(w*64 + bits.TrailingZeros64(word))
+			word &= word - 1
+		}
+	}
+}
+
+// lastWordMask returns the mask of the bits of the last word denoting rows of a block
+// with numEntries rows
+func lastWordMask(numEntries int) uint64 {
+	if numEntries%64 == 0 {
+		return ^uint64(0)
+	}
+	return uint64(1)<<uint(numEntries%64) - 1
+}
+
+// selectionScratch provides the temporary selections needed to combine the selections
+// of subconditionals. It is owned by a single worker, since the conditional itself is
+// shared between all workers of a query
+type selectionScratch struct {
+	free []selection
+}
+
+func (s *selectionScratch) get(numEntries int) selection {
+	if len(s.free) == 0 {
+		return selection(nil).resize(numEntries)
+	}
+	sel := s.free[len(s.free)-1]
+	s.free = s.free[:len(s.free)-1]
+	return sel.resize(numEntries)
+}
+
+func (s *selectionScratch) put(sel selection) {
+	s.free = append(s.free, sel)
+}
+
+// generateSelectFn generates the columnar counterpart of the closure generated by
+// generateCompareFn: instead of comparing the value of a single flow, it selects all
+// flows of a block satisfying the condition by scanning the raw column directly
+func generateSelectFn(condition *conditionNode, value []byte, netmask int) error {
+	switch condition.attribute {
+	case "sip", "dip", "snet", "dnet":
+		colIdx := SipColIdx
+		if condition.attribute == "dip" || condition.attribute == "dnet" {
+			colIdx = DipColIdx
+		}
+
+		// addresses are compared as two masked 64 bit words. For plain addresses all
+		// bits are relevant, for networks those covered by the netmask
+		if condition.attribute == "sip" || condition.attribute == "dip" {
+			netmask = 8 * SipSizeof
+		}
+		maskHi, maskLo := netmaskWords(netmask)
+		valueHi, valueLo := binary.BigEndian.Uint64(value[0:8])&maskHi, binary.BigEndian.Uint64(value[8:16])&maskLo
+
+		var negate bool
+		switch condition.comparator {
+		case "=":
+		case "!=":
+			negate = true
+		default:
+			return errors.New("Comparator \"" + condition.comparator + "\" not allowed for attribute \"" + condition.attribute + "\"")
+		}
+
+		condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+			col := blocks[colIdx]
+			for i := 0; i < numEntries; i++ {
+				hi, lo := binary.BigEndian.Uint64(col[i*16:i*16+8]), binary.BigEndian.Uint64(col[i*16+8:i*16+16])
+				if (hi&maskHi == valueHi && lo&maskLo == valueLo) != negate {
+					sel[i>>6] |= 1 << uint(i&63)
+				}
+			}
+		}
+		return nil
+	case "dport":
+		lo, hi, negate, err := comparatorRange(condition, uint64(binary.BigEndian.Uint16(value[:DportSizeof])), 0xffff)
+		if err != nil {
+			return err
+		}
+		lo16, width := uint16(lo), uint16(hi-lo)
+
+		condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+			col := blocks[DportColIdx]
+			for i := 0; i < numEntries; i++ {
+				if (binary.BigEndian.Uint16(col[i*2:i*2+2])-lo16 <= width) != negate {
+					sel[i>>6] |= 1 << uint(i&63)
+				}
+			}
+		}
+		return nil
+	case "proto":
+		lo, hi, negate, err := comparatorRange(condition, uint64(value[0]), 0xff)
+		if err != nil {
+			return err
+		}
+		lo8, width := uint8(lo), uint8(hi-lo)
+
+		condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+			col := blocks[ProtoColIdx]
+			for i := 0; i < numEntries; i++ {
+				if (col[i]-lo8 <= width) != negate {
+					sel[i>>6] |= 1 << uint(i&63)
+				}
+			}
+		}
+		return nil
+	case "ipv":
+		ipVersion := value[0]
+		switch condition.comparator {
+		case "=":
+			condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+				for w := range sel {
+					sel[w] = ipVersionWord(blocks, numEntries, w, ipVersion)
+				}
+			}
+			return nil
+		case "!=":
+			condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+				for w := range sel {
+					sel[w] = ^ipVersionWord(blocks, numEntries, w, ipVersion)
+				}
+				if len(sel) > 0 {
+					sel[len(sel)-1] &= lastWordMask(numEntries)
+				}
+			}
+			return nil
+		default:
+			return errors.New("Comparator \"" + condition.comparator + "\" not allowed for attribute \"" + condition.attribute + "\"")
+		}
+	default:
+		return errors.New("Unknown attribute \"" + condition.attribute + "\"")
+	}
+}
+
+// selectIPVersion restricts the selection of an address condition to flows of the given
+// IP version, the same way matchIPVersion does for the comparison of a single flow
+func selectIPVersion(condition *conditionNode, ipVersion byte) {
+	selectAddress := condition.selectValues
+	if condition.comparator == "=" {
+		condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+			selectAddress(blocks, numEntries, sel)
+			for w, word := range sel {
+				if word != 0 {
+					sel[w] = word & ipVersionWord(blocks, numEntries, w, ipVersion)
+				}
+			}
+		}
+	} else {
+		condition.selectValues = func(blocks *[ColIdxCount][]byte, numEntries int, sel selection) {
+			selectAddress(blocks, numEntries, sel)
+			for w, word := range sel {
+				sel[w] = word | ^ipVersionWord(blocks, numEntries, w, ipVersion)
+			}
+			if len(sel) > 0 {
+				sel[len(sel)-1] &= lastWordMask(numEntries)
+			}
+		}
+	}
+}
+
+// ipVersionWord returns the bits of word w of a selection denoting the flows of the given
+// IP version
+func ipVersionWord(blocks *[ColIdxCount][]byte, numEntries, w int, ipVersion byte) (word uint64) {
+	end := w*64 + 64
+	if end > numEntries {
+		end = numEntries
+	}
+	for i := w * 64; i < end; i++ {
+		if rowIPVersion(blocks, i) == ipVersion {
+			word |= 1 << uint(i&63)
+		}
+	}
+	return word
+}
+
+// rowIPVersion returns the IP version of the i-th flow of a block. Flows of unknown version
+// are treated like Key.Version does, inferring the version from the addresses. If they
+// weren't read, the version remains unknown (0)
+func rowIPVersion(blocks *[ColIdxCount][]byte, i int) byte {
+	if versions := blocks[IPVersionColIdx]; versions != nil && versions[i] != 0 {
+		return versions[i]
+	}
+	if blocks[SipColIdx] == nil || blocks[DipColIdx] == nil {
+		return 0
+	}
+	if !isIPv4Address(blocks[SipColIdx], i) || !isIPv4Address(blocks[DipColIdx], i) {
+		return IPv6
+	}
+	return IPv4
+}
+
+// isIPv4Address checks whether bytes 4 to 15 of the i-th address of the column are zero
+func isIPv4Address(col []byte, i int) bool {
+	return binary.BigEndian.Uint32(col[i*16+4:i*16+8]) == 0 && binary.BigEndian.Uint64(col[i*16+8:i*16+16]) == 0
+}
+
+// netmaskWords returns the netmask of the given length as two 64 bit words
+func netmaskWords(netmask int) (hi, lo uint64) {
+	if netmask >= 64 {
+		return ^uint64(0), ^(^uint64(0) >> uint(netmask-64))
+	}
+	return ^(^uint64(0) >> uint(netmask)), 0
+}
+
+// comparatorRange translates the comparison of an attribute with the given value into
+// the range [lo, hi] of matching values (or of non-matching values if negate is set),
+// allowing each comparator to be evaluated with a single comparison per flow
+func comparatorRange(condition *conditionNode, value, max uint64) (lo, hi uint64, negate bool, err error) {
+	switch condition.comparator {
+	case "=":
+		return value, value, false, nil
+	case "!=":
+		return value, value, true, nil
+	case "<":
+		if value == 0 {
+			return 0, max, true, nil
+		}
+		return 0, value - 1, false, nil
+	case ">":
+		if value == max {
+			return 0, max, true, nil
+		}
+		return value + 1, max, false, nil
+	case "<=":
+		return 0, value, false, nil
+	case ">=":
+		return value, max, false, nil
+	default:
+		return 0, 0, false, errors.New("Comparator \"" + condition.comparator + "\" not allowed for attribute \"" + condition.attribute + "\"")
+	}
+}
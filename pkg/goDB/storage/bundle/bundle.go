@@ -0,0 +1,316 @@
+// Package bundle implements portable goDB bundles (.gpdb files). A bundle is an
+// uncompressed tar archive holding a slice of a goDB (the selected blocks of the column
+// files, the per-day metadata and the database summary), preceded by a manifest which
+// describes its content and lists the checksums of all files. Bundles can be extracted
+// (see Extract) or queried in place (see Open)
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// FileSuffix denotes the conventional suffix of bundle files
+	FileSuffix = ".gpdb"
+
+	// ManifestFileName denotes the name of the manifest, which is the first entry of
+	// each bundle
+	ManifestFileName = "manifest.json"
+
+	// formatVersion denotes the current version of the bundle format
+	formatVersion = 1
+)
+
+// Manifest describes the content of a bundle
+type Manifest struct {
+	Version  int       `json:"version"`
+	Created  time.Time `json:"created"`
+	Hostname string    `json:"hostname,omitempty"`
+
+	// First and Last denote the time range the bundle was exported for
+	First int64 `json:"first"`
+	Last  int64 `json:"last"`
+
+	// Interfaces summarizes the data of each interface contained in the bundle
+	Interfaces map[string]goDB.InterfaceSummary `json:"interfaces"`
+
+	// Files lists all files of the bundle (except for the manifest itself)
+	Files []File `json:"files"`
+}
+
+// File describes a file contained in a bundle
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// entry denotes the location of a file within the bundle
+type entry struct {
+	offset, size int64
+}
+
+// Bundle provides read-only access to the goDB contained in a bundle file without
+// extracting it. It implements storage.Store, with all paths being interpreted relative
+// to the path of the bundle file (i.e. the bundle takes the place of the DB directory)
+type Bundle struct {
+	path     string
+	file     *os.File
+	manifest *Manifest
+	entries  map[string]entry
+
+	options []gpfile.Option
+}
+
+var errReadOnly = errors.New("Bundles are read-only")
+
+// IsBundle returns true if path denotes a (bundle) file rather than a DB directory
+func IsBundle(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Open opens the bundle located at path. The GPFile options (e.g. the keyring required
+// to decrypt blocks) are applied to all column files read from the bundle. The checksums
+// of the files are not verified (see Verify)
+func Open(path string, options ...gpfile.Option) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{
+		path:    filepath.Clean(path),
+		file:    f,
+		entries: make(map[string]entry),
+		options: options,
+	}
+	if err = b.index(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Invalid bundle %s: %s", path, err)
+	}
+
+	return b, nil
+}
+
+// index reads the manifest and determines the location of all files in the bundle
+func (b *Bundle) index() error {
+	r := &offsetReader{ReadSeeker: b.file}
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// the manifest is required to be the first entry
+		if b.manifest == nil {
+			if hdr.Name != ManifestFileName {
+				return errors.New("Missing manifest")
+			}
+			if b.manifest, err = readManifest(tr); err != nil {
+				return err
+			}
+			continue
+		}
+		b.entries[hdr.Name] = entry{offset: r.offset, size: hdr.Size}
+	}
+
+	if b.manifest == nil {
+		return errors.New("Missing manifest")
+	}
+	return nil
+}
+
+func readManifest(r io.Reader) (*Manifest, error) {
+	var manifest Manifest
+	if err := jsoniter.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("Could not read manifest: %s", err)
+	}
+	if manifest.Version != formatVersion {
+		return nil, fmt.Errorf("Unsupported bundle version: %d", manifest.Version)
+	}
+	return &manifest, nil
+}
+
+// Manifest returns the manifest of the bundle
+func (b *Bundle) Manifest() *Manifest {
+	return b.manifest
+}
+
+// Close closes the bundle file
+func (b *Bundle) Close() error {
+	return b.file.Close()
+}
+
+// rel returns the name of the entry corresponding to path
+func (b *Bundle) rel(op, path string) (string, error) {
+	rel, err := filepath.Rel(b.path, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+func (b *Bundle) section(op, path string) (*io.SectionReader, error) {
+	name, err := b.rel(op, path)
+	if err != nil {
+		return nil, err
+	}
+	e, exists := b.entries[name]
+	if !exists {
+		return nil, &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+	}
+	return io.NewSectionReader(b.file, e.offset, e.size), nil
+}
+
+// Open returns the (read-only) backend located at path
+func (b *Bundle) Open(path string, mode storage.Mode, encoderType encoders.Type) (storage.Backend, error) {
+	if mode != storage.ModeRead {
+		return nil, errReadOnly
+	}
+
+	header, err := b.section("open", path+gpfile.HeaderFileSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("GPFile invalid: %s", err)
+	}
+
+	// the data file is absent if the column only holds empty blocks
+	var data io.ReaderAt = bytes.NewReader(nil)
+	if section, err := b.section("open", path); err == nil {
+		data = section
+	}
+
+	return gpfile.NewReader(path, header, data, append([]gpfile.Option{gpfile.WithEncoder(encoderType)}, b.options...)...)
+}
+
+// ReadDir returns the sorted names of all directories located directly below path
+func (b *Bundle) ReadDir(path string) ([]string, error) {
+	name, err := b.rel("readdir", path)
+	if err != nil {
+		return nil, err
+	}
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+
+	var (
+		dirs  []string
+		found bool
+		seen  = make(map[string]struct{})
+	)
+	for entryName := range b.entries {
+		if !strings.HasPrefix(entryName, prefix) {
+			continue
+		}
+		found = true
+
+		rest := entryName[len(prefix):]
+		i := strings.IndexByte(rest, '/')
+		if i < 0 {
+			continue
+		}
+		if _, exists := seen[rest[:i]]; !exists {
+			seen[rest[:i]] = struct{}{}
+			dirs = append(dirs, rest[:i])
+		}
+	}
+	if !found && prefix != "" {
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: os.ErrNotExist}
+	}
+
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// MkdirAll fails since bundles are read-only
+func (b *Bundle) MkdirAll(path string) error {
+	return errReadOnly
+}
+
+// ReadFile returns the contents of the file located at path
+func (b *Bundle) ReadFile(path string) ([]byte, error) {
+	section, err := b.section("read", path)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, section.Size())
+	if _, err = io.ReadFull(section, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// WriteFile fails since bundles are read-only
+func (b *Bundle) WriteFile(path string, data []byte) error {
+	return errReadOnly
+}
+
+// AppendFile fails since bundles are read-only
+func (b *Bundle) AppendFile(path string, data []byte) error {
+	return errReadOnly
+}
+
+// CreateFile fails since bundles are read-only
+func (b *Bundle) CreateFile(path string) error {
+	return errReadOnly
+}
+
+// CopyDir fails since bundles are read-only
+func (b *Bundle) CopyDir(src, dst string) error {
+	return errReadOnly
+}
+
+// Rename fails since bundles are read-only
+func (b *Bundle) Rename(oldPath, newPath string) error {
+	return errReadOnly
+}
+
+// RemoveAll fails since bundles are read-only
+func (b *Bundle) RemoveAll(path string) error {
+	return errReadOnly
+}
+
+// offsetReader keeps track of the current offset in the underlying file, which (once
+// the header of a tar entry has been read) denotes the beginning of its data
+type offsetReader struct {
+	io.ReadSeeker
+	offset int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeeker.Seek(offset, whence)
+	if err == nil {
+		r.offset = pos
+	}
+	return pos, err
+}
@@ -0,0 +1,187 @@
+package bundle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+const testTimestamp = int64(1456428600)
+
+// writeTestDB writes one block per write interval for the first hour starting at
+// testTimestamp, each holding a single flow
+func writeTestDB(t *testing.T, dbPath, iface string) {
+	t.Helper()
+
+	w := goDB.NewDBWriter(dbPath, iface, encoders.EncoderTypeLZ4)
+	for i := int64(0); i < 12; i++ {
+		ts := testTimestamp + i*goDB.DBWriteInterval
+
+		var key goDB.Key
+		key.Sip[0], key.Dip[0], key.Protocol = 10, byte(i), 6
+		flowmap := goDB.AggFlowMap{key: &goDB.Val{NBytesRcvd: uint64(i + 1), NPktsRcvd: 1}}
+		if _, err := w.Write(flowmap, goDB.BlockMetadata{Timestamp: ts}, ts); err != nil {
+			t.Fatalf("Failed to write flows: %s", err)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "db")
+	writeTestDB(t, dbPath, "eth0")
+	writeTestDB(t, dbPath, "eth1")
+
+	// export the blocks of the first half hour of eth0 only
+	var (
+		out   = filepath.Join(tmpDir, "test"+FileSuffix)
+		first = testTimestamp - goDB.DBWriteInterval
+		last  = testTimestamp + 5*goDB.DBWriteInterval
+	)
+	result, err := Export(dbPath, []string{"eth0"}, first, last, out)
+	if err != nil {
+		t.Fatalf("Failed to export bundle: %s", err)
+	}
+	if len(result.ArchivedDirs) != 0 {
+		t.Fatalf("Unexpected archived directories: %v", result.ArchivedDirs)
+	}
+	want := goDB.InterfaceSummary{FlowCount: 6, Traffic: 1 + 2 + 3 + 4 + 5 + 6, Begin: testTimestamp, End: last}
+	if len(result.Manifest.Interfaces) != 1 || result.Manifest.Interfaces["eth0"] != want {
+		t.Fatalf("Unexpected interfaces in manifest: want %+v, have %+v", want, result.Manifest.Interfaces)
+	}
+
+	if _, err = Verify(out); err != nil {
+		t.Fatalf("Failed to verify bundle: %s", err)
+	}
+
+	// query the bundle in place and compare the blocks against the original database
+	b, err := Open(out)
+	if err != nil {
+		t.Fatalf("Failed to open bundle: %s", err)
+	}
+	defer b.Close()
+
+	ifaces, err := b.ReadDir(out)
+	if err != nil || len(ifaces) != 1 || ifaces[0] != "eth0" {
+		t.Fatalf("Unexpected interfaces in bundle: %v (%v)", ifaces, err)
+	}
+	summary, err := goDB.ReadDBSummary(b, out)
+	if err != nil || summary.Interfaces["eth0"] != want {
+		t.Fatalf("Unexpected summary in bundle: %+v (%v)", summary, err)
+	}
+	if err = b.WriteFile(filepath.Join(out, "test"), nil); err != errReadOnly {
+		t.Fatalf("Unexpected error writing to bundle: %v", err)
+	}
+
+	day := filepath.Join("eth0", "1456358400", "bytes_rcvd.gpf")
+	backend, err := b.Open(filepath.Join(out, day), storage.ModeRead, encoders.EncoderTypeLZ4)
+	if err != nil {
+		t.Fatalf("Failed to open column in bundle: %s", err)
+	}
+	defer backend.Close()
+	orig, err := gpfile.New(filepath.Join(dbPath, day), gpfile.ModeRead)
+	if err != nil {
+		t.Fatalf("Failed to open original column: %s", err)
+	}
+	defer orig.Close()
+
+	header, err := backend.Blocks()
+	if err != nil {
+		t.Fatalf("Failed to read blocks from bundle: %s", err)
+	}
+	if len(header.Blocks) != 6 {
+		t.Fatalf("Unexpected number of blocks in bundle: %d", len(header.Blocks))
+	}
+	for ts := range header.Blocks {
+		data, err := backend.ReadBlock(ts)
+		if err != nil {
+			t.Fatalf("Failed to read block %d from bundle: %s", ts, err)
+		}
+		origData, err := orig.ReadBlock(ts)
+		if err != nil {
+			t.Fatalf("Failed to read original block %d: %s", ts, err)
+		}
+		if !bytes.Equal(data, origData) {
+			t.Fatalf("Unexpected data of block %d: want %v, have %v", ts, origData, data)
+		}
+	}
+
+	// extracted bundles hold a regular database
+	extracted := filepath.Join(tmpDir, "extracted")
+	if err = os.Mkdir(extracted, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	if _, err = Extract(out, extracted); err != nil {
+		t.Fatalf("Failed to extract bundle: %s", err)
+	}
+	summ, err := goDB.RebuildInterfaceSummary(gpfile.NewStore(), extracted, "eth0")
+	if err != nil || summ != want {
+		t.Fatalf("Unexpected summary of extracted database: %+v (%v)", summ, err)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "bundle")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dbPath := filepath.Join(tmpDir, "db")
+	writeTestDB(t, dbPath, "eth0")
+
+	out := filepath.Join(tmpDir, "test"+FileSuffix)
+	if _, err := Export(dbPath, []string{"eth0"}, 0, testTimestamp+goDB.EpochDay, out); err != nil {
+		t.Fatalf("Failed to export bundle: %s", err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Failed to read bundle: %s", err)
+	}
+	b, err := Open(out)
+	if err != nil {
+		t.Fatalf("Failed to open bundle: %s", err)
+	}
+	summaryEntry := b.entries[goDB.SummaryFileName]
+	b.Close()
+
+	var tests = []struct {
+		name   string
+		tamper func([]byte) []byte
+	}{
+		{"truncated", func(data []byte) []byte {
+			return data[:len(data)/2]
+		}},
+		{"modified data", func(data []byte) []byte {
+			data[summaryEntry.offset] ^= 0xff
+			return data
+		}},
+		{"missing manifest", func(data []byte) []byte {
+			return bytes.Replace(data, []byte(ManifestFileName), []byte("manifest.jsoo"), 1)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := filepath.Join(tmpDir, "tampered"+FileSuffix)
+			if err := ioutil.WriteFile(tampered, test.tamper(append([]byte{}, data...)), 0644); err != nil {
+				t.Fatalf("Failed to write bundle: %s", err)
+			}
+			if _, err := Verify(tampered); err == nil {
+				t.Fatalf("Verification of tampered bundle succeeded unexpectedly")
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/storage/s3"
+	jsoniter "github.com/json-iterator/go"
+)
+
+// ExportResult summarizes an export
+type ExportResult struct {
+	Manifest *Manifest
+
+	// ArchivedDirs lists the days which have been archived to object storage and could
+	// hence not be exported
+	ArchivedDirs []string
+}
+
+// Export writes the blocks of the given interfaces of the database at dbPath covering the
+// time range from first to last (as selected by a query) to a new bundle file at out.
+// Blocks are copied as they are stored, i.e. encrypted blocks remain encrypted
+func Export(dbPath string, ifaces []string, first, last int64, out string) (result ExportResult, err error) {
+	staging, err := ioutil.TempDir("", "gpdb-export")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(staging)
+
+	// select the blocks the same way a query does
+	keep := func(timestamp int64) bool {
+		return first < timestamp && timestamp < last+goDB.DBWriteInterval
+	}
+
+	manifest := &Manifest{
+		Version:    formatVersion,
+		Created:    time.Now().UTC(),
+		First:      first,
+		Last:       last,
+		Interfaces: make(map[string]goDB.InterfaceSummary),
+	}
+	manifest.Hostname, _ = os.Hostname()
+
+	store := gpfile.NewStore()
+	summary := goDB.NewDBSummary()
+	for _, iface := range ifaces {
+		days, err := ioutil.ReadDir(filepath.Join(dbPath, iface))
+		if err != nil {
+			return result, err
+		}
+
+		var exported bool
+		for _, day := range days {
+			dayTimestamp, err := strconv.ParseInt(day.Name(), 10, 64)
+			if !day.IsDir() || err != nil || dayTimestamp+goDB.EpochDay <= first || dayTimestamp >= last+goDB.DBWriteInterval {
+				continue
+			}
+
+			dayPath := filepath.Join(dbPath, iface, day.Name())
+			if _, err := os.Stat(filepath.Join(dayPath, s3.StubFileName)); err == nil {
+				result.ArchivedDirs = append(result.ArchivedDirs, dayPath)
+				continue
+			}
+
+			numBlocks, err := exportDay(dayPath, filepath.Join(staging, iface, day.Name()), keep)
+			if err != nil {
+				return result, fmt.Errorf("Could not export %s: %s", dayPath, err)
+			}
+			exported = exported || numBlocks > 0
+		}
+		if !exported {
+			continue
+		}
+		if err = exportTimeIndex(filepath.Join(dbPath, iface), filepath.Join(staging, iface), keep); err != nil {
+			return result, err
+		}
+
+		ifaceSumm, err := goDB.RebuildInterfaceSummary(store, staging, iface)
+		if err != nil {
+			return result, err
+		}
+		manifest.Interfaces[iface] = ifaceSumm
+		summary.Interfaces[iface] = ifaceSumm
+	}
+	if err = goDB.WriteDBSummary(store, staging, summary); err != nil {
+		return result, err
+	}
+
+	if err = writeBundle(staging, manifest, out); err != nil {
+		return result, err
+	}
+	result.Manifest = manifest
+	return result, nil
+}
+
+// exportDay copies the selected blocks of all column files and their metadata from
+// the day directory src to dst. Returns the number of blocks exported
+func exportDay(src, dst string, keep func(int64) bool) (int, error) {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return 0, err
+	}
+
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return 0, err
+	}
+
+	var numBlocks int
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".gpf"+gpfile.HeaderFileSuffix) {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), gpfile.HeaderFileSuffix)
+		n, err := gpfile.CopyBlocks(filepath.Join(src, name), filepath.Join(dst, name), keep)
+		if err != nil {
+			return 0, err
+		}
+		if n > numBlocks {
+			numBlocks = n
+		}
+	}
+
+	// days without any selected block are omitted entirely
+	if numBlocks == 0 {
+		return 0, os.RemoveAll(dst)
+	}
+
+	meta := goDB.TryReadMetadata(filepath.Join(src, goDB.MetadataFileName))
+	selected := goDB.NewMetadata()
+	for _, block := range meta.Blocks {
+		if keep(block.Timestamp) {
+			selected.Blocks = append(selected.Blocks, block)
+		}
+	}
+
+	return numBlocks, goDB.WriteMetadata(filepath.Join(dst, goDB.MetadataFileName), selected)
+}
+
+// exportTimeIndex writes the time index of the interface directory src, reduced to the
+// exported blocks, to dst. Interfaces without (valid) time index are exported without
+func exportTimeIndex(src, dst string, keep func(int64) bool) error {
+	store := gpfile.NewStore()
+	index, err := goDB.ReadTimeIndex(store, src)
+	if err != nil {
+		return nil
+	}
+	index.Remove(func(timestamp int64) bool {
+		return !keep(timestamp)
+	})
+	return index.Write(store, dst)
+}
+
+// writeBundle writes all files below dir to the bundle file at out, preceded by the
+// manifest (which is completed by the list of files and their checksums)
+func writeBundle(dir string, manifest *Manifest, out string) (err error) {
+	var paths []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		file := File{Path: filepath.ToSlash(strings.TrimPrefix(path, dir+string(filepath.Separator)))}
+		if file.Size, file.SHA256, err = checksum(path); err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+	manifestData, err := jsoniter.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// the bundle is assembled next to its destination and moved there once complete
+	tmpName := out + ".tmp"
+	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	tw := tar.NewWriter(f)
+	modTime := manifest.Created
+	if err = tw.WriteHeader(&tar.Header{Name: ManifestFileName, Mode: 0644, Size: int64(len(manifestData)), ModTime: modTime}); err != nil {
+		return err
+	}
+	if _, err = tw.Write(manifestData); err != nil {
+		return err
+	}
+	for i, path := range paths {
+		if err = writeEntry(tw, path, manifest.Files[i], modTime); err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, out)
+}
+
+func writeEntry(tw *tar.Writer, path string, file File, modTime time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = tw.WriteHeader(&tar.Header{Name: file.Path, Mode: 0644, Size: file.Size, ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+func checksum(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,124 @@
+package bundle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Verify checks that the bundle located at path is complete and that the checksums of
+// all its files match the manifest
+func Verify(path string) (*Manifest, error) {
+	return readBundle(path, func(name string, r io.Reader) error {
+		_, err := io.Copy(ioutil.Discard, r)
+		return err
+	})
+}
+
+// Extract verifies the bundle located at path and extracts its files to the (existing)
+// directory dir. If the verification fails, an error is returned and the extracted
+// files must not be used
+func Extract(path, dir string) (*Manifest, error) {
+	return readBundle(path, func(name string, r io.Reader) error {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	})
+}
+
+// readBundle reads all files of the bundle located at path, passing them to fn, and
+// verifies them against the manifest
+func readBundle(path string, fn func(name string, r io.Reader) error) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		manifest *Manifest
+		files    map[string]File
+		tr       = tar.NewReader(f)
+	)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid bundle %s: %s", path, err)
+		}
+
+		// the manifest is required to be the first entry
+		if manifest == nil {
+			if hdr.Name != ManifestFileName {
+				return nil, fmt.Errorf("Invalid bundle %s: Missing manifest", path)
+			}
+			if manifest, err = readManifest(tr); err != nil {
+				return nil, fmt.Errorf("Invalid bundle %s: %s", path, err)
+			}
+			files = make(map[string]File, len(manifest.Files))
+			for _, file := range manifest.Files {
+				files[file.Path] = file
+			}
+			continue
+		}
+
+		file, exists := files[hdr.Name]
+		if !exists || hdr.Typeflag != tar.TypeReg || !validName(hdr.Name) {
+			return nil, fmt.Errorf("Unexpected file in bundle: %s", hdr.Name)
+		}
+		delete(files, hdr.Name)
+
+		h := sha256.New()
+		counter := &countingWriter{}
+		if err = fn(hdr.Name, io.TeeReader(tr, io.MultiWriter(h, counter))); err != nil {
+			return nil, err
+		}
+		if counter.n != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+			return nil, fmt.Errorf("Checksum mismatch for %s", hdr.Name)
+		}
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("Invalid bundle %s: Missing manifest", path)
+	}
+	for name := range files {
+		return nil, fmt.Errorf("File missing in bundle: %s", name)
+	}
+
+	return manifest, nil
+}
+
+// validName makes sure that a file of the bundle cannot be extracted outside of the
+// target directory
+func validName(name string) bool {
+	clean := filepath.ToSlash(filepath.Clean(filepath.FromSlash(name)))
+	return clean == name && !filepath.IsAbs(name) && clean != ".." && !strings.HasPrefix(clean, "../")
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -2,12 +2,16 @@ package gpfile
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/els0r/goProbe/pkg/goDB/encoder"
 	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
 	"github.com/els0r/goProbe/pkg/goDB/storage"
 )
 
@@ -22,8 +26,9 @@ const (
 	// defaultEncoderType denotes the default encoder / compressor
 	defaultEncoderType = encoders.EncoderTypeLZ4
 
-	// headerVersion denotes the current header version
-	headerVersion = 1
+	// HeaderVersion denotes the current header version (i.e. the format version of newly
+	// written files)
+	HeaderVersion = 1
 
 	// ModeRead denotes read access
 	ModeRead = os.O_RDONLY
@@ -53,9 +58,26 @@ type GPFile struct {
 	defaultEncoderType encoders.Type
 	defaultEncoder     encoder.Encoder
 
+	// keyring provides the keys for decryption of blocks and (optionally) the key
+	// used to encrypt newly written blocks
+	keyring *encryption.Keyring
+
 	// accessMode denotes if the file is opened for read or write operations (to avoid
 	// race conditions and unpredictable behavior, only one mode is possible at a time)
 	accessMode int
+
+	// data provides the block data of read-only GPFiles which are not backed by a file
+	// of their own (see NewReader)
+	data io.ReaderAt
+
+	// skipKeyCheck allows to open files in read mode without providing the keys of
+	// encrypted blocks, in which case they can only be copied as they are
+	skipKeyCheck bool
+
+	// mmap denotes that the data file is memory-mapped (once a block is read) and that
+	// blocks are decompressed into pooled buffers (see WithMmap)
+	mmap   bool
+	mapped []byte
 }
 
 // New returns a new GPFile object to read and write goProbe flow data
@@ -86,6 +108,33 @@ func New(filename string, accessMode int, options ...Option) (*GPFile, error) {
 	return g, nil
 }
 
+// NewReader returns a read-only GPFile whose header and block data are provided by the
+// given readers (e.g. sections of an archive) instead of the file system
+func NewReader(filename string, header io.Reader, data io.ReaderAt, options ...Option) (*GPFile, error) {
+
+	g := &GPFile{
+		filename:           filename,
+		accessMode:         ModeRead,
+		defaultEncoderType: defaultEncoderType,
+		data:               data,
+	}
+
+	// apply functional options
+	for _, opt := range options {
+		opt(g)
+	}
+
+	var err error
+	if g.defaultEncoder, err = encoder.New(g.defaultEncoderType); err != nil {
+		return nil, err
+	}
+	if err = g.parseHeader(header); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
 // Blocks return the list of available blocks (and its metadata)
 func (g *GPFile) Blocks() (storage.BlockHeader, error) {
 	return g.header, nil
@@ -110,48 +159,52 @@ func (g *GPFile) ReadBlock(timestamp int64) ([]byte, error) {
 		return []byte{}, nil
 	}
 
-	// If the data file is not yet available, open it
-	if g.file == nil {
-		if err := g.open(g.accessMode); err != nil {
-			return nil, err
-		}
+	// Read the (decrypted) compressed data
+	blockData, err := g.readRawBlock(timestamp, block)
+	if err != nil {
+		return nil, err
 	}
 
 	// Instantiate decoder / decompressor
 	if block.EncoderType != g.defaultEncoder.Type() {
 		decoder, err := encoder.New(block.EncoderType)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to decode block %d based on detected encoder type %v: %s", block, block.EncoderType, err)
+			return nil, fmt.Errorf("Failed to decode block %d based on detected encoder type %v: %s", timestamp, block.EncoderType, err)
 		}
 		g.defaultEncoder = decoder
 	}
 
-	// if the file is read continuously, do not seek
-	var (
-		seekPos = block.Offset
-		err     error
-	)
-	if seekPos != g.lastSeekPos {
-		if g.lastSeekPos, err = g.file.Seek(seekPos, 0); err != nil {
-			return nil, err
-		}
+	// Perform decompression of data and store in output slice. The compressed data is
+	// already in memory, so it is "read" onto itself (unless it refers to the read-only
+	// mapping of the data file, in which case it is copied to a pooled buffer)
+	var in, uncompData []byte
+	if g.mmap {
+		in, uncompData = getBuffer(len(blockData)), getBuffer(block.RawLen)
+		defer putBuffer(in)
+	} else {
+		in, uncompData = blockData, make([]byte, block.RawLen)
 	}
-
-	// Perform decompression of data and store in output slice
-	blockData := make([]byte, block.Len)
-	uncompData := make([]byte, block.RawLen)
-	nRead, err := g.defaultEncoder.Decompress(blockData, uncompData, g.file)
+	nRead, err := g.defaultEncoder.Decompress(in, uncompData, bytes.NewReader(blockData))
 	if err != nil {
+		g.ReleaseBlock(uncompData)
 		return nil, err
 	}
 	if nRead != block.RawLen {
+		g.ReleaseBlock(uncompData)
 		return nil, fmt.Errorf("Unexpected amount of bytes after decompression, want %d, have %d", block.Len, nRead)
 	}
-	g.lastSeekPos += int64(block.Len)
 
 	return uncompData, nil
 }
 
+// ReleaseBlock returns the buffer of a block read in mmap mode to the pool. The data must
+// not be used anymore afterwards
+func (g *GPFile) ReleaseBlock(data []byte) {
+	if g.mmap {
+		putBuffer(data)
+	}
+}
+
 // WriteBlock writes data for a given timestamp to the file
 func (g *GPFile) WriteBlock(timestamp int64, blockData []byte) error {
 
@@ -160,45 +213,42 @@ func (g *GPFile) WriteBlock(timestamp int64, blockData []byte) error {
 		return fmt.Errorf("Cannot write to GPFile in read mode")
 	}
 
-	// If block data is empty, do nothing except updating the header
-	if len(blockData) == 0 {
-		g.header.Blocks[timestamp] = storage.Block{
-			Offset:      g.header.CurrentOffset,
-			EncoderType: g.defaultEncoderType,
-		}
-		return g.writeHeader()
-	}
-
-	// If the data file is not yet available, open it
-	if g.file == nil {
-		if err := g.open(g.accessMode); err != nil {
+	// Compress block data (if any)
+	var compressed bytes.Buffer
+	if len(blockData) > 0 {
+		if _, err := g.defaultEncoder.Compress(blockData, &compressed); err != nil {
 			return err
 		}
 	}
 
-	// Compress + write block data to file (append)
-	nWritten, err := g.defaultEncoder.Compress(blockData, g.fileBuffer)
-	if err != nil {
-		return err
-	}
-	if err = g.fileBuffer.Flush(); err != nil {
-		return err
+	// The offsets of the blocks are derived from their order in the header, hence blocks
+	// arriving late (or replacing a block with the same timestamp) cannot be appended
+	if g.hasBlocksFrom(timestamp) {
+		return g.insertBlock(timestamp, len(blockData), compressed.Bytes())
 	}
 
-	// Update and write header data
-	g.header.Blocks[timestamp] = storage.Block{
-		Offset:      g.header.CurrentOffset,
-		Len:         nWritten,
-		RawLen:      len(blockData),
-		EncoderType: g.defaultEncoderType,
+	if err := g.writeRawBlock(timestamp, g.defaultEncoderType, len(blockData), compressed.Bytes()); err != nil {
+		return err
 	}
-	g.header.CurrentOffset += int64(nWritten)
 
 	return g.writeHeader()
 }
 
+// hasBlocksFrom returns true if the file contains a block written at or after timestamp
+func (g *GPFile) hasBlocksFrom(timestamp int64) bool {
+	for ts := range g.header.Blocks {
+		if ts >= timestamp {
+			return true
+		}
+	}
+	return false
+}
+
 // Close closes the file
 func (g *GPFile) Close() error {
+	if err := g.unmapData(); err != nil {
+		return err
+	}
 	if g.file != nil {
 		return g.file.Close()
 	}
@@ -227,51 +277,144 @@ func (g *GPFile) open(flags int) (err error) {
 	return
 }
 
-func (g *GPFile) readHeader() error {
+// readRawBlock reads the compressed data of a (non-empty) block, decrypting it if required
+func (g *GPFile) readRawBlock(timestamp int64, block storage.Block) ([]byte, error) {
+	blockData, err := g.readStoredBlock(block)
+	if err != nil {
+		return nil, err
+	}
 
-	// Check if a header file exists for this file and open the file for buffered
-	// reading
-	gpfHeaderFile := g.filename + HeaderFileSuffix
-	gpfHeader, err := os.OpenFile(gpfHeaderFile, os.O_RDONLY, defaultPermissions)
-	if err == nil {
+	if block.KeyID == "" {
+		return blockData, nil
+	}
 
-		g.header = storage.BlockHeader{
-			Blocks: make(map[int64]storage.Block),
+	key, err := g.keyring.Key(block.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return key.Open(blockData, blockAdditionalData(timestamp))
+}
+
+// readStoredBlock reads the data of a (non-empty) block as it is stored
+func (g *GPFile) readStoredBlock(block storage.Block) ([]byte, error) {
+	if g.mmap && g.accessMode == ModeRead && g.data == nil {
+		return g.mappedBlock(block.Offset, block.Len)
+	}
+
+	blockData := make([]byte, block.Len)
+	if g.data != nil {
+		if _, err := g.data.ReadAt(blockData, block.Offset); err != nil {
+			return nil, err
 		}
-		buffer := bufio.NewReader(gpfHeader)
-		scanner := bufio.NewScanner(buffer)
-
-		// Read the global header information and all individual blocks
-		var (
-			ts          int64
-			curOffset   int
-			block       storage.Block
-			encoderType encoders.Type
-		)
-		scanner.Scan()
-		_, err := fmt.Sscanf(scanner.Text(), "v%d,%d,%d", &g.header.Version, &g.header.CurrentOffset, &encoderType)
-		if err != nil {
+		return blockData, nil
+	}
+
+	// If the data file is not yet available, open it
+	if g.file == nil {
+		if err := g.open(g.accessMode); err != nil {
+			return nil, err
+		}
+	}
+
+	// if the file is read continuously, do not seek
+	var (
+		seekPos = block.Offset
+		err     error
+	)
+	if seekPos != g.lastSeekPos {
+		if g.lastSeekPos, err = g.file.Seek(seekPos, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = io.ReadFull(g.file, blockData); err != nil {
+		return nil, err
+	}
+	g.lastSeekPos += int64(block.Len)
+
+	return blockData, nil
+}
+
+// writeRawBlock appends the compressed data of a block to the file, encrypting it with the
+// primary key of the keyring (if any). Only the header data held in memory is updated
+func (g *GPFile) writeRawBlock(timestamp int64, encoderType encoders.Type, rawLen int, data []byte) error {
+	return g.writeRawBlockWithKey(timestamp, encoderType, rawLen, data, g.keyring.Primary())
+}
+
+// writeRawBlockWithKey appends the compressed data of a block to the file, encrypting it
+// with key (unless nil)
+func (g *GPFile) writeRawBlockWithKey(timestamp int64, encoderType encoders.Type, rawLen int, data []byte, key *encryption.Key) error {
+
+	// If block data is empty, do nothing except updating the header
+	if len(data) == 0 {
+		g.header.Blocks[timestamp] = storage.Block{
+			Offset:      g.header.CurrentOffset,
+			EncoderType: encoderType,
+		}
+		return nil
+	}
+
+	var (
+		keyID string
+		err   error
+	)
+	if key != nil {
+		if data, err = key.Seal(data, blockAdditionalData(timestamp)); err != nil {
 			return err
 		}
-		for scanner.Scan() {
-			line := scanner.Text()
-			if strings.Count(line, ",") == 2 {
-				if _, err := fmt.Sscanf(scanner.Text(), "%d,%d,%d", &ts, &block.Len, &block.RawLen); err != nil {
-					return err
-				}
-				block.EncoderType = encoderType
-			} else {
-				if _, err := fmt.Sscanf(scanner.Text(), "%d,%d,%d,%d", &ts, &block.Len, &block.RawLen, &block.EncoderType); err != nil {
-					return err
-				}
-			}
+		keyID = key.ID()
+	}
 
-			block.Offset = int64(curOffset)
-			curOffset += block.Len
-			g.header.Blocks[ts] = block
+	return g.appendStoredBlock(timestamp, storage.Block{
+		RawLen:      rawLen,
+		EncoderType: encoderType,
+		KeyID:       keyID,
+	}, data)
+}
+
+// appendStoredBlock appends the data of a (non-empty) block as it is to be stored. The
+// offset and length of the block are set accordingly
+func (g *GPFile) appendStoredBlock(timestamp int64, block storage.Block, data []byte) (err error) {
+
+	// If the data file is not yet available, open it
+	if g.file == nil {
+		if err := g.open(g.accessMode); err != nil {
+			return err
 		}
+	}
+
+	// Write block data to file (append)
+	if _, err = g.fileBuffer.Write(data); err != nil {
+		return err
+	}
+	if err = g.fileBuffer.Flush(); err != nil {
+		return err
+	}
+
+	block.Offset, block.Len = g.header.CurrentOffset, len(data)
+	g.header.Blocks[timestamp] = block
+	g.header.CurrentOffset += int64(len(data))
+
+	return nil
+}
 
-		return scanner.Err()
+// blockAdditionalData binds the encrypted data of a block to its timestamp, such that
+// blocks cannot be swapped undetected
+func blockAdditionalData(timestamp int64) []byte {
+	var ad [8]byte
+	binary.BigEndian.PutUint64(ad[:], uint64(timestamp))
+	return ad[:]
+}
+
+func (g *GPFile) readHeader() error {
+
+	// Check if a header file exists for this file and open the file for buffered
+	// reading
+	gpfHeaderFile := g.filename + HeaderFileSuffix
+	gpfHeader, err := os.OpenFile(gpfHeaderFile, os.O_RDONLY, defaultPermissions)
+	if err == nil {
+		defer gpfHeader.Close()
+		return g.parseHeader(gpfHeader)
 	}
 
 	// If the file doesn't exist, do nothing, otherwise throw the encountered error
@@ -288,7 +431,68 @@ func (g *GPFile) readHeader() error {
 	// Initialize a new header
 	g.header = storage.BlockHeader{
 		Blocks:  make(map[int64]storage.Block),
-		Version: headerVersion,
+		Version: HeaderVersion,
+	}
+
+	return nil
+}
+
+func (g *GPFile) parseHeader(gpfHeader io.Reader) error {
+	g.header = storage.BlockHeader{
+		Blocks: make(map[int64]storage.Block),
+	}
+	buffer := bufio.NewReader(gpfHeader)
+	scanner := bufio.NewScanner(buffer)
+
+	// Read the global header information and all individual blocks
+	var (
+		ts          int64
+		curOffset   int
+		block       storage.Block
+		encoderType encoders.Type
+	)
+	scanner.Scan()
+	_, err := fmt.Sscanf(scanner.Text(), "v%d,%d,%d", &g.header.Version, &g.header.CurrentOffset, &encoderType)
+	if err != nil {
+		return err
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		block.KeyID = ""
+		switch strings.Count(line, ",") {
+		case 2:
+			if _, err := fmt.Sscanf(scanner.Text(), "%d,%d,%d", &ts, &block.Len, &block.RawLen); err != nil {
+				return err
+			}
+			block.EncoderType = encoderType
+		case 3:
+			if _, err := fmt.Sscanf(scanner.Text(), "%d,%d,%d,%d", &ts, &block.Len, &block.RawLen, &block.EncoderType); err != nil {
+				return err
+			}
+		default:
+			if _, err := fmt.Sscanf(scanner.Text(), "%d,%d,%d,%d,%s", &ts, &block.Len, &block.RawLen, &block.EncoderType, &block.KeyID); err != nil {
+				return err
+			}
+		}
+
+		block.Offset = int64(curOffset)
+		curOffset += block.Len
+		g.header.Blocks[ts] = block
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Fail early if encrypted blocks cannot be read due to a missing key
+	if g.accessMode == ModeRead && !g.skipKeyCheck {
+		for ts, block := range g.header.Blocks {
+			if block.KeyID == "" || block.RawLen == 0 {
+				continue
+			}
+			if _, err := g.keyring.Key(block.KeyID); err != nil {
+				return fmt.Errorf("Cannot decrypt block %d: %w", ts, err)
+			}
+		}
 	}
 
 	return nil
@@ -311,7 +515,11 @@ func (g *GPFile) writeHeader() error {
 		return err
 	}
 	for _, block := range g.header.OrderedList() {
-		if block.EncoderType != g.defaultEncoderType {
+		if block.KeyID != "" {
+			if _, err := fmt.Fprintf(buffer, "%d,%d,%d,%d,%s\n", block.Timestamp, block.Len, block.RawLen, block.EncoderType, block.KeyID); err != nil {
+				return err
+			}
+		} else if block.EncoderType != g.defaultEncoderType {
 			if _, err := fmt.Fprintf(buffer, "%d,%d,%d,%d\n", block.Timestamp, block.Len, block.RawLen, block.EncoderType); err != nil {
 				return err
 			}
@@ -3,6 +3,7 @@ package gpfile
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
 )
 
 const (
@@ -193,9 +195,376 @@ func (g *GPFile) validateBlocks(nExpected int) error {
 	if len(blocks.OrderedList()) != nExpected {
 		return fmt.Errorf("Unexpected number of ordered block list, want %d, have %d", nExpected, len(blocks.OrderedList()))
 	}
-	if blocks.Version != headerVersion {
-		return fmt.Errorf("Unexpected header version, want %d, have %d", headerVersion, blocks.Version)
+	if blocks.Version != HeaderVersion {
+		return fmt.Errorf("Unexpected header version, want %d, have %d", HeaderVersion, blocks.Version)
 	}
 
 	return nil
 }
+
+func writeTestBlocks(t *testing.T, keyring *encryption.Keyring, n int) {
+	gpf, err := New(testFilePath, ModeWrite, WithKeyring(keyring))
+	if err != nil {
+		t.Fatalf("Failed to create new GPFile: %s", err)
+	}
+	for i := 0; i < n; i++ {
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint64(data, uint64(i))
+		if err := gpf.WriteBlock(int64(i), data); err != nil {
+			t.Fatalf("Failed to write block: %s", err)
+		}
+	}
+	if err := gpf.Close(); err != nil {
+		t.Fatalf("Failed to close test file: %s", err)
+	}
+}
+
+func checkTestBlocks(t *testing.T, keyring *encryption.Keyring, n int, keyID string) {
+	t.Helper()
+	gpf, err := New(testFilePath, ModeRead, WithKeyring(keyring))
+	if err != nil {
+		t.Fatalf("Failed to read GPFile: %s", err)
+	}
+	defer gpf.Close()
+
+	for i := 0; i < n; i++ {
+		if block := gpf.header.Blocks[int64(i)]; block.KeyID != keyID {
+			t.Fatalf("Unexpected key ID of block %d: want %q, have %q", i, keyID, block.KeyID)
+		}
+		data, err := gpf.ReadBlock(int64(i))
+		if err != nil {
+			t.Fatalf("Failed to read block %d: %s", i, err)
+		}
+		if binary.BigEndian.Uint64(data) != uint64(i) {
+			t.Fatalf("Unexpected data in block %d: %x", i, data)
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	key, _, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	keyring := encryption.NewKeyring(key)
+
+	writeTestBlocks(t, keyring, 10)
+	defer os.Remove(testFilePath)
+	defer os.Remove(testFilePath + HeaderFileSuffix)
+
+	// the plaintext must not be stored in the file
+	data, err := ioutil.ReadFile(testFilePath)
+	if err != nil {
+		t.Fatalf("Failed to read test file: %s", err)
+	}
+	if bytes.Contains(data, []byte{0, 0, 0, 0, 0, 0, 0, 7}) {
+		t.Fatalf("Found plaintext in encrypted file")
+	}
+
+	checkTestBlocks(t, keyring, 10, key.ID())
+
+	// reading without the key has to fail upon opening the file
+	_, err = New(testFilePath, ModeRead)
+	var missingKeyErr *encryption.MissingKeyError
+	if !errors.As(err, &missingKeyErr) {
+		t.Fatalf("Expected missing key error, got %v", err)
+	}
+}
+
+func TestWriteLateBlock(t *testing.T) {
+	key, _, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	var tests = []struct {
+		name    string
+		keyring *encryption.Keyring
+	}{
+		{"unencrypted", nil},
+		{"encrypted", encryption.NewKeyring(key)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer os.Remove(testFilePath)
+			defer os.Remove(testFilePath + HeaderFileSuffix)
+
+			gpf, err := New(testFilePath, ModeWrite, WithKeyring(test.keyring))
+			if err != nil {
+				t.Fatalf("Failed to create new GPFile: %s", err)
+			}
+
+			// blocks 2 and 4 arrive late, block 3 is written twice and block 0 is empty
+			want := map[int64][]byte{
+				0: {},
+				1: bytes.Repeat([]byte{1}, 100),
+				2: bytes.Repeat([]byte{2}, 200),
+				3: bytes.Repeat([]byte{4}, 50),
+				4: bytes.Repeat([]byte{5}, 300),
+				5: bytes.Repeat([]byte{6}, 10),
+			}
+			for _, block := range []struct {
+				timestamp int64
+				data      []byte
+			}{
+				{0, want[0]}, {1, want[1]}, {3, bytes.Repeat([]byte{3}, 400)}, {5, want[5]}, {2, want[2]}, {3, want[3]}, {4, want[4]},
+			} {
+				if err := gpf.WriteBlock(block.timestamp, block.data); err != nil {
+					t.Fatalf("Failed to write block %d: %s", block.timestamp, err)
+				}
+			}
+			if err := gpf.Close(); err != nil {
+				t.Fatalf("Failed to close test file: %s", err)
+			}
+
+			gpf, err = New(testFilePath, ModeRead, WithKeyring(test.keyring))
+			if err != nil {
+				t.Fatalf("Failed to read GPFile: %s", err)
+			}
+			defer gpf.Close()
+			if len(gpf.header.Blocks) != len(want) {
+				t.Fatalf("Unexpected number of blocks: %d", len(gpf.header.Blocks))
+			}
+			for ts, data := range want {
+				have, err := gpf.ReadBlock(ts)
+				if err != nil {
+					t.Fatalf("Failed to read block %d: %s", ts, err)
+				}
+				if !bytes.Equal(have, data) {
+					t.Fatalf("Unexpected data of block %d", ts)
+				}
+			}
+
+			// no temporary files are left behind
+			if _, err := os.Stat(testFilePath + rewriteSuffix); !os.IsNotExist(err) {
+				t.Fatalf("Temporary file left behind")
+			}
+		})
+	}
+}
+
+func TestMmap(t *testing.T) {
+	key, _, err := encryption.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	var tests = []struct {
+		name    string
+		keyring *encryption.Keyring
+	}{
+		{"unencrypted", nil},
+		{"encrypted", encryption.NewKeyring(key)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeTestBlocks(t, test.keyring, 10)
+			defer os.Remove(testFilePath)
+			defer os.Remove(testFilePath + HeaderFileSuffix)
+
+			gpf, err := New(testFilePath, ModeRead, WithKeyring(test.keyring), WithMmap())
+			if err != nil {
+				t.Fatalf("Failed to read GPFile: %s", err)
+			}
+			defer gpf.Close()
+
+			// read the blocks twice, so that released buffers are reused
+			for round := 0; round < 2; round++ {
+				for i := 0; i < 10; i++ {
+					data, err := gpf.ReadBlock(int64(i))
+					if err != nil {
+						t.Fatalf("Failed to read block %d: %s", i, err)
+					}
+					if len(data) != 8 || binary.BigEndian.Uint64(data) != uint64(i) {
+						t.Fatalf("Unexpected data of block %d: %v", i, data)
+					}
+					gpf.ReleaseBlock(data)
+				}
+			}
+		})
+	}
+
+	// blocks exceeding the mapped file are detected
+	writeTestBlocks(t, nil, 10)
+	defer os.Remove(testFilePath)
+	defer os.Remove(testFilePath + HeaderFileSuffix)
+	info, err := os.Stat(testFilePath)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %s", err)
+	}
+	if err = os.Truncate(testFilePath, info.Size()-1); err != nil {
+		t.Fatalf("Failed to truncate file: %s", err)
+	}
+	gpf, err := New(testFilePath, ModeRead, WithMmap())
+	if err != nil {
+		t.Fatalf("Failed to read GPFile: %s", err)
+	}
+	defer gpf.Close()
+	if _, err = gpf.ReadBlock(9); err == nil {
+		t.Fatalf("Reading truncated block succeeded unexpectedly")
+	}
+}
+
+func TestRekey(t *testing.T) {
+	oldKey, _, _ := encryption.GenerateKey()
+	newKey, _, _ := encryption.GenerateKey()
+
+	writeTestBlocks(t, encryption.NewKeyring(oldKey), 10)
+	defer os.Remove(testFilePath)
+	defer os.Remove(testFilePath + HeaderFileSuffix)
+
+	var tests = []struct {
+		name       string
+		keyring    *encryption.Keyring
+		numRekeyed int
+		keyID      string
+	}{
+		{"rotate", encryption.NewKeyring(newKey, oldKey), 10, newKey.ID()},
+		{"up to date", encryption.NewKeyring(newKey), 0, newKey.ID()},
+		{"decrypt", encryption.NewKeyring(nil, newKey), 10, ""},
+		{"encrypt", encryption.NewKeyring(oldKey), 10, oldKey.ID()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			numRekeyed, err := Rekey(testFilePath, test.keyring)
+			if err != nil {
+				t.Fatalf("Failed to rekey file: %s", err)
+			}
+			if numRekeyed != test.numRekeyed {
+				t.Fatalf("Unexpected number of rekeyed blocks: want %d, have %d", test.numRekeyed, numRekeyed)
+			}
+			checkTestBlocks(t, test.keyring, 10, test.keyID)
+		})
+	}
+
+	// rekeying without the current key has to fail and leave the file intact
+	if _, err := Rekey(testFilePath, encryption.NewKeyring(newKey)); err == nil {
+		t.Fatalf("Expected an error rekeying without the current key, got none")
+	}
+	checkTestBlocks(t, encryption.NewKeyring(oldKey), 10, oldKey.ID())
+}
+
+func TestRecoverRewrite(t *testing.T) {
+	writeTestBlocks(t, nil, 3)
+	defer os.Remove(testFilePath)
+	defer os.Remove(testFilePath + HeaderFileSuffix)
+
+	tmpName := testFilePath + rewriteSuffix
+
+	// an incomplete rewrite (no header yet) is discarded
+	if err := ioutil.WriteFile(tmpName, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("Failed to write temporary file: %s", err)
+	}
+	if err := recoverRewrite(testFilePath); err != nil {
+		t.Fatalf("Failed to recover rewrite: %s", err)
+	}
+	if _, err := os.Stat(tmpName); !os.IsNotExist(err) {
+		t.Fatalf("Expected incomplete rewrite to be discarded")
+	}
+	checkTestBlocks(t, nil, 3, "")
+
+	// a complete rewrite interrupted after the data file has been replaced is finished
+	oldHeader, err := ioutil.ReadFile(testFilePath + HeaderFileSuffix)
+	if err != nil {
+		t.Fatalf("Failed to read header: %s", err)
+	}
+	key, _, _ := encryption.GenerateKey()
+	keyring := encryption.NewKeyring(key)
+	os.Remove(testFilePath)
+	os.Remove(testFilePath + HeaderFileSuffix)
+	writeTestBlocks(t, keyring, 3)
+	if err := os.Rename(testFilePath+HeaderFileSuffix, tmpName+HeaderFileSuffix); err != nil {
+		t.Fatalf("Failed to move header: %s", err)
+	}
+	if err := ioutil.WriteFile(testFilePath+HeaderFileSuffix, oldHeader, 0644); err != nil {
+		t.Fatalf("Failed to write header: %s", err)
+	}
+
+	if err := recoverRewrite(testFilePath); err != nil {
+		t.Fatalf("Failed to recover rewrite: %s", err)
+	}
+	if _, err := os.Stat(tmpName + HeaderFileSuffix); !os.IsNotExist(err) {
+		t.Fatalf("Expected temporary header to be moved")
+	}
+	checkTestBlocks(t, keyring, 3, key.ID())
+}
+
+func TestRecompress(t *testing.T) {
+	key, _, _ := encryption.GenerateKey()
+
+	var tests = []struct {
+		name    string
+		encoder encoders.Type
+		keyring *encryption.Keyring
+	}{
+		{"lz4", encoders.EncoderTypeLZ4, nil},
+		{"column codec", encoders.Chain(encoders.CodecVarint, 8, encoders.EncoderTypeLZ4), nil},
+		{"encrypted", encoders.EncoderTypeLZ4, encryption.NewKeyring(key)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gpf, err := New(testFilePath, ModeWrite, WithEncoder(test.encoder), WithKeyring(test.keyring))
+			if err != nil {
+				t.Fatalf("Failed to create new GPFile: %s", err)
+			}
+			defer gpf.Delete()
+			for i := 0; i < 100; i++ {
+				data := make([]byte, 8*(i%10))
+				for j := 0; j < len(data); j += 8 {
+					binary.BigEndian.PutUint64(data[j:], uint64(i*j))
+				}
+				if err := gpf.WriteBlock(int64(i), data); err != nil {
+					t.Fatalf("Failed to write block: %s", err)
+				}
+			}
+			if err := gpf.Close(); err != nil {
+				t.Fatalf("Failed to close test file: %s", err)
+			}
+
+			estimate, err := Recompress(testFilePath, encoders.EncoderTypeZSTD, test.keyring, true)
+			if err != nil {
+				t.Fatalf("Failed to estimate recompression: %s", err)
+			}
+			result, err := Recompress(testFilePath, encoders.EncoderTypeZSTD, test.keyring, false)
+			if err != nil {
+				t.Fatalf("Failed to recompress file: %s", err)
+			}
+			if result.NumBlocks != 90 || estimate != result {
+				t.Fatalf("Unexpected recompression result: estimated %+v, have %+v", estimate, result)
+			}
+
+			gpf, err = New(testFilePath, ModeRead, WithKeyring(test.keyring))
+			if err != nil {
+				t.Fatalf("Failed to read GPFile: %s", err)
+			}
+			for i := 0; i < 100; i++ {
+				block := gpf.header.Blocks[int64(i)]
+				if want := encoders.Chain(test.encoder.Codec(), test.encoder.Width(), encoders.EncoderTypeZSTD); i%10 != 0 && block.EncoderType != want {
+					t.Fatalf("Unexpected encoder of block %d: want %v, have %v", i, want, block.EncoderType)
+				}
+				if test.keyring != nil && i%10 != 0 && block.KeyID != key.ID() {
+					t.Fatalf("Block %d is no longer encrypted", i)
+				}
+
+				data, err := gpf.ReadBlock(int64(i))
+				if err != nil {
+					t.Fatalf("Failed to read block %d: %s", i, err)
+				}
+				if len(data) != 8*(i%10) {
+					t.Fatalf("Unexpected length of block %d: %d", i, len(data))
+				}
+				for j := 0; j < len(data); j += 8 {
+					if binary.BigEndian.Uint64(data[j:]) != uint64(i*j) {
+						t.Fatalf("Unexpected data in block %d: %x", i, data)
+					}
+				}
+			}
+			gpf.Close()
+
+			// all blocks are up to date now
+			if result, err = Recompress(testFilePath, encoders.EncoderTypeZSTD, test.keyring, false); err != nil || result.NumBlocks != 0 {
+				t.Fatalf("Unexpected result of repeated recompression: %+v, %v", result, err)
+			}
+		})
+	}
+}
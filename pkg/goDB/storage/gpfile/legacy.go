@@ -0,0 +1,267 @@
+package gpfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/lz4"
+)
+
+const (
+	// LegacyVersion denotes the format version of GPFiles predating the header file
+	LegacyVersion = 0
+
+	// legacyBufSize allocates space for each part of the legacy header (512 slots for
+	// 64bit integers)
+	legacyBufSize = 4096
+
+	// legacyNumElements is the number of available legacy header slots
+	legacyNumElements = legacyBufSize / 8
+
+	// legacyBlockPadding denotes the size of the prefix / suffix surrounding each legacy
+	// block
+	legacyBlockPadding = 8
+)
+
+// LegacyResult summarizes the conversion of a legacy GPFile
+type LegacyResult struct {
+	NumBlocks int
+
+	// SkippedBlocks lists the timestamps of corrupt blocks which could not be converted
+	SkippedBlocks []int64
+}
+
+// ConvertLegacy converts the legacy GPFile located at filename (which stores its block
+// index at the beginning of the data file) to the current format. Files which already
+// have a header are left untouched, so that an interrupted conversion of a directory can
+// be resumed. In dry-run mode, the file is converted to a temporary file which is
+// discarded afterwards
+func ConvertLegacy(filename string, dryRun bool, options ...Option) (result LegacyResult, err error) {
+	if err = recoverRewrite(filename); err != nil {
+		return result, err
+	}
+	if _, err = os.Stat(filename + HeaderFileSuffix); err == nil {
+		return result, nil
+	} else if !os.IsNotExist(err) {
+		return result, err
+	}
+
+	legacy, err := newLegacyFile(filename)
+	if err != nil {
+		return result, err
+	}
+	defer legacy.Close()
+
+	tmpName := filename + rewriteSuffix
+	dst, err := New(tmpName, ModeWrite, options...)
+	if err != nil {
+		return result, err
+	}
+	defer func() {
+		if err != nil || dryRun {
+			dst.Close()
+			removeIfExists(tmpName)
+			removeIfExists(tmpName + HeaderFileSuffix)
+		}
+	}()
+
+	for i, ts := range legacy.timestamps {
+		if ts == 0 {
+			continue
+		}
+		block, err := legacy.readBlock(i)
+		if err == errLegacyBlockCorrupt {
+			result.SkippedBlocks = append(result.SkippedBlocks, ts)
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("Could not read legacy block %d of %s: %s", ts, filename, err)
+		}
+
+		// cut off the now unnecessary block prefix / suffix
+		if len(block) >= 2*legacyBlockPadding {
+			block = block[legacyBlockPadding : len(block)-legacyBlockPadding]
+		}
+
+		var compressed bytes.Buffer
+		if len(block) > 0 {
+			if _, err = dst.defaultEncoder.Compress(block, &compressed); err != nil {
+				return result, err
+			}
+		}
+		if err = dst.writeRawBlock(ts, dst.defaultEncoderType, len(block), compressed.Bytes()); err != nil {
+			return result, err
+		}
+		result.NumBlocks++
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, commit(dst, filename)
+}
+
+// Version returns the format version of the GPFile located at filename
+func Version(filename string) (int, error) {
+	f, err := os.Open(filename + HeaderFileSuffix)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return 0, err
+		}
+
+		// a data file without header stems from the legacy format
+		if _, err := os.Stat(filename); err != nil {
+			return 0, err
+		}
+		return LegacyVersion, nil
+	}
+	defer f.Close()
+
+	var version int
+	if _, err = fmt.Fscanf(f, "v%d,", &version); err != nil {
+		return 0, fmt.Errorf("Invalid header of %s: %s", filename, err)
+	}
+	return version, nil
+}
+
+// Verify checks that the header of the GPFile located at filename can be parsed and that
+// all of its blocks can be read. Encrypted blocks are decrypted and decompressed only if
+// their key is provided
+func Verify(filename string, options ...Option) error {
+	g, err := New(filename, ModeRead, append(options, withoutKeyCheck())...)
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+
+	for _, block := range g.header.OrderedList() {
+		if block.RawLen == 0 {
+			continue
+		}
+		if _, err := g.keyring.Key(block.KeyID); block.KeyID != "" && err != nil {
+			if _, err = g.readStoredBlock(block.Block); err != nil {
+				return fmt.Errorf("Could not read block %d of %s: %s", block.Timestamp, filename, err)
+			}
+			continue
+		}
+		if _, err = g.ReadBlock(block.Timestamp); err != nil {
+			return fmt.Errorf("Could not read block %d of %s: %s", block.Timestamp, filename, err)
+		}
+	}
+	return nil
+}
+
+var errLegacyBlockCorrupt = errors.New("Corrupt legacy block")
+
+// legacyFile provides read access to GPFiles stored in the legacy format
+type legacyFile struct {
+
+	// The file header contains 512 64 bit addresses pointing to the end (+1 byte) of
+	// each compressed block, the lookup table which stores 512 timestamps and the raw
+	// lengths of the blocks
+	blocks     []int64
+	timestamps []int64
+	lengths    []int64
+
+	filename    string
+	file        *os.File
+	lastSeekPos int64
+
+	// legacy blocks are always compressed with LZ4
+	encoder encoder.Encoder
+}
+
+func newLegacyFile(filename string) (*legacyFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &legacyFile{
+		filename: filename,
+		file:     f,
+		encoder:  lz4.New(),
+	}
+	for _, part := range []struct {
+		name   string
+		values *[]int64
+	}{
+		{"blocks", &l.blocks},
+		{"lookup table", &l.timestamps},
+		{"block lengths", &l.lengths},
+	} {
+		buf := make([]byte, legacyBufSize)
+		if n, err := f.Read(buf); err != nil || n != legacyBufSize {
+			f.Close()
+			return nil, fmt.Errorf("Invalid legacy header (%s) of %s", part.name, filename)
+		}
+
+		values := make([]int64, legacyNumElements)
+		for i := range values {
+			pos := i * 8
+			values[i] = int64(buf[pos])<<56 | int64(buf[pos+1])<<48 | int64(buf[pos+2])<<40 | int64(buf[pos+3])<<32 | int64(buf[pos+4])<<24 | int64(buf[pos+5])<<16 | int64(buf[pos+6])<<8 | int64(buf[pos+7])
+		}
+		*part.values = values
+	}
+	l.lastSeekPos = 3 * legacyBufSize
+
+	return l, nil
+}
+
+// readBlock returns the data of the block in the given slot
+func (l *legacyFile) readBlock(slot int) ([]byte, error) {
+	if l.timestamps[slot] == 0 && l.blocks[slot] == 0 && l.lengths[slot] == 0 {
+		return nil, errors.New("Block " + strconv.Itoa(slot) + " is empty")
+	}
+
+	// the first block starts right after the header, all others at the end of the
+	// previous one
+	var seekPos int64 = 3 * legacyBufSize
+	if slot != 0 {
+		seekPos = l.blocks[slot-1]
+	}
+	readLen := l.blocks[slot] - seekPos
+	if readLen == 0 || l.lengths[slot] == 0 {
+		return []byte{}, nil
+	}
+	if readLen < 0 || l.lengths[slot] < 0 {
+		return nil, errLegacyBlockCorrupt
+	}
+
+	// if the file is read continuously, do not seek
+	if seekPos != l.lastSeekPos {
+		var err error
+		if l.lastSeekPos, err = l.file.Seek(seekPos, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		bufComp = make([]byte, readLen)
+		buf     = make([]byte, l.lengths[slot])
+	)
+	n, err := l.encoder.Decompress(bufComp, buf, l.file)
+	l.lastSeekPos = -1
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "Invalid LZ4 data detected during decompression") {
+			return nil, errLegacyBlockCorrupt
+		}
+		return nil, err
+	}
+	if int64(n) != l.lengths[slot] {
+		return nil, errLegacyBlockCorrupt
+	}
+	l.lastSeekPos = seekPos + readLen
+
+	return buf, nil
+}
+
+// Close closes the underlying file
+func (l *legacyFile) Close() error {
+	return l.file.Close()
+}
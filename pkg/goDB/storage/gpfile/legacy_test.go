@@ -0,0 +1,133 @@
+package gpfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/lz4"
+)
+
+// writeLegacyFile writes the blocks to a GPFile in the legacy format, i.e. preceded by
+// the block index and with each block being surrounded by padding
+func writeLegacyFile(t *testing.T, filename string, timestamps []int64, blocks [][]byte) {
+	t.Helper()
+
+	var (
+		header = make([]byte, 3*legacyBufSize)
+		data   bytes.Buffer
+		offset = int64(3 * legacyBufSize)
+	)
+	for i, block := range blocks {
+		padded := append(append(make([]byte, legacyBlockPadding), block...), make([]byte, legacyBlockPadding)...)
+		n, err := lz4.New().Compress(padded, &data)
+		if err != nil {
+			t.Fatalf("Failed to compress legacy block: %s", err)
+		}
+		offset += int64(n)
+
+		binary.BigEndian.PutUint64(header[i*8:], uint64(offset))
+		binary.BigEndian.PutUint64(header[legacyBufSize+i*8:], uint64(timestamps[i]))
+		binary.BigEndian.PutUint64(header[2*legacyBufSize+i*8:], uint64(len(padded)))
+	}
+
+	if err := ioutil.WriteFile(filename, append(header, data.Bytes()...), 0644); err != nil {
+		t.Fatalf("Failed to write legacy file: %s", err)
+	}
+}
+
+func TestConvertLegacy(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "legacy")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var (
+		filename   = filepath.Join(tmpDir, "sip.gpf")
+		timestamps = []int64{1456428600, 1456428900, 1456429200}
+		blocks     = [][]byte{
+			bytes.Repeat([]byte{1, 2, 3, 4}, 100),
+			{},
+			bytes.Repeat([]byte{5, 6, 7, 8}, 1000),
+		}
+	)
+	writeLegacyFile(t, filename, timestamps, blocks)
+	legacyData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read legacy file: %s", err)
+	}
+
+	if version, err := Version(filename); err != nil || version != LegacyVersion {
+		t.Fatalf("Unexpected version of legacy file: %d (%v)", version, err)
+	}
+
+	// a dry run doesn't touch the file
+	result, err := ConvertLegacy(filename, true)
+	if err != nil {
+		t.Fatalf("Failed to convert legacy file (dry run): %s", err)
+	}
+	if result.NumBlocks != len(blocks) || len(result.SkippedBlocks) != 0 {
+		t.Fatalf("Unexpected result of dry run: %+v", result)
+	}
+	if data, err := ioutil.ReadFile(filename); err != nil || !bytes.Equal(data, legacyData) {
+		t.Fatalf("Legacy file modified during dry run")
+	}
+	if entries, _ := ioutil.ReadDir(tmpDir); len(entries) != 1 {
+		t.Fatalf("Unexpected files left behind by dry run: %d", len(entries))
+	}
+
+	if _, err = ConvertLegacy(filename, false); err != nil {
+		t.Fatalf("Failed to convert legacy file: %s", err)
+	}
+	if version, err := Version(filename); err != nil || version != HeaderVersion {
+		t.Fatalf("Unexpected version of converted file: %d (%v)", version, err)
+	}
+	if err = Verify(filename); err != nil {
+		t.Fatalf("Failed to verify converted file: %s", err)
+	}
+
+	gpf, err := New(filename, ModeRead)
+	if err != nil {
+		t.Fatalf("Failed to open converted file: %s", err)
+	}
+	defer gpf.Close()
+	for i, ts := range timestamps {
+		data, err := gpf.ReadBlock(ts)
+		if err != nil {
+			t.Fatalf("Failed to read converted block %d: %s", ts, err)
+		}
+		if !bytes.Equal(data, blocks[i]) {
+			t.Fatalf("Unexpected data of converted block %d", ts)
+		}
+	}
+
+	// converting the file again is a no-op
+	if result, err = ConvertLegacy(filename, false); err != nil || result.NumBlocks != 0 {
+		t.Fatalf("Unexpected result of repeated conversion: %+v (%v)", result, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	writeTestBlocks(t, nil, 10)
+	defer os.Remove(testFilePath)
+	defer os.Remove(testFilePath + HeaderFileSuffix)
+
+	if err := Verify(testFilePath); err != nil {
+		t.Fatalf("Failed to verify file: %s", err)
+	}
+
+	info, err := os.Stat(testFilePath)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %s", err)
+	}
+	if err = os.Truncate(testFilePath, info.Size()-1); err != nil {
+		t.Fatalf("Failed to truncate file: %s", err)
+	}
+	if err = Verify(testFilePath); err == nil {
+		t.Fatalf("Verification of truncated file succeeded unexpectedly")
+	}
+}
@@ -0,0 +1,55 @@
+package gpfile
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// mapData memory-maps the data file (read-only), unless it has been mapped already
+func (g *GPFile) mapData() error {
+	if g.mapped != nil {
+		return nil
+	}
+
+	if g.file == nil {
+		if err := g.open(ModeRead); err != nil {
+			return err
+		}
+	}
+	info, err := g.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// empty files cannot be mapped
+	if info.Size() == 0 {
+		g.mapped = []byte{}
+		return nil
+	}
+	if g.mapped, err = syscall.Mmap(int(g.file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED); err != nil {
+		return fmt.Errorf("Could not map %s: %s", g.filename, err)
+	}
+	return nil
+}
+
+// mappedBlock returns the stored data of a block from the mapped data file. The data must
+// not be modified
+func (g *GPFile) mappedBlock(offset int64, length int) ([]byte, error) {
+	if err := g.mapData(); err != nil {
+		return nil, err
+	}
+	if offset < 0 || offset+int64(length) > int64(len(g.mapped)) {
+		return nil, fmt.Errorf("Block at offset %d (length %d) exceeds size of %s", offset, length, g.filename)
+	}
+	return g.mapped[offset : offset+int64(length)], nil
+}
+
+// unmapData releases the mapping of the data file (if any)
+func (g *GPFile) unmapData() error {
+	mapped := g.mapped
+	g.mapped = nil
+	if len(mapped) == 0 {
+		return nil
+	}
+	return syscall.Munmap(mapped)
+}
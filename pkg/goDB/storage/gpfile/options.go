@@ -1,6 +1,9 @@
 package gpfile
 
-import "github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+import (
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+)
 
 // Option defines optional arguments to gpfile
 type Option func(*GPFile)
@@ -13,3 +16,27 @@ func WithEncoder(e encoders.Type) Option {
 		return
 	}
 }
+
+// WithKeyring allows to set the keys used for decryption of blocks. If the keyring has
+// a primary key, newly written blocks are encrypted with it
+func WithKeyring(keyring *encryption.Keyring) Option {
+	return func(g *GPFile) {
+		g.keyring = keyring
+	}
+}
+
+// WithMmap memory-maps the data file of GPFiles opened in read mode instead of reading
+// blocks via seek / read calls, and decompresses blocks into pooled buffers. Blocks read
+// in this mode should be handed back via ReleaseBlock once they aren't referenced anymore
+func WithMmap() Option {
+	return func(g *GPFile) {
+		g.mmap = true
+	}
+}
+
+// withoutKeyCheck allows to open encrypted files without providing their keys
+func withoutKeyCheck() Option {
+	return func(g *GPFile) {
+		g.skipKeyCheck = true
+	}
+}
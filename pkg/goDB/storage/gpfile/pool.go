@@ -0,0 +1,36 @@
+package gpfile
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// maxPooledBufferBits denotes the size (as power of two) of the largest buffer which is
+// pooled. Larger buffers are allocated (and garbage collected) as usual
+const maxPooledBufferBits = 26
+
+// bufferPools holds pools of buffers, indexed by the power of two of their capacity
+var bufferPools [maxPooledBufferBits + 1]sync.Pool
+
+// getBuffer returns a buffer of length n, taken from the pool with the smallest capacity
+// sufficient for n bytes
+func getBuffer(n int) []byte {
+	class := bits.Len(uint(n - 1))
+	if n == 0 || class > maxPooledBufferBits {
+		return make([]byte, n)
+	}
+	if buf, ok := bufferPools[class].Get().(*[]byte); ok {
+		return (*buf)[:n]
+	}
+	return make([]byte, n, 1<<class)
+}
+
+// putBuffer returns a buffer obtained from getBuffer to its pool
+func putBuffer(buf []byte) {
+	class := bits.Len(uint(cap(buf) - 1))
+	if cap(buf) == 0 || class > maxPooledBufferBits || cap(buf) != 1<<class {
+		return
+	}
+	buf = buf[:0]
+	bufferPools[class].Put(&buf)
+}
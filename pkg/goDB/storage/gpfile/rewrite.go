@@ -0,0 +1,435 @@
+package gpfile
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+// rewriteSuffix denotes the suffix of the temporary file a GPFile is rewritten to
+const rewriteSuffix = ".rewrite"
+
+// rewrite rewrites all blocks of the GPFile located at filename by passing them to fn,
+// which is supposed to write them to dst. The rewritten file is assembled next to the
+// original and replaces it once complete. An interrupted replacement is completed upon
+// the next rewrite (see recoverRewrite)
+func rewrite(filename string, options []Option, fn func(src, dst *GPFile, block storage.BlockAtTime) error) (err error) {
+	if err = recoverRewrite(filename); err != nil {
+		return err
+	}
+
+	src, err := New(filename, ModeRead, options...)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpName := filename + rewriteSuffix
+	dst, err := New(tmpName, ModeWrite, options...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			removeIfExists(tmpName)
+			removeIfExists(tmpName + HeaderFileSuffix)
+		}
+	}()
+
+	for _, block := range src.header.OrderedList() {
+		if err = fn(src, dst, block); err != nil {
+			return fmt.Errorf("Could not rewrite block %d of %s: %s", block.Timestamp, filename, err)
+		}
+	}
+
+	return commit(dst, filename)
+}
+
+// Create creates the GPFile located at filename (replacing an existing one) with the
+// blocks passed to write by fn, which have to be passed in increasing order of their
+// timestamps. Like a rewrite, the file is assembled next to its destination and only
+// moved there once complete
+func Create(filename string, fn func(write func(timestamp int64, data []byte) error) error, options ...Option) (err error) {
+	if err = recoverRewrite(filename); err != nil {
+		return err
+	}
+
+	tmpName := filename + rewriteSuffix
+	dst, err := New(tmpName, ModeWrite, options...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			removeIfExists(tmpName)
+			removeIfExists(tmpName + HeaderFileSuffix)
+		}
+	}()
+
+	err = fn(func(timestamp int64, data []byte) error {
+		if dst.hasBlocksFrom(timestamp) {
+			return fmt.Errorf("Block %d of %s is out of order", timestamp, filename)
+		}
+
+		var compressed bytes.Buffer
+		if len(data) > 0 {
+			if _, err := dst.defaultEncoder.Compress(data, &compressed); err != nil {
+				return err
+			}
+		}
+		return dst.writeRawBlock(timestamp, dst.defaultEncoderType, len(data), compressed.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+
+	return commit(dst, filename)
+}
+
+// commit persists the temporary GPFile dst and moves it to filename
+func commit(dst *GPFile, filename string) error {
+
+	// make sure that the data is persisted before the header referencing it is written.
+	// The data file is created even if all blocks are empty, so that its absence after a
+	// crash unambiguously indicates that it has already been moved (see replace)
+	if dst.file == nil {
+		if err := dst.open(ModeWrite); err != nil {
+			return err
+		}
+	}
+	if err := dst.file.Sync(); err != nil {
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := dst.writeHeader(); err != nil {
+		return err
+	}
+
+	return replace(dst.filename, filename)
+}
+
+// replace moves the GPFile tmpName to filename. The data file is moved first: once it
+// has been replaced, the presence of the temporary header alone indicates that the
+// header still has to be moved
+func replace(tmpName, filename string) error {
+	if _, err := os.Stat(tmpName); err == nil {
+		if err := os.Rename(tmpName, filename); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Rename(tmpName+HeaderFileSuffix, filename+HeaderFileSuffix)
+}
+
+// recoverRewrite completes or discards an interrupted rewrite of the GPFile at filename
+func recoverRewrite(filename string) error {
+	tmpName := filename + rewriteSuffix
+
+	_, errHeader := os.Stat(tmpName + HeaderFileSuffix)
+	if os.IsNotExist(errHeader) {
+		// the rewrite was interrupted before completion, discard it
+		return removeIfExists(tmpName)
+	} else if errHeader != nil {
+		return errHeader
+	}
+
+	// the header is written last, so the rewritten file is complete
+	return replace(tmpName, filename)
+}
+
+// insertBlock writes the (compressed) data of a block which doesn't succeed all blocks of
+// the file by rewriting it with the block in place, replacing an existing block with the
+// same timestamp. All other blocks are copied as they are stored
+func (g *GPFile) insertBlock(timestamp int64, rawLen int, data []byte) error {
+	if err := g.Close(); err != nil {
+		return err
+	}
+	g.file = nil
+
+	var inserted bool
+	err := rewrite(g.filename, []Option{WithEncoder(g.defaultEncoderType), withoutKeyCheck()}, func(src, dst *GPFile, block storage.BlockAtTime) error {
+		if !inserted && block.Timestamp >= timestamp {
+			if err := dst.writeRawBlockWithKey(timestamp, g.defaultEncoderType, rawLen, data, g.keyring.Primary()); err != nil {
+				return err
+			}
+			inserted = true
+		}
+		if block.Timestamp == timestamp {
+			return nil
+		}
+		return dst.copyStoredBlock(src, block)
+	})
+	if err != nil {
+		return err
+	}
+
+	return g.readHeader()
+}
+
+// copyStoredBlock copies a block of src as it is stored, i.e. without decrypting it
+func (g *GPFile) copyStoredBlock(src *GPFile, block storage.BlockAtTime) error {
+	if block.Len == 0 {
+		g.header.Blocks[block.Timestamp] = storage.Block{
+			Offset:      g.header.CurrentOffset,
+			RawLen:      block.RawLen,
+			EncoderType: block.EncoderType,
+		}
+		return nil
+	}
+
+	data, err := src.readStoredBlock(block.Block)
+	if err != nil {
+		return err
+	}
+	return g.appendStoredBlock(block.Timestamp, block.Block, data)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Rekey re-encrypts all blocks of the GPFile located at filename with the primary key of
+// the keyring (or stores them unencrypted if it has none). The keyring has to provide all
+// keys the blocks are currently encrypted with. The compressed block data itself is left
+// untouched. Returns the number of blocks which were re-encrypted
+func Rekey(filename string, keyring *encryption.Keyring) (int, error) {
+	var primaryID string
+	if primary := keyring.Primary(); primary != nil {
+		primaryID = primary.ID()
+	}
+
+	// avoid rewriting files whose blocks are all encrypted with the primary key already
+	if err := recoverRewrite(filename); err != nil {
+		return 0, err
+	}
+	gpf, err := New(filename, ModeRead, WithKeyring(keyring))
+	if err != nil {
+		return 0, err
+	}
+	gpf.Close()
+
+	var upToDate = true
+	for _, block := range gpf.header.Blocks {
+		if block.RawLen > 0 && block.KeyID != primaryID {
+			upToDate = false
+			break
+		}
+	}
+	if upToDate {
+		return 0, nil
+	}
+
+	var numRekeyed int
+	err = rewrite(filename, []Option{WithKeyring(keyring)}, func(src, dst *GPFile, block storage.BlockAtTime) error {
+		var (
+			data []byte
+			err  error
+		)
+		if block.RawLen > 0 {
+			if data, err = src.readRawBlock(block.Timestamp, block.Block); err != nil {
+				return err
+			}
+			if block.KeyID != primaryID {
+				numRekeyed++
+			}
+		}
+		return dst.writeRawBlock(block.Timestamp, block.EncoderType, block.RawLen, data)
+	})
+
+	return numRekeyed, err
+}
+
+// RecompressResult summarizes the recompression of the blocks of a GPFile
+type RecompressResult struct {
+	NumBlocks int   // number of blocks recompressed
+	OldSize   int64 // size of these blocks before recompression (in bytes)
+	NewSize   int64 // size of these blocks after recompression (in bytes)
+}
+
+// Add adds the result of another recompression
+func (r *RecompressResult) Add(other RecompressResult) {
+	r.NumBlocks += other.NumBlocks
+	r.OldSize += other.OldSize
+	r.NewSize += other.NewSize
+}
+
+// Recompress rewrites all blocks of the GPFile located at filename which are not yet
+// compressed with compressor. Column codecs (see encoders.Chain) are retained and
+// encrypted blocks remain encrypted with their key, which has to be provided by the
+// keyring. In dry-run mode, the blocks are recompressed in memory only in order to
+// determine the resulting size
+func Recompress(filename string, compressor encoders.Type, keyring *encryption.Keyring, dryRun bool) (result RecompressResult, err error) {
+	compressor = compressor.Compressor()
+	if _, err = encoder.New(compressor); err != nil {
+		return result, err
+	}
+
+	if err = recoverRewrite(filename); err != nil {
+		return result, err
+	}
+	gpf, err := New(filename, ModeRead, WithKeyring(keyring))
+	if err != nil {
+		return result, err
+	}
+	defer gpf.Close()
+
+	rc := recompressor{compressor: compressor, keyring: keyring, encoders: make(map[encoders.Type]encoder.Encoder)}
+
+	// determine the blocks to be recompressed (and their resulting size in dry-run mode)
+	for _, block := range gpf.header.OrderedList() {
+		if !rc.required(block.Block) {
+			continue
+		}
+		result.NumBlocks++
+		result.OldSize += int64(block.Len)
+
+		if dryRun {
+			_, data, key, err := rc.recompress(gpf, block)
+			if err != nil {
+				return result, fmt.Errorf("Could not recompress block %d of %s: %s", block.Timestamp, filename, err)
+			}
+			result.NewSize += int64(len(data))
+			if key != nil {
+				result.NewSize += int64(key.Overhead())
+			}
+		}
+	}
+	if dryRun || result.NumBlocks == 0 {
+		return result, nil
+	}
+
+	err = rewrite(filename, []Option{WithKeyring(keyring)}, func(src, dst *GPFile, block storage.BlockAtTime) error {
+		if block.RawLen == 0 {
+			return dst.writeRawBlockWithKey(block.Timestamp, block.EncoderType, 0, nil, nil)
+		}
+
+		var (
+			encoderType = block.EncoderType
+			data        []byte
+			key         *encryption.Key
+			err         error
+		)
+		if rc.required(block.Block) {
+			if encoderType, data, key, err = rc.recompress(src, block); err != nil {
+				return err
+			}
+		} else {
+			if data, err = src.readRawBlock(block.Timestamp, block.Block); err != nil {
+				return err
+			}
+			if key, err = rc.key(block.Block); err != nil {
+				return err
+			}
+		}
+		if err = dst.writeRawBlockWithKey(block.Timestamp, encoderType, block.RawLen, data, key); err != nil {
+			return err
+		}
+
+		if rc.required(block.Block) {
+			result.NewSize += int64(dst.header.Blocks[block.Timestamp].Len)
+		}
+		return nil
+	})
+	if err != nil {
+		return RecompressResult{}, err
+	}
+
+	return result, nil
+}
+
+// recompressor converts blocks to a different compressor, caching the required encoders
+type recompressor struct {
+	compressor encoders.Type
+	keyring    *encryption.Keyring
+	encoders   map[encoders.Type]encoder.Encoder
+}
+
+func (r *recompressor) required(block storage.Block) bool {
+	return block.RawLen > 0 && block.EncoderType.Compressor() != r.compressor
+}
+
+// key returns the key the block is encrypted with (nil if it is unencrypted)
+func (r *recompressor) key(block storage.Block) (*encryption.Key, error) {
+	if block.KeyID == "" {
+		return nil, nil
+	}
+	return r.keyring.Key(block.KeyID)
+}
+
+// recompress reads and decompresses a block from src and compresses it again, returning
+// the new encoder type, the compressed data and the key it has to be encrypted with
+func (r *recompressor) recompress(src *GPFile, block storage.BlockAtTime) (encoders.Type, []byte, *encryption.Key, error) {
+	data, err := src.ReadBlock(block.Timestamp)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	encoderType := encoders.Chain(block.EncoderType.Codec(), block.EncoderType.Width(), r.compressor)
+	enc, exists := r.encoders[encoderType]
+	if !exists {
+		if enc, err = encoder.New(encoderType); err != nil {
+			return 0, nil, nil, err
+		}
+		r.encoders[encoderType] = enc
+	}
+
+	var compressed bytes.Buffer
+	if _, err = enc.Compress(data, &compressed); err != nil {
+		return 0, nil, nil, err
+	}
+
+	key, err := r.key(block.Block)
+	return encoderType, compressed.Bytes(), key, err
+}
+
+// CopyBlocks copies all blocks of the GPFile located at src for which keep returns true
+// to a new GPFile at dst. The blocks are copied as they are stored, hence encrypted
+// blocks can be copied without providing their key. Returns the number of copied blocks
+func CopyBlocks(src, dst string, keep func(timestamp int64) bool) (int, error) {
+	srcFile, err := New(src, ModeRead, withoutKeyCheck())
+	if err != nil {
+		return 0, err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := New(dst, ModeWrite, WithEncoder(srcFile.defaultEncoderType))
+	if err != nil {
+		return 0, err
+	}
+	if len(dstFile.header.Blocks) > 0 {
+		dstFile.Close()
+		return 0, fmt.Errorf("Cannot copy blocks to %s: file already exists", dst)
+	}
+
+	var numCopied int
+	for _, block := range srcFile.header.OrderedList() {
+		if !keep(block.Timestamp) {
+			continue
+		}
+		numCopied++
+
+		if err = dstFile.copyStoredBlock(srcFile, block); err != nil {
+			dstFile.Close()
+			return numCopied, err
+		}
+	}
+
+	if err = dstFile.Close(); err != nil {
+		return numCopied, err
+	}
+	return numCopied, dstFile.writeHeader()
+}
@@ -0,0 +1,157 @@
+package gpfile
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+const (
+	// defaultDirPermissions denotes the permissions used for directory creation
+	defaultDirPermissions = 0755
+
+	// sharedFilePermissions denotes the permissions used for auxiliary files that
+	// are appended to by other users (e.g. the query log)
+	sharedFilePermissions = 0666
+)
+
+// Store implements a file system based storage.Store, providing access to
+// goDB directories holding GPFiles
+type Store struct {
+	options []Option
+}
+
+// NewStore returns a new file system based store. The options are applied to all
+// GPFiles opened via the store (e.g. WithKeyring)
+func NewStore(options ...Option) *Store {
+	return &Store{options: options}
+}
+
+// Open opens the GPFile located at path
+func (s *Store) Open(path string, mode storage.Mode, encoderType encoders.Type) (storage.Backend, error) {
+	accessMode := ModeRead
+	if mode == storage.ModeWrite {
+		accessMode = ModeWrite
+	}
+
+	return New(path, accessMode, append([]Option{WithEncoder(encoderType)}, s.options...)...)
+}
+
+// ReadDir returns the sorted names of all directories located directly below path
+func (s *Store) ReadDir(path string) ([]string, error) {
+	dirList, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, file := range dirList {
+		if file.IsDir() {
+			dirs = append(dirs, file.Name())
+		}
+	}
+	sort.Strings(dirs)
+
+	return dirs, nil
+}
+
+// MkdirAll creates the directory path, along with all necessary parents
+func (s *Store) MkdirAll(path string) error {
+	return os.MkdirAll(path, defaultDirPermissions)
+}
+
+// ReadFile returns the contents of the file located at path
+func (s *Store) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+// WriteFile replaces the contents of the file located at path
+func (s *Store) WriteFile(path string, data []byte) error {
+	return ioutil.WriteFile(path, data, defaultPermissions)
+}
+
+// AppendFile appends data to the file located at path. If the file doesn't exist yet,
+// it is created with permissions allowing all users to append to it
+func (s *Store) AppendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, sharedFilePermissions)
+	if os.IsNotExist(err) {
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, sharedFilePermissions); err != nil {
+			return err
+		}
+
+		// explicitly set the permissions since they are subject to the umask on creation
+		if err = os.Chmod(path, sharedFilePermissions); err != nil {
+			f.Close()
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	return err
+}
+
+// CreateFile creates the empty file located at path, failing if it exists already
+func (s *Store) CreateFile(path string) error {
+	f, err := os.OpenFile(path, os.O_EXCL|os.O_CREATE, sharedFilePermissions)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// CopyDir copies all regular files located directly below the directory src (i.e. the
+// GPFiles, their headers and auxiliary files) to the new directory dst
+func (s *Store) CopyDir(src, dst string) error {
+	files, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err = os.Mkdir(dst, defaultDirPermissions); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if err = copyFile(filepath.Join(src, file.Name()), filepath.Join(dst, file.Name()), file.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Rename moves the file or directory located at oldPath to newPath
+func (s *Store) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
+// RemoveAll removes path along with everything below it
+func (s *Store) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
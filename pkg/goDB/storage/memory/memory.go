@@ -0,0 +1,402 @@
+// Package memory implements an in-memory goDB storage backend. It allows to write and
+// query goDB data without touching the file system, e.g. for testing purposes or for
+// tools embedding goDB
+package memory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+// headerVersion denotes the header version reported by in-memory backends
+const headerVersion = 1
+
+// column holds the blocks of a single backend. It outlives the Backend handles
+// that are used to access it
+type column struct {
+	sync.RWMutex
+
+	header storage.BlockHeader
+	data   map[int64][]byte
+}
+
+// Store implements an in-memory storage.Store. It is safe for concurrent use
+type Store struct {
+	sync.RWMutex
+
+	dirs    map[string]struct{}
+	files   map[string][]byte
+	columns map[string]*column
+}
+
+// NewStore returns a new, empty in-memory store
+func NewStore() *Store {
+	return &Store{
+		dirs:    map[string]struct{}{},
+		files:   make(map[string][]byte),
+		columns: make(map[string]*column),
+	}
+}
+
+// Open returns the backend located at path. Data is kept uncompressed, hence the encoder
+// type is ignored
+func (s *Store) Open(path string, mode storage.Mode, encoderType encoders.Type) (storage.Backend, error) {
+	path = filepath.Clean(path)
+
+	s.Lock()
+	defer s.Unlock()
+
+	col, exists := s.columns[path]
+	if !exists {
+
+		// Much like a GPFile, a backend has to exist in order to be read from
+		if mode == storage.ModeRead {
+			return nil, fmt.Errorf("Backend invalid: %s", notExist("open", path))
+		}
+		if err := s.checkParent("open", path); err != nil {
+			return nil, err
+		}
+
+		col = &column{
+			header: storage.BlockHeader{
+				Blocks:  make(map[int64]storage.Block),
+				Version: headerVersion,
+			},
+			data: make(map[int64][]byte),
+		}
+		s.columns[path] = col
+	}
+
+	return &Backend{col: col, mode: mode}, nil
+}
+
+// ReadDir returns the sorted names of all directories located directly below path
+func (s *Store) ReadDir(path string) ([]string, error) {
+	path = filepath.Clean(path)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	if _, exists := s.dirs[path]; !exists {
+		return nil, notExist("readdir", path)
+	}
+
+	var dirs []string
+	for dir := range s.dirs {
+		if dir != path && filepath.Dir(dir) == path {
+			dirs = append(dirs, filepath.Base(dir))
+		}
+	}
+	sort.Strings(dirs)
+
+	return dirs, nil
+}
+
+// MkdirAll creates the directory path, along with all necessary parents
+func (s *Store) MkdirAll(path string) error {
+	path = filepath.Clean(path)
+
+	s.Lock()
+	defer s.Unlock()
+
+	for {
+		s.dirs[path] = struct{}{}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return nil
+		}
+		path = parent
+	}
+}
+
+// ReadFile returns the contents of the auxiliary file located at path
+func (s *Store) ReadFile(path string) ([]byte, error) {
+	path = filepath.Clean(path)
+
+	s.RLock()
+	defer s.RUnlock()
+
+	data, exists := s.files[path]
+	if !exists {
+		return nil, notExist("open", path)
+	}
+
+	return append([]byte{}, data...), nil
+}
+
+// WriteFile replaces the contents of the auxiliary file located at path
+func (s *Store) WriteFile(path string, data []byte) error {
+	path = filepath.Clean(path)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkParent("open", path); err != nil {
+		return err
+	}
+	s.files[path] = append([]byte{}, data...)
+
+	return nil
+}
+
+// AppendFile appends data to the auxiliary file located at path, creating it if
+// it does not exist yet
+func (s *Store) AppendFile(path string, data []byte) error {
+	path = filepath.Clean(path)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkParent("open", path); err != nil {
+		return err
+	}
+	s.files[path] = append(s.files[path], data...)
+
+	return nil
+}
+
+// CreateFile creates the empty auxiliary file located at path, failing if it exists
+// already
+func (s *Store) CreateFile(path string) error {
+	path = filepath.Clean(path)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err := s.checkParent("open", path); err != nil {
+		return err
+	}
+	if _, exists := s.files[path]; exists {
+		return &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
+	}
+	s.files[path] = []byte{}
+
+	return nil
+}
+
+// CopyDir copies the backends and auxiliary files located directly below the directory
+// src to the new directory dst
+func (s *Store) CopyDir(src, dst string) error {
+	src, dst = filepath.Clean(src), filepath.Clean(dst)
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, exists := s.dirs[src]; !exists {
+		return notExist("open", src)
+	}
+	if _, exists := s.dirs[dst]; exists {
+		return &os.PathError{Op: "mkdir", Path: dst, Err: os.ErrExist}
+	}
+	if err := s.checkParent("mkdir", dst); err != nil {
+		return err
+	}
+	s.dirs[dst] = struct{}{}
+
+	for path, data := range s.files {
+		if filepath.Dir(path) == src {
+			s.files[filepath.Join(dst, filepath.Base(path))] = append([]byte{}, data...)
+		}
+	}
+	for path, col := range s.columns {
+		if filepath.Dir(path) == src {
+			s.columns[filepath.Join(dst, filepath.Base(path))] = col.copy()
+		}
+	}
+
+	return nil
+}
+
+// Rename moves the auxiliary file, backend or directory (along with everything below it)
+// located at oldPath to newPath
+func (s *Store) Rename(oldPath, newPath string) error {
+	oldPath, newPath = filepath.Clean(oldPath), filepath.Clean(newPath)
+
+	s.Lock()
+	defer s.Unlock()
+
+	_, isDir := s.dirs[oldPath]
+	_, isFile := s.files[oldPath]
+	_, isColumn := s.columns[oldPath]
+	if !isDir && !isFile && !isColumn {
+		return notExist("rename", oldPath)
+	}
+	if err := s.checkParent("rename", newPath); err != nil {
+		return err
+	}
+	if _, exists := s.dirs[newPath]; exists {
+		return &os.PathError{Op: "rename", Path: newPath, Err: os.ErrExist}
+	}
+
+	moved := func(path string) string {
+		return newPath + path[len(oldPath):]
+	}
+	for dir := range s.dirs {
+		if isBelow(dir, oldPath) {
+			delete(s.dirs, dir)
+			s.dirs[moved(dir)] = struct{}{}
+		}
+	}
+	for path, data := range s.files {
+		if isBelow(path, oldPath) {
+			delete(s.files, path)
+			s.files[moved(path)] = data
+		}
+	}
+	for path, col := range s.columns {
+		if isBelow(path, oldPath) {
+			delete(s.columns, path)
+			s.columns[moved(path)] = col
+		}
+	}
+
+	return nil
+}
+
+// RemoveAll removes path along with everything below it
+func (s *Store) RemoveAll(path string) error {
+	path = filepath.Clean(path)
+
+	s.Lock()
+	defer s.Unlock()
+
+	for dir := range s.dirs {
+		if isBelow(dir, path) {
+			delete(s.dirs, dir)
+		}
+	}
+	for file := range s.files {
+		if isBelow(file, path) {
+			delete(s.files, file)
+		}
+	}
+	for col := range s.columns {
+		if isBelow(col, path) {
+			delete(s.columns, col)
+		}
+	}
+
+	return nil
+}
+
+// isBelow returns whether path is located at or below dir
+func isBelow(path, dir string) bool {
+	if path == dir {
+		return true
+	}
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, dir)
+}
+
+func (s *Store) checkParent(op, path string) error {
+	if _, exists := s.dirs[filepath.Dir(path)]; !exists {
+		return notExist(op, path)
+	}
+	return nil
+}
+
+func notExist(op, path string) error {
+	return &os.PathError{Op: op, Path: path, Err: os.ErrNotExist}
+}
+
+// copy returns a deep copy of the column
+func (c *column) copy() *column {
+	c.RLock()
+	defer c.RUnlock()
+
+	cp := &column{
+		header: storage.BlockHeader{
+			Blocks:        make(map[int64]storage.Block, len(c.header.Blocks)),
+			CurrentOffset: c.header.CurrentOffset,
+			Version:       c.header.Version,
+		},
+		data: make(map[int64][]byte, len(c.data)),
+	}
+	for ts, block := range c.header.Blocks {
+		cp.header.Blocks[ts] = block
+	}
+	for ts, data := range c.data {
+		cp.data[ts] = append([]byte{}, data...)
+	}
+	return cp
+}
+
+// Backend implements storage.Backend for a single in-memory column
+type Backend struct {
+	col  *column
+	mode storage.Mode
+}
+
+// Blocks returns the list of available blocks (and its metadata)
+func (b *Backend) Blocks() (storage.BlockHeader, error) {
+	b.col.RLock()
+	defer b.col.RUnlock()
+
+	header := storage.BlockHeader{
+		Blocks:        make(map[int64]storage.Block, len(b.col.header.Blocks)),
+		CurrentOffset: b.col.header.CurrentOffset,
+		Version:       b.col.header.Version,
+	}
+	for ts, block := range b.col.header.Blocks {
+		header.Blocks[ts] = block
+	}
+
+	return header, nil
+}
+
+// ReadBlock searches if a block for a given timestamp exists and returns in its data
+func (b *Backend) ReadBlock(timestamp int64) ([]byte, error) {
+
+	// Check that the backend has been opened in the correct mode
+	if b.mode != storage.ModeRead {
+		return nil, fmt.Errorf("Cannot read from backend in write mode")
+	}
+
+	b.col.RLock()
+	defer b.col.RUnlock()
+
+	data, found := b.col.data[timestamp]
+	if !found {
+		return nil, fmt.Errorf("Block for timestamp %v not found", timestamp)
+	}
+
+	return append([]byte{}, data...), nil
+}
+
+// WriteBlock writes data for a given timestamp to the backend
+func (b *Backend) WriteBlock(timestamp int64, blockData []byte) error {
+
+	// Check that the backend has been opened in the correct mode
+	if b.mode != storage.ModeWrite {
+		return fmt.Errorf("Cannot write to backend in read mode")
+	}
+
+	b.col.Lock()
+	defer b.col.Unlock()
+
+	b.col.data[timestamp] = append([]byte{}, blockData...)
+	b.col.header.Blocks[timestamp] = storage.Block{
+		Offset:      b.col.header.CurrentOffset,
+		Len:         len(blockData),
+		RawLen:      len(blockData),
+		EncoderType: encoders.EncoderTypeNull,
+	}
+	b.col.header.CurrentOffset += int64(len(blockData))
+
+	return nil
+}
+
+// Close closes the backend handle. The data remains available in the store
+func (b *Backend) Close() error {
+	return nil
+}
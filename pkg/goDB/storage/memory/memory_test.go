@@ -0,0 +1,262 @@
+package memory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+const (
+	testDir  = "db/eth0/1456358400"
+	testPath = testDir + "/bytes_rcvd.gpf"
+)
+
+func TestFailedRead(t *testing.T) {
+	s := NewStore()
+
+	_, err := s.Open(testPath, storage.ModeRead, encoders.EncoderTypeLZ4)
+	if err == nil {
+		t.Fatalf("Expected an error trying to open a non-existing backend for reading, got none")
+	}
+}
+
+func TestMissingDirectory(t *testing.T) {
+	s := NewStore()
+
+	if _, err := s.Open(testPath, storage.ModeWrite, encoders.EncoderTypeLZ4); !os.IsNotExist(err) {
+		t.Fatalf("Expected not-exist error trying to create a backend in a missing directory, got %v", err)
+	}
+	if err := s.WriteFile(testDir+"/meta.json", []byte{}); !os.IsNotExist(err) {
+		t.Fatalf("Expected not-exist error trying to write a file in a missing directory, got %v", err)
+	}
+	if _, err := s.ReadDir(testDir); !os.IsNotExist(err) {
+		t.Fatalf("Expected not-exist error trying to list a missing directory, got %v", err)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	s := NewStore()
+
+	for _, dir := range []string{"db/eth1/1456444800", "db/eth1/1456358400", "db/eth0/1456358400"} {
+		if err := s.MkdirAll(dir); err != nil {
+			t.Fatalf("Failed to create directory %s: %s", dir, err)
+		}
+	}
+
+	var tests = []struct {
+		path     string
+		expected []string
+	}{
+		{"db", []string{"eth0", "eth1"}},
+		{"db/", []string{"eth0", "eth1"}},
+		{"db/eth1", []string{"1456358400", "1456444800"}},
+		{"db/eth0/1456358400", nil},
+	}
+	for _, test := range tests {
+		dirs, err := s.ReadDir(test.path)
+		if err != nil {
+			t.Fatalf("Failed to read directory %s: %s", test.path, err)
+		}
+		if len(dirs) != len(test.expected) {
+			t.Fatalf("Unexpected directories below %s: have %v, want %v", test.path, dirs, test.expected)
+		}
+		for i := range dirs {
+			if dirs[i] != test.expected[i] {
+				t.Fatalf("Unexpected directories below %s: have %v, want %v", test.path, dirs, test.expected)
+			}
+		}
+	}
+}
+
+func TestFiles(t *testing.T) {
+	s := NewStore()
+	if err := s.MkdirAll(testDir); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+
+	path := testDir + "/query.log"
+	for _, line := range []string{"a\n", "b\n"} {
+		if err := s.AppendFile(path, []byte(line)); err != nil {
+			t.Fatalf("Failed to append to file: %s", err)
+		}
+	}
+	data, err := s.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read file: %s", err)
+	}
+	if string(data) != "a\nb\n" {
+		t.Fatalf("Unexpected file contents: %q", data)
+	}
+
+	if err := s.WriteFile(path, []byte("c")); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	if data, _ = s.ReadFile(path); string(data) != "c" {
+		t.Fatalf("Unexpected file contents after overwrite: %q", data)
+	}
+}
+
+func TestRoundtrip(t *testing.T) {
+	s := NewStore()
+	if err := s.MkdirAll(testDir); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+
+	// write the blocks through separate handles, the way DBWriter does it
+	for i := 0; i < 101; i++ {
+		backend, err := s.Open(testPath, storage.ModeWrite, encoders.EncoderTypeLZ4)
+		if err != nil {
+			t.Fatalf("Failed to open backend for writing: %s", err)
+		}
+		if _, err := backend.ReadBlock(int64(i)); err == nil {
+			t.Fatalf("Expected error trying to read from backend in write mode, got none")
+		}
+		if err := backend.WriteBlock(int64(i), testBlock(i)); err != nil {
+			t.Fatalf("Failed to write block: %s", err)
+		}
+		if err := backend.Close(); err != nil {
+			t.Fatalf("Failed to close backend: %s", err)
+		}
+	}
+
+	backend, err := s.Open(testPath, storage.ModeRead, encoders.EncoderTypeLZ4)
+	if err != nil {
+		t.Fatalf("Failed to open backend for reading: %s", err)
+	}
+	defer backend.Close()
+
+	if err := backend.WriteBlock(1000, []byte{1}); err == nil {
+		t.Fatalf("Expected error trying to write to backend in read mode, got none")
+	}
+
+	blocks, err := backend.Blocks()
+	if err != nil {
+		t.Fatalf("Failed to get blocks: %s", err)
+	}
+	if blocks.Version != headerVersion {
+		t.Fatalf("Unexpected header version, want %d, have %d", headerVersion, blocks.Version)
+	}
+	for i, block := range blocks.OrderedList() {
+		if block.Timestamp != int64(i) {
+			t.Fatalf("Unexpected timestamp at block %d: %d", i, block.Timestamp)
+		}
+		if block.RawLen != len(testBlock(i)) {
+			t.Fatalf("Unexpected raw length at block %d: %d", i, block.RawLen)
+		}
+
+		blockData, err := backend.ReadBlock(block.Timestamp)
+		if err != nil {
+			t.Fatalf("Failed to read block %d: %s", i, err)
+		}
+		if !bytes.Equal(blockData, testBlock(i)) {
+			t.Fatalf("Unexpected data at block %d: %v", i, blockData)
+		}
+	}
+}
+
+func TestDirOperations(t *testing.T) {
+	s := NewStore()
+	if err := s.MkdirAll(testDir); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+
+	// locks can only be taken once
+	lockPath := "db/.lock"
+	if err := s.CreateFile(lockPath); err != nil {
+		t.Fatalf("Failed to create lock file: %s", err)
+	}
+	if err := s.CreateFile(lockPath); !os.IsExist(err) {
+		t.Fatalf("Expected exists error trying to create lock file twice, got %v", err)
+	}
+	if err := s.RemoveAll(lockPath); err != nil {
+		t.Fatalf("Failed to remove lock file: %s", err)
+	}
+	if err := s.CreateFile(lockPath); err != nil {
+		t.Fatalf("Failed to create removed lock file: %s", err)
+	}
+
+	backend, err := s.Open(testPath, storage.ModeWrite, encoders.EncoderTypeLZ4)
+	if err != nil {
+		t.Fatalf("Failed to open backend for writing: %s", err)
+	}
+	if err := backend.WriteBlock(1, testBlock(1)); err != nil {
+		t.Fatalf("Failed to write block: %s", err)
+	}
+	backend.Close()
+	if err := s.WriteFile(testDir+"/meta.json", []byte("{}")); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+
+	// copies don't share blocks with the original
+	copyDir := "db/eth0/1456444800"
+	if err := s.CopyDir(testDir, copyDir); err != nil {
+		t.Fatalf("Failed to copy directory: %s", err)
+	}
+	if err := s.CopyDir(testDir, copyDir); !os.IsExist(err) {
+		t.Fatalf("Expected exists error trying to copy to existing directory, got %v", err)
+	}
+	backend, _ = s.Open(testPath, storage.ModeWrite, encoders.EncoderTypeLZ4)
+	if err := backend.WriteBlock(2, testBlock(2)); err != nil {
+		t.Fatalf("Failed to write block: %s", err)
+	}
+	backend.Close()
+	backend, err = s.Open(copyDir+"/bytes_rcvd.gpf", storage.ModeRead, encoders.EncoderTypeLZ4)
+	if err != nil {
+		t.Fatalf("Failed to open copied backend: %s", err)
+	}
+	blocks, _ := backend.Blocks()
+	backend.Close()
+	if len(blocks.Blocks) != 1 {
+		t.Fatalf("Unexpected blocks in copy: %v", blocks.Blocks)
+	}
+	if data, err := s.ReadFile(copyDir + "/meta.json"); err != nil || string(data) != "{}" {
+		t.Fatalf("Unexpected copied file contents: %q (%v)", data, err)
+	}
+
+	// renaming moves everything below the directory
+	if err := s.Rename("db/eth0", "db/eth1"); err != nil {
+		t.Fatalf("Failed to rename directory: %s", err)
+	}
+	if _, err := s.ReadDir("db/eth0"); !os.IsNotExist(err) {
+		t.Fatalf("Expected not-exist error trying to list renamed directory, got %v", err)
+	}
+	if dirs, _ := s.ReadDir("db/eth1"); len(dirs) != 2 {
+		t.Fatalf("Unexpected directories after rename: %v", dirs)
+	}
+	if _, err := s.ReadFile("db/eth1/1456444800/meta.json"); err != nil {
+		t.Fatalf("Failed to read renamed file: %s", err)
+	}
+	if err := s.Rename("db/eth0", "db/eth2"); !os.IsNotExist(err) {
+		t.Fatalf("Expected not-exist error trying to rename missing directory, got %v", err)
+	}
+
+	// removals don't affect directories sharing a prefix
+	if err := s.MkdirAll("db/eth10"); err != nil {
+		t.Fatalf("Failed to create directory: %s", err)
+	}
+	if err := s.RemoveAll("db/eth1"); err != nil {
+		t.Fatalf("Failed to remove directory: %s", err)
+	}
+	if err := s.RemoveAll("db/eth1"); err != nil {
+		t.Fatalf("Failed to remove missing directory: %s", err)
+	}
+	if dirs, _ := s.ReadDir("db"); len(dirs) != 1 || dirs[0] != "eth10" {
+		t.Fatalf("Unexpected directories after removal: %v", dirs)
+	}
+	if _, err := s.Open("db/eth1/1456444800/bytes_rcvd.gpf", storage.ModeRead, encoders.EncoderTypeLZ4); err == nil {
+		t.Fatalf("Expected error trying to open removed backend, got none")
+	}
+}
+
+func testBlock(i int) []byte {
+	if i == 100 {
+		return []byte{}
+	}
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint64(data, uint64(i))
+	return data
+}
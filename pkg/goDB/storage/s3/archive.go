@@ -0,0 +1,170 @@
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// secondsPerDay denotes the duration covered by a goDB day directory
+const secondsPerDay = 86400
+
+// timeNow allows to override the current time in tests
+var timeNow = time.Now
+
+// localFiles denotes files that remain in the local day directory upon archival. They
+// are small and read by the writer / tooling independently of the column data
+var localFiles = map[string]struct{}{
+	StubFileName: struct{}{},
+	"meta.json":  struct{}{},
+}
+
+// Archiver moves the column files of cold days to object storage
+type Archiver struct {
+	client *Client
+	cfg    Config
+
+	// prefix denotes the common key prefix of all archived objects
+	prefix string
+}
+
+// NewArchiver creates an archiver uploading to the bucket described by cfg. All
+// objects are stored below the (optional) key prefix
+func NewArchiver(cfg Config, prefix string) (*Archiver, error) {
+	client, err := NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = DefaultRegion
+	}
+
+	return &Archiver{
+		client: client,
+		cfg:    cfg,
+		prefix: path.Clean("/" + prefix)[1:],
+	}, nil
+}
+
+// ArchiveDay uploads all column files of the given day of an interface and replaces
+// them with a stub marker. Days that have already been archived are skipped. Returns
+// true if the day was archived in the course of the call
+func (a *Archiver) ArchiveDay(dbPath, iface string, day int64) (bool, error) {
+	dayDir := strconv.FormatInt(day, 10)
+	dir := filepath.Join(dbPath, iface, dayDir)
+
+	if _, err := os.Stat(filepath.Join(dir, StubFileName)); err == nil {
+		return false, nil
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	stub := Stub{
+		Endpoint:  a.cfg.Endpoint,
+		Bucket:    a.cfg.Bucket,
+		Region:    a.cfg.Region,
+		KeyPrefix: path.Join(a.prefix, iface, dayDir),
+		Files:     make(map[string]int64),
+	}
+
+	// upload and verify all files before touching the local data
+	for _, file := range files {
+		if _, local := localFiles[file.Name()]; local || !file.Mode().IsRegular() {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return false, err
+		}
+		key := stub.Key(file.Name())
+		if err := a.client.PutObject(key, data); err != nil {
+			return false, err
+		}
+		size, err := a.client.HeadObject(key)
+		if err != nil {
+			return false, err
+		}
+		if size != int64(len(data)) {
+			return false, fmt.Errorf("Verification of archived object %q failed: want size %d, have %d", key, len(data), size)
+		}
+
+		stub.Files[file.Name()] = size
+	}
+	stub.Archived = timeNow().Unix()
+
+	// the stub marks the day as archived, so it has to be in place before any file is removed
+	stubData, err := json.Marshal(stub)
+	if err != nil {
+		return false, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, StubFileName), append(stubData, '\n')); err != nil {
+		return false, err
+	}
+
+	for name := range stub.Files {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// ArchiveBefore archives all days of all interfaces that ended before t. Returns the
+// number of days archived in the course of the call
+func (a *Archiver) ArchiveBefore(dbPath string, t time.Time) (int, error) {
+	ifaces, err := subDirs(dbPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, iface := range ifaces {
+		days, err := subDirs(filepath.Join(dbPath, iface))
+		if err != nil {
+			return count, err
+		}
+		for _, dayDir := range days {
+			day, err := strconv.ParseInt(dayDir, 10, 64)
+			if err != nil || day+secondsPerDay > t.Unix() {
+				continue
+			}
+
+			archived, err := a.ArchiveDay(dbPath, iface, day)
+			if err != nil {
+				return count, fmt.Errorf("Failed to archive day %d of interface %s: %s", day, iface, err)
+			}
+			if archived {
+				count++
+			}
+		}
+	}
+
+	return count, nil
+}
+
+func subDirs(path string) ([]string, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []string
+	for _, file := range files {
+		if file.IsDir() {
+			dirs = append(dirs, file.Name())
+		}
+	}
+	return dirs, nil
+}
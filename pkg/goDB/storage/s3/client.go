@@ -0,0 +1,242 @@
+// Package s3 implements archival of cold goDB days to S3-compatible object storage
+// (e.g. MinIO). Archived days are replaced by a stub marker in the local database
+// and fetched transparently (and cached locally) when they are queried
+package s3
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRegion denotes the region used for request signing if none is configured
+	DefaultRegion = "us-east-1"
+
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	signingService   = "s3"
+
+	amzDateFormat   = "20060102T150405Z"
+	amzShortDateFmt = "20060102"
+
+	defaultRequestTimeout = 5 * time.Minute
+)
+
+// Config stores the parameters required to access a bucket
+type Config struct {
+	Endpoint  string // URL of the S3 endpoint, e.g. http://minio.example.com:9000
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+}
+
+// Client is a minimal S3 client supporting the object operations required for archival.
+// Requests are signed using AWS Signature Version 4 and use path-style addressing, which
+// is supported by all common S3-compatible stores
+type Client struct {
+	endpoint *url.URL
+	bucket   string
+	region   string
+
+	accessKey string
+	secretKey string
+
+	httpClient *http.Client
+
+	// now allows to override the time used for signing
+	now func() time.Time
+}
+
+// NewClient creates a new client for the bucket described by cfg
+func NewClient(cfg Config) (*Client, error) {
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q: %s", cfg.Endpoint, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q: scheme must be http or https", cfg.Endpoint)
+	}
+	if endpoint.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q: missing host", cfg.Endpoint)
+	}
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("No S3 bucket specified")
+	}
+
+	region := cfg.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+
+	return &Client{
+		endpoint:   endpoint,
+		bucket:     cfg.Bucket,
+		region:     region,
+		accessKey:  cfg.AccessKey,
+		secretKey:  cfg.SecretKey,
+		httpClient: &http.Client{Timeout: defaultRequestTimeout},
+		now:        time.Now,
+	}, nil
+}
+
+// PutObject uploads data to the object identified by key
+func (c *Client) PutObject(key string, data []byte) error {
+	resp, err := c.do(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return statusError(http.MethodPut, key, resp)
+	}
+	return nil
+}
+
+// GetObject downloads the object identified by key
+func (c *Client) GetObject(key string) ([]byte, error) {
+	resp, err := c.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(http.MethodGet, key, resp)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// HeadObject returns the size of the object identified by key
+func (c *Client) HeadObject(key string) (int64, error) {
+	resp, err := c.do(http.MethodHead, key, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, statusError(http.MethodHead, key, resp)
+	}
+	return resp.ContentLength, nil
+}
+
+func (c *Client) do(method, key string, body []byte) (*http.Response, error) {
+	objectURL := *c.endpoint
+	objectURL.Path = c.objectPath(key)
+	objectURL.RawPath = escapePath(objectURL.Path)
+
+	req, err := http.NewRequest(method, objectURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		req.Body = nil
+		req.ContentLength = 0
+	}
+	c.sign(req, body)
+
+	return c.httpClient.Do(req)
+}
+
+func (c *Client) objectPath(key string) string {
+	return "/" + c.bucket + "/" + strings.TrimLeft(key, "/")
+}
+
+// sign adds the AWS Signature Version 4 headers to the request
+func (c *Client) sign(req *http.Request, body []byte) {
+	var (
+		t           = c.now().UTC()
+		amzDate     = t.Format(amzDateFormat)
+		shortDate   = t.Format(amzShortDateFmt)
+		payloadHash = hashHex(body)
+		scope       = strings.Join([]string{shortDate, c.region, signingService, "aws4_request"}, "/")
+	)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		"", // no query parameters are used
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{
+		signingAlgorithm,
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signature := hex.EncodeToString(hmacSHA256(signingKey(c.secretKey, shortDate, c.region, signingService), []byte(stringToSign)))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, c.accessKey, scope, signedHeaders, signature,
+	))
+}
+
+// signingKey derives the request signing key from the secret key
+func signingKey(secretKey, shortDate, region, service string) []byte {
+	kDate := hmacSHA256([]byte("AWS4"+secretKey), []byte(shortDate))
+	kRegion := hmacSHA256(kDate, []byte(region))
+	kService := hmacSHA256(kRegion, []byte(service))
+	return hmacSHA256(kService, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// escapePath URI-encodes each segment of path as required by the signature algorithm
+func escapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = escapeSegment(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func escapeSegment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func statusError(method, key string, resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if len(msg) > 0 {
+		return fmt.Errorf("S3 %s of object %q failed with status %s: %s", method, key, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return fmt.Errorf("S3 %s of object %q failed with status %s", method, key, resp.Status)
+}
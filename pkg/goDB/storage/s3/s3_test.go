@@ -0,0 +1,353 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+const (
+	testBucket    = "goprobe"
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testDay       = int64(1456358400)
+)
+
+// fakeS3 is a minimal in-memory stand-in for an S3-compatible object store
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+	gets    int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	f := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), signingAlgorithm+" Credential="+testAccessKey+"/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		f.Lock()
+		defer f.Unlock()
+
+		data, exists := f.objects[r.URL.Path]
+		switch r.Method {
+		case http.MethodPut:
+			f.objects[r.URL.Path] = body
+		case http.MethodGet, http.MethodHead:
+			if !exists {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if r.Method == http.MethodGet {
+				f.gets++
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return f, server
+}
+
+func TestSigningKey(t *testing.T) {
+	// example from the AWS Signature Version 4 documentation
+	key := signingKey(testSecretKey, "20120215", "us-east-1", "iam")
+	if have := hex.EncodeToString(key); have != "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d" {
+		t.Fatalf("Unexpected signing key: %s", have)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	var tests = []Config{
+		{Endpoint: "", Bucket: testBucket},
+		{Endpoint: "ftp://localhost", Bucket: testBucket},
+		{Endpoint: "http://", Bucket: testBucket},
+		{Endpoint: "http://localhost:9000", Bucket: ""},
+	}
+	for _, cfg := range tests {
+		if _, err := NewClient(cfg); err == nil {
+			t.Fatalf("Expected error for config %+v, got none", cfg)
+		}
+	}
+}
+
+func TestArchiveAndQuery(t *testing.T) {
+	fake, server := newFakeS3(t)
+
+	dbPath := t.TempDir()
+	dayDir := filepath.Join(dbPath, "eth0", strconv.Itoa(int(testDay)))
+	if err := os.MkdirAll(dayDir, 0755); err != nil {
+		t.Fatalf("Failed to create day directory: %s", err)
+	}
+	writeTestColumn(t, filepath.Join(dayDir, "bytes_rcvd.gpf"))
+	writeTestColumn(t, filepath.Join(dayDir, "bytes_sent.gpf"))
+	if err := ioutil.WriteFile(filepath.Join(dayDir, "meta.json"), []byte("{}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write metadata: %s", err)
+	}
+
+	archiver, err := NewArchiver(Config{
+		Endpoint:  server.URL,
+		Bucket:    testBucket,
+		AccessKey: testAccessKey,
+		SecretKey: testSecretKey,
+	}, "probe1/")
+	if err != nil {
+		t.Fatalf("Failed to create archiver: %s", err)
+	}
+
+	// the day has not ended yet, so it must not be archived
+	if n, err := archiver.ArchiveBefore(dbPath, time.Unix(testDay+3600, 0)); err != nil || n != 0 {
+		t.Fatalf("Unexpected result archiving the current day: %d, %v", n, err)
+	}
+	if n, err := archiver.ArchiveBefore(dbPath, time.Unix(testDay+2*secondsPerDay, 0)); err != nil || n != 1 {
+		t.Fatalf("Unexpected result archiving cold days: %d, %v", n, err)
+	}
+
+	// only the stub and the metadata remain locally
+	files, err := ioutil.ReadDir(dayDir)
+	if err != nil {
+		t.Fatalf("Failed to list day directory: %s", err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	if strings.Join(names, ",") != StubFileName+",meta.json" {
+		t.Fatalf("Unexpected files in archived day directory: %v", names)
+	}
+	if _, exists := fake.objects["/"+testBucket+"/probe1/eth0/1456358400/bytes_rcvd.gpf.meta"]; !exists {
+		t.Fatalf("Header not found in object store, have %d objects", len(fake.objects))
+	}
+
+	// archiving is idempotent
+	if archived, err := archiver.ArchiveDay(dbPath, "eth0", testDay); err != nil || archived {
+		t.Fatalf("Unexpected result archiving an archived day: %v, %v", archived, err)
+	}
+
+	local := &stubCountingStore{Store: gpfile.NewStore()}
+	store := NewStore(local,
+		WithCacheDir(t.TempDir()),
+		WithCredentials(testAccessKey, testSecretKey),
+	)
+
+	// the directory structure is unaffected by archival
+	days, err := store.ReadDir(filepath.Join(dbPath, "eth0"))
+	if err != nil || len(days) != 1 {
+		t.Fatalf("Unexpected day directories: %v, %v", days, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		checkTestColumn(t, store, filepath.Join(dayDir, "bytes_rcvd.gpf"))
+	}
+	if fake.gets != 2 {
+		t.Fatalf("Expected the column to be fetched once (data + header), have %d requests", fake.gets)
+	}
+	if local.stubReads != 1 {
+		t.Fatalf("Expected the stub to be read once, have %d reads", local.stubReads)
+	}
+
+	if _, err := store.Open(filepath.Join(dayDir, "bytes_rcvd.gpf"), storage.ModeWrite, encoders.EncoderTypeLZ4); err == nil {
+		t.Fatalf("Expected error trying to write to an archived day, got none")
+	}
+	if _, err := store.Open(filepath.Join(dayDir, "sip.gpf"), storage.ModeRead, encoders.EncoderTypeLZ4); err == nil {
+		t.Fatalf("Expected error trying to read a column that was never written, got none")
+	}
+}
+
+func TestStubsForgotten(t *testing.T) {
+	var (
+		dbPath  = t.TempDir()
+		dayDir  = filepath.Join(dbPath, "eth0", "1456358400")
+		stubDir = dayDir + ".merge"
+		local   = &stubCountingStore{Store: gpfile.NewStore()}
+		store   = NewStore(local, WithCacheDir(t.TempDir()))
+	)
+	for _, dir := range []string{dayDir, stubDir} {
+		if err := store.MkdirAll(dir); err != nil {
+			t.Fatalf("Failed to create directory: %s", err)
+		}
+	}
+	if err := store.WriteFile(filepath.Join(stubDir, StubFileName), []byte(`{"files":{}}`)); err != nil {
+		t.Fatalf("Failed to write stub: %s", err)
+	}
+
+	backend, err := store.Open(filepath.Join(dayDir, "bytes_rcvd.gpf"), storage.ModeWrite, encoders.EncoderTypeLZ4)
+	if err != nil {
+		t.Fatalf("Failed to open backend for writing: %s", err)
+	}
+	backend.Close()
+
+	// the day is replaced by an archived one
+	if err := store.RemoveAll(dayDir); err != nil {
+		t.Fatalf("Failed to remove day: %s", err)
+	}
+	if err := store.Rename(stubDir, dayDir); err != nil {
+		t.Fatalf("Failed to rename day: %s", err)
+	}
+	if _, err := store.Open(filepath.Join(dayDir, "bytes_rcvd.gpf"), storage.ModeWrite, encoders.EncoderTypeLZ4); err == nil {
+		t.Fatalf("Expected error trying to write to a day replaced by an archived one, got none")
+	}
+	if local.stubReads != 2 {
+		t.Fatalf("Expected the stub to be read again after the rename, have %d reads", local.stubReads)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	_, server := newFakeS3(t)
+
+	dbPath := t.TempDir()
+	for _, day := range []int64{testDay, testDay + secondsPerDay} {
+		dayDir := filepath.Join(dbPath, "eth0", strconv.Itoa(int(day)))
+		if err := os.MkdirAll(dayDir, 0755); err != nil {
+			t.Fatalf("Failed to create day directory: %s", err)
+		}
+		writeTestColumn(t, filepath.Join(dayDir, "bytes_rcvd.gpf"))
+	}
+
+	archiver, err := NewArchiver(Config{Endpoint: server.URL, Bucket: testBucket, AccessKey: testAccessKey, SecretKey: testSecretKey}, "")
+	if err != nil {
+		t.Fatalf("Failed to create archiver: %s", err)
+	}
+	if n, err := archiver.ArchiveBefore(dbPath, time.Unix(testDay+3*secondsPerDay, 0)); err != nil || n != 2 {
+		t.Fatalf("Unexpected result archiving cold days: %d, %v", n, err)
+	}
+
+	// allow for a single column to be cached
+	cacheDir := t.TempDir()
+	store := NewStore(gpfile.NewStore(),
+		WithCacheDir(cacheDir),
+		WithCredentials(testAccessKey, testSecretKey),
+		WithMaxCacheSize(1),
+	)
+	for _, day := range []int64{testDay, testDay + secondsPerDay} {
+		checkTestColumn(t, store, filepath.Join(dbPath, "eth0", strconv.Itoa(int(day)), "bytes_rcvd.gpf"))
+	}
+
+	if _, err := os.Stat(filepath.Join(cacheDir, testBucket, "eth0", strconv.Itoa(int(testDay)), "bytes_rcvd.gpf")); !os.IsNotExist(err) {
+		t.Fatalf("Expected least recently used column to be evicted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, testBucket, "eth0", strconv.Itoa(int(testDay+secondsPerDay)), "bytes_rcvd.gpf")); err != nil {
+		t.Fatalf("Expected most recently used column to be cached: %s", err)
+	}
+}
+
+func TestPrepareCacheDir(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// cache directories are created or made inaccessible to other users
+	for _, dir := range []string{filepath.Join(tmpDir, "new", "cache"), filepath.Join(tmpDir, "existing")} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, "existing"), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %s", err)
+		}
+		if err := NewStore(gpfile.NewStore(), WithCacheDir(dir)).prepareCacheDir(); err != nil {
+			t.Fatalf("Failed to prepare cache directory %s: %s", dir, err)
+		}
+		if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != cacheDirPermissions {
+			t.Fatalf("Unexpected cache directory %s: %v, %v", dir, fi, err)
+		}
+	}
+
+	// files and directories owned by other users are rejected
+	file := filepath.Join(tmpDir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("Failed to create file: %s", err)
+	}
+	if err := NewStore(gpfile.NewStore(), WithCacheDir(file)).prepareCacheDir(); err == nil {
+		t.Fatalf("Expected error using a file as cache directory, got none")
+	}
+	if os.Geteuid() == 0 {
+		planted := filepath.Join(tmpDir, "planted")
+		if err := os.Mkdir(planted, 0777); err != nil {
+			t.Fatalf("Failed to create directory: %s", err)
+		}
+		if err := os.Chown(planted, 65534, 65534); err != nil {
+			t.Fatalf("Failed to change owner: %s", err)
+		}
+		if err := NewStore(gpfile.NewStore(), WithCacheDir(planted)).prepareCacheDir(); err == nil {
+			t.Fatalf("Expected error using a directory owned by another user, got none")
+		}
+	}
+}
+
+// stubCountingStore counts the archive stubs read from the wrapped store
+type stubCountingStore struct {
+	storage.Store
+	stubReads int
+}
+
+func (s *stubCountingStore) ReadFile(path string) ([]byte, error) {
+	if filepath.Base(path) == StubFileName {
+		s.stubReads++
+	}
+	return s.Store.ReadFile(path)
+}
+
+func testBlock(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 64*(i+1))
+}
+
+func writeTestColumn(t *testing.T, path string) {
+	for i := 0; i < 3; i++ {
+		gpf, err := gpfile.New(path, gpfile.ModeWrite)
+		if err != nil {
+			t.Fatalf("Failed to create GPFile: %s", err)
+		}
+		if err := gpf.WriteBlock(testDay+int64(i)*300, testBlock(i)); err != nil {
+			t.Fatalf("Failed to write block: %s", err)
+		}
+		if err := gpf.Close(); err != nil {
+			t.Fatalf("Failed to close GPFile: %s", err)
+		}
+	}
+}
+
+func checkTestColumn(t *testing.T, store storage.Store, path string) {
+	backend, err := store.Open(path, storage.ModeRead, encoders.EncoderTypeLZ4)
+	if err != nil {
+		t.Fatalf("Failed to open archived backend: %s", err)
+	}
+	defer backend.Close()
+
+	blocks, err := backend.Blocks()
+	if err != nil {
+		t.Fatalf("Failed to get blocks: %s", err)
+	}
+	if len(blocks.Blocks) != 3 {
+		t.Fatalf("Unexpected number of blocks: %d", len(blocks.Blocks))
+	}
+	for i, block := range blocks.OrderedList() {
+		data, err := backend.ReadBlock(block.Timestamp)
+		if err != nil {
+			t.Fatalf("Failed to read block %d: %s", i, err)
+		}
+		if !bytes.Equal(data, testBlock(i)) {
+			t.Fatalf("Unexpected data in block %d", i)
+		}
+	}
+}
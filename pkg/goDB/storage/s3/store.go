@@ -0,0 +1,386 @@
+package s3
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+const (
+	// DefaultMaxCacheSize denotes the default size limit of the local cache (in bytes)
+	DefaultMaxCacheSize = 4 * 1024 * 1024 * 1024
+
+	// AccessKeyEnv and SecretKeyEnv denote the environment variables the default
+	// credentials are read from
+	AccessKeyEnv = "AWS_ACCESS_KEY_ID"
+	SecretKeyEnv = "AWS_SECRET_ACCESS_KEY"
+
+	// the cache holds flow data and is hence only accessible by its owner
+	cacheDirPermissions = 0700
+)
+
+// DefaultCacheDir returns the directory archived files are cached in by default, i.e.
+// goprobe_archive_cache in the user's cache directory (e.g. ~/.cache on Linux)
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("No archive cache directory available: %s", err)
+	}
+	return filepath.Join(dir, "goprobe_archive_cache"), nil
+}
+
+// Store implements an archive-aware storage.Store. All operations are passed through
+// to the underlying local store, except for opening backends of archived days: these
+// are fetched from object storage on demand and served from a local cache
+type Store struct {
+	storage.Store
+
+	cacheDir     string
+	maxCacheSize int64
+
+	// the cache directory is checked once, before it is used for the first time
+	cacheDirOnce sync.Once
+	cacheDirErr  error
+
+	// stubsMutex guards the archive stubs of the days opened so far (nil for days which
+	// haven't been archived), which are read once per day rather than once per column
+	stubsMutex sync.Mutex
+	stubs      map[string]*Stub
+
+	accessKey string
+	secretKey string
+
+	// keyring provides the keys to decrypt blocks of archived days
+	keyring *encryption.Keyring
+
+	// fetchMutex serializes downloads and cache maintenance
+	fetchMutex sync.Mutex
+
+	clientsMutex sync.Mutex
+	clients      map[string]*Client
+}
+
+// StoreOption allows to set optional parameters of the archive-aware store
+type StoreOption func(*Store)
+
+// WithCacheDir sets the directory archived files are cached in (see DefaultCacheDir).
+// It is created if required and must only be accessible by the current user
+func WithCacheDir(dir string) StoreOption {
+	return func(s *Store) {
+		s.cacheDir = dir
+	}
+}
+
+// WithMaxCacheSize limits the size of the local cache (in bytes). Least recently used
+// files are evicted once the limit is exceeded. A limit <= 0 disables eviction
+func WithMaxCacheSize(size int64) StoreOption {
+	return func(s *Store) {
+		s.maxCacheSize = size
+	}
+}
+
+// WithCredentials sets the credentials used to access object storage. By default, they
+// are read from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables
+func WithCredentials(accessKey, secretKey string) StoreOption {
+	return func(s *Store) {
+		s.accessKey = accessKey
+		s.secretKey = secretKey
+	}
+}
+
+// WithKeyring sets the keys used to decrypt blocks of archived days. The local store
+// has to be configured separately
+func WithKeyring(keyring *encryption.Keyring) StoreOption {
+	return func(s *Store) {
+		s.keyring = keyring
+	}
+}
+
+// NewStore wraps the local store to make archived days accessible
+func NewStore(local storage.Store, opts ...StoreOption) *Store {
+	s := &Store{
+		Store:        local,
+		maxCacheSize: DefaultMaxCacheSize,
+		accessKey:    os.Getenv(AccessKeyEnv),
+		secretKey:    os.Getenv(SecretKeyEnv),
+		stubs:        make(map[string]*Stub),
+		clients:      make(map[string]*Client),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Open returns the backend located at path. If the day the backend belongs to has been
+// archived, its data is fetched into the local cache first. Archived days are read-only
+func (s *Store) Open(path string, mode storage.Mode, encoderType encoders.Type) (storage.Backend, error) {
+	stub, err := s.stub(filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read archive stub for %s: %s", path, err)
+	}
+	if stub == nil {
+		return s.Store.Open(path, mode, encoderType)
+	}
+
+	if mode != storage.ModeRead {
+		return nil, fmt.Errorf("Cannot write to %s: day has been archived", path)
+	}
+
+	cachedPath, err := s.fetch(stub, filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+
+	return gpfile.New(cachedPath, gpfile.ModeRead, gpfile.WithEncoder(encoderType), gpfile.WithKeyring(s.keyring))
+}
+
+// stub returns the archive stub of the day located at dir (nil if it hasn't been
+// archived)
+func (s *Store) stub(dir string) (*Stub, error) {
+	s.stubsMutex.Lock()
+	defer s.stubsMutex.Unlock()
+
+	if stub, exists := s.stubs[dir]; exists {
+		return stub, nil
+	}
+	stub, err := ReadStub(s.Store, dir)
+	if err != nil {
+		return nil, err
+	}
+	s.stubs[dir] = stub
+	return stub, nil
+}
+
+// Rename moves the file or directory located at oldPath to newPath, forgetting the
+// archive stubs read for the days below either of them
+func (s *Store) Rename(oldPath, newPath string) error {
+	err := s.Store.Rename(oldPath, newPath)
+	s.forgetStubs(oldPath, newPath)
+	return err
+}
+
+// RemoveAll removes path along with everything below it, forgetting the archive stubs
+// read for the days below it
+func (s *Store) RemoveAll(path string) error {
+	err := s.Store.RemoveAll(path)
+	s.forgetStubs(path)
+	return err
+}
+
+// forgetStubs drops the archive stubs of the days located at or below any of the paths
+func (s *Store) forgetStubs(paths ...string) {
+	s.stubsMutex.Lock()
+	defer s.stubsMutex.Unlock()
+
+	for dir := range s.stubs {
+		for _, path := range paths {
+			path = filepath.Clean(path)
+			if dir == path || strings.HasPrefix(dir, path+string(filepath.Separator)) {
+				delete(s.stubs, dir)
+				break
+			}
+		}
+	}
+}
+
+// fetch makes sure the data file (and header) of an archived column are available in
+// the local cache and returns the path of the cached data file
+func (s *Store) fetch(stub *Stub, name string) (string, error) {
+	headerName := name + gpfile.HeaderFileSuffix
+	if _, exists := stub.Files[headerName]; !exists {
+		return "", fmt.Errorf("Backend invalid: %s", &os.PathError{Op: "open", Path: stub.Key(headerName), Err: os.ErrNotExist})
+	}
+
+	// the data file only exists if at least one non-empty block has been written
+	names := []string{headerName}
+	if _, exists := stub.Files[name]; exists {
+		names = append(names, name)
+	}
+
+	s.cacheDirOnce.Do(func() {
+		s.cacheDirErr = s.prepareCacheDir()
+	})
+	if s.cacheDirErr != nil {
+		return "", s.cacheDirErr
+	}
+
+	s.fetchMutex.Lock()
+	defer s.fetchMutex.Unlock()
+
+	var (
+		paths   = make(map[string]struct{}, len(names))
+		fetched bool
+	)
+	for _, n := range names {
+		path := s.cachePath(stub, n)
+		paths[path] = struct{}{}
+
+		// use the cached copy if it is complete, marking it as recently used
+		if fi, err := os.Stat(path); err == nil && fi.Size() == stub.Files[n] {
+			now := timeNow()
+			os.Chtimes(path, now, now)
+			continue
+		}
+
+		client, err := s.client(stub)
+		if err != nil {
+			return "", err
+		}
+		data, err := client.GetObject(stub.Key(n))
+		if err != nil {
+			return "", err
+		}
+		if int64(len(data)) != stub.Files[n] {
+			return "", fmt.Errorf("Unexpected size of archived object %q: want %d, have %d", stub.Key(n), stub.Files[n], len(data))
+		}
+		if err := writeFileAtomic(path, data); err != nil {
+			return "", fmt.Errorf("Failed to cache archived object %q: %s", stub.Key(n), err)
+		}
+		fetched = true
+	}
+
+	if fetched && s.maxCacheSize > 0 {
+		if err := s.evict(paths); err != nil {
+			return "", fmt.Errorf("Failed to clean up archive cache: %s", err)
+		}
+	}
+
+	return s.cachePath(stub, name), nil
+}
+
+// prepareCacheDir creates the cache directory unless it exists. Cached files are read as
+// DB data, hence a directory owned (e.g. planted in advance) by another user is rejected
+// and the directory is made inaccessible to other users
+func (s *Store) prepareCacheDir() (err error) {
+	if s.cacheDir == "" {
+		if s.cacheDir, err = DefaultCacheDir(); err != nil {
+			return err
+		}
+	}
+	if err = os.MkdirAll(s.cacheDir, cacheDirPermissions); err != nil {
+		return fmt.Errorf("Failed to create archive cache %s: %s", s.cacheDir, err)
+	}
+
+	fi, err := os.Lstat(s.cacheDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("Archive cache %s is not a directory", s.cacheDir)
+	}
+	if stat, ok := fi.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("Archive cache %s is not owned by the current user", s.cacheDir)
+	}
+	if fi.Mode().Perm()&^cacheDirPermissions != 0 {
+		return os.Chmod(s.cacheDir, cacheDirPermissions)
+	}
+	return nil
+}
+
+func (s *Store) cachePath(stub *Stub, name string) string {
+	return filepath.Join(s.cacheDir, stub.Bucket, filepath.FromSlash(stub.Key(name)))
+}
+
+func (s *Store) client(stub *Stub) (*Client, error) {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	id := stub.Endpoint + "/" + stub.Bucket
+	if client, exists := s.clients[id]; exists {
+		return client, nil
+	}
+
+	client, err := NewClient(Config{
+		Endpoint:  stub.Endpoint,
+		Bucket:    stub.Bucket,
+		Region:    stub.Region,
+		AccessKey: s.accessKey,
+		SecretKey: s.secretKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.clients[id] = client
+
+	return client, nil
+}
+
+// evict removes the least recently used files from the cache until it fits the
+// configured size limit. Files in keep are never removed
+func (s *Store) evict(keep map[string]struct{}) error {
+	type cachedFile struct {
+		path string
+		info os.FileInfo
+	}
+
+	var (
+		files     []cachedFile
+		totalSize int64
+	)
+	err := filepath.Walk(s.cacheDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, cachedFile{path, info})
+			totalSize += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].info.ModTime().Before(files[j].info.ModTime())
+	})
+	for _, f := range files {
+		if totalSize <= s.maxCacheSize {
+			break
+		}
+		if _, exists := keep[f.path]; exists {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		totalSize -= f.info.Size()
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file, which is then moved to path
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, cacheDirPermissions); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
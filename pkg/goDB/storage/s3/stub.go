@@ -0,0 +1,66 @@
+package s3
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+// StubFileName denotes the name of the marker file replacing the data of an archived day
+const StubFileName = "archived.json"
+
+// Stub describes where the data of an archived day is located. It deliberately does not
+// contain any credentials
+type Stub struct {
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	Region   string `json:"region,omitempty"`
+
+	// KeyPrefix denotes the common prefix of all objects belonging to the day
+	KeyPrefix string `json:"key_prefix"`
+
+	// Files maps the names of all archived files to their size
+	Files map[string]int64 `json:"files"`
+
+	// Archived denotes the time the day was archived (unix timestamp)
+	Archived int64 `json:"archived"`
+}
+
+// Key returns the object key of an archived file
+func (s *Stub) Key(name string) string {
+	if s.KeyPrefix == "" {
+		return name
+	}
+	return s.KeyPrefix + "/" + name
+}
+
+// FileNames returns the sorted names of all archived files
+func (s *Stub) FileNames() []string {
+	names := make([]string, 0, len(s.Files))
+	for name := range s.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadStub returns the stub of the day located at dir. If the day has not been
+// archived, nil is returned without an error
+func ReadStub(store storage.Store, dir string) (*Stub, error) {
+	data, err := store.ReadFile(filepath.Join(dir, StubFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var stub Stub
+	if err := json.Unmarshal(data, &stub); err != nil {
+		return nil, err
+	}
+	return &stub, nil
+}
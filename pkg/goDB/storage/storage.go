@@ -2,17 +2,29 @@ package storage
 
 import (
 	"sort"
-	"time"
 
 	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
 )
 
+// Mode denotes the access mode of a storage backend
+type Mode int
+
+// Enumeration of access modes. To avoid race conditions and unpredictable behavior,
+// a backend can only be opened in one of the modes at a time
+const (
+	ModeRead  Mode = iota // read access
+	ModeWrite             // write / append access
+)
+
 // Block denotes a block of goprobe data
 type Block struct {
 	EncoderType encoders.Type `json:"e,omitempty"`
 	Offset      int64         `json:"p,omitempty"`
 	Len         int           `json:"l,omitempty"`
 	RawLen      int           `json:"r,omitempty"`
+
+	// KeyID identifies the key used to encrypt the block (if any)
+	KeyID string `json:"k,omitempty"`
 }
 
 // BlockHeader denotes a list of blocks pertaining to a storage backend
@@ -53,11 +65,62 @@ type Backend interface {
 	Blocks() (BlockHeader, error)
 
 	// ReadBlock searches if a block for a given timestamp exists and returns in its data
-	ReadBlock(timestamp time.Time) ([]byte, error)
+	ReadBlock(timestamp int64) ([]byte, error)
 
 	// WriteBlock writes data for a given timestamp to storage
-	WriteBlock(timestamp time.Time, blockData []byte) error
+	WriteBlock(timestamp int64, blockData []byte) error
 
 	// Close closes a storage backend
 	Close() error
 }
+
+// BlockReleaser is implemented by backends which read blocks into pooled buffers. Once the
+// data returned by ReadBlock isn't referenced anymore, it can be returned to the pool
+type BlockReleaser interface {
+	ReleaseBlock(data []byte)
+}
+
+// Store provides access to the storage backends of a goDB, as well as to the
+// directory structure and auxiliary files (e.g. metadata) surrounding them.
+// All paths are interpreted in the same way as file system paths, i.e. the
+// backend for the sip column of interface eth0 on a given day is found at
+// <dbpath>/eth0/<day timestamp>/sip.gpf
+type Store interface {
+
+	// Open returns the backend located at path. In write mode, blocks are compressed
+	// with the requested encoder (if supported by the store)
+	Open(path string, mode Mode, encoderType encoders.Type) (Backend, error)
+
+	// ReadDir returns the (sorted) names of all directories located directly below path
+	ReadDir(path string) ([]string, error)
+
+	// MkdirAll creates the directory path, along with all necessary parents
+	MkdirAll(path string) error
+
+	// ReadFile returns the contents of the auxiliary file located at path
+	ReadFile(path string) ([]byte, error)
+
+	// WriteFile replaces the contents of the auxiliary file located at path
+	WriteFile(path string, data []byte) error
+
+	// AppendFile appends data to the auxiliary file located at path, creating it
+	// if it does not exist yet
+	AppendFile(path string, data []byte) error
+
+	// CreateFile creates the empty auxiliary file located at path. It fails with an error
+	// satisfying os.IsExist if the file exists already, allowing it to be used as lock
+	CreateFile(path string) error
+
+	// CopyDir copies the backends and auxiliary files located directly below the
+	// directory src to the directory dst, which must not exist yet. Blocks are copied
+	// as they are, i.e. without being decoded
+	CopyDir(src, dst string) error
+
+	// Rename moves the file or directory (along with everything below it) located at
+	// oldPath to newPath
+	Rename(oldPath, newPath string) error
+
+	// RemoveAll removes path along with everything below it. It succeeds if path does
+	// not exist
+	RemoveAll(path string) error
+}
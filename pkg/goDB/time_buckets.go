@@ -0,0 +1,93 @@
+package goDB
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTimeResolution parses the size of the buckets the time attribute is aggregated
+// into. Besides the durations understood by time.ParseDuration, a number of days can be
+// given, e.g. "7d". The resolution must be a multiple of the DB's write interval and
+// either divide a day or be a multiple of a day, so that buckets align to midnight
+func ParseTimeResolution(s string) (time.Duration, error) {
+	var (
+		resolution time.Duration
+		err        error
+	)
+	if strings.HasSuffix(s, "d") {
+		var days int
+		days, err = strconv.Atoi(strings.TrimSuffix(s, "d"))
+		resolution = time.Duration(int64(days)*EpochDay) * time.Second
+	} else {
+		resolution, err = time.ParseDuration(s)
+	}
+	if err != nil {
+		return 0, errors.New("Invalid time resolution \"" + s + "\"")
+	}
+
+	seconds := int64(resolution / time.Second)
+	switch {
+	case resolution <= 0 || resolution%time.Second != 0 || seconds%DBWriteInterval != 0:
+		return 0, errors.New("Time resolution \"" + s + "\" is not a positive multiple of " + strconv.FormatInt(DBWriteInterval/60, 10) + " minutes")
+	case EpochDay%seconds != 0 && seconds%EpochDay != 0:
+		return 0, errors.New("Time resolution \"" + s + "\" neither divides nor is a multiple of a day")
+	}
+	return resolution, nil
+}
+
+// TimeBucket returns the start of the bucket of the given resolution holding the block
+// written at tstamp (i.e. covering the DBWriteInterval before tstamp). Buckets are
+// aligned to the wall clock of loc: hourly buckets start at the full hour, daily buckets
+// at midnight, and multi-day buckets count the days since the epoch. If the clock is set
+// back, the repeated wall clock times form buckets of their own
+func TimeBucket(tstamp int64, resolution time.Duration, loc *time.Location) int64 {
+	blockStart := tstamp - DBWriteInterval
+	wall := wallClock(blockStart, loc)
+	return fromWallClock(wall-floorMod(wall, int64(resolution/time.Second)), blockStart, loc)
+}
+
+// NextTimeBucket returns the start of the bucket following the one starting at bucket
+func NextTimeBucket(bucket int64, resolution time.Duration, loc *time.Location) int64 {
+	// buckets are shorter or longer than the resolution if the clock is adjusted within
+	// them, hence the blocks after the jump are checked until a new bucket starts
+	for tstamp := bucket + int64(resolution/time.Second) + DBWriteInterval; ; tstamp += DBWriteInterval {
+		if next := TimeBucket(tstamp, resolution, loc); next > bucket {
+			return next
+		}
+	}
+}
+
+// wallClock returns the wall clock time of tstamp in loc, in seconds since the epoch
+func wallClock(tstamp int64, loc *time.Location) int64 {
+	t := time.Unix(tstamp, 0).In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC).Unix()
+}
+
+// fromWallClock converts a wall clock time in loc back into a timestamp. Wall clock times
+// occurring twice resolve to the last occurrence not after before, those skipped (e.g.
+// when switching to daylight saving time) to the time after the gap
+func fromWallClock(wall, before int64, loc *time.Location) int64 {
+	u := time.Unix(wall, 0).UTC()
+	t := time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, loc).Unix()
+
+	// check the occurrences with the offsets in effect around t
+	result := t
+	for _, shift := range []int64{-12 * 3600, 12 * 3600} {
+		_, offset := time.Unix(t+shift, 0).In(loc).Zone()
+		if candidate := wall - int64(offset); candidate <= before && wallClock(candidate, loc) == wall &&
+			(result > before || candidate > result) {
+			result = candidate
+		}
+	}
+	return result
+}
+
+func floorMod(a, b int64) int64 {
+	m := a % b
+	if m < 0 {
+		m += b
+	}
+	return m
+}
@@ -0,0 +1,129 @@
+package goDB
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeResolution(t *testing.T) {
+	var tests = []struct {
+		in         string
+		resolution time.Duration
+		success    bool
+	}{
+		{"5m", 5 * time.Minute, true},
+		{"1h", time.Hour, true},
+		{"90m", 90 * time.Minute, true},
+		{"1d", 24 * time.Hour, true},
+		{"7d", 7 * 24 * time.Hour, true},
+		{"48h", 48 * time.Hour, true},
+		{"7m", 0, false},
+		{"7h", 0, false},
+		{"36h", 0, false},
+		{"0d", 0, false},
+		{"-1h", 0, false},
+		{"1.5d", 0, false},
+		{"hourly", 0, false},
+		{"", 0, false},
+	}
+
+	for _, test := range tests {
+		resolution, err := ParseTimeResolution(test.in)
+		if test.success != (err == nil) {
+			t.Fatalf("%q: unexpected error: %v", test.in, err)
+		}
+		if resolution != test.resolution {
+			t.Fatalf("%q: want %s, have %s", test.in, test.resolution, resolution)
+		}
+	}
+}
+
+func TestTimeBucket(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skipf("Time zone database not available: %s", err)
+	}
+	kolkata, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skipf("Time zone database not available: %s", err)
+	}
+
+	date := func(loc *time.Location, month time.Month, day, hour, min int) int64 {
+		return time.Date(2021, month, day, hour, min, 0, 0, loc).Unix()
+	}
+
+	var tests = []struct {
+		tstamp     int64
+		resolution time.Duration
+		loc        *time.Location
+		bucket     int64
+	}{
+		// the block written at the full hour belongs to the previous hour
+		{date(time.UTC, 3, 1, 10, 5), time.Hour, time.UTC, date(time.UTC, 3, 1, 10, 0)},
+		{date(time.UTC, 3, 1, 11, 0), time.Hour, time.UTC, date(time.UTC, 3, 1, 10, 0)},
+		{date(time.UTC, 3, 1, 0, 0), 24 * time.Hour, time.UTC, date(time.UTC, 2, 28, 0, 0)},
+		{date(time.UTC, 3, 1, 23, 55), 15 * time.Minute, time.UTC, date(time.UTC, 3, 1, 23, 45)},
+
+		// buckets align to the local wall clock, even with offsets of half an hour
+		{date(kolkata, 3, 1, 10, 5), time.Hour, kolkata, date(kolkata, 3, 1, 10, 0)},
+		{date(kolkata, 3, 1, 10, 5), 24 * time.Hour, kolkata, date(kolkata, 3, 1, 0, 0)},
+		{date(zurich, 3, 1, 0, 5), 24 * time.Hour, zurich, date(zurich, 3, 1, 0, 0)},
+		{date(zurich, 3, 1, 0, 5), 24 * time.Hour, time.UTC, date(time.UTC, 2, 28, 0, 0)},
+
+		// days with a switch to / from daylight saving time are 23 / 25 hours long
+		{date(zurich, 3, 28, 23, 55), 24 * time.Hour, zurich, date(zurich, 3, 28, 0, 0)},
+		{date(zurich, 3, 28, 12, 0), 6 * time.Hour, zurich, date(zurich, 3, 28, 6, 0)},
+		{date(zurich, 10, 31, 23, 55), 24 * time.Hour, zurich, date(zurich, 10, 31, 0, 0)},
+
+		// the hour from 02:00 to 03:00 repeats when switching back to standard time
+		{date(time.UTC, 10, 31, 0, 10), time.Hour, zurich, date(time.UTC, 10, 31, 0, 0)},
+		{date(time.UTC, 10, 31, 1, 10), time.Hour, zurich, date(time.UTC, 10, 31, 1, 0)},
+
+		// multi-day buckets count the days since the epoch (1970-01-01 was a Thursday)
+		{date(zurich, 3, 3, 12, 0), 7 * 24 * time.Hour, zurich, date(zurich, 2, 25, 0, 0)},
+		{date(zurich, 3, 4, 0, 5), 7 * 24 * time.Hour, zurich, date(zurich, 3, 4, 0, 0)},
+	}
+
+	for _, test := range tests {
+		bucket := TimeBucket(test.tstamp, test.resolution, test.loc)
+		if bucket != test.bucket {
+			t.Fatalf("Bucket of %s (%s, %s): want %s, have %s", time.Unix(test.tstamp, 0).In(test.loc),
+				test.resolution, test.loc, time.Unix(test.bucket, 0).In(test.loc), time.Unix(bucket, 0).In(test.loc))
+		}
+	}
+}
+
+func TestNextTimeBucket(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skipf("Time zone database not available: %s", err)
+	}
+
+	// iterating over the buckets around the switches to and from daylight saving time
+	// yields the buckets of the blocks in the same order
+	for _, day := range []int64{
+		time.Date(2021, 3, 27, 0, 0, 0, 0, zurich).Unix(),
+		time.Date(2021, 10, 30, 0, 0, 0, 0, zurich).Unix(),
+	} {
+		for _, resolution := range []time.Duration{15 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour} {
+			var buckets []int64
+			for tstamp := day + DBWriteInterval; tstamp <= day+3*EpochDay; tstamp += DBWriteInterval {
+				bucket := TimeBucket(tstamp, resolution, zurich)
+				if len(buckets) > 0 && bucket < buckets[len(buckets)-1] {
+					t.Fatalf("%s: block %s: bucket %s precedes bucket %s", resolution, time.Unix(tstamp, 0).In(zurich),
+						time.Unix(bucket, 0).In(zurich), time.Unix(buckets[len(buckets)-1], 0).In(zurich))
+				}
+				if len(buckets) == 0 || bucket != buckets[len(buckets)-1] {
+					buckets = append(buckets, bucket)
+				}
+			}
+
+			for i := 1; i < len(buckets); i++ {
+				if next := NextTimeBucket(buckets[i-1], resolution, zurich); next != buckets[i] {
+					t.Fatalf("%s: bucket following %s: want %s, have %s", resolution, time.Unix(buckets[i-1], 0).In(zurich),
+						time.Unix(buckets[i], 0).In(zurich), time.Unix(next, 0).In(zurich))
+				}
+			}
+		}
+	}
+}
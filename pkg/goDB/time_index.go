@@ -0,0 +1,240 @@
+package goDB
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+const (
+	// TimeIndexFileName denotes the per-interface index of block timestamps
+	TimeIndexFileName = "timeindex.bin"
+
+	// timeIndexVersion denotes the version of the time index format
+	timeIndexVersion = 1
+)
+
+// TimeIndex lists the timestamps of all blocks of an interface, allowing to plan queries
+// without opening the column files of each day. It is stored as a version byte followed
+// by the (zigzag varint encoded) differences between subsequently written timestamps,
+// which allows the DBWriter to append to it
+type TimeIndex struct {
+	timestamps []int64 // sorted, without duplicates
+
+	// last denotes the timestamp appended last, which the next one is encoded relative to
+	last int64
+}
+
+var errTimeIndexCorrupt = errors.New("Time index corrupt")
+
+// ReadTimeIndex reads the time index of the interface directory located at ifacePath
+func ReadTimeIndex(store storage.Store, ifacePath string) (*TimeIndex, error) {
+	data, err := store.ReadFile(filepath.Join(ifacePath, TimeIndexFileName))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 || data[0] != timeIndexVersion {
+		return nil, errTimeIndexCorrupt
+	}
+
+	t := new(TimeIndex)
+	for pos := 1; pos < len(data); {
+		delta, n := binary.Varint(data[pos:])
+		if n <= 0 {
+			return nil, errTimeIndexCorrupt
+		}
+		pos += n
+		t.last += delta
+		t.timestamps = append(t.timestamps, t.last)
+	}
+	t.normalize()
+
+	return t, nil
+}
+
+// RebuildTimeIndex rebuilds the time index of the interface directory located at
+// ifacePath from the block headers of all its days and writes it
+func RebuildTimeIndex(store storage.Store, ifacePath string) (*TimeIndex, error) {
+	dirs, err := store.ReadDir(ifacePath)
+	if err != nil {
+		return nil, err
+	}
+
+	t := new(TimeIndex)
+	for _, dir := range dirs {
+		if _, err := strconv.ParseInt(dir, 10, 64); err != nil {
+			continue
+		}
+		timestamps, err := dayBlockTimestamps(store, filepath.Join(ifacePath, dir))
+		if err != nil {
+			return nil, err
+		}
+		t.timestamps = append(t.timestamps, timestamps...)
+	}
+	t.normalize()
+
+	return t, t.Write(store, ifacePath)
+}
+
+// dayBlockTimestamps returns the timestamps of all blocks stored in a day directory
+func dayBlockTimestamps(store storage.Store, dayPath string) ([]int64, error) {
+	path := filepath.Join(dayPath, columnFileNames[BytesRcvdColIdx]+".gpf")
+	backend, err := store.Open(path, storage.ModeRead, encoders.EncoderTypeLZ4)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read file: %s: %s", path, err)
+	}
+	defer backend.Close()
+
+	header, err := backend.Blocks()
+	if err != nil {
+		return nil, fmt.Errorf("Could not get blocks from file: %s: %s", path, err)
+	}
+	timestamps := make([]int64, 0, len(header.Blocks))
+	for _, block := range header.OrderedList() {
+		timestamps = append(timestamps, block.Timestamp)
+	}
+	return timestamps, nil
+}
+
+// Write (over)writes the time index of the interface directory located at ifacePath
+func (t *TimeIndex) Write(store storage.Store, ifacePath string) error {
+	data := []byte{timeIndexVersion}
+	buf := make([]byte, binary.MaxVarintLen64)
+
+	t.last = 0
+	for _, ts := range t.timestamps {
+		n := binary.PutVarint(buf, ts-t.last)
+		data = append(data, buf[:n]...)
+		t.last = ts
+	}
+
+	return store.WriteFile(filepath.Join(ifacePath, TimeIndexFileName), data)
+}
+
+// appendTimestamp adds the timestamp to the index and appends it to the index file of the
+// interface directory located at ifacePath
+func (t *TimeIndex) appendTimestamp(store storage.Store, ifacePath string, timestamp int64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutVarint(buf, timestamp-t.last)
+	if err := store.AppendFile(filepath.Join(ifacePath, TimeIndexFileName), buf[:n]); err != nil {
+		return err
+	}
+	t.last = timestamp
+
+	// blocks are usually appended in order, hence sorting is rarely required
+	t.timestamps = append(t.timestamps, timestamp)
+	if n := len(t.timestamps); n > 1 && t.timestamps[n-2] >= timestamp {
+		t.normalize()
+	}
+	return nil
+}
+
+// contains returns true if the index holds a block with the given timestamp
+func (t *TimeIndex) contains(timestamp int64) bool {
+	i := sort.Search(len(t.timestamps), func(i int) bool {
+		return t.timestamps[i] >= timestamp
+	})
+	return i < len(t.timestamps) && t.timestamps[i] == timestamp
+}
+
+// normalize sorts the timestamps and removes duplicates
+func (t *TimeIndex) normalize() {
+	sort.Slice(t.timestamps, func(i, j int) bool {
+		return t.timestamps[i] < t.timestamps[j]
+	})
+
+	var n int
+	for i, ts := range t.timestamps {
+		if i == 0 || ts != t.timestamps[n-1] {
+			t.timestamps[n] = ts
+			n++
+		}
+	}
+	t.timestamps = t.timestamps[:n]
+}
+
+// Len returns the number of blocks in the index
+func (t *TimeIndex) Len() int {
+	return len(t.timestamps)
+}
+
+// First returns the timestamp of the first block (or 0 if the index is empty)
+func (t *TimeIndex) First() int64 {
+	if len(t.timestamps) == 0 {
+		return 0
+	}
+	return t.timestamps[0]
+}
+
+// Last returns the timestamp of the last block (or 0 if the index is empty)
+func (t *TimeIndex) Last() int64 {
+	if len(t.timestamps) == 0 {
+		return 0
+	}
+	return t.timestamps[len(t.timestamps)-1]
+}
+
+// Days returns the number of days holding at least one block
+func (t *TimeIndex) Days() int {
+	var (
+		n       int
+		lastDay int64 = -1
+	)
+	for _, ts := range t.timestamps {
+		if day := DayTimestamp(ts); day != lastDay {
+			n++
+			lastDay = day
+		}
+	}
+	return n
+}
+
+// Coverage returns the fraction of write intervals between the first and last block for
+// which a block is present
+func (t *TimeIndex) Coverage() float64 {
+	if len(t.timestamps) == 0 {
+		return 0
+	}
+	return float64(len(t.timestamps)) / float64((t.Last()-t.First())/DBWriteInterval+1)
+}
+
+// DayBlocks returns the timestamps of all blocks of the day starting at dayTimestamp
+// selected by a query for the time range from tfirst to tlast. The second return value
+// is false if the index doesn't contain any blocks of that day
+func (t *TimeIndex) DayBlocks(dayTimestamp, tfirst, tlast int64) ([]int64, bool) {
+	i := sort.Search(len(t.timestamps), func(i int) bool {
+		return t.timestamps[i] >= dayTimestamp
+	})
+	j := sort.Search(len(t.timestamps), func(i int) bool {
+		return t.timestamps[i] >= dayTimestamp+EpochDay
+	})
+	if i == j {
+		return nil, false
+	}
+
+	var selected []int64
+	for _, ts := range t.timestamps[i:j] {
+		if tfirst < ts && ts < tlast+DBWriteInterval {
+			selected = append(selected, ts)
+		}
+	}
+	return selected, true
+}
+
+// Remove drops all blocks for which remove returns true from the index
+func (t *TimeIndex) Remove(remove func(timestamp int64) bool) {
+	var n int
+	for _, ts := range t.timestamps {
+		if !remove(ts) {
+			t.timestamps[n] = ts
+			n++
+		}
+	}
+	t.timestamps = t.timestamps[:n]
+}
@@ -0,0 +1,159 @@
+package goDB
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+)
+
+// writeIndexTestBlocks writes a block for each of the timestamps
+func writeIndexTestBlocks(t *testing.T, w *DBWriter, timestamps ...int64) {
+	t.Helper()
+	for _, ts := range timestamps {
+		flowmap := AggFlowMap{testStatsKey("10.0.0.1", "10.0.0.2", 443, 6): &Val{NBytesRcvd: 1}}
+		if _, err := w.Write(flowmap, BlockMetadata{Timestamp: ts}, ts); err != nil {
+			t.Fatalf("Failed to write flows: %s", err)
+		}
+	}
+}
+
+func TestTimeIndex(t *testing.T) {
+	const timestamp = int64(1456358400 + 300)
+
+	tmpDir, err := ioutil.TempDir("", "timeindex")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var (
+		store     = gpfile.NewStore()
+		ifacePath = filepath.Join(tmpDir, "eth0")
+		want      = []int64{timestamp, timestamp + DBWriteInterval, timestamp + 2*DBWriteInterval, timestamp + 4*DBWriteInterval, timestamp + EpochDay}
+	)
+
+	// the last but one block arrives late
+	writeIndexTestBlocks(t, NewDBWriter(tmpDir, "eth0", encoders.EncoderTypeLZ4), want[0], want[1], want[3], want[4], want[2])
+
+	index, err := ReadTimeIndex(store, ifacePath)
+	if err != nil {
+		t.Fatalf("Failed to read time index: %s", err)
+	}
+	if !reflect.DeepEqual(index.timestamps, want) {
+		t.Fatalf("Unexpected timestamps in index: want %v, have %v", want, index.timestamps)
+	}
+	if index.Days() != 2 || index.First() != want[0] || index.Last() != want[4] {
+		t.Fatalf("Unexpected index statistics: %d days from %d to %d", index.Days(), index.First(), index.Last())
+	}
+	if coverage := index.Coverage(); coverage != float64(len(want))/float64(EpochDay/DBWriteInterval+1) {
+		t.Fatalf("Unexpected coverage: %f", coverage)
+	}
+
+	var tests = []struct {
+		day, first, last int64
+		want             []int64
+		exists           bool
+	}{
+		{DayTimestamp(timestamp), 0, timestamp + EpochDay, want[:4], true},
+		{DayTimestamp(timestamp), timestamp, timestamp + 2*DBWriteInterval, want[1:3], true},
+		{DayTimestamp(timestamp), timestamp + 5*DBWriteInterval, timestamp + EpochDay, nil, true},
+		{DayTimestamp(timestamp) + EpochDay, 0, timestamp + EpochDay, want[4:], true},
+		{DayTimestamp(timestamp) + 2*EpochDay, 0, timestamp + 3*EpochDay, nil, false},
+	}
+	for _, test := range tests {
+		selected, exists := index.DayBlocks(test.day, test.first, test.last)
+		if exists != test.exists || !reflect.DeepEqual(selected, test.want) {
+			t.Fatalf("Unexpected blocks of day %d (%d - %d): want %v (%v), have %v (%v)", test.day, test.first, test.last, test.want, test.exists, selected, exists)
+		}
+	}
+
+	// the index is rebuilt by the next writer if it is damaged
+	if err = ioutil.WriteFile(filepath.Join(ifacePath, TimeIndexFileName), []byte{timeIndexVersion, 0x80}, 0644); err != nil {
+		t.Fatalf("Failed to damage time index: %s", err)
+	}
+	if _, err = ReadTimeIndex(store, ifacePath); err == nil {
+		t.Fatalf("Reading damaged time index succeeded unexpectedly")
+	}
+	want = append(want, timestamp+EpochDay+DBWriteInterval)
+	writeIndexTestBlocks(t, NewDBWriter(tmpDir, "eth0", encoders.EncoderTypeLZ4), want[5])
+	if index, err = ReadTimeIndex(store, ifacePath); err != nil || !reflect.DeepEqual(index.timestamps, want) {
+		t.Fatalf("Unexpected timestamps in rebuilt index: want %v, have %v (%v)", want, index, err)
+	}
+
+	// blocks missing from the index (e.g. due to a crash) are added by the next writer
+	index.Remove(func(ts int64) bool {
+		return ts == want[5]
+	})
+	if err = index.Write(store, ifacePath); err != nil {
+		t.Fatalf("Failed to write time index: %s", err)
+	}
+	want = append(want, timestamp+EpochDay+2*DBWriteInterval)
+	writeIndexTestBlocks(t, NewDBWriter(tmpDir, "eth0", encoders.EncoderTypeLZ4), want[6])
+	if index, err = ReadTimeIndex(store, ifacePath); err != nil || !reflect.DeepEqual(index.timestamps, want) {
+		t.Fatalf("Unexpected timestamps in reconciled index: want %v, have %v (%v)", want, index, err)
+	}
+
+	rebuilt, err := RebuildTimeIndex(store, ifacePath)
+	if err != nil || !reflect.DeepEqual(rebuilt.timestamps, want) {
+		t.Fatalf("Unexpected timestamps after rebuild: want %v, have %v (%v)", want, rebuilt, err)
+	}
+}
+
+func TestCreateWorkerJobsTimeIndex(t *testing.T) {
+	const timestamp = int64(1456358400 + 300)
+
+	tmpDir, err := ioutil.TempDir("", "timeindex")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var timestamps []int64
+	for i := int64(0); i < 3; i++ {
+		timestamps = append(timestamps, timestamp+i*EpochDay, timestamp+i*EpochDay+DBWriteInterval)
+	}
+	writeIndexTestBlocks(t, NewDBWriter(tmpDir, "eth0", encoders.EncoderTypeLZ4), timestamps...)
+
+	plan := func() []DBWorkload {
+		t.Helper()
+		w, err := NewDBWorkManager(tmpDir, "eth0", 1)
+		if err != nil {
+			t.Fatalf("Failed to create work manager: %s", err)
+		}
+		if _, err = w.CreateWorkerJobs(timestamp, timestamp+2*EpochDay, nil); err != nil {
+			t.Fatalf("Failed to create worker jobs: %s", err)
+		}
+		return w.workloads
+	}
+
+	// the plan is identical with and without time index, and days missing from the
+	// index are planned based on their block headers
+	withIndex := plan()
+	if len(withIndex) != 3 || len(withIndex[0].load) != 1 || len(withIndex[2].load) != 1 {
+		t.Fatalf("Unexpected workloads: %+v", withIndex)
+	}
+	index, err := ReadTimeIndex(gpfile.NewStore(), filepath.Join(tmpDir, "eth0"))
+	if err != nil {
+		t.Fatalf("Failed to read time index: %s", err)
+	}
+	index.Remove(func(ts int64) bool {
+		return DayTimestamp(ts) == DayTimestamp(timestamp+EpochDay)
+	})
+	if err = index.Write(gpfile.NewStore(), filepath.Join(tmpDir, "eth0")); err != nil {
+		t.Fatalf("Failed to write time index: %s", err)
+	}
+	if partialIndex := plan(); !reflect.DeepEqual(partialIndex, withIndex) {
+		t.Fatalf("Unexpected workloads with partial index: want %+v, have %+v", withIndex, partialIndex)
+	}
+	if err = os.Remove(filepath.Join(tmpDir, "eth0", TimeIndexFileName)); err != nil {
+		t.Fatalf("Failed to remove time index: %s", err)
+	}
+	if withoutIndex := plan(); !reflect.DeepEqual(withoutIndex, withIndex) {
+		t.Fatalf("Unexpected workloads without index: want %+v, have %+v", withIndex, withoutIndex)
+	}
+}
@@ -0,0 +1,123 @@
+package goDB
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+)
+
+// summaryLockTimeout denotes how long a Writer waits for the lock of the database summary
+const summaryLockTimeout = 10 * time.Second
+
+// Writer writes flows from arbitrary sources (e.g. converted flow logs) to a database. In
+// contrast to the DBWriter used by goProbe, it handles several interfaces, validates its
+// input and keeps the database summary up to date
+type Writer struct {
+	dbPath      string
+	encoderType encoders.Type
+	store       storage.Store
+	opts        []Option
+
+	writers map[string]*DBWriter
+}
+
+// NewWriter returns a Writer for the database located at dbPath, which is created if it
+// doesn't exist. Blocks are compressed using encoderType
+func NewWriter(dbPath string, encoderType encoders.Type, opts ...Option) *Writer {
+	return &Writer{
+		dbPath:      dbPath,
+		encoderType: encoderType,
+		store:       applyOptions(opts).store,
+		opts:        opts,
+		writers:     make(map[string]*DBWriter),
+	}
+}
+
+// Write writes the flows of interface iface as the block for timestamp (in seconds since
+// epoch), denoting the end of the interval the flows were observed in. Blocks written for
+// an existing timestamp are merged with the stored ones. The metadata of the block and the
+// summary of the database are updated accordingly
+func (w *Writer) Write(iface string, timestamp int64, flows AggFlowMap) error {
+	if err := validateIface(iface); err != nil {
+		return err
+	}
+	if timestamp <= 0 {
+		return fmt.Errorf("Invalid timestamp: %d", timestamp)
+	}
+	for K, V := range flows {
+		if V == nil {
+			return fmt.Errorf("Missing counters of flow %s", K)
+		}
+		if K.IPVersion != IPv4 && K.IPVersion != IPv6 {
+			return fmt.Errorf("Invalid IP version %d of flow %s", K.IPVersion, K)
+		}
+	}
+
+	writer, exists := w.writers[iface]
+	if !exists {
+		writer = NewDBWriter(w.dbPath, iface, w.encoderType, w.opts...)
+		w.writers[iface] = writer
+	}
+
+	// the pcap statistics are not available for flows from other sources
+	meta := BlockMetadata{
+		Timestamp:            timestamp,
+		PcapPacketsReceived:  -1,
+		PcapPacketsDropped:   -1,
+		PcapPacketsIfDropped: -1,
+	}
+	update, err := writer.Write(flows, meta, timestamp)
+	if err != nil {
+		return err
+	}
+
+	return w.updateSummary(update)
+}
+
+// updateSummary adds the update to the summary of the database. The summary is shared
+// with other processes (e.g. goProbe) and therefore modified under its lock
+func (w *Writer) updateSummary(update InterfaceSummaryUpdate) error {
+	return ModifyDBSummary(w.store, w.dbPath, summaryLockTimeout, func(summ *DBSummary) (*DBSummary, error) {
+		summ.Update(update)
+		return summ, nil
+	})
+}
+
+// WriteRows groups the rows by interface and timestamp and writes them as blocks (see
+// Write). Rows sharing the same interface, timestamp and key are aggregated
+func (w *Writer) WriteRows(rows []Row) error {
+	type block struct {
+		iface     string
+		timestamp int64
+	}
+
+	var (
+		order  []block
+		blocks = make(map[block]AggFlowMap)
+	)
+	for _, row := range rows {
+		b := block{row.Iface, row.Time}
+		flows, exists := blocks[b]
+		if !exists {
+			flows = make(AggFlowMap)
+			blocks[b] = flows
+			order = append(order, b)
+		}
+
+		val := row.Val
+		if existing, exists := flows[row.Key]; exists {
+			addVal(existing, &val)
+		} else {
+			flows[row.Key] = &val
+		}
+	}
+
+	for _, b := range order {
+		if err := w.Write(b.iface, b.timestamp, blocks[b]); err != nil {
+			return fmt.Errorf("Could not write block %d of %s: %s", b.timestamp, b.iface, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package goDB
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/memory"
+)
+
+func TestWriterValidation(t *testing.T) {
+	key := testStatsKey("10.0.0.1", "10.0.0.2", 443, 6)
+	key.IPVersion = IPv4
+
+	var tests = []struct {
+		name      string
+		iface     string
+		timestamp int64
+		flows     AggFlowMap
+	}{
+		{"empty interface", "", 1456428600, AggFlowMap{key: &Val{}}},
+		{"hidden interface", ".eth0", 1456428600, AggFlowMap{key: &Val{}}},
+		{"interface path", "../eth0", 1456428600, AggFlowMap{key: &Val{}}},
+		{"invalid timestamp", "eth0", 0, AggFlowMap{key: &Val{}}},
+		{"missing counters", "eth0", 1456428600, AggFlowMap{key: nil}},
+		{"missing IP version", "eth0", 1456428600, AggFlowMap{testStatsKey("10.0.0.1", "10.0.0.2", 443, 6): &Val{}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dbPath := t.TempDir()
+			if err := NewWriter(dbPath, encoders.EncoderTypeLZ4).Write(test.iface, test.timestamp, test.flows); err == nil {
+				t.Fatalf("Expected write to fail")
+			}
+		})
+	}
+}
+
+func TestWriterMemoryStore(t *testing.T) {
+	key := testStatsKey("10.0.0.1", "10.0.0.2", 443, 6)
+	key.IPVersion = IPv4
+
+	// the database doesn't exist in the file system, so any access to it fails
+	var (
+		dbPath = filepath.Join(t.TempDir(), "db")
+		store  = memory.NewStore()
+		w      = NewWriter(dbPath, encoders.EncoderTypeLZ4, WithStore(store))
+	)
+	for i, iface := range []string{"eth0", "eth1", "eth0"} {
+		timestamp := 1456428600 + int64(i)*DBWriteInterval
+		if err := w.Write(iface, timestamp, AggFlowMap{key: &Val{NBytesRcvd: 1, NBytesSent: 2}}); err != nil {
+			t.Fatalf("Failed to write flows: %s", err)
+		}
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Fatalf("Database unexpectedly written to the file system: %v", err)
+	}
+
+	summ, err := ReadDBSummary(store, dbPath)
+	if err != nil {
+		t.Fatalf("Failed to read summary: %s", err)
+	}
+	var want = map[string]InterfaceSummary{
+		"eth0": {FlowCount: 2, Traffic: 6, Begin: 1456428600, End: 1456428600 + 2*DBWriteInterval},
+		"eth1": {FlowCount: 1, Traffic: 3, Begin: 1456428600 + DBWriteInterval, End: 1456428600 + DBWriteInterval},
+	}
+	if len(summ.Interfaces) != len(want) {
+		t.Fatalf("Unexpected summary: want %v, have %v", want, summ.Interfaces)
+	}
+	for iface, ifaceSumm := range want {
+		if summ.Interfaces[iface] != ifaceSumm {
+			t.Fatalf("Unexpected summary of %s: want %v, have %v", iface, ifaceSumm, summ.Interfaces[iface])
+		}
+	}
+}
+
+func TestReaderCanceled(t *testing.T) {
+	key := testStatsKey("10.0.0.1", "10.0.0.2", 443, 6)
+	key.IPVersion = IPv4
+
+	dbPath := t.TempDir()
+	if err := NewWriter(dbPath, encoders.EncoderTypeLZ4).Write("eth0", 1456428600, AggFlowMap{key: &Val{NBytesRcvd: 1}}); err != nil {
+		t.Fatalf("Failed to write flows: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r, err := NewReader(dbPath, WithContext(ctx))
+	if err != nil {
+		t.Fatalf("Failed to create reader: %s", err)
+	}
+	defer r.Close()
+
+	if r.Next() {
+		t.Fatalf("Unexpectedly read row after cancellation: %v", r.Row())
+	}
+	if !errors.Is(r.Err(), context.Canceled) {
+		t.Fatalf("Expected reading to be canceled, got: %v", r.Err())
+	}
+}
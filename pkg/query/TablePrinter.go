@@ -38,6 +38,9 @@ const (
 	OutcolDip
 	OutcolDport
 	OutcolProto
+	OutcolIPVersion
+	OutcolSnet
+	OutcolDnet
 	OutcolInPkts
 	OutcolInPktsPercent
 	OutcolInBytes
@@ -83,6 +86,12 @@ func columns(hasAttrTime, hasAttrIface bool, attributes []goDB.Attribute, d Dire
 			cols = append(cols, OutcolProto)
 		case "dport":
 			cols = append(cols, OutcolDport)
+		case "ipv":
+			cols = append(cols, OutcolIPVersion)
+		case "snet":
+			cols = append(cols, OutcolSnet)
+		case "dnet":
+			cols = append(cols, OutcolDnet)
 		}
 	}
 
@@ -141,9 +150,12 @@ func tryLookup(ips2domains map[string]string, ip string) string {
 
 // extract extracts the string that needs to be printed for the given OutputColumn.
 // The format argument is used to format the string appropriatly for the desired
-// output format. ips2domains is needed for reverse DNS lookups. totals is needed
-// for percentage calculations. e contains the actual data that is extracted.
-func extract(format Formatter, ips2domains map[string]string, totals Counts, e Entry, col OutputColumn) string {
+// output format. The printer's ips2domains is needed for reverse DNS lookups, its
+// totals for percentage calculations and its attributes for the prefix lengths of
+// networks. e contains the actual data that is extracted.
+func (b *basePrinter) extract(format Formatter, e Entry, col OutputColumn) string {
+	ips2domains, totals := b.ips2domains, b.totals
+
 	nz := func(u uint64) uint64 {
 		if u == 0 {
 			u = (1 << 64) - 1
@@ -167,6 +179,13 @@ func extract(format Formatter, ips2domains map[string]string, totals Counts, e E
 		return format.String(goDB.DportAttribute{}.ExtractStrings(&e.k)[0])
 	case OutcolProto:
 		return format.String(goDB.ProtoAttribute{}.ExtractStrings(&e.k)[0])
+	case OutcolIPVersion:
+		return format.String(goDB.IPVersionAttribute{}.ExtractStrings(&e.k)[0])
+	case OutcolSnet, OutcolDnet:
+		// the key escapes through the Attribute interface: copy it so that only this case
+		// allocates rather than every call of extract
+		k := e.k
+		return format.String(b.network(col).ExtractStrings(&k)[0])
 
 	case OutcolInBytes, OutcolBothBytesRcvd:
 		return format.Size(e.nBr)
@@ -218,6 +237,28 @@ func extractTotal(format Formatter, totals Counts, col OutputColumn) string {
 	}
 }
 
+// network returns the query's network attribute (snet or dnet) printed in col
+func (b *basePrinter) network(col OutputColumn) goDB.Attribute {
+	for _, attrib := range b.attributes {
+		switch attrib.(type) {
+		case goDB.SnetAttribute:
+			if col == OutcolSnet {
+				return attrib
+			}
+		case goDB.DnetAttribute:
+			if col == OutcolDnet {
+				return attrib
+			}
+		}
+	}
+	panic("network attribute not part of the query")
+}
+
+// isCounter returns whether col holds a counter (or percentage) rather than an attribute
+func isCounter(col OutputColumn) bool {
+	return col >= OutcolInPkts
+}
+
 // describe comes up with a nice string for the given SortOrder and Direction.
 func describe(o SortOrder, d Direction) string {
 	result := "accumulated "
@@ -246,13 +287,15 @@ func describe(o SortOrder, d Direction) string {
 // formats, e.g. JSON, CSV, and nicely aligned human readable text.
 //
 // You will typically want to call AddRow() for each entry you want to print
-// (in order). When you've added all rows, you can add a footer or summary with
+// (in order). For grouped queries, AddGroup() is called with the totals of each
+// group before adding the group's entries. When you've added all rows, you can add a footer or summary with
 // Footer. Not all implementations use all the arguments provided to Footer().
 // Lastly, you should call Print() to make sure that all data is printed.
 //
 // Note that some impementations may start printing data before you call Print().
 type TablePrinter interface {
 	AddRow(entry Entry)
+	AddGroup(total Entry)
 	Footer(conditional string, spanFirst, spanLast time.Time, queryDuration, resolveDuration time.Duration)
 	Print() error
 }
@@ -279,6 +322,12 @@ type basePrinter struct {
 	ifaces string
 
 	cols []OutputColumn
+
+	// time zone the timestamps are printed in (local time if unset)
+	location *time.Location
+
+	// columns of the attributes the entries are grouped by
+	isGroupCol [CountOutcol]bool
 }
 
 func makeBasePrinter(
@@ -301,11 +350,46 @@ func makeBasePrinter(
 		Counts{totalInPkts, totalOutPkts, totalInBytes, totalOutBytes},
 		ifaces,
 		columns(hasAttrTime, hasAttrIface, attributes, direction),
+		nil,
+		[CountOutcol]bool{},
 	}
 
 	return result
 }
 
+// grouped returns whether the entries are printed in groups
+func (b *basePrinter) grouped() bool {
+	for _, isGroupCol := range b.isGroupCol {
+		if isGroupCol {
+			return true
+		}
+	}
+	return false
+}
+
+// groupBy marks the columns of the attributes of the grouping g and moves them to the
+// front, so that the printed groups are easy to tell apart
+func (b *basePrinter) groupBy(g *grouping) {
+	for _, col := range columns(g.hasAttrTime, g.hasAttrIface, g.attributes, b.direction) {
+		if !isCounter(col) {
+			b.isGroupCol[col] = true
+		}
+	}
+
+	cols := make([]OutputColumn, 0, len(b.cols))
+	for _, col := range b.cols {
+		if b.isGroupCol[col] {
+			cols = append(cols, col)
+		}
+	}
+	for _, col := range b.cols {
+		if !b.isGroupCol[col] {
+			cols = append(cols, col)
+		}
+	}
+	b.cols = cols
+}
+
 // CSVFormatter writes lines in CSV format
 type CSVFormatter struct{}
 
@@ -344,6 +428,9 @@ type CSVTablePrinter struct {
 	basePrinter
 	writer *csv.Writer
 	fields []string
+
+	// rank of the current group (0 if ungrouped)
+	group int
 }
 
 // NewCSVTablePrinter creates a new CSVTablePrinter
@@ -351,7 +438,8 @@ func NewCSVTablePrinter(b basePrinter) *CSVTablePrinter {
 	c := CSVTablePrinter{
 		b,
 		csv.NewWriter(b.output),
-		make([]string, 0, len(b.cols)),
+		make([]string, 0, len(b.cols)+1),
+		0,
 	}
 
 	headers := [CountOutcol]string{
@@ -361,12 +449,19 @@ func NewCSVTablePrinter(b basePrinter) *CSVTablePrinter {
 		"dip",
 		"dport",
 		"proto",
+		"ipv",
+		"snet",
+		"dnet",
 		"packets", "%", "data vol.", "%",
 		"packets", "%", "data vol.", "%",
 		"packets", "%", "data vol.", "%",
 		"packets received", "packets sent", "%", "data vol. received", "data vol. sent", "%",
 	}
 
+	// the rows of grouped queries are prefixed with the rank of their group
+	if c.grouped() {
+		c.fields = append(c.fields, "group")
+	}
 	for _, col := range c.cols {
 		c.fields = append(c.fields, headers[col])
 	}
@@ -378,12 +473,21 @@ func NewCSVTablePrinter(b basePrinter) *CSVTablePrinter {
 // AddRow writes a row to the CSVTablePrinter
 func (c *CSVTablePrinter) AddRow(entry Entry) {
 	c.fields = c.fields[:0]
+	if c.grouped() {
+		c.fields = append(c.fields, fmt.Sprint(c.group))
+	}
 	for _, col := range c.cols {
-		c.fields = append(c.fields, extract(CSVFormatter{}, c.ips2domains, c.totals, entry, col))
+		c.fields = append(c.fields, c.extract(CSVFormatter{}, entry, col))
 	}
 	c.writer.Write(c.fields)
 }
 
+// AddGroup starts the next group. Its totals aren't written, since every row carries
+// all attributes including the group's
+func (c *CSVTablePrinter) AddGroup(total Entry) {
+	c.group++
+}
+
 // Footer appends the CSV footer to the table
 func (c *CSVTablePrinter) Footer(conditional string, spanFirst, spanLast time.Time, queryDuration, resolveDuration time.Duration) {
 	var summaryEntries [CountOutcol]string
@@ -459,6 +563,9 @@ var jsonKeys = [CountOutcol]string{
 	"dip",
 	"dport",
 	"proto",
+	"ipv",
+	"snet",
+	"dnet",
 	"packets", "packets_percent", "bytes", "bytes_percent",
 	"packets", "packets_percent", "bytes", "bytes_percent",
 	"packets", "packets_percent", "bytes", "bytes_percent",
@@ -469,6 +576,7 @@ var jsonKeys = [CountOutcol]string{
 type JSONTablePrinter struct {
 	basePrinter
 	rows      []map[string]*jsoniter.RawMessage
+	groups    []map[string]interface{}
 	data      map[string]interface{}
 	queryType string
 }
@@ -478,6 +586,7 @@ func NewJSONTablePrinter(b basePrinter, queryType string) *JSONTablePrinter {
 	j := JSONTablePrinter{
 		b,
 		nil,
+		nil,
 		make(map[string]interface{}),
 		queryType,
 	}
@@ -486,15 +595,37 @@ func NewJSONTablePrinter(b basePrinter, queryType string) *JSONTablePrinter {
 }
 
 // AddRow adds a new JSON formatted row to the JSON printer
+// The rows of grouped queries are nested into their group and omit the group's attributes
 func (j *JSONTablePrinter) AddRow(entry Entry) {
 	row := make(map[string]*jsoniter.RawMessage)
 	for _, col := range j.cols {
-		val := jsoniter.RawMessage(extract(JSONFormatter{}, j.ips2domains, j.totals, entry, col))
+		if j.isGroupCol[col] {
+			continue
+		}
+		val := jsoniter.RawMessage(j.extract(JSONFormatter{}, entry, col))
 		row[jsonKeys[col]] = &val
 	}
+	if len(j.groups) > 0 {
+		group := j.groups[len(j.groups)-1]
+		group["rows"] = append(group["rows"].([]map[string]*jsoniter.RawMessage), row)
+		return
+	}
 	j.rows = append(j.rows, row)
 }
 
+// AddGroup adds a new group with the group's attributes and totals to the JSON printer
+func (j *JSONTablePrinter) AddGroup(total Entry) {
+	group := make(map[string]interface{})
+	for _, col := range j.cols {
+		if j.isGroupCol[col] || isCounter(col) {
+			val := jsoniter.RawMessage(j.extract(JSONFormatter{}, total, col))
+			group[jsonKeys[col]] = &val
+		}
+	}
+	group["rows"] = []map[string]*jsoniter.RawMessage{}
+	j.groups = append(j.groups, group)
+}
+
 // Footer adds the summary footer in JSON format
 func (j *JSONTablePrinter) Footer(conditional string, spanFirst, spanLast time.Time, queryDuration, resolveDuration time.Duration) {
 	j.data["status"] = "ok"
@@ -526,12 +657,18 @@ func (j *JSONTablePrinter) Footer(conditional string, spanFirst, spanLast time.T
 
 // Print prints out the JSON formatted flows to stdout
 func (j *JSONTablePrinter) Print() error {
-	j.data[j.queryType] = j.rows
+	if j.grouped() {
+		j.data[j.queryType] = j.groups
+	} else {
+		j.data[j.queryType] = j.rows
+	}
 	return jsoniter.NewEncoder(j.output).Encode(j.data)
 }
 
 // TextFormatter table formats goProbe flows (goQuery's default)
-type TextFormatter struct{}
+type TextFormatter struct {
+	location *time.Location // time zone of the printed times (local time if nil)
+}
 
 // NewTextFormatter returns a new TextFormatter
 func NewTextFormatter() TextFormatter {
@@ -599,8 +736,12 @@ func (TextFormatter) Float(f float64) string {
 }
 
 // Time formats epoch to "06-01-02 15:04:05"
-func (TextFormatter) Time(epoch int64) string {
-	return time.Unix(epoch, 0).Format("06-01-02 15:04:05")
+func (f TextFormatter) Time(epoch int64) string {
+	t := time.Unix(epoch, 0)
+	if f.location != nil {
+		t = t.In(f.location)
+	}
+	return t.Format("06-01-02 15:04:05")
 }
 
 // String returns s
@@ -648,6 +789,9 @@ func NewTextTablePrinter(b basePrinter, numFlows int, resolveTimeout time.Durati
 		"dip",
 		"dport",
 		"proto",
+		"ipv",
+		"snet",
+		"dnet",
 		"in", "%", "in", "%",
 		"out", "%", "out", "%",
 		"in+out", "%", "in+out", "%",
@@ -669,16 +813,31 @@ func NewTextTablePrinter(b basePrinter, numFlows int, resolveTimeout time.Durati
 	return t
 }
 
-// AddRow adds a flow entry to the table printer
+// AddRow adds a flow entry to the table printer. The attributes of the group are left
+// blank for the entries of grouped queries
 func (t *TextTablePrinter) AddRow(entry Entry) {
 	for _, col := range t.cols {
-		fmt.Fprint(t.writer, extract(TextFormatter{}, t.ips2domains, t.totals, entry, col))
+		if !t.isGroupCol[col] {
+			fmt.Fprint(t.writer, t.extract(TextFormatter{location: t.location}, entry, col))
+		}
 		fmt.Fprint(t.writer, "\t")
 	}
 	fmt.Fprintln(t.writer)
 	t.numPrinted++
 }
 
+// AddGroup adds a line with the group's attributes and totals to the table printer,
+// leaving the other attributes blank
+func (t *TextTablePrinter) AddGroup(total Entry) {
+	for _, col := range t.cols {
+		if t.isGroupCol[col] || isCounter(col) {
+			fmt.Fprint(t.writer, t.extract(TextFormatter{location: t.location}, total, col))
+		}
+		fmt.Fprint(t.writer, "\t")
+	}
+	fmt.Fprintln(t.writer)
+}
+
 // Footer appends the summary to the table printer
 func (t *TextTablePrinter) Footer(conditional string, spanFirst, spanLast time.Time, queryDuration, resolveDuration time.Duration) {
 	var isTotal [CountOutcol]bool
@@ -776,6 +935,9 @@ var influxDBKeys = [CountOutcol]string{
 	"dip",
 	"dport",
 	"proto",
+	"ipv",
+	"snet",
+	"dnet",
 	"packets", "packets_percent", "bytes", "bytes_percent",
 	"packets", "packets_percent", "bytes", "bytes_percent",
 	"packets", "packets_percent", "bytes", "bytes_percent",
@@ -867,6 +1029,9 @@ func NewInfluxDBTablePrinter(b basePrinter) *InfluxDBTablePrinter {
 	isFieldCol[OutcolDip] = true
 	isFieldCol[OutcolDport] = true
 	isTagCol[OutcolProto] = true
+	isTagCol[OutcolIPVersion] = true
+	isFieldCol[OutcolSnet] = true
+	isFieldCol[OutcolDnet] = true
 	isFieldCol[OutcolInPkts] = true
 	// ignore OutcolInPktsPercent
 	isFieldCol[OutcolInBytes] = true
@@ -918,7 +1083,7 @@ func (i *InfluxDBTablePrinter) AddRow(entry Entry) {
 		fmt.Fprint(i.output, ",")
 		fmt.Fprint(i.output, influxDBKeys[col])
 		fmt.Fprint(i.output, "=")
-		fmt.Fprint(i.output, extract(TextFormatter{}, i.ips2domains, i.totals, entry, col))
+		fmt.Fprint(i.output, i.extract(TextFormatter{}, entry, col))
 	}
 
 	fmt.Fprint(i.output, " ")
@@ -926,23 +1091,28 @@ func (i *InfluxDBTablePrinter) AddRow(entry Entry) {
 	// Fields
 	fmt.Fprint(i.output, influxDBKeys[i.fieldCols[0]])
 	fmt.Fprint(i.output, "=")
-	fmt.Fprint(i.output, extract(InfluxDBFormatter{}, i.ips2domains, i.totals, entry, i.fieldCols[0]))
+	fmt.Fprint(i.output, i.extract(InfluxDBFormatter{}, entry, i.fieldCols[0]))
 	for _, col := range i.fieldCols[1:] {
 		fmt.Fprint(i.output, ",")
 		fmt.Fprint(i.output, influxDBKeys[col])
 		fmt.Fprint(i.output, "=")
-		fmt.Fprint(i.output, extract(InfluxDBFormatter{}, i.ips2domains, i.totals, entry, col))
+		fmt.Fprint(i.output, i.extract(InfluxDBFormatter{}, entry, col))
 	}
 
 	// Time
 	if i.hasAttrTime {
 		fmt.Fprint(i.output, " ")
-		fmt.Fprint(i.output, extract(InfluxDBFormatter{}, i.ips2domains, i.totals, entry, OutcolTime))
+		fmt.Fprint(i.output, i.extract(InfluxDBFormatter{}, entry, OutcolTime))
 	}
 
 	fmt.Fprintln(i.output)
 }
 
+// AddGroup is a no-op for the InfluxDBTablePrinter, whose data points carry all attributes
+func (*InfluxDBTablePrinter) AddGroup(total Entry) {
+	return
+}
+
 // Footer is a no-op for the InfluxDBTablePrinter
 func (*InfluxDBTablePrinter) Footer(conditional string, spanFirst, spanLast time.Time, queryDuration, resolveDuration time.Duration) {
 	return
@@ -967,6 +1137,10 @@ func (s *Statement) NewTablePrinter(ips2domains map[string]string, sums Counts,
 		sums.PktsRcvd, sums.PktsSent, sums.BytesRcvd, sums.BytesSent,
 		strings.Join(s.Ifaces, ","),
 	)
+	b.location = s.Location
+	if s.grouping != nil {
+		b.groupBy(s.grouping)
+	}
 
 	switch s.Format {
 	case "txt":
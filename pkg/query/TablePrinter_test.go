@@ -68,6 +68,13 @@ var columnsTests = []struct {
 			OutcolBothBytesRcvd, OutcolBothBytesSent, OutcolBothBytesPercent,
 		},
 	},
+	{
+		"snet/24,dport,dnet/16/48",
+		DirectionIn,
+		[]OutputColumn{OutcolSnet, OutcolDport, OutcolDnet,
+			OutcolInPkts, OutcolInPktsPercent, OutcolInBytes, OutcolInBytesPercent,
+		},
+	},
 }
 
 func TestColumns(t *testing.T) {
@@ -106,6 +113,7 @@ var extractTestsEntry = Entry{
 			[16]byte{10, 11, 12, 13, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // 10.11.12.13
 			[2]byte{0xCB, 0xF1}, // 52209
 			6,                   // TCP
+			goDB.IPv4,
 		},
 	},
 	40 * 1024, // nBr
@@ -131,6 +139,9 @@ var extractTests = []struct {
 			"10.11.12.13",
 			"52209",
 			"TCP",
+			"4",
+			"192.168.0.1/32",
+			"10.11.12.13/32",
 			"10.00  ", "0.00", "40.00 kB", "0.00",
 			"3.00  ", "0.00", "20.00 kB", "0.00",
 			"13.00  ", "0.00", "60.00 kB", "0.00",
@@ -151,6 +162,9 @@ var extractTests = []struct {
 			"dip.example.com",
 			"52209",
 			"TCP",
+			"4",
+			"192.168.0.1/32",
+			"10.11.12.13/32",
 			"10.00  ", "0.00", "40.00 kB", "0.00",
 			"3.00  ", "0.00", "20.00 kB", "0.00",
 			"13.00  ", "0.00", "60.00 kB", "0.00",
@@ -168,6 +182,9 @@ var extractTests = []struct {
 			"10.11.12.13",
 			"52209",
 			"TCP",
+			"4",
+			"192.168.0.1/32",
+			"10.11.12.13/32",
 			"10.00  ", "50.00", "40.00 kB", "33.33",
 			"3.00  ", "33.33", "20.00 kB", "25.00",
 			"13.00  ", "44.83", "60.00 kB", "30.00",
@@ -177,9 +194,15 @@ var extractTests = []struct {
 }
 
 func TestExtract(t *testing.T) {
+	networks := goDB.PrefixLengths{V4: 32, V6: 128}
 	for _, test := range extractTests {
+		b := basePrinter{
+			ips2domains: test.ips2domains,
+			totals:      test.totals,
+			attributes:  []goDB.Attribute{goDB.SnetAttribute{PrefixLengths: networks}, goDB.DnetAttribute{PrefixLengths: networks}},
+		}
 		for col := OutputColumn(0); col < CountOutcol; col++ {
-			actual := extract(test.format, test.ips2domains, test.totals, extractTestsEntry, col)
+			actual := b.extract(test.format, extractTestsEntry, col)
 			if test.outputs[col] != actual {
 				t.Fatalf("Column %d: Expected '%s', got '%s'", col, test.outputs[col], actual)
 			}
@@ -231,6 +254,7 @@ var printerAnsiTestsEntries = []Entry{
 				[16]byte{10, 11, 12, 13, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // 10.11.12.13
 				[2]byte{0x29, 0x45}, // 10565
 				6,                   // TCP
+				goDB.IPv4,
 			},
 		},
 		0, // nBr
@@ -247,6 +271,7 @@ var printerAnsiTestsEntries = []Entry{
 				[16]byte{10, 11, 12, 14, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // 10.11.12.14
 				[2]byte{0x29, 0x45}, // 10565
 				6,                   // TCP
+				goDB.IPv4,
 			},
 		},
 		2094476019, // nBr
@@ -266,6 +291,7 @@ var printerTestsEntries = []Entry{
 				[16]byte{10, 11, 12, 13, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // 10.11.12.13
 				[2]byte{0x29, 0x45}, // 10565
 				6,                   // TCP
+				goDB.IPv4,
 			},
 		},
 		7004484352, // nBr
@@ -282,6 +308,7 @@ var printerTestsEntries = []Entry{
 				[16]byte{10, 11, 12, 14, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, // 10.11.12.14
 				[2]byte{0x29, 0x45}, // 10565
 				6,                   // TCP
+				goDB.IPv4,
 			},
 		},
 		2094476019, // nBr
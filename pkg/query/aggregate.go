@@ -1,13 +1,41 @@
 package query
 
 import (
+	"context"
+	"runtime"
+	"runtime/debug"
+
 	"github.com/els0r/goProbe/pkg/goDB"
 )
 
 type aggregateResult struct {
-	aggregatedMap map[goDB.ExtraKey]goDB.Val
+	aggregatedMap map[goDB.CompactKey]goDB.Val
 	totals        Counts
 	err           error
+
+	// spilled holds the partitions of the result written to disk if the aggregation
+	// exceeded its memory budget. The aggregated map is empty in this case
+	spilled *spillFiles
+}
+
+// spillConfig determines when the aggregated map is spilled to disk
+type spillConfig struct {
+	// dir is the directory temporary files are created in (default if empty)
+	dir string
+
+	// pressure signals that the memory consumption of the query is above its budget
+	pressure <-chan struct{}
+
+	// maxEntries forces spilling once the map holds more entries (0: no limit)
+	maxEntries int
+}
+
+// keyDecoder converts the compact keys of the aggregated flows back into full keys
+type keyDecoder struct {
+	query *goDB.Query
+
+	// ifaces holds the interfaces by the IDs they are interned as
+	ifaces []string
 }
 
 // Counts is a convenience wrapper around the summed counters
@@ -18,9 +46,13 @@ type Counts struct {
 
 // receive maps on mapChan until mapChan gets closed.
 // Then send aggregation result over resultChan.
-// If an error occurs, aggregate may return prematurely.
+// If an error occurs, aggregate may return prematurely. Once ctx is done, the maps
+// received are discarded and the error of ctx is sent.
+// If the memory budget is exceeded (see spillConfig), the aggregated map is spilled to
+// disk, partitioned by key, and aggregation continues with an empty map. The spilled
+// partitions are merged by aggregateResult.entries.
 // Closes resultChan on termination.
-func aggregate(mapChan <-chan map[goDB.ExtraKey]goDB.Val) chan aggregateResult {
+func aggregate(ctx context.Context, mapChan <-chan map[goDB.CompactKey]*goDB.Val, spill spillConfig) chan aggregateResult {
 
 	// create channel that returns the final aggregate result
 	resultChan := make(chan aggregateResult, 1)
@@ -28,8 +60,17 @@ func aggregate(mapChan <-chan map[goDB.ExtraKey]goDB.Val) chan aggregateResult {
 	go func() {
 		defer close(resultChan)
 
-		var finalMap = make(map[goDB.ExtraKey]goDB.Val)
+		var finalMap = make(map[goDB.CompactKey]goDB.Val)
 		var totals Counts
+		var spilled *spillFiles
+
+		// fail hands back the error and removes the spilled partitions
+		fail := func(err error) {
+			if spilled != nil {
+				spilled.remove()
+			}
+			resultChan <- aggregateResult{err: err}
+		}
 
 		// Temporary goDB.Val because map values cannot be updated in-place
 		var tempVal goDB.Val
@@ -37,10 +78,16 @@ func aggregate(mapChan <-chan map[goDB.ExtraKey]goDB.Val) chan aggregateResult {
 
 		for item := range mapChan {
 			if item == nil {
-				resultChan <- aggregateResult{err: errorInternalProcessing}
+				fail(errorInternalProcessing)
 				return
 			}
 
+			// keep draining the channel so the workers can finish, but stop aggregating
+			if ctx.Err() != nil {
+				finalMap = nil
+				continue
+			}
+
 			for k, v := range item {
 				totals.BytesRcvd += v.NBytesRcvd
 				totals.BytesSent += v.NBytesSent
@@ -55,13 +102,56 @@ func aggregate(mapChan <-chan map[goDB.ExtraKey]goDB.Val) chan aggregateResult {
 
 					finalMap[k] = tempVal
 				} else {
-					finalMap[k] = v
+					finalMap[k] = *v
 				}
 			}
 			item = nil
+
+			// spill the map if the memory budget is exceeded
+			overBudget := spill.maxEntries > 0 && len(finalMap) > spill.maxEntries
+			select {
+			case <-spill.pressure:
+				overBudget = true
+			default:
+			}
+			if overBudget && len(finalMap) > 0 {
+				var err error
+				if spilled == nil {
+					if spilled, err = newSpillFiles(spill.dir); err != nil {
+						fail(err)
+						return
+					}
+				}
+				if err = spilled.write(finalMap); err != nil {
+					fail(err)
+					return
+				}
+				finalMap = make(map[goDB.CompactKey]goDB.Val)
+
+				// hand the memory of the spilled map back right away
+				runtime.GC()
+				debug.FreeOSMemory()
+			}
 		}
 
 		// push the final result
+		if err := ctx.Err(); err != nil {
+			fail(err)
+			return
+		}
+		if spilled != nil {
+			if len(finalMap) > 0 {
+				if err := spilled.write(finalMap); err != nil {
+					fail(err)
+					return
+				}
+			}
+			resultChan <- aggregateResult{
+				spilled: spilled,
+				totals:  totals,
+			}
+			return
+		}
 		if len(finalMap) == 0 {
 			resultChan <- aggregateResult{err: errorNoResults}
 			return
@@ -74,3 +164,82 @@ func aggregate(mapChan <-chan map[goDB.ExtraKey]goDB.Val) chan aggregateResult {
 	}()
 	return resultChan
 }
+
+// entries returns the aggregated entries matching the having filter (unless it is nil),
+// sorted by less (unless it is nil) and limited to the first limit ones, as well as the
+// total number of matching entries. The keys are only decoded for the entries returned
+func (r *aggregateResult) entries(ctx context.Context, having havingNode, less by, limit int, keys keyDecoder) ([]Entry, int, error) {
+	entries, hits, err := r.sortEntries(ctx, having, less, limit, keys)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if less != nil {
+		less.Sort(entries)
+	}
+	if limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return decodeEntries(entries, keys), hits, nil
+}
+
+// sortEntries collects the aggregated entries matching the having filter (unless it is
+// nil), as well as the total number of matching entries. Spilled partitions are merged
+// one after another, keeping only the top limit entries by less of the partitions merged
+// so far. Without sort order, at most limit entries are collected
+func (r *aggregateResult) sortEntries(ctx context.Context, having havingNode, less by, limit int, keys keyDecoder) ([]sortEntry, int, error) {
+	var (
+		entries []sortEntry
+		hits    int
+	)
+	appendEntries := func(m map[goDB.CompactKey]goDB.Val) {
+		for k, val := range m {
+			e := sortEntry{key: k, nBr: val.NBytesRcvd, nPr: val.NPktsRcvd, nBs: val.NBytesSent, nPs: val.NPktsSent}
+			if having != nil && !having.match(&e) {
+				continue
+			}
+			hits++
+
+			// without sort order, any entries can be shown
+			if less == nil && len(entries) == limit {
+				continue
+			}
+			e.time = keys.query.KeyTime(k)
+			entries = append(entries, e)
+		}
+	}
+
+	if r.spilled == nil {
+		entries = make([]sortEntry, 0, len(r.aggregatedMap))
+		appendEntries(r.aggregatedMap)
+
+		// Now is a good time to release memory one last time for the final processing step
+		r.aggregatedMap = nil
+		runtime.GC()
+		debug.FreeOSMemory()
+	} else {
+		err := r.spilled.merge(ctx, func(m map[goDB.CompactKey]goDB.Val) {
+			appendEntries(m)
+
+			// drop the entries which can't make it to the top anymore (sorting only once
+			// twice the number of entries required has been collected)
+			if less != nil && len(entries) > limit && len(entries)-limit >= limit {
+				less.Sort(entries)
+				entries = entries[:limit]
+			}
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return entries, hits, nil
+}
+
+// decodeEntries converts sort entries into entries, decoding their keys
+func decodeEntries(entries []sortEntry, keys keyDecoder) []Entry {
+	decoded := make([]Entry, len(entries))
+	for i, e := range entries {
+		decoded[i] = Entry{k: keys.query.DecodeKey(e.key, keys.ifaces), nBr: e.nBr, nPr: e.nPr, nBs: e.nBs, nPs: e.nPs}
+	}
+	return decoded
+}
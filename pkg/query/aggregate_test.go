@@ -0,0 +1,128 @@
+package query
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+)
+
+func testAggregate(t *testing.T, maps []map[goDB.CompactKey]*goDB.Val, spill spillConfig) aggregateResult {
+	t.Helper()
+
+	mapChan := make(chan map[goDB.CompactKey]*goDB.Val, len(maps))
+	for _, m := range maps {
+		mapChan <- m
+	}
+	close(mapChan)
+
+	agg := <-aggregate(context.Background(), mapChan, spill)
+	if agg.err != nil {
+		t.Fatalf("aggregation failed: %s", agg.err)
+	}
+	return agg
+}
+
+func TestAggregateSpill(t *testing.T) {
+	attributes, hasAttrTime, hasAttrIface, err := goDB.ParseQueryType("iface,sip,dport")
+	if err != nil {
+		t.Fatalf("failed to parse query type: %s", err)
+	}
+	keys := keyDecoder{
+		query:  goDB.NewQuery(attributes, nil, hasAttrTime, hasAttrIface),
+		ifaces: []string{"eth0", "eth1", "t4_12345"},
+	}
+
+	var maps []map[goDB.CompactKey]*goDB.Val
+	for i := 0; i < 8; i++ {
+		m := make(map[goDB.CompactKey]*goDB.Val)
+		for j := 0; j < 500; j++ {
+			// every key occurs in two maps, spread over several interfaces
+			var k goDB.ExtraKey
+			k.Sip[0], k.Sip[1], k.Dport[1] = byte(i/2), byte(j), byte(j>>8)
+			k.IPVersion = goDB.IPv4
+			m[keys.query.EncodeKey(&k, j%3)] = &goDB.Val{NBytesRcvd: uint64(i*1000 + j), NBytesSent: 1, NPktsRcvd: uint64(j), NPktsSent: 2}
+		}
+		maps = append(maps, m)
+	}
+
+	spillDir := t.TempDir()
+	inMemory := testAggregate(t, maps, spillConfig{})
+	spilled := testAggregate(t, maps, spillConfig{dir: spillDir, maxEntries: 100})
+	if spilled.spilled == nil || spilled.spilled.numSpills != len(maps) {
+		t.Fatalf("expected the aggregation to be spilled after every map")
+	}
+	if inMemory.totals != spilled.totals {
+		t.Fatalf("totals differ: in memory %v, spilled %v", inMemory.totals, spilled.totals)
+	}
+
+	var tests = []struct {
+		name   string
+		having string
+		less   by
+		limit  int
+		hits   int
+	}{
+		{"all entries by bytes", "", By(SortTraffic, DirectionSum, false), MaxResults, 2000},
+		{"top 10 by bytes", "", By(SortTraffic, DirectionSum, false), 10, 2000},
+		{"top 300 by bytes in", "", By(SortTraffic, DirectionIn, true), 300, 2000},
+		{"unsorted", "", nil, MaxResults, 2000},
+		{"top 10 having packets", "packets_rcvd >= 400", By(SortTraffic, DirectionSum, false), 10, 1200},
+		{"unsorted having bytes", "bytes > 3k & packets_rcvd < 100", nil, MaxResults, 150},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			having, err := parseHaving(test.having, DirectionSum)
+			if err != nil {
+				t.Fatalf("failed to parse having filter: %s", err)
+			}
+			want, wantHits, err := (&aggregateResult{aggregatedMap: copyMap(inMemory.aggregatedMap)}).entries(context.Background(), having, test.less, test.limit, keys)
+			if err != nil {
+				t.Fatalf("failed to get entries: %s", err)
+			}
+			have, haveHits, err := spilled.entries(context.Background(), having, test.less, test.limit, keys)
+			if err != nil {
+				t.Fatalf("failed to merge spilled entries: %s", err)
+			}
+			if wantHits != test.hits || haveHits != wantHits {
+				t.Fatalf("unexpected number of hits: want %d, have %d / %d", test.hits, wantHits, haveHits)
+			}
+			for _, e := range have {
+				if having != nil && !having.match(&sortEntry{nBr: e.nBr, nBs: e.nBs, nPr: e.nPr, nPs: e.nPs}) {
+					t.Fatalf("entry %v doesn't match the having filter", e)
+				}
+			}
+
+			// without sort order, the entries returned are arbitrary
+			if test.less == nil {
+				sortEntries(want)
+				sortEntries(have)
+			}
+			if !reflect.DeepEqual(want, have) {
+				t.Fatalf("entries differ:\nwant %v\nhave %v", want, have)
+			}
+		})
+	}
+
+	spilled.spilled.remove()
+	if files, _ := ioutil.ReadDir(spillDir); len(files) != 0 {
+		t.Fatalf("spill files left behind: %v", files)
+	}
+}
+
+func copyMap(m map[goDB.CompactKey]goDB.Val) map[goDB.CompactKey]goDB.Val {
+	c := make(map[goDB.CompactKey]goDB.Val, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func sortEntries(entries []Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].nBr < entries[j].nBr || (entries[i].nBr == entries[j].nBr && entries[i].k.Iface < entries[j].k.Iface)
+	})
+}
@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -8,6 +9,11 @@ import (
 	"time"
 
 	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encryption"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
+	"github.com/els0r/goProbe/pkg/goDB/storage/bundle"
+	"github.com/els0r/goProbe/pkg/goDB/storage/gpfile"
+	"github.com/els0r/goProbe/pkg/goDB/storage/s3"
 	"github.com/els0r/goProbe/pkg/query/dns"
 )
 
@@ -47,6 +53,7 @@ type Args struct {
 
 	// data filtering
 	Condition string
+	Having    string // filter on the aggregated counters, e.g. "bytes > 1G"
 
 	// counter addition
 	In  bool
@@ -57,6 +64,15 @@ type Args struct {
 	First string
 	Last  string
 
+	// time bucketing
+	Resolution string // size of the buckets of the time attribute, e.g. 1h
+	TimeZone   string // time zone the buckets are aligned to (local time if empty)
+	ZeroFill   bool   // print empty buckets
+
+	// grouping
+	GroupBy       string // attributes the entries are grouped by, e.g. sip
+	LimitPerGroup int    // number of entries shown per group (all if 0)
+
 	// formatting
 	Format        string
 	SortBy        string // column to sort by (packets or bytes)
@@ -78,6 +94,17 @@ type Args struct {
 	DBPath    string
 	MaxMemPct int
 
+	// KeyFiles lists the key files used to decrypt encrypted blocks
+	KeyFiles []string `json:",omitempty"`
+
+	// ArchiveCacheDir denotes the directory days archived to object storage are cached
+	// in. If unset, the user's cache directory is used
+	ArchiveCacheDir string `json:",omitempty"`
+
+	// Store provides access to the DB's storage backends. If unset, the DB is read
+	// from the file system, fetching archived days from object storage on demand
+	Store storage.Store `json:"-"`
+
 	// stores who produced these args (caller)
 	Caller string
 }
@@ -91,6 +118,12 @@ func (a *Args) String() string {
 	if a.Condition != "" {
 		str += fmt.Sprintf(", condition: %s", a.Condition)
 	}
+	if a.Having != "" {
+		str += fmt.Sprintf(", having: %s", a.Having)
+	}
+	if a.GroupBy != "" {
+		str += fmt.Sprintf(", group-by: %s, limit-per-group: %d", a.GroupBy, a.LimitPerGroup)
+	}
 	str += fmt.Sprintf(", db: %s, limit: %d, from: %s, to: %s",
 		a.DBPath,
 		a.NumResults,
@@ -110,7 +143,8 @@ func (a *Args) String() string {
 }
 
 // Prepare takes the query Arguments, validates them and creates an executable statement. Optionally, additional writers can be passed to route query results to different destinations.
-func (a *Args) Prepare(writers ...io.Writer) (*Statement, error) {
+// Resolving the host names of the condition is aborted once ctx is done.
+func (a *Args) Prepare(ctx context.Context, writers ...io.Writer) (*Statement, error) {
 
 	// if not already done beforehand, enforce defaults for args
 	if a.SortBy == "" {
@@ -123,10 +157,28 @@ func (a *Args) Prepare(writers ...io.Writer) (*Statement, error) {
 		Conditions: a.Condition,
 		Caller:     a.Caller,
 		Output:     os.Stdout, // by default, we write results to the console
+		store:      a.Store,
 	}
-
 	var err error
 
+	if s.store == nil {
+		var keyring *encryption.Keyring
+		if keyring, err = encryption.ReadKeyring(a.KeyFiles...); err != nil {
+			return s, err
+		}
+		if bundle.IsBundle(a.DBPath) {
+			// bundles are queried in place (read-only)
+			if s.store, err = bundle.Open(a.DBPath, gpfile.WithKeyring(keyring)); err != nil {
+				return s, err
+			}
+		} else {
+			s.store = s3.NewStore(gpfile.NewStore(gpfile.WithKeyring(keyring), gpfile.WithMmap()),
+				s3.WithKeyring(keyring),
+				s3.WithCacheDir(a.ArchiveCacheDir),
+			)
+		}
+	}
+
 	// verify config format
 	_, verifies := PermittedFormats[a.Format]
 	if !verifies {
@@ -135,14 +187,14 @@ func (a *Args) Prepare(writers ...io.Writer) (*Statement, error) {
 	s.Format = a.Format
 
 	// check DB path
-	err = CheckDBExists(a.DBPath)
+	err = checkDBExists(s.store, a.DBPath)
 	if err != nil {
 		return s, err
 	}
 	s.DBPath = a.DBPath
 
 	// assign ifaces
-	s.Ifaces, err = parseIfaceList(s.DBPath, a.Ifaces)
+	s.Ifaces, err = parseIfaceList(s.store, s.DBPath, a.Ifaces)
 	if err != nil {
 		return s, fmt.Errorf("failed to parse interface list: %s", err)
 	}
@@ -178,6 +230,37 @@ func (a *Args) Prepare(writers ...io.Writer) (*Statement, error) {
 		s.NumResults = MaxResults
 	}
 
+	// determine the size of the time buckets, which can be set either in the query type
+	// (e.g. time:1h) or via the resolution argument
+	s.Resolution, err = goDB.QueryTimeResolution(a.Query)
+	if err != nil {
+		return s, fmt.Errorf("failed to parse query type: %s", err)
+	}
+	if a.Resolution != "" {
+		resolution, err := goDB.ParseTimeResolution(a.Resolution)
+		if err != nil {
+			return s, fmt.Errorf("invalid time resolution: %s", err)
+		}
+		if s.Resolution != 0 && s.Resolution != resolution {
+			return s, fmt.Errorf("conflicting time resolutions %s and %s", s.Resolution, resolution)
+		}
+		s.Resolution = resolution
+	}
+	if s.Resolution != 0 && !s.HasAttrTime {
+		return s, fmt.Errorf("a time resolution requires the time attribute")
+	}
+	s.Location = time.Local
+	if a.TimeZone != "" {
+		s.Location, err = time.LoadLocation(a.TimeZone)
+		if err != nil {
+			return s, fmt.Errorf("invalid time zone: %s", err)
+		}
+	}
+	if a.ZeroFill && s.Resolution == 0 {
+		return s, fmt.Errorf("zero-filling requires a time resolution")
+	}
+	s.ZeroFill = a.ZeroFill
+
 	// parse time bound
 	s.Last, err = goDB.ParseTimeArgument(a.Last)
 	if err != nil {
@@ -211,6 +294,13 @@ func (a *Args) Prepare(writers ...io.Writer) (*Statement, error) {
 		s.Direction = DirectionBoth
 	}
 
+	// parse the filter of the aggregated entries, whose counters depend on the direction
+	s.Having = a.Having
+	s.havingFilter, err = parseHaving(a.Having, s.Direction)
+	if err != nil {
+		return s, fmt.Errorf("having filter error: %s", err)
+	}
+
 	// check resolve timeout and DNS
 	if s.Resolve {
 		err := dns.CheckDNS()
@@ -236,7 +326,7 @@ func (a *Args) Prepare(writers ...io.Writer) (*Statement, error) {
 	s.Conditions = a.Condition
 
 	// build condition tree to check if there is a syntax error before starting processing
-	queryConditional, parseErr := goDB.ParseAndInstrumentConditional(a.Condition, time.Duration(a.ResolveTimeout))
+	queryConditional, parseErr := goDB.ParseAndInstrumentConditionalContext(ctx, a.Condition, time.Duration(a.ResolveTimeout))
 	if parseErr != nil {
 		return s, fmt.Errorf("condition error: %s", parseErr)
 	}
@@ -273,17 +363,59 @@ func (a *Args) Prepare(writers ...io.Writer) (*Statement, error) {
 		s.Output = io.MultiWriter(writers...)
 	}
 
-	s.Query = goDB.NewQuery(queryAttributes, queryConditional, s.HasAttrTime, s.HasAttrIface)
+	s.Query = goDB.NewQuery(queryAttributes, queryConditional, s.HasAttrTime, s.HasAttrIface,
+		goDB.WithTimeResolution(s.Resolution, s.Location),
+	)
+
+	// the time series shown with zero-filling are ranked by their counters, the entries of
+	// each series are sorted chronologically
+	if s.ZeroFill {
+		rank := PermittedSortBy[a.SortBy]
+		if rank == SortTime {
+			rank = SortTraffic
+		}
+		s.seriesLess = By(rank, s.Direction, a.SortAscending)
+	}
+
+	// group the entries after aggregation, limiting the number of groups instead of
+	// the number of entries
+	if a.LimitPerGroup != 0 && a.GroupBy == "" {
+		return s, fmt.Errorf("a limit per group requires grouping the entries")
+	}
+	if a.GroupBy != "" {
+		if s.ZeroFill {
+			return s, fmt.Errorf("zero-filling cannot be combined with grouping")
+		}
+		s.GroupBy, s.LimitPerGroup = a.GroupBy, a.LimitPerGroup
+		s.grouping, err = newGrouping(a.GroupBy, s.Query, s.HasAttrTime, s.HasAttrIface, a.LimitPerGroup)
+		if err != nil {
+			return s, fmt.Errorf("group-by error: %s", err)
+		}
+
+		// time based queries are sorted chronologically, which only applies to the groups
+		// or entries holding the time attribute. The others are ranked by their counters
+		rank := PermittedSortBy[a.SortBy]
+		if rank == SortTime {
+			rank = SortTraffic
+		}
+		s.grouping.groupLess = By(rank, s.Direction, a.SortAscending)
+		s.grouping.entryLess = s.grouping.groupLess
+		if s.grouping.hasAttrTime {
+			s.grouping.groupLess = By(SortTime, s.Direction, true)
+		} else if s.HasAttrTime {
+			s.grouping.entryLess = By(SortTime, s.Direction, true)
+		}
+	}
 	return s, nil
 }
 
-func parseIfaceList(dbPath string, ifacelist string) (ifaces []string, err error) {
+func parseIfaceList(store storage.Store, dbPath string, ifacelist string) (ifaces []string, err error) {
 	if ifacelist == "" {
 		return nil, fmt.Errorf("no interface(s) specified")
 	}
 
 	if strings.ToLower(ifacelist) == "any" {
-		summary, err := goDB.ReadDBSummary(dbPath)
+		summary, err := goDB.ReadDBSummary(store, dbPath)
 		if err != nil {
 			return nil, err
 		}
@@ -304,10 +436,14 @@ func parseIfaceList(dbPath string, ifacelist string) (ifaces []string, err error
 
 // CheckDBExists will return nil if a DB at path exists and otherwise the error encountered
 func CheckDBExists(path string) error {
+	return checkDBExists(gpfile.NewStore(), path)
+}
+
+func checkDBExists(store storage.Store, path string) error {
 	if path == "" {
 		return fmt.Errorf("empty DB path provided")
 	}
-	_, err := os.Stat(path)
+	_, err := store.ReadDir(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return fmt.Errorf("DB directory does not exist at %s", path)
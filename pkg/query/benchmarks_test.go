@@ -3,8 +3,12 @@ package query
 
 import (
 	"bytes"
+	"context"
 	"os/exec"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	lg "github.com/els0r/log"
 )
@@ -112,19 +116,86 @@ func BenchmarkStdQueryCSVOutputCondition(b *testing.B) {
 	_ = buf
 }
 
+// Conditional benchmarks
+// The file system cache isn't flushed in order to measure the evaluation of the
+// conditional rather than I/O
+
+func BenchmarkNestedConditionAllIfaces(b *testing.B) {
+
+	buf := &bytes.Buffer{}
+
+	benchQuery(b, buf, nil,
+		strings.Join(multiIfaces, ","), "sip,dip,dport",
+		[]Option{WithDBPath(TestDB),
+			WithFirst("0"),
+			WithCondition("((dport eq 443 || dport eq 80) and dport neq 8080) and ! (dnet eq 127.0.0.0/8 or dnet eq 10.0.0.0/8 or dnet eq 172.16.0.0/12 or dnet eq 192.168.0.0/16)"),
+		}...,
+	)
+
+	_ = buf
+}
+
+// Memory benchmarks
+// Besides the allocations, the peak heap in use while running the query is reported
+// (as peak-heap-B), e.g.:
+//      go test -run=^$ -bench=BenchmarkPeakHeap
+
+func BenchmarkPeakHeapTimeDportAllIfaces(b *testing.B) {
+	benchPeakHeap(b, strings.Join(multiIfaces, ","), "time,dport",
+		[]Option{WithDBPath(TestDB),
+			WithFirst("0"),
+			WithNumResults(MaxResults),
+			WithFormat("json"),
+		}...,
+	)
+}
+
+func BenchmarkPeakHeapRawAllIfaces(b *testing.B) {
+	benchPeakHeap(b, strings.Join(multiIfaces, ","), "sip,dip,dport,proto",
+		[]Option{WithDBPath(TestDB),
+			WithFirst("0"),
+			WithNumResults(MaxResults),
+			WithFormat("json"),
+		}...,
+	)
+}
+
+// Multi-interface benchmarks
+// The workloads of all interfaces of a query are processed by a shared pool of workers.
+// See BenchmarkExecuteReadJobs in goDB for a comparison with processing the interfaces
+// one after another
+
+// multiIfaces lists all interfaces of the test DB
+var multiIfaces = []string{"eth0", "eth1", "eth2", "t_c1_fwde", "t_c1_fwde1", "tun_3g_c1_fw1", "tun_3g_c1_fwde"}
+
+func BenchmarkMultiIfaceShared(b *testing.B) {
+
+	buf := &bytes.Buffer{}
+
+	benchQuery(b, buf, nil,
+		strings.Join(multiIfaces, ","), "sip,dip,dport",
+		[]Option{WithDBPath(TestDB),
+			WithFirst("0"),
+		}...,
+	)
+
+	_ = buf
+}
+
 func benchQuery(b *testing.B, buf *bytes.Buffer, flushFunc func(), iface, query string,
 	opts ...Option) {
+	b.ReportAllocs()
 	for n := 0; n < b.N; n++ {
 
 		// prepare query
 		args := NewArgs(query, iface, opts...)
-		query, err := args.Prepare(buf)
+		query, err := args.Prepare(context.Background(), buf)
 		if err != nil {
 			b.Fatalf("error during prepare: %s", err)
 		}
 
 		// run query
-		err = query.Execute()
+		err = query.Execute(context.Background())
 		if err != nil {
 			b.Fatalf("error during execute: %s", err)
 		}
@@ -137,6 +208,47 @@ func benchQuery(b *testing.B, buf *bytes.Buffer, flushFunc func(), iface, query
 	}
 }
 
+func benchPeakHeap(b *testing.B, iface, query string, opts ...Option) {
+	stop := samplePeakHeap()
+	benchQuery(b, &bytes.Buffer{}, nil, iface, query, opts...)
+	b.ReportMetric(float64(stop()), "peak-heap-B")
+}
+
+// samplePeakHeap samples the heap in use every millisecond until the returned function
+// is called, which returns the maximum observed
+func samplePeakHeap() func() uint64 {
+	runtime.GC()
+
+	var (
+		done   = make(chan struct{})
+		result = make(chan uint64)
+	)
+	go func() {
+		var (
+			m      runtime.MemStats
+			peak   uint64
+			ticker = time.NewTicker(time.Millisecond)
+		)
+		defer ticker.Stop()
+		for {
+			runtime.ReadMemStats(&m)
+			if m.HeapInuse > peak {
+				peak = m.HeapInuse
+			}
+			select {
+			case <-done:
+				result <- peak
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() uint64 {
+		close(done)
+		return <-result
+	}
+}
+
 func flushCaches() {
 	var log = lg.NewTextLogger()
 
@@ -14,6 +14,7 @@
 package dns
 
 import (
+	"context"
 	"net"
 	"time"
 )
@@ -26,11 +27,14 @@ type LookupResult struct {
 }
 
 // TimedReverseLookup performs a reverse lookup on the given ips. The lookup takes at most timeout time, afterwards
-// it is aborted.
+// it is aborted. It is aborted as well if ctx is canceled.
 // Returns a mapping IP => domain. If the lookup is aborted because of a timeout, the current mapping
 // is returned with the pending lookups missing. If there is no RDNS entry for an IP, the corresponding
 // key in the result will not be associated with any value (i.e. domain).
-func TimedReverseLookup(ips []string, timeout time.Duration) (ipToDomain map[string]string) {
+func TimedReverseLookup(ctx context.Context, ips []string, timeout time.Duration) (ipToDomain map[string]string) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
 	// Compute set of ips so we look up each unique IP exactly once
 	// This assumes that the ips are provided in a normalized format.
 	ipToDomain = make(map[string]string)
@@ -39,7 +43,9 @@ func TimedReverseLookup(ips []string, timeout time.Duration) (ipToDomain map[str
 		ipset[ip] = struct{}{}
 	}
 
-	lookupChannel := make(chan LookupResult, 1)
+	// the channel is large enough to hold all results, hence lookups finishing after the
+	// timeout don't block
+	lookupChannel := make(chan LookupResult, len(ipset))
 	var pending int
 	// Perform an asynchronous lookup for every ip in the set. The results are sent
 	// over the lookup channel.
@@ -48,11 +54,8 @@ func TimedReverseLookup(ips []string, timeout time.Duration) (ipToDomain map[str
 			lookupR := LookupResult{}
 			lookupR.IP = ip
 			lookupR.Domain = ""
-			domains, err := net.LookupAddr(ip)
-			if err != nil {
-				lookupChannel <- lookupR
-			}
-			if len(domains) > 0 {
+			domains, err := net.DefaultResolver.LookupAddr(ctx, ip)
+			if err == nil && len(domains) > 0 {
 				lookupR.Success = true
 				lookupR.Domain = domains[0]
 			}
@@ -68,7 +71,7 @@ func TimedReverseLookup(ips []string, timeout time.Duration) (ipToDomain map[str
 			if LookupResult.Success {
 				ipToDomain[LookupResult.IP] = LookupResult.Domain
 			}
-		case <-time.After(timeout):
+		case <-ctx.Done():
 			pending = 0
 		}
 	}
@@ -0,0 +1,157 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+)
+
+// Group holds the top entries of a group of a grouped query (e.g. the top destination
+// ports of a source IP), along with the group's attributes and the summed counters of
+// all its entries
+type Group struct {
+	total   Entry
+	entries []Entry
+}
+
+// grouping describes how the entries of a query are grouped and limited per group
+type grouping struct {
+	// attributes the entries are grouped by
+	attributes                []goDB.Attribute
+	hasAttrTime, hasAttrIface bool
+
+	// order of the groups and of the entries within a group
+	groupLess, entryLess by
+
+	// maximum number of entries shown per group (0: no limit)
+	limitPerGroup int
+}
+
+// newGrouping creates a grouping by the attributes of groupBy (e.g. "sip" or "iface"),
+// which have to be a subset of the attributes of the query
+func newGrouping(groupBy string, query *goDB.Query, hasAttrTime, hasAttrIface bool, limitPerGroup int) (*grouping, error) {
+	attributes, groupHasTime, groupHasIface, err := goDB.ParseQueryType(groupBy)
+	if err != nil {
+		return nil, err
+	}
+	if groupHasTime && !hasAttrTime {
+		return nil, fmt.Errorf("cannot group by time, which is not part of the query")
+	}
+	if groupHasIface && !hasAttrIface {
+		return nil, fmt.Errorf("cannot group by iface, which is not part of the query")
+	}
+
+	queryAttributes := make(map[string]goDB.Attribute)
+	for _, attribute := range query.Attributes {
+		queryAttributes[attribute.Name()] = attribute
+	}
+	for _, attribute := range attributes {
+		queryAttribute, exists := queryAttributes[attribute.Name()]
+		if !exists {
+			return nil, fmt.Errorf("cannot group by %s, which is not part of the query", attribute.Name())
+		}
+		// networks (e.g. snet/24) have to match the prefix lengths of the query
+		if queryAttribute != attribute {
+			return nil, fmt.Errorf("cannot group by %s with prefix lengths differing from the query", attribute.Name())
+		}
+	}
+	if len(attributes) == len(query.Attributes) && groupHasTime == hasAttrTime && groupHasIface == hasAttrIface {
+		return nil, fmt.Errorf("cannot group by all attributes of the query")
+	}
+	if limitPerGroup < 0 {
+		return nil, fmt.Errorf("the limit per group must not be negative")
+	}
+
+	return &grouping{
+		attributes:    attributes,
+		hasAttrTime:   groupHasTime,
+		hasAttrIface:  groupHasIface,
+		limitPerGroup: limitPerGroup,
+	}, nil
+}
+
+// key returns the key of the group of an entry, retaining only the group's attributes
+func (g *grouping) key(k goDB.ExtraKey) goDB.ExtraKey {
+	var group goDB.ExtraKey
+	if g.hasAttrTime {
+		group.Time = k.Time
+	}
+	if g.hasAttrIface {
+		group.Iface = k.Iface
+	}
+	for _, attribute := range g.attributes {
+		switch attribute.Name() {
+		case "sip", "snet":
+			group.Sip, group.IPVersion = k.Sip, k.IPVersion
+		case "dip", "dnet":
+			group.Dip, group.IPVersion = k.Dip, k.IPVersion
+		case "dport":
+			group.Dport = k.Dport
+		case "proto":
+			group.Protocol = k.Protocol
+		case "ipv":
+			group.IPVersion = k.IPVersion
+		}
+	}
+	return group
+}
+
+// groups returns the aggregated entries matching the having filter (unless it is nil) in
+// groups. The first limit groups are returned, each holding the first limitPerGroup
+// entries. The total number of matching entries is returned as well
+func (r *aggregateResult) groups(ctx context.Context, having havingNode, g *grouping, limit int, keys keyDecoder) ([]Group, int, error) {
+	entries, hits, err := r.sortEntries(ctx, having, nil, MaxResults, keys)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// partition the entries, summing up the counters of each group
+	type partition struct {
+		total   sortEntry
+		key     goDB.ExtraKey
+		entries []sortEntry
+	}
+	var (
+		partitions []*partition
+		byGroupKey = make(map[goDB.ExtraKey]*partition)
+	)
+	for _, e := range entries {
+		groupKey := g.key(keys.query.DecodeKey(e.key, keys.ifaces))
+		p, exists := byGroupKey[groupKey]
+		if !exists {
+			p = &partition{key: groupKey, total: sortEntry{time: groupKey.Time}}
+			byGroupKey[groupKey] = p
+			partitions = append(partitions, p)
+		}
+		p.total.nBr, p.total.nBs = p.total.nBr+e.nBr, p.total.nBs+e.nBs
+		p.total.nPr, p.total.nPs = p.total.nPr+e.nPr, p.total.nPs+e.nPs
+		p.entries = append(p.entries, e)
+	}
+
+	// order the groups by their totals
+	if g.groupLess != nil {
+		sort.Slice(partitions, func(i, j int) bool { return g.groupLess(&partitions[i].total, &partitions[j].total) })
+	}
+	if limit < len(partitions) {
+		partitions = partitions[:limit]
+	}
+
+	groups := make([]Group, len(partitions))
+	for i, p := range partitions {
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		if g.entryLess != nil {
+			g.entryLess.Sort(p.entries)
+		}
+		if g.limitPerGroup > 0 && g.limitPerGroup < len(p.entries) {
+			p.entries = p.entries[:g.limitPerGroup]
+		}
+
+		groups[i].total = Entry{k: p.key, nBr: p.total.nBr, nBs: p.total.nBs, nPr: p.total.nPr, nPs: p.total.nPs}
+		groups[i].entries = decodeEntries(p.entries, keys)
+	}
+	return groups, hits, nil
+}
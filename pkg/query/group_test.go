@@ -0,0 +1,222 @@
+package query
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestNewGrouping(t *testing.T) {
+	var tests = []struct {
+		queryType string
+		groupBy   string
+		valid     bool
+	}{
+		{"sip,dport", "sip", true},
+		{"iface,sip,dip", "iface", true},
+		{"iface,sip,dip", "iface,sip", true},
+		{"time,sip", "time", true},
+		{"talk_conv", "sip", true},
+		{"sip,dport", "sip,dport", false},
+		{"sip,dport", "dip", false},
+		{"sip,dport", "iface", false},
+		{"sip,dport", "time", false},
+		{"sip,dport", "unknown", false},
+		{"sip,dport", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.queryType+"/"+test.groupBy, func(t *testing.T) {
+			attributes, hasAttrTime, hasAttrIface, err := goDB.ParseQueryType(test.queryType)
+			if err != nil {
+				t.Fatalf("failed to parse query type: %s", err)
+			}
+			query := goDB.NewQuery(attributes, nil, hasAttrTime, hasAttrIface)
+
+			_, err = newGrouping(test.groupBy, query, hasAttrTime, hasAttrIface, 5)
+			if test.valid && err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !test.valid && err == nil {
+				t.Fatalf("expected grouping by %s to fail", test.groupBy)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	attributes, hasAttrTime, hasAttrIface, err := goDB.ParseQueryType("sip,dport")
+	if err != nil {
+		t.Fatalf("failed to parse query type: %s", err)
+	}
+	keys := keyDecoder{query: goDB.NewQuery(attributes, nil, hasAttrTime, hasAttrIface)}
+
+	// source i talks to ports 1..i+1, port j carrying j bytes
+	m := make(map[goDB.CompactKey]goDB.Val)
+	for i := 0; i < 4; i++ {
+		for j := 1; j <= i+1; j++ {
+			var k goDB.ExtraKey
+			k.Sip[0], k.Dport[1] = byte(i), byte(j)
+			k.IPVersion = goDB.IPv4
+			m[keys.query.EncodeKey(&k, 0)] = goDB.Val{NBytesRcvd: uint64(j), NPktsRcvd: 1}
+		}
+	}
+
+	var tests = []struct {
+		name          string
+		having        string
+		limit         int
+		limitPerGroup int
+		hits          int
+		totals        []uint64
+		entries       [][]uint64
+	}{
+		{"all groups", "", MaxResults, 0, 10, []uint64{10, 6, 3, 1}, [][]uint64{{4, 3, 2, 1}, {3, 2, 1}, {2, 1}, {1}}},
+		{"top 2 per group", "", MaxResults, 2, 10, []uint64{10, 6, 3, 1}, [][]uint64{{4, 3}, {3, 2}, {2, 1}, {1}}},
+		{"top 2 groups", "", 2, 1, 10, []uint64{10, 6}, [][]uint64{{4}, {3}}},
+		{"having", "bytes >= 2", MaxResults, 2, 6, []uint64{9, 5, 2}, [][]uint64{{4, 3}, {3, 2}, {2}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g, err := newGrouping("sip", keys.query, hasAttrTime, hasAttrIface, test.limitPerGroup)
+			if err != nil {
+				t.Fatalf("failed to create grouping: %s", err)
+			}
+			g.groupLess = By(SortTraffic, DirectionIn, false)
+			g.entryLess = g.groupLess
+			having, err := parseHaving(test.having, DirectionIn)
+			if err != nil {
+				t.Fatalf("failed to parse having filter: %s", err)
+			}
+
+			groups, hits, err := (&aggregateResult{aggregatedMap: copyMap(m)}).groups(context.Background(), having, g, test.limit, keys)
+			if err != nil {
+				t.Fatalf("failed to get groups: %s", err)
+			}
+			if hits != test.hits {
+				t.Fatalf("unexpected number of hits: want %d, have %d", test.hits, hits)
+			}
+
+			var totals []uint64
+			var entries [][]uint64
+			for _, group := range groups {
+				if group.total.k.Dport != [2]byte{} {
+					t.Fatalf("group key %v retains the non-group attributes", group.total.k)
+				}
+				totals = append(totals, group.total.nBr)
+				var groupEntries []uint64
+				for _, e := range group.entries {
+					if e.k.Sip != group.total.k.Sip {
+						t.Fatalf("entry %v doesn't belong to group %v", e.k, group.total.k)
+					}
+					groupEntries = append(groupEntries, e.nBr)
+				}
+				entries = append(entries, groupEntries)
+			}
+			if !reflect.DeepEqual(totals, test.totals) {
+				t.Fatalf("unexpected group totals: want %v, have %v", test.totals, totals)
+			}
+			if !reflect.DeepEqual(entries, test.entries) {
+				t.Fatalf("unexpected group entries: want %v, have %v", test.entries, entries)
+			}
+		})
+	}
+}
+
+func TestGroupedPrinters(t *testing.T) {
+	attributes, hasAttrTime, hasAttrIface, err := goDB.ParseQueryType("dport,sip")
+	if err != nil {
+		t.Fatalf("failed to parse query type: %s", err)
+	}
+	g, err := newGrouping("sip", goDB.NewQuery(attributes, nil, hasAttrTime, hasAttrIface), hasAttrTime, hasAttrIface, 0)
+	if err != nil {
+		t.Fatalf("failed to create grouping: %s", err)
+	}
+
+	entry := func(sip byte, dport byte, bytes uint64) Entry {
+		var k goDB.ExtraKey
+		k.Sip[0], k.Dport[1] = sip, dport
+		k.IPVersion = goDB.IPv4
+		return Entry{k: k, nBr: bytes}
+	}
+	total := entry(1, 0, 30)
+	total.k.Dport = [2]byte{}
+	rows := []Entry{entry(1, 80, 20), entry(1, 22, 10)}
+
+	var tests = []struct {
+		format string
+		lines  []string
+	}{
+		{"csv", []string{
+			"group,sip,dport,packets,%,data vol.,%",
+			"1,1.0.0.0,80,0,0.00,20,66.67",
+			"1,1.0.0.0,22,0,0.00,10,33.33",
+		}},
+		{"json", []string{
+			`{"dport,sip":[{"bytes":30,"bytes_percent":100,"packets":0,"packets_percent":0,"sip":"1.0.0.0","rows":[` +
+				`{"bytes":20,"bytes_percent":66.66666666666667,"dport":"80","packets":0,"packets_percent":0},` +
+				`{"bytes":10,"bytes_percent":33.333333333333336,"dport":"22","packets":0,"packets_percent":0}]}]}`,
+		}},
+		{"txt", []string{
+			"",
+			"                  packets           bytes        ",
+			"      sip  dport       in     %        in       %",
+			"  1.0.0.0          0.00    0.00  30.00  B  100.00",
+			"              80   0.00    0.00  20.00  B   66.67",
+			"              22   0.00    0.00  10.00  B   33.33",
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			b := makeBasePrinter(&buf, SortTraffic, hasAttrTime, hasAttrIface, DirectionIn, attributes, nil, 0, 0, 30, 0, "eth0")
+			b.groupBy(g)
+
+			var p TablePrinter
+			switch test.format {
+			case "csv":
+				p = NewCSVTablePrinter(b)
+			case "json":
+				p = NewJSONTablePrinter(b, "dport,sip")
+			case "txt":
+				p = NewTextTablePrinter(b, len(rows), 0)
+			}
+			p.AddGroup(total)
+			for _, row := range rows {
+				p.AddRow(row)
+			}
+			if err := p.Print(); err != nil {
+				t.Fatalf("failed to print: %s", err)
+			}
+
+			// JSON objects are compared regardless of the order of their keys
+			if test.format == "json" {
+				var want, have interface{}
+				if err := jsoniter.UnmarshalFromString(test.lines[0], &want); err != nil {
+					t.Fatalf("invalid expected JSON: %s", err)
+				}
+				if err := jsoniter.Unmarshal(buf.Bytes(), &have); err != nil {
+					t.Fatalf("invalid JSON output: %s", err)
+				}
+				if !reflect.DeepEqual(want, have) {
+					t.Fatalf("unexpected JSON output:\nwant %s\nhave %s", test.lines[0], buf.String())
+				}
+				return
+			}
+
+			lines := strings.Split(buf.String(), "\n")
+			if len(lines) < len(test.lines) {
+				t.Fatalf("expected at least %d lines, got:\n%s", len(test.lines), buf.String())
+			}
+			for i, line := range test.lines {
+				if lines[i] != line {
+					t.Fatalf("unexpected line %d:\nwant %q\nhave %q", i, line, lines[i])
+				}
+			}
+		})
+	}
+}
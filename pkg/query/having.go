@@ -0,0 +1,252 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+)
+
+// havingNode is a node of the AST of a having filter. Contrary to conditionals, which
+// select the flows before aggregation, having filters select the aggregated entries
+// by their counters, e.g. "bytes_sent > 1G & packets < 100"
+type havingNode interface {
+	match(e *sortEntry) bool
+	String() string
+}
+
+type havingCondition struct {
+	counter    string
+	comparator string
+	value      uint64
+	get        func(e *sortEntry) uint64
+}
+
+type havingNot struct{ node havingNode }
+
+type havingAnd struct{ left, right havingNode }
+
+type havingOr struct{ left, right havingNode }
+
+func (c havingCondition) match(e *sortEntry) bool {
+	v := c.get(e)
+	switch c.comparator {
+	case "=":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	case "<":
+		return v < c.value
+	case ">":
+		return v > c.value
+	case "<=":
+		return v <= c.value
+	case ">=":
+		return v >= c.value
+	}
+	return false
+}
+
+func (n havingNot) match(e *sortEntry) bool { return !n.node.match(e) }
+func (n havingAnd) match(e *sortEntry) bool { return n.left.match(e) && n.right.match(e) }
+func (n havingOr) match(e *sortEntry) bool  { return n.left.match(e) || n.right.match(e) }
+
+func (c havingCondition) String() string {
+	return fmt.Sprintf("%s %s %d", c.counter, c.comparator, c.value)
+}
+func (n havingNot) String() string { return "!(" + n.node.String() + ")" }
+func (n havingAnd) String() string { return "(" + n.left.String() + " & " + n.right.String() + ")" }
+func (n havingOr) String() string  { return "(" + n.left.String() + " | " + n.right.String() + ")" }
+
+// havingCounters maps the counters a having filter can refer to onto their values. The
+// counters "bytes" and "packets" depend on the direction of the query, i.e. they refer to
+// the counters as printed
+var havingCounters = map[string]func(d Direction) func(e *sortEntry) uint64{
+	"bytes": func(d Direction) func(e *sortEntry) uint64 {
+		switch d {
+		case DirectionIn:
+			return func(e *sortEntry) uint64 { return e.nBr }
+		case DirectionOut:
+			return func(e *sortEntry) uint64 { return e.nBs }
+		}
+		return func(e *sortEntry) uint64 { return e.nBr + e.nBs }
+	},
+	"packets": func(d Direction) func(e *sortEntry) uint64 {
+		switch d {
+		case DirectionIn:
+			return func(e *sortEntry) uint64 { return e.nPr }
+		case DirectionOut:
+			return func(e *sortEntry) uint64 { return e.nPs }
+		}
+		return func(e *sortEntry) uint64 { return e.nPr + e.nPs }
+	},
+	"bytes_rcvd":   func(Direction) func(e *sortEntry) uint64 { return func(e *sortEntry) uint64 { return e.nBr } },
+	"bytes_sent":   func(Direction) func(e *sortEntry) uint64 { return func(e *sortEntry) uint64 { return e.nBs } },
+	"packets_rcvd": func(Direction) func(e *sortEntry) uint64 { return func(e *sortEntry) uint64 { return e.nPr } },
+	"packets_sent": func(Direction) func(e *sortEntry) uint64 { return func(e *sortEntry) uint64 { return e.nPs } },
+}
+
+// parseHaving parses a having filter for a query considering the counters of the given
+// direction. Filters are tokenized like conditionals and follow the same grammar, with
+// conditions on counters instead of attributes:
+//
+//	having -> disjunction
+//	disjunction -> conjunction ('|' conjunction)*
+//	conjunction -> negation ('&' negation)*
+//	negation -> '!' primitive | primitive
+//	primitive -> '(' disjunction ')' | condition
+//	condition -> counter comparator value
+//	counter -> 'bytes' | 'packets' | 'bytes_rcvd' | 'bytes_sent' | 'packets_rcvd' | 'packets_sent'
+//
+// Values may carry a unit suffix (see parseCounterValue). An empty filter yields a nil node
+func parseHaving(having string, d Direction) (havingNode, error) {
+	sanitized, err := goDB.SanitizeUserInput(having)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := goDB.TokenizeConditional(sanitized)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := &havingParser{tokens: tokens, direction: d}
+	node := p.disjunction()
+	if p.err == nil && p.pos < len(p.tokens) {
+		p.fail("unexpected %q", p.tokens[p.pos])
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return node, nil
+}
+
+// havingParser is a recursive descent parser for having filters, structured like the
+// parser of conditionals
+type havingParser struct {
+	tokens    []string
+	pos       int
+	direction Direction
+	err       error
+}
+
+// fail records the first parsing error
+func (p *havingParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%s in '%s'", fmt.Sprintf(format, args...), strings.Join(p.tokens, " "))
+	}
+}
+
+func (p *havingParser) accept(token string) bool {
+	if p.err == nil && p.pos < len(p.tokens) && p.tokens[p.pos] == token {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *havingParser) next() string {
+	if p.pos >= len(p.tokens) {
+		p.fail("unexpected end of input")
+		return ""
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+func (p *havingParser) disjunction() havingNode {
+	node := p.conjunction()
+	for p.accept("|") {
+		node = havingOr{left: node, right: p.conjunction()}
+	}
+	return node
+}
+
+func (p *havingParser) conjunction() havingNode {
+	node := p.negation()
+	for p.accept("&") {
+		node = havingAnd{left: node, right: p.negation()}
+	}
+	return node
+}
+
+func (p *havingParser) negation() havingNode {
+	if p.accept("!") {
+		return havingNot{node: p.primitive()}
+	}
+	return p.primitive()
+}
+
+func (p *havingParser) primitive() havingNode {
+	if p.accept("(") {
+		node := p.disjunction()
+		if !p.accept(")") {
+			p.fail("expected )")
+		}
+		return node
+	}
+	return p.condition()
+}
+
+func (p *havingParser) condition() havingNode {
+	var c havingCondition
+
+	c.counter = p.next()
+	counter, exists := havingCounters[c.counter]
+	if !exists {
+		p.fail("expected counter instead of %q", c.counter)
+		return c
+	}
+	c.get = counter(p.direction)
+
+	switch c.comparator = p.next(); c.comparator {
+	case "=", "!=", "<", ">", "<=", ">=":
+	default:
+		p.fail("expected comparison operator instead of %q", c.comparator)
+		return c
+	}
+
+	value := p.next()
+	if p.err != nil {
+		return c
+	}
+	var err error
+	if c.value, err = parseCounterValue(value, strings.HasPrefix(c.counter, "bytes")); err != nil {
+		p.fail("%s", err)
+	}
+	return c
+}
+
+// parseCounterValue parses a counter value with an optional unit suffix k, M, G, T or P.
+// In line with the printed values, the units of byte counters are powers of 1024 (and may
+// be followed by "B" or "iB", e.g. 1.5GB), those of packet counters powers of 1000
+func parseCounterValue(s string, bytes bool) (uint64, error) {
+	value := strings.ToLower(s)
+	if bytes {
+		value = strings.TrimSuffix(strings.TrimSuffix(value, "b"), "i")
+	}
+
+	base, multiplier := 1000.0, 1.0
+	if bytes {
+		base = 1024
+	}
+	if i := strings.IndexAny(value, "kmgtp"); i >= 0 && i == len(value)-1 {
+		multiplier = math.Pow(base, float64(strings.IndexByte("kmgtp", value[i])+1))
+		value = value[:i]
+	}
+
+	if multiplier == 1 {
+		if v, err := strconv.ParseUint(value, 10, 64); err == nil {
+			return v, nil
+		}
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 || math.IsInf(v, 0) || math.IsNaN(v) || v*multiplier >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid counter value %q", s)
+	}
+	return uint64(v * multiplier), nil
+}
@@ -0,0 +1,104 @@
+package query
+
+import (
+	"testing"
+)
+
+func TestParseCounterValue(t *testing.T) {
+	var tests = []struct {
+		in      string
+		bytes   bool
+		value   uint64
+		success bool
+	}{
+		{"100", false, 100, true},
+		{"18446744073709551615", false, 18446744073709551615, true},
+		{"10k", false, 10000, true},
+		{"1.5M", false, 1500000, true},
+		{"2g", false, 2000000000, true},
+		{"1k", true, 1024, true},
+		{"1G", true, 1 << 30, true},
+		{"1gb", true, 1 << 30, true},
+		{"1.5GiB", true, 3 << 29, true},
+		{"2T", true, 2 << 40, true},
+		{"1gb", false, 0, false},
+		{"-1", false, 0, false},
+		{"1x", true, 0, false},
+		{"k", true, 0, false},
+		{"100000p", true, 0, false},
+	}
+
+	for _, test := range tests {
+		value, err := parseCounterValue(test.in, test.bytes)
+		if test.success != (err == nil) {
+			t.Fatalf("%q: unexpected error: %v", test.in, err)
+		}
+		if value != test.value {
+			t.Fatalf("%q: want %d, have %d", test.in, test.value, value)
+		}
+	}
+}
+
+func TestParseHaving(t *testing.T) {
+	var tests = []struct {
+		in        string
+		direction Direction
+		out       string
+		success   bool
+	}{
+		{"", DirectionSum, "", true},
+		{"bytes > 1k", DirectionSum, "bytes > 1024", true},
+		{"bytes_sent > 1G & packets < 100", DirectionBoth, "(bytes_sent > 1073741824 & packets < 100)", true},
+		{"packets >= 10k or !(bytes_rcvd = 0)", DirectionIn, "(packets >= 10000 | !(bytes_rcvd = 0))", true},
+		{"(packets_rcvd < 1 | packets_sent < 1) & bytes != 5", DirectionOut, "((packets_rcvd < 1 | packets_sent < 1) & bytes != 5)", true},
+		{"dport > 100", DirectionSum, "", false},
+		{"bytes 100", DirectionSum, "", false},
+		{"bytes >", DirectionSum, "", false},
+		{"bytes > 1x", DirectionSum, "", false},
+		{"(bytes > 1", DirectionSum, "", false},
+		{"bytes > 1 packets < 2", DirectionSum, "", false},
+	}
+
+	for _, test := range tests {
+		node, err := parseHaving(test.in, test.direction)
+		if test.success != (err == nil) {
+			t.Fatalf("%q: unexpected error: %v", test.in, err)
+		}
+		if node == nil {
+			if test.out != "" {
+				t.Fatalf("%q: want %s, have nil", test.in, test.out)
+			}
+			continue
+		}
+		if node.String() != test.out {
+			t.Fatalf("%q: want %s, have %s", test.in, test.out, node)
+		}
+	}
+
+	// the counters depend on the direction
+	e := &sortEntry{nBr: 1, nBs: 2, nPr: 10, nPs: 20}
+	for _, test := range []struct {
+		having    string
+		direction Direction
+		match     bool
+	}{
+		{"bytes = 3 & packets = 30", DirectionSum, true},
+		{"bytes = 3 & packets = 30", DirectionBoth, true},
+		{"bytes = 1 & packets = 10", DirectionIn, true},
+		{"bytes = 2 & packets = 20", DirectionOut, true},
+		{"bytes = 2", DirectionIn, false},
+		{"bytes_rcvd < bytes_sent", DirectionSum, false},
+		{"!(packets_sent > 20) & packets_sent >= 20", DirectionIn, true},
+	} {
+		node, err := parseHaving(test.having, test.direction)
+		if err != nil {
+			if test.match {
+				t.Fatalf("%q: unexpected error: %s", test.having, err)
+			}
+			continue
+		}
+		if node.match(e) != test.match {
+			t.Fatalf("%q (%s): want match %t", test.having, test.direction, test.match)
+		}
+	}
+}
@@ -14,10 +14,16 @@ const (
 	// Variables for manual garbage collection calls
 	goGCInterval = 5 * time.Second
 	goGCLimit    = 6291456 // Limit for GC call, in bytes
+
+	// spillMemFraction denotes the fraction of the maximum allowed memory above which the
+	// aggregated flows are spilled to disk
+	spillMemFraction = 0.75
 )
 
-// watchHeap makes sure to alert on too high memory consumption
-func watchHeap(maxAllowedMemPct int, errors chan error) chan struct{} {
+// watchHeap makes sure to alert on too high memory consumption. Once memory consumption
+// exceeds spillMemFraction of the allowed memory, the aggregation is asked to spill to disk
+// via pressure. The query is aborted via errors if it exceeds the allowed memory nonetheless
+func watchHeap(maxAllowedMemPct int, errors chan error, pressure chan struct{}) chan struct{} {
 	stopChan := make(chan struct{})
 
 	go func() {
@@ -53,6 +59,12 @@ func watchHeap(maxAllowedMemPct int, errors chan error) chan struct{} {
 					errors <- fmt.Errorf("memory consumption above %v%% of physical memory. Aborting query", maxAllowedMemPct)
 					return
 				}
+				if float64(usedMem/1024) > spillMemFraction*float64(maxAllowedMem) {
+					select {
+					case pressure <- struct{}{}:
+					default:
+					}
+				}
 
 				// Conditionally call a manual garbage collection and memory release if the current heap allocation
 				// is above goGCLimit and more than goGCInterval seconds have passed
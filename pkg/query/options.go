@@ -1,11 +1,16 @@
 package query
 
+import "github.com/els0r/goProbe/pkg/goDB/storage"
+
 // Option allows to modify an existing Args container
 type Option func(*Args)
 
 // WithCondition sets the condition argument
 func WithCondition(c string) Option { return func(a *Args) { a.Condition = c } }
 
+// WithHaving sets the filter on the aggregated counters
+func WithHaving(h string) Option { return func(a *Args) { a.Having = h } }
+
 // WithDirectionIn considers the incoming flows
 func WithDirectionIn() Option { return func(a *Args) { a.In = true } }
 
@@ -21,6 +26,21 @@ func WithFirst(f string) Option { return func(a *Args) { a.First = f } }
 // WithLast sets the last timestampt to consider
 func WithLast(l string) Option { return func(a *Args) { a.Last = l } }
 
+// WithResolution sets the size of the buckets of the time attribute
+func WithResolution(r string) Option { return func(a *Args) { a.Resolution = r } }
+
+// WithTimeZone sets the time zone the time buckets are aligned to
+func WithTimeZone(tz string) Option { return func(a *Args) { a.TimeZone = tz } }
+
+// WithZeroFill prints empty time buckets
+func WithZeroFill() Option { return func(a *Args) { a.ZeroFill = true } }
+
+// WithGroupBy groups the entries by the given attributes, e.g. sip
+func WithGroupBy(g string) Option { return func(a *Args) { a.GroupBy = g } }
+
+// WithLimitPerGroup sets how many entries are returned per group
+func WithLimitPerGroup(n int) Option { return func(a *Args) { a.LimitPerGroup = n } }
+
 // WithFormat sets the output format
 func WithFormat(f string) Option { return func(a *Args) { a.Format = f } }
 
@@ -57,6 +77,12 @@ func WithResolveRows(r int) Option { return func(a *Args) { a.ResolveRows = r }
 // WithDBPath sets the location of the goDB
 func WithDBPath(p string) Option { return func(a *Args) { a.DBPath = p } }
 
+// WithStore sets the store from which the DB's storage backends are read
+func WithStore(s storage.Store) Option { return func(a *Args) { a.Store = s } }
+
+// WithKeyFiles sets the key files used to decrypt encrypted blocks
+func WithKeyFiles(paths ...string) Option { return func(a *Args) { a.KeyFiles = paths } }
+
 // WithMaxMemPct is an advanced parameter to restrict system memory usage to a fixed percentage of the available memory during query processing
 func WithMaxMemPct(m int) Option { return func(a *Args) { a.MaxMemPct = m } }
 
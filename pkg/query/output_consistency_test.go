@@ -13,6 +13,7 @@ package query
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -101,37 +102,80 @@ func TestOutputConsistency(t *testing.T) {
 		var buf = &bytes.Buffer{}
 		var stmt *Statement
 		for i, args := range arguments {
-			buf.Reset()
-
-			// prepare query
-			stmt, err = args.Prepare(buf)
-			if err != nil {
-				t.Fatalf("[%d] failed to prepare query: %s", i, err)
-			}
-
-			// run query
-			err = stmt.Execute()
-			if err != nil {
-				t.Fatalf("[%d] failed to run query: %s", i, err)
+			// each query is run in memory and spilling the aggregated flows to disk
+			// after every workload, which must not change the output
+			for _, maxAggregateEntries := range []int{0, 1} {
+				buf.Reset()
+
+				// prepare query
+				stmt, err = args.Prepare(context.Background(), buf)
+				if err != nil {
+					t.Fatalf("[%d] failed to prepare query: %s", i, err)
+				}
+				stmt.maxAggregateEntries = maxAggregateEntries
+
+				// run query
+				err = stmt.Execute(context.Background())
+				if err != nil {
+					t.Fatalf("[%d] failed to run query: %s", i, err)
+				}
+
+				actualOutputJSON := buf.Bytes()
+
+				var actualOutput interface{}
+				err = jsoniter.Unmarshal(actualOutputJSON, &actualOutput)
+				if err != nil {
+					t.Fatalf("[%d] failed to decode JSON output: %s\n%s", i, err, string(actualOutputJSON))
+				}
+				var match bool
+				match, err = outputMatches(expectedOutput, actualOutput)
+				if err != nil || !match {
+					t.Logf("[%d] arguments: from %s\n%s", i, argumentFile, args.String())
+					//				mi, _ := jsoniter.MarshalIndent(expectedOutput, "", " ")
+					//				t.Fatalf("[%d] output from testcase %s doesn't match correct output.\nWant: %s", i, testCase, string(mi))
+					t.Fatalf("[%d] output from testcase %s doesn't match correct output (spilling after %d entries): %s", i, testCase, maxAggregateEntries, err)
+				}
 			}
+		}
+	}
+}
 
-			actualOutputJSON := buf.Bytes()
+// BenchmarkOutputConsistency runs the queries of all output consistency tests, reporting
+// their allocations and the peak heap in use (as peak-heap-B)
+func BenchmarkOutputConsistency(b *testing.B) {
+	testCases, err := testCases()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var arguments []Args
+	for _, testCase := range testCases {
+		argumentsJSON, err := ioutil.ReadFile(path.Join(outputConsistencyDir, testCase+argsSuffix))
+		if err != nil {
+			b.Fatalf("Could not read argument file of %s. Error: %s", testCase, err)
+		}
+		var caseArguments []Args
+		if err = jsoniter.Unmarshal(argumentsJSON, &caseArguments); err != nil {
+			b.Fatalf("Could not decode argument file of %s. Error: %s", testCase, err)
+		}
+		arguments = append(arguments, caseArguments...)
+	}
 
-			var actualOutput interface{}
-			err = jsoniter.Unmarshal(actualOutputJSON, &actualOutput)
+	b.ReportAllocs()
+	stop := samplePeakHeap()
+	var buf = &bytes.Buffer{}
+	for n := 0; n < b.N; n++ {
+		for i, args := range arguments {
+			buf.Reset()
+			stmt, err := args.Prepare(context.Background(), buf)
 			if err != nil {
-				t.Fatalf("[%d] failed to decode JSON output: %s\n%s", i, err, string(actualOutputJSON))
+				b.Fatalf("[%d] failed to prepare query: %s", i, err)
 			}
-			var match bool
-			match, err = outputMatches(expectedOutput, actualOutput)
-			if err != nil || !match {
-				t.Logf("[%d] arguments: from %s\n%s", i, argumentFile, args.String())
-				//				mi, _ := jsoniter.MarshalIndent(expectedOutput, "", " ")
-				//				t.Fatalf("[%d] output from testcase %s doesn't match correct output.\nWant: %s", i, testCase, string(mi))
-				t.Fatalf("[%d] output from testcase %s doesn't match correct output: %s", i, testCase, err)
+			if err = stmt.Execute(context.Background()); err != nil {
+				b.Fatalf("[%d] failed to run query: %s", i, err)
 			}
 		}
 	}
+	b.ReportMetric(float64(stop()), "peak-heap-B")
 }
 
 func testCases() (testCases []string, err error) {
@@ -1,16 +1,17 @@
 package query
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"time"
 
 	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/storage"
 	"github.com/els0r/goProbe/pkg/query/dns"
 	jsoniter "github.com/json-iterator/go"
 )
@@ -29,6 +30,7 @@ type Statement struct {
 
 	// needed for feedback to user
 	Conditions string `json:"condition,omitempty"`
+	Having     string `json:"having,omitempty"`
 	QueryType  string `json:"query_type"`
 
 	// which direction is added
@@ -38,6 +40,15 @@ type Statement struct {
 	First int64 `json:"from"`
 	Last  int64 `json:"to"`
 
+	// time bucketing
+	Resolution time.Duration  `json:"resolution,omitempty"`
+	Location   *time.Location `json:"-"`
+	ZeroFill   bool           `json:"zero_fill,omitempty"`
+
+	// grouping
+	GroupBy       string `json:"group_by,omitempty"`
+	LimitPerGroup int    `json:"limit_per_group,omitempty"`
+
 	// formatting
 	Format        string    `json:"format"`
 	NumResults    int       `json:"limit"`
@@ -58,6 +69,22 @@ type Statement struct {
 	DBPath    string `json:"db"`
 	MaxMemPct int    `json:"-"`
 
+	// store provides access to the DB's storage backends
+	store storage.Store
+
+	// havingFilter selects the aggregated entries shown (all if nil)
+	havingFilter havingNode
+
+	// grouping groups the aggregated entries (no grouping if nil)
+	grouping *grouping
+
+	// seriesLess ranks the time series of zero-filled queries by their totals
+	seriesLess by
+
+	// maxAggregateEntries forces spilling the aggregated flows to disk once they exceed
+	// the given number of entries (instead of only when exceeding the memory budget)
+	maxAggregateEntries int
+
 	// query statistics
 	Stats ExecutionStats `json:"query_stats"`
 
@@ -124,6 +151,12 @@ func (s *Statement) String() string {
 	if s.Conditions != "" {
 		str += fmt.Sprintf(", condition: %s", s.Conditions)
 	}
+	if s.Having != "" {
+		str += fmt.Sprintf(", having: %s", s.Having)
+	}
+	if s.GroupBy != "" {
+		str += fmt.Sprintf(", group-by: %s, limit-per-group: %d", s.GroupBy, s.LimitPerGroup)
+	}
 	tFrom, tTo := time.Unix(s.First, 0), time.Unix(s.Last, 0)
 	str += fmt.Sprintf(", db: %s, limit: %d, from: %s, to: %s",
 		s.DBPath,
@@ -142,28 +175,31 @@ func (s *Statement) String() string {
 	return str
 }
 
-// log writes a json marshaled query statement to disk
+// log writes a json marshaled query statement to the DB's query log
 func (s *Statement) log() {
+	if s.store == nil {
+		return
+	}
 
-	// open the file in append mode
-	querylog, err := os.OpenFile(filepath.Join(s.DBPath, goDB.QueryLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	data, err := jsoniter.Marshal(s)
 	if err != nil {
 		return
 	}
-	defer querylog.Close()
 
-	// opportunistically write statement to disk
-	err = jsoniter.NewEncoder(querylog).Encode(s)
+	// opportunistically append statement to the query log
+	s.store.AppendFile(filepath.Join(s.DBPath, goDB.QueryLogFile), append(data, '\n'))
 }
 
-// Execute runs the query with the provided parameters
-func (s *Statement) Execute() error {
+// Execute runs the query with the provided parameters. Execution is aborted once ctx is
+// done, in which case the returned error wraps the error of ctx
+func (s *Statement) Execute(ctx context.Context) error {
 
 	var err error
 
 	// start ticker to check memory consumption every second
 	memErrors := make(chan error, 1)
-	stopHeapWatch := watchHeap(s.MaxMemPct, memErrors)
+	memPressure := make(chan struct{}, 1)
+	stopHeapWatch := watchHeap(s.MaxMemPct, memErrors, memPressure)
 
 	// make sure the memory ticker stops upon function return
 	defer func() {
@@ -201,16 +237,20 @@ func (s *Statement) Execute() error {
 		return fmt.Errorf("query is not executable")
 	}
 
-	// create work managers
-	workManagers := map[string]*goDB.DBWorkManager{} // map interfaces to workManagers
+	// create work managers. The flows are keyed by the index of their interface in ifaces
+	var (
+		workManagers []*goDB.DBWorkManager // in the order of the interfaces
+		ifaces       []string
+	)
 	for _, iface := range s.Ifaces {
-		wm, nonempty, err := createWorkManager(s.DBPath, iface, s.First, s.Last, s.Query, numProcessingUnits)
+		wm, nonempty, err := createWorkManager(s.store, s.DBPath, iface, s.First, s.Last, s.Query, numProcessingUnits)
 		if err != nil {
 			return err
 		}
 		// Only add work managers that have work to do.
 		if nonempty {
-			workManagers[iface] = wm
+			workManagers = append(workManagers, wm)
+			ifaces = append(ifaces, iface)
 		}
 	}
 
@@ -227,17 +267,24 @@ func (s *Statement) Execute() error {
 	}
 
 	// Channel for handling of returned maps
-	mapChan := make(chan map[goDB.ExtraKey]goDB.Val, 1024)
-	aggregateChan := aggregate(mapChan)
-
-	// spawn reader processing units and make them work on the individual DB blocks
-	// processing by interface is sequential, e.g. for multi-interface queries
-	for _, workManager := range workManagers {
-		err = workManager.ExecuteWorkerReadJobs(mapChan, memErrors)
+	mapChan := make(chan map[goDB.CompactKey]*goDB.Val, 1024)
+	aggregateChan := aggregate(ctx, mapChan, spillConfig{
+		pressure:   memPressure,
+		maxEntries: s.maxAggregateEntries,
+	})
+
+	// spawn reader processing units and make them work on the individual DB blocks. The
+	// workloads of all interfaces share the processing units, e.g. for multi-interface queries
+	if len(workManagers) > 0 {
+		err = goDB.ExecuteReadJobs(ctx, workManagers, numProcessingUnits, mapChan, memErrors)
 		if err != nil {
 
-			// an error from the routine can only be of type memory error
-			err = fmt.Errorf("%w: %v", errorMemoryBreach, err)
+			// an error from the routine is either due to cancellation or of type memory error
+			if ctx.Err() != nil {
+				err = fmt.Errorf("query aborted: %w", ctx.Err())
+			} else {
+				err = fmt.Errorf("%w: %v", errorMemoryBreach, err)
+			}
 
 			// close the map channel. This will make sure that the aggregation routine
 			// actually finishes
@@ -245,6 +292,9 @@ func (s *Statement) Execute() error {
 
 			// empty the aggregateChan
 			agg := <-aggregateChan
+			if agg.spilled != nil {
+				agg.spilled.remove()
+			}
 
 			// call the garbage collector
 			agg.aggregatedMap = nil
@@ -265,34 +315,55 @@ func (s *Statement) Execute() error {
 		switch err {
 		case errorNoResults:
 			return s.noResults()
+		case context.Canceled, context.DeadlineExceeded:
+			err = fmt.Errorf("query aborted: %w", err)
+			return err
 		default:
 			return err
 		}
 	}
-
-	/// DATA PRESENATION ///
-	var mapEntries = make([]Entry, len(agg.aggregatedMap))
-	var val goDB.Val
-	count := 0
-
-	for mapEntries[count].k, val = range agg.aggregatedMap {
-
-		mapEntries[count].nBr = val.NBytesRcvd
-		mapEntries[count].nPr = val.NPktsRcvd
-		mapEntries[count].nBs = val.NBytesSent
-		mapEntries[count].nPs = val.NPktsSent
-
-		count++
+	if agg.spilled != nil {
+		defer agg.spilled.remove()
 	}
 
-	// Now is a good time to release memory one last time for the final processing step
-	agg.aggregatedMap = nil
-	runtime.GC()
-	debug.FreeOSMemory()
+	/// DATA PRESENATION ///
 
 	// there is no need to sort influxdb datapoints
+	var less by
 	if s.Format != "influxdb" {
-		By(s.SortBy, s.Direction, s.SortAscending).Sort(mapEntries)
+		less = By(s.SortBy, s.Direction, s.SortAscending)
+	}
+	var (
+		mapEntries []Entry
+		groups     []Group
+		count      int
+	)
+	keys := keyDecoder{query: s.Query, ifaces: ifaces}
+	if s.grouping != nil {
+		groups, count, err = agg.groups(ctx, s.havingFilter, s.grouping, s.NumResults, keys)
+	} else if s.ZeroFill {
+		// the limit applies to the number of series, whose gaps are filled below
+		mapEntries, count, err = agg.series(ctx, s.havingFilter, s.seriesLess, s.NumResults, keys)
+	} else {
+		mapEntries, count, err = agg.entries(ctx, s.havingFilter, less, s.NumResults, keys)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("query aborted: %w", ctx.Err())
+		}
+		return err
+	}
+	if count == 0 {
+		// all entries were filtered out by the having filter
+		return s.noResults()
+	}
+
+	// fill the gaps of the time series
+	if s.ZeroFill {
+		mapEntries, err = zeroFill(mapEntries, tSpanFirst.Unix()+goDB.DBWriteInterval, tSpanLast.Unix(), s.Resolution, s.Location, MaxResults)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Find map from ips to domains for reverse DNS
@@ -310,8 +381,14 @@ func (s *Statement) Execute() error {
 			}
 		}
 
-		for i, l := 0, len(mapEntries); i < l && i < s.ResolveRows; i++ {
-			key := mapEntries[i].k
+		// the rows printed, in order
+		rows := mapEntries
+		for _, group := range groups {
+			rows = append(rows, group.total)
+			rows = append(rows, group.entries...)
+		}
+		for i, l := 0, len(rows); i < l && i < s.ResolveRows; i++ {
+			key := rows[i].k
 			if sip != nil {
 				ips = append(ips, sip.ExtractStrings(&key)[0])
 			}
@@ -321,7 +398,7 @@ func (s *Statement) Execute() error {
 		}
 
 		resolveStart := time.Now()
-		ips2domains = dns.TimedReverseLookup(ips, s.ResolveTimeout)
+		ips2domains = dns.TimedReverseLookup(ctx, ips, s.ResolveTimeout)
 		resolveDuration = time.Now().Sub(resolveStart)
 	}
 
@@ -338,26 +415,49 @@ func (s *Statement) Execute() error {
 
 	// stop timing everything related to the query and store the hits
 	s.Stats.Duration = time.Now().Sub(s.Stats.Start)
-	s.Stats.Hits = len(mapEntries)
+	s.Stats.Hits = count
 
 	// fill the printer
-	if s.NumResults < len(mapEntries) {
-		mapEntries = mapEntries[:s.NumResults]
-	}
-	s.Stats.HitsDisplayed = len(mapEntries)
-	for doneFilling := false; !doneFilling; {
+	add := func(addRow func(Entry), entry Entry) error {
 		select {
-		case err = <-memErrors:
+		case err := <-memErrors:
 			return fmt.Errorf("%w: %v", errorMemoryBreach, err)
+		case <-ctx.Done():
+			return fmt.Errorf("query aborted: %w", ctx.Err())
 		default:
-			for _, entry := range mapEntries {
-				printer.AddRow(entry)
+			addRow(entry)
+			return nil
+		}
+	}
+	s.Stats.HitsDisplayed = len(mapEntries)
+	for _, entry := range mapEntries {
+		if err = add(printer.AddRow, entry); err != nil {
+			return err
+		}
+	}
+	for _, group := range groups {
+		s.Stats.HitsDisplayed += len(group.entries)
+		if err = add(printer.AddGroup, group.total); err != nil {
+			return err
+		}
+		for _, entry := range group.entries {
+			if err = add(printer.AddRow, entry); err != nil {
+				return err
 			}
-			doneFilling = true
 		}
 	}
+	if s.Location != nil {
+		tSpanFirst, tSpanLast = tSpanFirst.In(s.Location), tSpanLast.In(s.Location)
+	}
 	printer.Footer(s.Conditions, tSpanFirst, tSpanLast, s.Stats.Duration, resolveDuration)
 
+	// nothing has been written yet, hence a query aborted during resolution or while
+	// filling the printer doesn't leave partial output behind
+	if ctx.Err() != nil {
+		err = fmt.Errorf("query aborted: %w", ctx.Err())
+		return err
+	}
+
 	// print the data
 	err = printer.Print()
 	if err != nil {
@@ -367,8 +467,8 @@ func (s *Statement) Execute() error {
 	return nil
 }
 
-func createWorkManager(dbPath string, iface string, tfirst, tlast int64, query *goDB.Query, numProcessingUnits int) (workManager *goDB.DBWorkManager, nonempty bool, err error) {
-	workManager, err = goDB.NewDBWorkManager(dbPath, iface, numProcessingUnits)
+func createWorkManager(store storage.Store, dbPath string, iface string, tfirst, tlast int64, query *goDB.Query, numProcessingUnits int) (workManager *goDB.DBWorkManager, nonempty bool, err error) {
+	workManager, err = goDB.NewDBWorkManager(dbPath, iface, numProcessingUnits, goDB.WithStore(store))
 	if err != nil {
 		return nil, false, fmt.Errorf("could not initialize query work manager for interface '%s': %s", iface, err)
 	}
@@ -2,8 +2,14 @@ package query
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/els0r/goProbe/pkg/goDB"
+	"github.com/els0r/goProbe/pkg/goDB/encoder/encoders"
+	"github.com/els0r/goProbe/pkg/goDB/storage/memory"
 	jsoniter "github.com/json-iterator/go"
 )
 
@@ -36,7 +42,7 @@ func TestEmptyOutput(t *testing.T) {
 			a := NewArgs(test.query, test.iface, test.opts...)
 
 			// prepare query
-			stmt, err := a.Prepare()
+			stmt, err := a.Prepare(context.Background())
 			if err != nil {
 				t.Fatalf("prepare query: %s; args: %s", err, a)
 			}
@@ -46,7 +52,7 @@ func TestEmptyOutput(t *testing.T) {
 			stmt.Output = buf
 
 			// execute query
-			err = stmt.Execute()
+			err = stmt.Execute(context.Background())
 			if err != nil {
 				t.Fatalf("execute query: %s", err)
 			}
@@ -95,16 +101,216 @@ func TestSimpleQuery(t *testing.T) {
 			a := NewArgs(test.query, test.iface, test.opts...)
 
 			// prepare query
-			stmt, err := a.Prepare()
+			stmt, err := a.Prepare(context.Background())
 			if err != nil {
 				t.Fatalf("prepare query: %s", err)
 			}
 
 			// execute query
-			err = stmt.Execute()
+			err = stmt.Execute(context.Background())
 			if err != nil {
 				t.Fatalf("execute query: %s", err)
 			}
 		})
 	}
 }
+
+// Check that time buckets aggregate the blocks and are zero-filled
+func TestTimeResolution(t *testing.T) {
+	type row struct {
+		Time    int64  `json:"time,string"`
+		Sip     string `json:"sip"`
+		Dip     string `json:"dip"`
+		Proto   string `json:"proto"`
+		Packets int64  `json:"packets"`
+		Bytes   int64  `json:"bytes"`
+	}
+	run := func(query string, opts ...Option) (rows []row) {
+		opts = append([]Option{WithDirectionSum(), WithDBPath(TestDB), WithFirst("1456428000"), WithLast("1456473000"),
+			WithNumResults(MaxResults), WithFormat("json"), WithTimeZone("UTC")}, opts...)
+		stmt, err := NewArgs(query, "eth1", opts...).Prepare(context.Background())
+		if err != nil {
+			t.Fatalf("prepare query: %s", err)
+		}
+		var buf = &bytes.Buffer{}
+		stmt.Output = buf
+		if err = stmt.Execute(context.Background()); err != nil {
+			t.Fatalf("execute query: %s", err)
+		}
+
+		var output map[string]jsoniter.RawMessage
+		if err = jsoniter.Unmarshal(buf.Bytes(), &output); err != nil {
+			t.Fatalf("failed to parse output as JSON: %s", err)
+		}
+		if err = jsoniter.Unmarshal(output[query], &rows); err != nil {
+			t.Fatalf("failed to parse rows: %s", err)
+		}
+		return rows
+	}
+
+	var bytesPerHour = make(map[int64]int64)
+	for _, row := range run("time") {
+		bytesPerHour[(row.Time-goDB.DBWriteInterval)/3600*3600] += row.Bytes
+	}
+
+	rows := run("time:1h", WithZeroFill())
+	if len(rows) < 2 {
+		t.Fatalf("expected several hourly buckets, got %d", len(rows))
+	}
+	for i, row := range rows {
+		if i > 0 && row.Time != rows[i-1].Time+3600 {
+			t.Fatalf("buckets %d and %d are not consecutive hours", rows[i-1].Time, row.Time)
+		}
+		if row.Bytes != bytesPerHour[row.Time] {
+			t.Fatalf("bucket %d: want %d bytes, have %d", row.Time, bytesPerHour[row.Time], row.Bytes)
+		}
+		delete(bytesPerHour, row.Time)
+	}
+	if len(bytesPerHour) > 0 {
+		t.Fatalf("buckets missing: %v", bytesPerHour)
+	}
+
+	// with a limit, only the top series by packets are shown, with all their buckets
+	type series struct{ sip, dip string }
+	var (
+		full        = make(map[row]struct{})
+		seriesTotal = make(map[series]int64)
+	)
+	for _, r := range run("time:1h,sip,dip", WithZeroFill()) {
+		full[r] = struct{}{}
+		seriesTotal[series{r.Sip, r.Dip}] += r.Packets
+	}
+	const limit = 3
+	if len(seriesTotal) <= limit {
+		t.Fatalf("expected more than %d series, got %d", limit, len(seriesTotal))
+	}
+	limited := run("time:1h,sip,dip", WithZeroFill(), WithNumResults(limit))
+	if len(limited) != limit*len(rows) {
+		t.Fatalf("unexpected number of rows with limit: want %d, have %d", limit*len(rows), len(limited))
+	}
+	shown := make(map[series]struct{})
+	for _, r := range limited {
+		if _, exists := full[r]; !exists {
+			t.Fatalf("unexpected row with limit: %+v", r)
+		}
+		shown[series{r.Sip, r.Dip}] = struct{}{}
+	}
+	for s := range shown {
+		for other, total := range seriesTotal {
+			if _, exists := shown[other]; !exists && total > seriesTotal[s] {
+				t.Fatalf("series %v (%d packets) shown instead of %v (%d packets)", s, seriesTotal[s], other, total)
+			}
+		}
+	}
+
+	// a resolution requires the time attribute and mustn't conflict with the query type
+	for _, args := range []*Args{
+		NewArgs("sip", "eth1", WithDBPath(TestDB), WithResolution("1h")),
+		NewArgs("time:1h", "eth1", WithDBPath(TestDB), WithResolution("1d")),
+		NewArgs("time", "eth1", WithDBPath(TestDB), WithZeroFill()),
+		NewArgs("time", "eth1", WithDBPath(TestDB), WithResolution("1h"), WithTimeZone("Nowhere/Special")),
+	} {
+		if _, err := args.Prepare(context.Background()); err == nil {
+			t.Fatalf("expected preparation of %s to fail", args)
+		}
+	}
+}
+
+// Check that queries are aborted without output once their context is done
+func TestCanceledQuery(t *testing.T) {
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+
+	var tests = []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{"canceled", canceled, context.Canceled},
+		{"deadline exceeded", expired, context.DeadlineExceeded},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := NewArgs("talk_conv", "eth1", WithDBPath(TestDB), WithFirst("-30000d"), WithFormat("json"))
+			stmt, err := a.Prepare(context.Background())
+			if err != nil {
+				t.Fatalf("prepare query: %s", err)
+			}
+
+			var buf = &bytes.Buffer{}
+			stmt.Output = buf
+
+			err = stmt.Execute(test.ctx)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected query to fail with %v, got: %v", test.err, err)
+			}
+			if buf.Len() != 0 {
+				t.Fatalf("unexpected output of aborted query: %s", buf.String())
+			}
+		})
+	}
+}
+
+// Check that flows written to an in-memory store can be queried without touching the
+// file system
+func TestMemoryStore(t *testing.T) {
+
+	var (
+		store  = memory.NewStore()
+		dbPath = "/memdb"
+		tstamp = int64(1456358700)
+	)
+
+	flows := goDB.AggFlowMap{
+		goDB.Key{Sip: [16]byte{10, 0, 0, 1}, Dip: [16]byte{10, 0, 0, 2}, Dport: [2]byte{0, 80}, Protocol: 6}:  &goDB.Val{NBytesRcvd: 100, NBytesSent: 200, NPktsRcvd: 1, NPktsSent: 2},
+		goDB.Key{Sip: [16]byte{10, 0, 0, 1}, Dip: [16]byte{10, 0, 0, 3}, Dport: [2]byte{1, 187}, Protocol: 6}: &goDB.Val{NBytesRcvd: 300, NBytesSent: 400, NPktsRcvd: 3, NPktsSent: 4},
+		goDB.Key{Sip: [16]byte{10, 0, 0, 4}, Dip: [16]byte{10, 0, 0, 2}, Dport: [2]byte{0, 53}, Protocol: 17}: &goDB.Val{NBytesRcvd: 50, NBytesSent: 50, NPktsRcvd: 1, NPktsSent: 1},
+	}
+
+	w := goDB.NewDBWriter(dbPath, "eth0", encoders.EncoderTypeLZ4, goDB.WithStore(store))
+	for i := int64(0); i < 3; i++ {
+		if _, err := w.Write(flows, goDB.BlockMetadata{Timestamp: tstamp + i*goDB.DBWriteInterval}, tstamp+i*goDB.DBWriteInterval); err != nil {
+			t.Fatalf("write flows: %s", err)
+		}
+	}
+
+	a := NewArgs("sip", "eth0", WithStore(store), WithDBPath(dbPath), WithFirst("0"), WithLast("1456444800"), WithDirectionSum(), WithFormat("json"))
+	stmt, err := a.Prepare(context.Background())
+	if err != nil {
+		t.Fatalf("prepare query: %s", err)
+	}
+
+	var buf = &bytes.Buffer{}
+	stmt.Output = buf
+	if err = stmt.Execute(context.Background()); err != nil {
+		t.Fatalf("execute query: %s", err)
+	}
+
+	var actualOutput struct {
+		Rows []struct {
+			Sip   string `json:"sip"`
+			Bytes uint64 `json:"bytes"`
+		} `json:"sip"`
+	}
+	if err = jsoniter.Unmarshal(buf.Bytes(), &actualOutput); err != nil {
+		t.Fatalf("failed to parse output as JSON: %s", err)
+	}
+	if len(actualOutput.Rows) != 2 {
+		t.Fatalf("unexpected number of rows: %s", buf.String())
+	}
+	if actualOutput.Rows[0].Sip != "10.0.0.1" || actualOutput.Rows[0].Bytes != 3*1000 {
+		t.Fatalf("unexpected first row: %+v", actualOutput.Rows[0])
+	}
+	if actualOutput.Rows[1].Sip != "10.0.0.4" || actualOutput.Rows[1].Bytes != 3*100 {
+		t.Fatalf("unexpected second row: %+v", actualOutput.Rows[1])
+	}
+
+	// the query must have been logged to the store
+	if _, err = store.ReadFile(dbPath + "/" + goDB.QueryLogFile); err != nil {
+		t.Fatalf("query log not written to store: %s", err)
+	}
+}
@@ -25,11 +25,21 @@ type Entry struct {
 	nPr, nPs uint64
 }
 
-type by func(e1, e2 *Entry) bool
+// sortEntry is the compact counterpart of Entry, holding the key of an aggregated flow
+// as it was aggregated. Flows are sorted as sortEntry and only those shown are decoded
+// into an Entry
+type sortEntry struct {
+	key      goDB.CompactKey
+	time     int64
+	nBr, nBs uint64
+	nPr, nPs uint64
+}
+
+type by func(e1, e2 *sortEntry) bool
 
 type entrySorter struct {
-	entries []Entry
-	less    func(e1, e2 *Entry) bool
+	entries []sortEntry
+	less    func(e1, e2 *sortEntry) bool
 }
 
 // String implement human-readable printing of the sort order
@@ -75,7 +85,7 @@ func (s SortOrder) UnmarshalJSON(b []byte) error {
 }
 
 // Sort is a method on the function type, By, that sorts the argument slice according to the function
-func (b by) Sort(entries []Entry) {
+func (b by) Sort(entries []sortEntry) {
 	es := &entrySorter{
 		entries: entries,
 		less:    b, // closure for sort order defintion
@@ -105,29 +115,29 @@ func By(sort SortOrder, direction Direction, ascending bool) by {
 		switch direction {
 		case DirectionBoth, DirectionSum:
 			if ascending {
-				return func(e1, e2 *Entry) bool {
+				return func(e1, e2 *sortEntry) bool {
 					return e1.nPs+e1.nPr < e2.nPs+e2.nPr
 				}
 			}
-			return func(e1, e2 *Entry) bool {
+			return func(e1, e2 *sortEntry) bool {
 				return e1.nPs+e1.nPr > e2.nPs+e2.nPr
 			}
 		case DirectionIn:
 			if ascending {
-				return func(e1, e2 *Entry) bool {
+				return func(e1, e2 *sortEntry) bool {
 					return e1.nPr < e2.nPr
 				}
 			}
-			return func(e1, e2 *Entry) bool {
+			return func(e1, e2 *sortEntry) bool {
 				return e1.nPr > e2.nPr
 			}
 		case DirectionOut:
 			if ascending {
-				return func(e1, e2 *Entry) bool {
+				return func(e1, e2 *sortEntry) bool {
 					return e1.nPs < e2.nPs
 				}
 			}
-			return func(e1, e2 *Entry) bool {
+			return func(e1, e2 *sortEntry) bool {
 				return e1.nPs > e2.nPs
 			}
 		}
@@ -135,40 +145,40 @@ func By(sort SortOrder, direction Direction, ascending bool) by {
 		switch direction {
 		case DirectionBoth, DirectionSum:
 			if ascending {
-				return func(e1, e2 *Entry) bool {
+				return func(e1, e2 *sortEntry) bool {
 					return e1.nBs+e1.nBr < e2.nBs+e2.nBr
 				}
 			}
-			return func(e1, e2 *Entry) bool {
+			return func(e1, e2 *sortEntry) bool {
 				return e1.nBs+e1.nBr > e2.nBs+e2.nBr
 			}
 		case DirectionIn:
 			if ascending {
-				return func(e1, e2 *Entry) bool {
+				return func(e1, e2 *sortEntry) bool {
 					return e1.nBr < e2.nBr
 				}
 			}
-			return func(e1, e2 *Entry) bool {
+			return func(e1, e2 *sortEntry) bool {
 				return e1.nBr > e2.nBr
 			}
 		case DirectionOut:
 			if ascending {
-				return func(e1, e2 *Entry) bool {
+				return func(e1, e2 *sortEntry) bool {
 					return e1.nBs < e2.nBs
 				}
 			}
-			return func(e1, e2 *Entry) bool {
+			return func(e1, e2 *sortEntry) bool {
 				return e1.nBs > e2.nBs
 			}
 		}
 	case SortTime:
 		if ascending {
-			return func(e1, e2 *Entry) bool {
-				return e1.k.Time < e2.k.Time
+			return func(e1, e2 *sortEntry) bool {
+				return e1.time < e2.time
 			}
 		}
-		return func(e1, e2 *Entry) bool {
-			return e1.k.Time > e2.k.Time
+		return func(e1, e2 *sortEntry) bool {
+			return e1.time > e2.time
 		}
 	}
 
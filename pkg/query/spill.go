@@ -0,0 +1,166 @@
+package query
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+)
+
+// numSpillPartitions denotes the number of partitions the key space is split into when
+// spilling aggregated maps to disk. The partitions are merged one after another, hence
+// merging requires about 1/numSpillPartitions of the memory of the full result
+const numSpillPartitions = 64
+
+// spillFiles holds the partitions of the aggregated maps spilled to disk. Each spill
+// appends the entries of a map to the files of their partitions, so a key may occur
+// in several spills, but always in the same partition
+type spillFiles struct {
+	dir     string
+	files   [numSpillPartitions]*os.File
+	writers [numSpillPartitions]*bufio.Writer
+
+	numSpills  int
+	numEntries int
+
+	buf []byte
+}
+
+// newSpillFiles creates a temporary directory for spilled partitions in dir (or the
+// default directory for temporary files if dir is empty)
+func newSpillFiles(dir string) (*spillFiles, error) {
+	tmpDir, err := ioutil.TempDir(dir, "goquery-spill-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %s", err)
+	}
+	return &spillFiles{dir: tmpDir}, nil
+}
+
+// write appends all entries of the map to the partition files
+func (s *spillFiles) write(m map[goDB.CompactKey]goDB.Val) error {
+	for k, v := range m {
+		h := fnv.New32a()
+		h.Write([]byte(k))
+		p := h.Sum32() % numSpillPartitions
+
+		s.buf = appendSpillKey(s.buf[:0], k)
+		s.buf = appendSpillVal(s.buf, v)
+		if s.writers[p] == nil {
+			f, err := os.Create(filepath.Join(s.dir, strconv.Itoa(int(p))))
+			if err != nil {
+				return fmt.Errorf("failed to create spill file: %s", err)
+			}
+			s.files[p], s.writers[p] = f, bufio.NewWriter(f)
+		}
+		if _, err := s.writers[p].Write(s.buf); err != nil {
+			return fmt.Errorf("failed to spill aggregated flows: %s", err)
+		}
+	}
+	s.numSpills++
+	s.numEntries += len(m)
+	return nil
+}
+
+// merge aggregates the entries of each partition in a map and hands it to fn, one
+// partition after another. The map is only valid during the call
+func (s *spillFiles) merge(ctx context.Context, fn func(map[goDB.CompactKey]goDB.Val)) error {
+	for p, w := range s.writers {
+		if w == nil {
+			continue
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("failed to spill aggregated flows: %s", err)
+		}
+		if _, err := s.files[p].Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		m, err := readSpillPartition(ctx, bufio.NewReader(s.files[p]))
+		if err != nil {
+			return err
+		}
+		fn(m)
+	}
+	return nil
+}
+
+// remove closes and deletes all partition files
+func (s *spillFiles) remove() {
+	for _, f := range s.files {
+		if f != nil {
+			f.Close()
+		}
+	}
+	os.RemoveAll(s.dir)
+}
+
+func readSpillPartition(ctx context.Context, r *bufio.Reader) (map[goDB.CompactKey]goDB.Val, error) {
+	var (
+		m   = make(map[goDB.CompactKey]goDB.Val)
+		buf = make([]byte, 32)
+		v   goDB.Val
+	)
+	for i := 0; ; i++ {
+		// checking the context for every entry would be needlessly expensive
+		if i%4096 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			if err == io.EOF {
+				return m, nil
+			}
+			return nil, fmt.Errorf("failed to read spilled flows: %s", err)
+		}
+		if cap(buf) < int(l) {
+			buf = make([]byte, l)
+		}
+		if _, err := io.ReadFull(r, buf[:l]); err != nil {
+			return nil, fmt.Errorf("failed to read spilled flows: %s", err)
+		}
+		k := goDB.CompactKey(buf[:l])
+
+		if _, err := io.ReadFull(r, buf[:32]); err != nil {
+			return nil, fmt.Errorf("failed to read spilled flows: %s", err)
+		}
+		v.NBytesRcvd = binary.BigEndian.Uint64(buf[0:8])
+		v.NBytesSent = binary.BigEndian.Uint64(buf[8:16])
+		v.NPktsRcvd = binary.BigEndian.Uint64(buf[16:24])
+		v.NPktsSent = binary.BigEndian.Uint64(buf[24:32])
+
+		if val, exists := m[k]; exists {
+			val.NBytesRcvd += v.NBytesRcvd
+			val.NBytesSent += v.NBytesSent
+			val.NPktsRcvd += v.NPktsRcvd
+			val.NPktsSent += v.NPktsSent
+			m[k] = val
+		} else {
+			m[k] = v
+		}
+	}
+}
+
+// appendSpillKey appends the compact key to buf, preceded by its length (uvarint)
+func appendSpillKey(buf []byte, k goDB.CompactKey) []byte {
+	var lenBuf [binary.MaxVarintLen64]byte
+	buf = append(buf, lenBuf[:binary.PutUvarint(lenBuf[:], uint64(len(k)))]...)
+	return append(buf, k...)
+}
+
+func appendSpillVal(buf []byte, v goDB.Val) []byte {
+	var counters [32]byte
+	binary.BigEndian.PutUint64(counters[0:8], v.NBytesRcvd)
+	binary.BigEndian.PutUint64(counters[8:16], v.NBytesSent)
+	binary.BigEndian.PutUint64(counters[16:24], v.NPktsRcvd)
+	binary.BigEndian.PutUint64(counters[24:32], v.NPktsSent)
+	return append(buf, counters[:]...)
+}
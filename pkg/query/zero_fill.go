@@ -0,0 +1,106 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+)
+
+// series returns the entries of the aggregated time series (i.e. the entries sharing all
+// attributes but time) matching the having filter (unless it is nil). The first limit
+// series by the totals of their entries (ranked by less) are returned, one after another.
+// The total number of matching entries is returned as well
+func (r *aggregateResult) series(ctx context.Context, having havingNode, less by, limit int, keys keyDecoder) ([]Entry, int, error) {
+	entries, hits, err := r.sortEntries(ctx, having, nil, MaxResults, keys)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// partition the entries, summing up the counters of each series
+	type partition struct {
+		total   sortEntry
+		entries []sortEntry
+	}
+	var (
+		partitions  []*partition
+		bySeriesKey = make(map[goDB.CompactKey]*partition)
+	)
+	for _, e := range entries {
+		seriesKey := keys.query.SeriesKey(e.key)
+		p, exists := bySeriesKey[seriesKey]
+		if !exists {
+			p = &partition{}
+			bySeriesKey[seriesKey] = p
+			partitions = append(partitions, p)
+		}
+		p.total.nBr, p.total.nBs = p.total.nBr+e.nBr, p.total.nBs+e.nBs
+		p.total.nPr, p.total.nPs = p.total.nPr+e.nPr, p.total.nPs+e.nPs
+		p.entries = append(p.entries, e)
+	}
+	if ctx.Err() != nil {
+		return nil, 0, ctx.Err()
+	}
+
+	// order the series by their totals
+	if less != nil {
+		sort.Slice(partitions, func(i, j int) bool { return less(&partitions[i].total, &partitions[j].total) })
+	}
+	if limit < len(partitions) {
+		partitions = partitions[:limit]
+	}
+
+	var series []sortEntry
+	for _, p := range partitions {
+		series = append(series, p.entries...)
+	}
+	return decodeEntries(series, keys), hits, nil
+}
+
+// zeroFill makes the time series of the entries continuous: each series (i.e. the
+// entries sharing all attributes but time) gets an entry for every time bucket from the
+// one of the block written at firstBlock to the one of the block written at lastBlock,
+// adding empty entries where the series has no flows. The returned entries are ordered
+// by bucket, and within a bucket by the first appearance of their series in entries.
+// An error is returned if this would exceed maxEntries entries
+func zeroFill(entries []Entry, firstBlock, lastBlock int64, resolution time.Duration, loc *time.Location, maxEntries int) ([]Entry, error) {
+	var (
+		series   []goDB.ExtraKey
+		isSeries = make(map[goDB.ExtraKey]struct{})
+		byKey    = make(map[goDB.ExtraKey]Entry, len(entries))
+	)
+	for _, entry := range entries {
+		byKey[entry.k] = entry
+
+		k := entry.k
+		k.Time = 0
+		if _, exists := isSeries[k]; !exists {
+			isSeries[k] = struct{}{}
+			series = append(series, k)
+		}
+	}
+
+	first, last := goDB.TimeBucket(firstBlock, resolution, loc), goDB.TimeBucket(lastBlock, resolution, loc)
+	numBuckets := 0
+	for bucket := first; bucket <= last; bucket = goDB.NextTimeBucket(bucket, resolution, loc) {
+		numBuckets++
+	}
+	if len(series) > 0 && numBuckets > maxEntries/len(series) {
+		return nil, fmt.Errorf("zero-filling %d series over %d time buckets exceeds the maximum of %d rows", len(series), numBuckets, maxEntries)
+	}
+
+	filled := make([]Entry, 0, numBuckets*len(series))
+	for bucket := first; bucket <= last; bucket = goDB.NextTimeBucket(bucket, resolution, loc) {
+		for _, k := range series {
+			k.Time = bucket
+			entry, exists := byKey[k]
+			if !exists {
+				entry = Entry{k: k}
+			}
+			filled = append(filled, entry)
+		}
+	}
+	return filled, nil
+}
@@ -0,0 +1,51 @@
+package query
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/els0r/goProbe/pkg/goDB"
+)
+
+func TestZeroFill(t *testing.T) {
+	entry := func(tstamp int64, dport byte, nBr uint64) Entry {
+		return Entry{k: goDB.ExtraKey{Time: tstamp, Key: goDB.Key{Dport: [2]byte{0, dport}}}, nBr: nBr}
+	}
+
+	// blocks from 00:05 to 03:00, i.e. in the buckets 00:00 to 02:00
+	entries := []Entry{entry(0, 80, 1), entry(0, 53, 2), entry(7200, 80, 3)}
+	want := []Entry{
+		entry(0, 80, 1), entry(0, 53, 2),
+		entry(3600, 80, 0), entry(3600, 53, 0),
+		entry(7200, 80, 3), entry(7200, 53, 0),
+	}
+	if have, err := zeroFill(entries, 300, 3*3600, time.Hour, time.UTC, MaxResults); err != nil || !reflect.DeepEqual(want, have) {
+		t.Fatalf("want %v, have %v (%v)", want, have, err)
+	}
+
+	// the entries of all series and buckets must not exceed the limit
+	if _, err := zeroFill(entries, 300, 3*3600, time.Hour, time.UTC, len(want)-1); err == nil {
+		t.Fatalf("expected zero-filling beyond the limit to fail")
+	}
+}
+
+func TestZeroFillManySeries(t *testing.T) {
+	const numSeries = 10000
+
+	// one entry per series in the first of 24 buckets
+	entries := make([]Entry, numSeries)
+	for i := range entries {
+		entries[i] = Entry{k: goDB.ExtraKey{Key: goDB.Key{Dport: [2]byte{byte(i >> 8), byte(i)}}}, nBr: uint64(i)}
+	}
+	filled, err := zeroFill(entries, 300, 24*3600-1, time.Hour, time.UTC, MaxResults)
+	if err != nil {
+		t.Fatalf("failed to zero-fill: %s", err)
+	}
+	if len(filled) != 24*numSeries {
+		t.Fatalf("unexpected number of entries: want %d, have %d", 24*numSeries, len(filled))
+	}
+	if _, err := zeroFill(entries, 300, 24*3600-1, time.Hour, time.UTC, 24*numSeries-1); err == nil {
+		t.Fatalf("expected zero-filling beyond the limit to fail")
+	}
+}